@@ -4,6 +4,8 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"os"
 	"os/signal"
 	"strings"
@@ -18,6 +20,7 @@ import (
 	"github.com/sylabs/fuzzball-service/internal/app/iomanager"
 	"github.com/sylabs/fuzzball-service/internal/app/server"
 	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"github.com/sylabs/fuzzball-service/internal/pkg/fsarchive"
 	"github.com/sylabs/fuzzball-service/internal/pkg/mongodb"
 	"github.com/sylabs/fuzzball-service/internal/pkg/rediskv"
 	"github.com/sylabs/fuzzball-service/internal/pkg/scheduler"
@@ -34,6 +37,7 @@ const (
 	keyCORSAllowedOrigins         = "cors-allowed-origins"
 	keyCORSDebug                  = "cors-debug"
 	keyMongoURI                   = "mongo-uri"
+	keySecretKey                  = "secret-key"
 	keyNatsURIs                   = "nats-uris"
 	keyRedisURI                   = "redis-uri"
 	keyOAuth2IssuerURI            = "oauth2-issuer-uri"
@@ -41,6 +45,23 @@ const (
 	keyOAuth2Scopes               = "oauth2-scopes"
 	keyOAuth2PKCEClientID         = "oauth2-pkce-client-id"
 	keyOAuth2PKCERedirectEndpoint = "oauth2-pkce-redirect-endpoint"
+	keyJobOutputLimit             = "job-output-limit"
+	keyJobOutputTTL               = "job-output-ttl"
+	keyJobOutputArchive           = "job-output-archive"
+	keySchedulingPolicy           = "scheduling-policy"
+	keyDefaultNodeCPUs            = "default-node-cpus"
+	keyCleanupGracePeriod         = "cleanup-grace-period"
+	keyVolumeTimeout              = "volume-timeout"
+
+	// Suffixes of the keys of limits on the resources consumed by each user or project, which are
+	// prefixed by "user-" or "project-".
+	keyMaxRunningJobs     = "max-running-jobs"
+	keyMaxQueuedWorkflows = "max-queued-workflows"
+	keyMaxCPUs            = "max-cpus"
+	keyMaxOutputBytes     = "max-output-bytes"
+
+	// archiveGridFS selects archival of job output in the database.
+	archiveGridFS = "gridfs"
 )
 
 // Values set during build.
@@ -52,7 +73,7 @@ var (
 )
 
 // signalHandler catches SIGINT/SIGTERM to perform an orderly shutdown.
-func signalHandler(nc *nats.Conn, s server.Server, m iomanager.IOManager) {
+func signalHandler(nc *nats.Conn, s server.Server, m iomanager.IOManager, sched *scheduler.Scheduler) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
@@ -68,6 +89,10 @@ func signalHandler(nc *nats.Conn, s server.Server, m iomanager.IOManager) {
 		logrus.WithError(err).Warning("IO manager shutdown failed")
 	}
 
+	if err := sched.Stop(); err != nil {
+		logrus.WithError(err).Warning("scheduler shutdown failed")
+	}
+
 	// Drain nats connection before closing.
 	if err := nc.Drain(); err != nil {
 		logrus.WithError(err).Warning("starting nats connection draining failed")
@@ -78,8 +103,9 @@ func signalHandler(nc *nats.Conn, s server.Server, m iomanager.IOManager) {
 	}
 }
 
-// connectDB attempts to connect to the database.
-func connectDB(ctx context.Context, uri string) (mc *mongodb.Connection, err error) {
+// connectDB attempts to connect to the database. If secretKey is not empty, it is the base64
+// encoded key used to encrypt secrets stored in the database.
+func connectDB(ctx context.Context, uri, secretKey string) (mc *mongodb.Connection, err error) {
 	logrus.Info("connecting to database")
 	defer func(t time.Time) {
 		if err == nil {
@@ -87,7 +113,18 @@ func connectDB(ctx context.Context, uri string) (mc *mongodb.Connection, err err
 		}
 	}(time.Now())
 
-	return mongodb.NewConnection(ctx, uri, dbName)
+	var opts []func(*mongodb.Connection) error
+	if secretKey != "" {
+		key, err := base64.StdEncoding.DecodeString(secretKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret key: %w", err)
+		}
+		opts = append(opts, mongodb.OptSecretKey(key))
+	} else {
+		logrus.Warning("secret key not configured, secrets are disabled")
+	}
+
+	return mongodb.NewConnection(ctx, uri, dbName, opts...)
 }
 
 // connectNATS attempts to connect to the NATS system.
@@ -131,6 +168,7 @@ func getFlagSet() *pflag.FlagSet {
 	fs.StringSlice(keyCORSAllowedOrigins, []string{"*"}, "Comma-separated list of CORS allowed origins")
 	fs.Bool(keyCORSDebug, false, "Enable CORS debugging")
 	fs.String(keyMongoURI, "mongodb://localhost", "URI of MongoDB database")
+	fs.String(keySecretKey, "", "Base64 encoded 16, 24 or 32 byte key used to encrypt secrets in the database, or empty to disable secrets")
 	fs.StringSlice(keyNatsURIs, []string{"nats://localhost"}, "Comma-separated list of NATS server URIs")
 	fs.String(keyRedisURI, "redis://localhost", "URI of Redis")
 	fs.String(keyOAuth2IssuerURI, "https://dev-930666.okta.com/oauth2/default", "URI of OAuth 2.0 issuer")
@@ -138,12 +176,36 @@ func getFlagSet() *pflag.FlagSet {
 	fs.StringSlice(keyOAuth2Scopes, []string{"openid", "offline_access"}, "Recommended scope(s) for OAuth 2.0 clients to request")
 	fs.String(keyOAuth2PKCEClientID, "", "Client ID for OAuth 2.0 clients to use for Authorization Code flow with PKCE")
 	fs.String(keyOAuth2PKCERedirectEndpoint, "http://localhost:9876/authorization/callback", "Callback URL for OAuth 2.0 clients to use for Authorization Code flow with PKCE")
+	fs.Int(keyJobOutputLimit, 64<<20, "Maximum size of the output of a job in bytes, or 0 for no limit")
+	fs.Duration(keyJobOutputTTL, 24*time.Hour, "Amount of time to retain job output in Redis once a job finishes, or 0 to retain indefinitely")
+	fs.String(keyJobOutputArchive, archiveGridFS, "Where to archive output of finished jobs: \"gridfs\" to use the database, a directory path, or empty to disable archival")
+	fs.String(keySchedulingPolicy, "priority", fmt.Sprintf("Policy used to place queued jobs on nodes (one of: %v)", strings.Join(scheduler.PolicyNames(), ", ")))
+	fs.Int(keyDefaultNodeCPUs, scheduler.DefaultNodeCPUs, "Number of CPUs assumed for a node that does not report its number of CPUs")
+	fs.Duration(keyCleanupGracePeriod, scheduler.DefaultCleanupGracePeriod, "Amount of time jobs that are always run are given to clean up once their workflow is cancelled or times out")
+	fs.Duration(keyVolumeTimeout, scheduler.DefaultVolumeTimeout, "Amount of time an agent is given to create or delete each volume of a workflow")
+	for _, owner := range []string{"user", "project"} {
+		fs.Int(owner+"-"+keyMaxRunningJobs, 0, fmt.Sprintf("Maximum number of jobs of each %v running at once, or 0 for no limit", owner))
+		fs.Int(owner+"-"+keyMaxQueuedWorkflows, 0, fmt.Sprintf("Maximum number of workflows of each %v that have not finished, or 0 for no limit", owner))
+		fs.Int(owner+"-"+keyMaxCPUs, 0, fmt.Sprintf("Maximum number of CPUs allocated to running jobs of each %v, or 0 for no limit", owner))
+		fs.Int64(owner+"-"+keyMaxOutputBytes, 0, fmt.Sprintf("Maximum total size of output stored for jobs of each %v in bytes, or 0 for no limit", owner))
+	}
 
 	fs.Parse(os.Args[1:])
 
 	return fs
 }
 
+// getLimits returns the limits on the resources consumed by each user or project, as selected by
+// owner ("user" or "project").
+func getLimits(cfg *viper.Viper, owner string) core.Limits {
+	return core.Limits{
+		RunningJobs:     cfg.GetInt(owner + "-" + keyMaxRunningJobs),
+		QueuedWorkflows: cfg.GetInt(owner + "-" + keyMaxQueuedWorkflows),
+		CPUs:            cfg.GetInt(owner + "-" + keyMaxCPUs),
+		OutputBytes:     cfg.GetInt64(owner + "-" + keyMaxOutputBytes),
+	}
+}
+
 // getConfig gets a Viper instance to retrieve configuration.
 func getConfig() (*viper.Viper, error) {
 	v := viper.New()
@@ -160,22 +222,45 @@ func getConfig() (*viper.Viper, error) {
 	return v, nil
 }
 
-// getCore returns an initilized Core.
-func getCore(mc *mongodb.Connection, nc *nats.Conn, rc *rediskv.Connection) (*core.Core, error) {
-	// Encoded NATS connection.
-	ec, err := nats.NewEncodedConn(nc, nats.JSON_ENCODER)
+// getScheduler returns an initialized Scheduler, which places jobs according to the named policy,
+// within the limits on the jobs of each user (ul) and project (pl). Nodes that do not report their
+// number of CPUs are assumed to have cpus CPUs. Jobs that are always run are given grace to clean
+// up once their workflow is cancelled or times out, and agents are given volumeTimeout to create or
+// delete each volume.
+func getScheduler(mc *mongodb.Connection, nc *nats.Conn, rc *rediskv.Connection, policy string, cpus int, ul, pl core.Limits, grace, volumeTimeout time.Duration) (*scheduler.Scheduler, error) {
+	p, err := scheduler.PolicyByName(policy)
 	if err != nil {
 		return nil, err
 	}
 
-	// Initialize scheduler.
-	sched, err := scheduler.New(ec, mc, rc)
+	// Encoded NATS connection.
+	ec, err := nats.NewEncodedConn(nc, nats.JSON_ENCODER)
 	if err != nil {
 		return nil, err
 	}
 
+	return scheduler.New(ec, mc, rc, scheduler.OptPolicy(p), scheduler.OptDefaultNodeCPUs(cpus), scheduler.OptUserLimits(ul), scheduler.OptProjectLimits(pl), scheduler.OptCleanupGracePeriod(grace), scheduler.OptVolumeTimeout(volumeTimeout))
+}
+
+// getArchive returns the job output archive described by spec, or nil if archival is disabled.
+func getArchive(mc *mongodb.Connection, spec string) (iomanager.Archive, error) {
+	switch spec {
+	case "":
+		return nil, nil
+	case archiveGridFS:
+		return mc, nil
+	default:
+		return fsarchive.New(spec)
+	}
+}
+
+// getCore returns an initilized Core.
+func getCore(mc *mongodb.Connection, m iomanager.IOManager, sched *scheduler.Scheduler, ul, pl core.Limits) (*core.Core, error) {
 	// Build up core options.
-	opts := [](func(*core.Core) error){}
+	opts := [](func(*core.Core) error){
+		core.OptUserLimits(ul),
+		core.OptProjectLimits(pl),
+	}
 	if t, err := time.Parse(time.RFC3339, builtAt); err == nil {
 		opts = append(opts, core.OptBuiltAt(t))
 	}
@@ -190,7 +275,7 @@ func getCore(mc *mongodb.Connection, nc *nats.Conn, rc *rediskv.Connection) (*co
 	}
 
 	// Initialize core.
-	return core.New(mc, rc, sched, opts...)
+	return core.New(mc, m, sched, opts...)
 }
 
 func main() {
@@ -225,7 +310,7 @@ func main() {
 	defer cancel()
 
 	// Connect to MongoDB.
-	mc, err := connectDB(ctx, cfg.GetString(keyMongoURI))
+	mc, err := connectDB(ctx, cfg.GetString(keyMongoURI), cfg.GetString(keySecretKey))
 	if err != nil {
 		logrus.WithError(err).Error("failed to connect to database")
 		return
@@ -237,6 +322,12 @@ func main() {
 		}
 	}()
 
+	// Replace statuses recorded by earlier versions.
+	if err := mc.MigrateLegacyStatuses(ctx); err != nil {
+		logrus.WithError(err).Error("failed to migrate database")
+		return
+	}
+
 	// Connect to NATS.
 	nc, err := connectNATS(ctx, cfg.GetStringSlice(keyNatsURIs))
 	if err != nil {
@@ -259,10 +350,26 @@ func main() {
 		rc.Disconnect()
 	}()
 
+	// Get job output archive.
+	a, err := getArchive(mc, cfg.GetString(keyJobOutputArchive))
+	if err != nil {
+		logrus.WithError(err).Error("failed to get job output archive")
+		return
+	}
+
+	// Get limits on the resources consumed by each user and project.
+	ul, pl := getLimits(cfg, "user"), getLimits(cfg, "project")
+
 	// Spin up IO Manager.
 	ioc := iomanager.Config{
-		NATSConn:  nc,
-		RedisConn: rc,
+		NATSConn:             nc,
+		RedisConn:            rc,
+		Persister:            mc,
+		Archive:              a,
+		MaxOutputSize:        cfg.GetInt(keyJobOutputLimit),
+		OutputTTL:            cfg.GetDuration(keyJobOutputTTL),
+		MaxUserOutputSize:    ul.OutputBytes,
+		MaxProjectOutputSize: pl.OutputBytes,
 	}
 	m, err := iomanager.New(ioc)
 	if err != nil {
@@ -271,8 +378,25 @@ func main() {
 	}
 	m.Start()
 
+	// Spin up scheduler.
+	sched, err := getScheduler(mc, nc, rc, cfg.GetString(keySchedulingPolicy), cfg.GetInt(keyDefaultNodeCPUs), ul, pl, cfg.GetDuration(keyCleanupGracePeriod), cfg.GetDuration(keyVolumeTimeout))
+	if err != nil {
+		logrus.WithError(err).Error("failed to create scheduler")
+		return
+	}
+	if err := sched.Start(); err != nil {
+		logrus.WithError(err).Error("failed to start scheduler")
+		return
+	}
+
+	// Resume workflows that were in flight when the server last stopped.
+	if err := sched.Resume(ctx); err != nil {
+		logrus.WithError(err).Error("failed to resume workflows")
+		return
+	}
+
 	// Get core.
-	c, err := getCore(mc, nc, rc)
+	c, err := getCore(mc, m, sched, ul, pl)
 	if err != nil {
 		logrus.WithError(err).Error("failed to get core")
 		return
@@ -298,7 +422,7 @@ func main() {
 	}
 
 	// Spin off signal handler to do graceful shutdown.
-	go signalHandler(nc, s, m)
+	go signalHandler(nc, s, m, sched)
 
 	// Main server routine.
 	s.Run()
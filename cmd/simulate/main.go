@@ -0,0 +1,293 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"github.com/sylabs/fuzzball-service/internal/pkg/mongodb"
+	"github.com/sylabs/fuzzball-service/internal/pkg/scheduler"
+)
+
+// dbName is the name of the database in which the server persists jobs.
+const dbName = "server"
+
+// duration is a time.Duration that is encoded in JSON as a string, such as "1h30m".
+type duration time.Duration
+
+// MarshalJSON encodes a duration as a string accepted by time.ParseDuration.
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration from a string accepted by time.ParseDuration.
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// workloadNode is the format of a node in a recorded workload.
+type workloadNode struct {
+	ID     string            `json:"id"`
+	CPUs   int               `json:"cpus,omitempty"`
+	Memory int64             `json:"memory,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// workloadJob is the format of a job in a recorded workload.
+type workloadJob struct {
+	ID         string            `json:"id"`
+	UserID     string            `json:"user"`
+	ProjectID  string            `json:"project,omitempty"`
+	Priority   int               `json:"priority,omitempty"`
+	NodeID     string            `json:"node,omitempty"`
+	CPUs       int               `json:"cpus,omitempty"`
+	Memory     int64             `json:"memory,omitempty"`
+	Walltime   duration          `json:"walltime,omitempty"`
+	NodeLabels map[string]string `json:"nodeLabels,omitempty"`
+	Submit     duration          `json:"submit"`
+	Runtime    duration          `json:"runtime"`
+}
+
+// workloadFile is the format of a recorded workload.
+type workloadFile struct {
+	Nodes []workloadNode `json:"nodes"`
+	Jobs  []workloadJob  `json:"jobs"`
+}
+
+// readWorkload reads a recorded workload from the file at path.
+func readWorkload(path string) (scheduler.Workload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return scheduler.Workload{}, err
+	}
+	defer f.Close()
+
+	var wf workloadFile
+	if err := json.NewDecoder(f).Decode(&wf); err != nil {
+		return scheduler.Workload{}, fmt.Errorf("failed to decode workload: %w", err)
+	}
+
+	var w scheduler.Workload
+	for _, n := range wf.Nodes {
+		w.Nodes = append(w.Nodes, scheduler.Node{
+			ID:     n.ID,
+			CPUs:   n.CPUs,
+			Memory: n.Memory,
+			Labels: n.Labels,
+		})
+	}
+	for _, j := range wf.Jobs {
+		w.Jobs = append(w.Jobs, scheduler.WorkloadJob{
+			ID:        j.ID,
+			UserID:    j.UserID,
+			ProjectID: j.ProjectID,
+			Priority:  j.Priority,
+			NodeID:    j.NodeID,
+			Resources: core.Resources{
+				CPUs:     j.CPUs,
+				Memory:   j.Memory,
+				Walltime: time.Duration(j.Walltime),
+			},
+			NodeLabels: j.NodeLabels,
+			Submit:     time.Duration(j.Submit),
+			Runtime:    time.Duration(j.Runtime),
+		})
+	}
+	return w, nil
+}
+
+// writeWorkload writes workload wl to w, in the format read by readWorkload.
+func writeWorkload(w io.Writer, wl scheduler.Workload) error {
+	var wf workloadFile
+	for _, n := range wl.Nodes {
+		wf.Nodes = append(wf.Nodes, workloadNode{
+			ID:     n.ID,
+			CPUs:   n.CPUs,
+			Memory: n.Memory,
+			Labels: n.Labels,
+		})
+	}
+	for _, j := range wl.Jobs {
+		wf.Jobs = append(wf.Jobs, workloadJob{
+			ID:         j.ID,
+			UserID:     j.UserID,
+			ProjectID:  j.ProjectID,
+			Priority:   j.Priority,
+			NodeID:     j.NodeID,
+			CPUs:       j.Resources.CPUs,
+			Memory:     j.Resources.Memory,
+			Walltime:   duration(j.Resources.Walltime),
+			NodeLabels: j.NodeLabels,
+			Submit:     duration(j.Submit),
+			Runtime:    duration(j.Runtime),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(wf)
+}
+
+// recordWorkload records the workload of the jobs persisted in the database at uri that finished
+// at or after from, and before to.
+func recordWorkload(ctx context.Context, uri string, from, to time.Time) (scheduler.Workload, error) {
+	mc, err := mongodb.NewConnection(ctx, uri, dbName)
+	if err != nil {
+		return scheduler.Workload{}, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer mc.Disconnect(ctx)
+
+	var jobs []core.Job
+	var pa core.PageArgs
+	for {
+		p, err := mc.GetJobsFinishedBetween(ctx, pa, from, to)
+		if err != nil {
+			return scheduler.Workload{}, fmt.Errorf("failed to get jobs: %w", err)
+		}
+		jobs = append(jobs, p.Jobs...)
+
+		if !p.PageInfo.HasNextPage {
+			return scheduler.RecordWorkload(jobs), nil
+		}
+		pa.After = p.PageInfo.EndCursor
+	}
+}
+
+// printResults writes a summary of the outcome of simulating each policy to stdout.
+func printResults(names []string, results []scheduler.SimulationResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "POLICY\tMAKESPAN\tMEAN WAIT\tMAX WAIT\tUTILIZATION\tUNPLACED")
+	for i, res := range results {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%.1f%%\t%v\n", names[i], res.Makespan, res.MeanWait, res.MaxWait, 100*res.Utilization, len(res.Unplaced))
+	}
+
+	// Mean wait by user, to compare fairness.
+	users := make(map[string]bool)
+	for _, res := range results {
+		for u := range res.MeanWaitByUser {
+			users[u] = true
+		}
+	}
+	var ids []string
+	for u := range users {
+		ids = append(ids, u)
+	}
+	sort.Strings(ids)
+
+	fmt.Fprintln(tw)
+	fmt.Fprint(tw, "USER")
+	for _, name := range names {
+		fmt.Fprintf(tw, "\t%v", name)
+	}
+	fmt.Fprintln(tw)
+	for _, u := range ids {
+		fmt.Fprint(tw, u)
+		for _, res := range results {
+			fmt.Fprintf(tw, "\t%v", res.MeanWaitByUser[u])
+		}
+		fmt.Fprintln(tw)
+	}
+}
+
+func main() {
+	fs := pflag.NewFlagSet("simulate", pflag.ExitOnError)
+	names := fs.StringSlice("policy", scheduler.PolicyNames(), "Comma-separated list of scheduling policies to simulate")
+	cpus := fs.Int("default-node-cpus", scheduler.DefaultNodeCPUs, "Number of CPUs assumed for a node that does not report its number of CPUs")
+	userJobs := fs.Int("user-max-running-jobs", 0, "Maximum number of jobs of each user running at once, or 0 for no limit")
+	userCPUs := fs.Int("user-max-cpus", 0, "Maximum number of CPUs allocated to running jobs of each user, or 0 for no limit")
+	projectJobs := fs.Int("project-max-running-jobs", 0, "Maximum number of jobs of each project running at once, or 0 for no limit")
+	projectCPUs := fs.Int("project-max-cpus", 0, "Maximum number of CPUs allocated to running jobs of each project, or 0 for no limit")
+	record := fs.Bool("record", false, "Record the workload of finished jobs from the database to stdout, rather than simulating")
+	mongoURI := fs.String("mongo-uri", "mongodb://localhost", "URI of MongoDB database to record from")
+	since := fs.Duration("since", 24*time.Hour, "Record jobs that finished within this amount of time before --until")
+	until := fs.String("until", "", "Record jobs that finished before this RFC 3339 time (default now)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %v [flags] <workload.json>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %v --record [flags] > <workload.json>\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Replays a recorded workload against scheduling policies, and compares the outcomes.")
+		fmt.Fprintln(os.Stderr, "With --record, records the workload of jobs that finished recently from the database.")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	// The registry logs each node registering, which is not of interest in a simulation.
+	logrus.SetLevel(logrus.WarnLevel)
+
+	if *record {
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(2)
+		}
+
+		to := time.Now()
+		if *until != "" {
+			t, err := time.Parse(time.RFC3339, *until)
+			if err != nil {
+				logrus.WithError(err).Fatal("invalid end time")
+			}
+			to = t
+		}
+
+		w, err := recordWorkload(context.Background(), *mongoURI, to.Add(-*since), to)
+		if err != nil {
+			logrus.WithError(err).Fatal("failed to record workload")
+		}
+		if err := writeWorkload(os.Stdout, w); err != nil {
+			logrus.WithError(err).Fatal("failed to write workload")
+		}
+		return
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	w, err := readWorkload(fs.Arg(0))
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to read workload")
+	}
+
+	var results []scheduler.SimulationResult
+	for _, name := range *names {
+		p, err := scheduler.PolicyByName(name)
+		if err != nil {
+			logrus.WithError(err).Fatal("failed to get policy")
+		}
+
+		res, err := scheduler.Simulate(w,
+			scheduler.OptPolicy(p),
+			scheduler.OptDefaultNodeCPUs(*cpus),
+			scheduler.OptUserLimits(core.Limits{RunningJobs: *userJobs, CPUs: *userCPUs}),
+			scheduler.OptProjectLimits(core.Limits{RunningJobs: *projectJobs, CPUs: *projectCPUs}),
+		)
+		if err != nil {
+			logrus.WithError(err).Fatal("failed to simulate policy")
+		}
+		results = append(results, res)
+	}
+
+	printResults(*names, results)
+}
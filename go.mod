@@ -8,7 +8,9 @@ require (
 	github.com/friendsofgo/graphiql v0.2.2
 	github.com/go-git/go-git/v5 v5.0.0
 	github.com/go-redis/redis v6.15.7+incompatible
+	github.com/gorilla/websocket v1.4.1
 	github.com/graph-gophers/graphql-go v0.0.0-20191115155744-f33e81362277
+	github.com/graph-gophers/graphql-transport-ws v0.0.2
 	github.com/magefile/mage v1.9.0
 	github.com/nats-io/nats-server/v2 v2.1.4 // indirect
 	github.com/nats-io/nats.go v1.9.2
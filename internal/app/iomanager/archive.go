@@ -0,0 +1,303 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// archiveDelay is the time to wait after a job finishes before archiving its output, to allow
+// output that is still in flight, or awaiting reordering, to be stored.
+const archiveDelay = 2 * reorderTimeout
+
+// archiveBlockSize is the number of bytes of output read at a time when archiving output.
+const archiveBlockSize = 1 << 20
+
+// archiveInterval is the interval at which pending archival of job output is carried out, in case
+// it was not carried out when due, such as when the service restarts.
+const archiveInterval = time.Minute
+
+// archiveLease is the time for which a replica is given to archive the output of a job, after
+// which another replica may do so.
+const archiveLease = 10 * time.Minute
+
+// Archive is the interface that describes a durable store for the output of finished jobs.
+type Archive interface {
+	PutArchivedJobOutput(key string, r io.Reader) error
+	DeleteArchivedJobOutput(key string) error
+	GetArchivedJobOutputSize(key string) (size int, ok bool, err error)
+	GetArchivedJobOutputRange(key string, offset, limit int) (string, error)
+}
+
+// streamKey returns the key under which the output written to stream s, of the job output stored
+// at key, is archived.
+func streamKey(key string, s core.JobOutputStream) string {
+	return key + "." + strings.ToLower(s.String())
+}
+
+// logKey returns the key under which the log of the job output stored at key is archived.
+func logKey(key string) string {
+	return key + ".log"
+}
+
+// archivedOutputKeys returns the keys under which the job output stored at key is archived: the
+// output itself, the output written to each stream, and the log.
+func archivedOutputKeys(key string) []string {
+	return []string{key, streamKey(key, core.JobStdout), streamKey(key, core.JobStderr), logKey(key)}
+}
+
+// archivedLogLine is the form in which each line of a log is archived, as a line of JSON.
+type archivedLogLine struct {
+	ID     string               `json:"id"`
+	Stream core.JobOutputStream `json:"stream"`
+	Time   time.Time            `json:"time"`
+	Data   string               `json:"data"`
+}
+
+// writeArchivedLogLine writes log line l to w in archived form.
+func writeArchivedLogLine(w io.Writer, l core.JobLogLine) error {
+	return json.NewEncoder(w).Encode(archivedLogLine{l.ID, l.Stream, l.Time, l.Data})
+}
+
+// readArchivedLog reads log lines in archived form from r.
+func readArchivedLog(r io.Reader) ([]core.JobLogLine, error) {
+	var ls []core.JobLogLine
+	for d := json.NewDecoder(r); ; {
+		var l archivedLogLine
+		if err := d.Decode(&l); err == io.EOF {
+			return ls, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("malformed archived log: %w", err)
+		}
+		ls = append(ls, core.JobLogLine{ID: l.ID, Stream: l.Stream, Time: l.Time, Data: l.Data})
+	}
+}
+
+// rangeReader is an io.Reader that reads size bytes using get, in blocks.
+type rangeReader struct {
+	get    func(offset, limit int) (string, error)
+	offset int
+	size   int
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	n := len(p)
+	if n > archiveBlockSize {
+		n = archiveBlockSize
+	}
+	if rem := r.size - r.offset; n > rem {
+		n = rem
+	}
+
+	s, err := r.get(r.offset, n)
+	if err != nil {
+		return 0, err
+	}
+	if s == "" {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n = copy(p, s)
+	r.offset += n
+	return n, nil
+}
+
+// statusMessage is published by the scheduler on the updated subject of a job.
+type statusMessage struct {
+	Status core.JobStatus
+}
+
+// jobUpdatedHandler handles a change to the status of a job, scheduling archival of its output
+// once the job has finished. Archival is recorded in Redis, so that it is carried out even if the
+// service restarts before it is due.
+func (m IOManager) jobUpdatedHandler(msg *nats.Msg) {
+	id, ok := parseJobID(msg.Subject)
+	if !ok {
+		return
+	}
+
+	var sm statusMessage
+	if err := json.Unmarshal(msg.Data, &sm); err != nil {
+		logrus.Errorf("malformed job %s status event: %v, skipping", id, err)
+		handlerErrorsTotal.WithLabelValues("updated").Inc()
+		return
+	}
+
+	if sm.Status.IsTerminal() && (m.a != nil || m.outputTTL > 0) {
+		if err := m.rc.ScheduleJobOutputArchival(id, time.Now().Add(archiveDelay)); err != nil {
+			logrus.Errorf("failed to schedule job %s output archival: %v", id, err)
+			handlerErrorsTotal.WithLabelValues("updated").Inc()
+			return
+		}
+		time.AfterFunc(archiveDelay, m.archiveDueJobOutput)
+	}
+}
+
+// archiveDueJobOutput archives the output of jobs for which archival is due. Archival that fails
+// is retried once its lease expires.
+func (m IOManager) archiveDueJobOutput() {
+	ids, err := m.rc.ClaimDueJobOutputArchivals(time.Now(), archiveLease)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to claim due job output archivals")
+		return
+	}
+
+	for _, id := range ids {
+		if err := m.archiveJobOutput(id); err != nil {
+			logrus.WithError(err).WithField("jobID", id).Warn("failed to archive job output")
+			continue
+		}
+		if err := m.rc.RemoveJobOutputArchival(id); err != nil {
+			logrus.WithError(err).WithField("jobID", id).Warn("failed to remove job output archival")
+		}
+	}
+}
+
+// archivePendingOutput archives the output of jobs for which archival is due, on start and then
+// periodically, until m.done is closed. This carries out archival that was pending when the
+// service last stopped.
+func (m IOManager) archivePendingOutput() {
+	t := time.NewTicker(archiveInterval)
+	defer t.Stop()
+
+	for {
+		m.archiveDueJobOutput()
+
+		select {
+		case <-t.C:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// jobOutputKeys returns the keys under which output of job j is stored, including the output of
+// previous attempts.
+func jobOutputKeys(j core.Job) []string {
+	keys := []string{j.ID}
+	seen := map[string]bool{j.ID: true}
+	for _, a := range j.Attempts {
+		if a.OutputKey != "" && !seen[a.OutputKey] {
+			keys = append(keys, a.OutputKey)
+			seen[a.OutputKey] = true
+		}
+	}
+	return keys
+}
+
+// archiveOutput archives the output stored at key, along with the output written to each stream,
+// and the log.
+func (m IOManager) archiveOutput(key string) error {
+	n, err := m.rc.GetJobOutputSize(key)
+	if err != nil {
+		return err
+	}
+
+	// If no output is held in Redis, such as once it has expired, there is nothing to archive, and
+	// any output already archived is retained.
+	if n == 0 {
+		return nil
+	}
+
+	r := &rangeReader{
+		get: func(offset, limit int) (string, error) {
+			return m.rc.GetJobOutputRange(key, int64(offset), int64(limit))
+		},
+		size: int(n),
+	}
+	if err := m.a.PutArchivedJobOutput(key, r); err != nil {
+		return err
+	}
+
+	for _, s := range []core.JobOutputStream{core.JobStdout, core.JobStderr} {
+		s := s
+		err := m.putArchivedOutput(streamKey(key, s), func(w io.Writer) error {
+			return m.rc.WriteJobStreamOutput(w, key, s)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.putArchivedOutput(logKey(key), func(w io.Writer) error {
+		return m.rc.ScanJobLog(key, func(l core.JobLogLine) error {
+			return writeArchivedLogLine(w, l)
+		})
+	})
+}
+
+// putArchivedOutput archives the output written by write under key, streaming it to the archive as
+// it is written, rather than holding it in memory.
+func (m IOManager) putArchivedOutput(key string, write func(io.Writer) error) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw))
+	}()
+	err := m.a.PutArchivedJobOutput(key, pr)
+	pr.CloseWithError(err)
+	return err
+}
+
+// archiveJobOutput archives the output of the finished job with the supplied id, and sets the
+// output stored in Redis to expire. Output that fails to archive is not set to expire, and an
+// error is returned. Once the output expires, it is removed from the output quotas of the owner of
+// the job.
+func (m IOManager) archiveJobOutput(id string) error {
+	j, err := m.p.GetJob(context.Background(), id)
+	if err != nil {
+		return fmt.Errorf("failed to get job: %w", err)
+	}
+
+	var errs []string
+	for _, key := range jobOutputKeys(j) {
+		if m.a != nil {
+			if err := m.archiveOutput(key); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to archive output %v: %v", key, err))
+				continue
+			}
+		}
+
+		if m.outputTTL > 0 {
+			if err := m.rc.ExpireJobOutput(key, m.outputTTL); err != nil {
+				errs = append(errs, fmt.Sprintf("failed to expire output %v: %v", key, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	if m.outputTTL > 0 {
+		t := time.Now().Add(m.outputTTL)
+		if err := m.rc.ScheduleJobOutputRelease(id, outputOwner(j), t); err != nil {
+			return fmt.Errorf("failed to schedule job output release: %w", err)
+		}
+	}
+	return nil
+}
+
+// getArchivedJobOutputSize returns the size of the output archived under key, in bytes. If no
+// archive is configured, or no output is archived under key, zero is returned.
+func (m IOManager) getArchivedJobOutputSize(key string) (int, error) {
+	if m.a == nil {
+		return 0, nil
+	}
+	n, _, err := m.a.GetArchivedJobOutputSize(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get archived job output size: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+func TestCapOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		size   int
+		max    int
+		want   string
+		wantOK bool
+	}{
+		{"Fits", "abc", 0, 4, "abc", true},
+		{"Exact", "abcd", 0, 4, "abcd", true},
+		{"Truncated", "abcdef", 2, 4, "ab" + truncationMarker, true},
+		{"TruncatedRune", "aéb", 0, 2, "a" + truncationMarker, true},
+		{"TruncatedWideRune", "€uro", 0, 2, truncationMarker, true},
+		{"Full", "abc", 4, 4, truncationMarker, true},
+		{"AlreadyTruncated", "abc", 4 + len(truncationMarker), 4, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := capOutput(tt.data, tt.size, tt.max)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("got ok %v, want %v", ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRangeReader(t *testing.T) {
+	const data = "0123456789"
+
+	tests := []struct {
+		name    string
+		size    int
+		get     func(offset, limit int) (string, error)
+		want    string
+		wantErr bool
+	}{
+		{"Empty", 0, nil, "", false},
+		{"All", len(data), func(offset, limit int) (string, error) {
+			return data[offset : offset+limit], nil
+		}, data, false},
+		{"Short", len(data), func(offset, limit int) (string, error) {
+			if offset >= 5 {
+				return "", nil
+			}
+			return data[offset : offset+1], nil
+		}, "", true},
+		{"Error", len(data), func(offset, limit int) (string, error) {
+			return "", errors.New("failed")
+		}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ioutil.ReadAll(&rangeReader{get: tt.get, size: tt.size})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if got := string(b); got != tt.want {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestArchivedLog(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	ls := []core.JobLogLine{
+		{ID: "1-0", Stream: core.JobStdout, Time: now, Data: "out\n"},
+		{ID: "1-1", Stream: core.JobStderr, Time: now.Add(time.Second), Data: "err\n"},
+		{ID: "2-0", Stream: core.JobStdout, Time: now.Add(time.Minute), Data: "partial"},
+	}
+
+	var b bytes.Buffer
+	for _, l := range ls {
+		if err := writeArchivedLogLine(&b, l); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	}
+
+	got, err := readArchivedLog(&b)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if len(got) != len(ls) {
+		t.Fatalf("got %v log lines, want %v", len(got), len(ls))
+	}
+	for i, l := range got {
+		want := ls[i]
+		if l.ID != want.ID || l.Stream != want.Stream || l.Data != want.Data || !l.Time.Equal(want.Time) {
+			t.Errorf("got log line %+v, want %+v", l, want)
+		}
+	}
+
+	if _, err := readArchivedLog(strings.NewReader("{")); err == nil {
+		t.Error("unexpected success reading malformed log")
+	}
+}
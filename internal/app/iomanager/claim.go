@@ -0,0 +1,240 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// queueGroup is the NATS queue group joined by each replica of the IO manager, so that each job
+// message is delivered to a single replica.
+const queueGroup = "iomanager"
+
+// claimTTL is how long a claim on a job lasts, unless renewed.
+const claimTTL = 30 * time.Second
+
+// claimRenewInterval is how often claims held by a replica are renewed.
+const claimRenewInterval = claimTTL / 3
+
+// forwardTimeout is how long a replica has to acknowledge a message forwarded to it, after which
+// it is presumed to have failed.
+const forwardTimeout = time.Second
+
+// newReplicaID returns a random ID that identifies a replica of the IO manager.
+func newReplicaID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// forwardSubject returns the subject on which a message received on subject is forwarded to the
+// replica with the supplied ID.
+func forwardSubject(replicaID, subject string) string {
+	return fmt.Sprintf("iomanager.%v.%v", replicaID, subject)
+}
+
+// parseForwardSubject parses the original subject of a message forwarded to the replica with the
+// supplied ID.
+func parseForwardSubject(replicaID, subject string) (string, bool) {
+	prefix := forwardSubject(replicaID, "")
+	if !strings.HasPrefix(subject, prefix) {
+		logrus.Errorf("malformed forward subject: %s, skipping", subject)
+		return "", false
+	}
+	return strings.TrimPrefix(subject, prefix), true
+}
+
+// claimSet is the set of jobs claimed by a replica.
+type claimSet struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func newClaimSet() *claimSet {
+	return &claimSet{ids: make(map[string]bool)}
+}
+
+func (cs *claimSet) add(id string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.ids[id] = true
+}
+
+func (cs *claimSet) remove(id string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	delete(cs.ids, id)
+}
+
+func (cs *claimSet) list() []string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	ids := make([]string, 0, len(cs.ids))
+	for id := range cs.ids {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// route returns a handler that passes messages for a job to h if this replica owns the job, and
+// forwards them to the owning replica otherwise. The first replica to receive a message for a job
+// claims it, so that output of the job is stored by a single replica, in order. If the owner
+// cannot be determined, the message is passed to h. If the owner does not acknowledge a forwarded
+// message, it is presumed to have failed, and this replica takes over the job.
+func (m IOManager) route(handler string, h nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		id, ok := parseJobID(msg.Subject)
+		if !ok {
+			handlerErrorsTotal.WithLabelValues(handler).Inc()
+			return
+		}
+
+		owner, err := m.rc.ClaimJob(id, m.id, claimTTL)
+		if err != nil {
+			logrus.Errorf("failed to claim job %s: %v, handling locally", id, err)
+			handlerErrorsTotal.WithLabelValues(handler).Inc()
+			h(msg)
+			return
+		}
+
+		if owner != m.id {
+			err := m.forward(owner, msg)
+			if err == nil {
+				forwardedMessagesTotal.Inc()
+				return
+			}
+			logrus.Warnf("failed to forward job %s message to replica %s: %v, taking over", id, owner, err)
+
+			if owner, err = m.rc.TakeOverJob(id, owner, m.id, claimTTL); err != nil {
+				logrus.Errorf("failed to take over job %s: %v, handling locally", id, err)
+				handlerErrorsTotal.WithLabelValues(handler).Inc()
+				h(msg)
+				return
+			}
+
+			// Another replica may have taken over the job first.
+			if owner != m.id {
+				if err := m.forward(owner, msg); err != nil {
+					logrus.Errorf("failed to forward job %s message: %v, handling locally", id, err)
+					handlerErrorsTotal.WithLabelValues(handler).Inc()
+					h(msg)
+					return
+				}
+				forwardedMessagesTotal.Inc()
+				return
+			}
+		}
+
+		m.claims.add(id)
+		h(msg)
+	}
+}
+
+// forward forwards msg to the replica with the supplied ID, and waits for it to acknowledge the
+// message.
+func (m IOManager) forward(replicaID string, msg *nats.Msg) error {
+	_, err := m.nc.Request(forwardSubject(replicaID, msg.Subject), msg.Data, forwardTimeout)
+	return err
+}
+
+// forwardedHandler returns a handler for messages forwarded to this replica, which acknowledges
+// each message, and passes it to the handler in hs for the event of its original subject.
+func (m IOManager) forwardedHandler(hs map[string]nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		if msg.Reply != "" {
+			if err := m.nc.Publish(msg.Reply, nil); err != nil {
+				logrus.WithError(err).Warn("failed to acknowledge forwarded message")
+			}
+		}
+
+		subject, ok := parseForwardSubject(m.id, msg.Subject)
+		if !ok {
+			handlerErrorsTotal.WithLabelValues("forward").Inc()
+			return
+		}
+
+		h, ok := hs[subject[strings.LastIndex(subject, ".")+1:]]
+		if !ok {
+			logrus.Errorf("unexpected forward subject: %s, skipping", msg.Subject)
+			handlerErrorsTotal.WithLabelValues("forward").Inc()
+			return
+		}
+
+		h(&nats.Msg{Subject: subject, Data: msg.Data})
+	}
+}
+
+// jobReleaseHandler handles a change to the status of a job, releasing any claim this replica
+// holds on the job once the job has finished, and late output has had time to arrive.
+func (m IOManager) jobReleaseHandler(msg *nats.Msg) {
+	id, ok := parseJobID(msg.Subject)
+	if !ok {
+		handlerErrorsTotal.WithLabelValues("release").Inc()
+		return
+	}
+
+	var sm statusMessage
+	if err := json.Unmarshal(msg.Data, &sm); err != nil {
+		logrus.Errorf("malformed job %s status event: %v, skipping", id, err)
+		handlerErrorsTotal.WithLabelValues("release").Inc()
+		return
+	}
+
+	if sm.Status.IsTerminal() {
+		time.AfterFunc(reorderTimeout, func() { m.releaseJob(id) })
+	}
+}
+
+// releaseJob releases any claim this replica holds on the job with the supplied id.
+func (m IOManager) releaseJob(id string) {
+	m.claims.remove(id)
+	m.owners.remove(id)
+
+	if err := m.rc.ReleaseJob(id, m.id); err != nil {
+		logrus.WithField("jobID", id).WithError(err).Warn("failed to release job")
+	}
+}
+
+// renewClaims periodically renews the claims held by this replica until done is closed, at which
+// point the claims are released so that other replicas can take over.
+func (m IOManager) renewClaims() {
+	t := time.NewTicker(claimRenewInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			for _, id := range m.claims.list() {
+				owner, err := m.rc.ClaimJob(id, m.id, claimTTL)
+				if err != nil {
+					logrus.WithField("jobID", id).WithError(err).Warn("failed to renew job claim")
+					continue
+				}
+				if owner != m.id {
+					logrus.WithField("jobID", id).Warn("job claim lost")
+					m.claims.remove(id)
+				}
+			}
+
+		case <-m.done:
+			for _, id := range m.claims.list() {
+				m.releaseJob(id)
+			}
+			return
+		}
+	}
+}
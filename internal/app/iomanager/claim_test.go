@@ -0,0 +1,71 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestParseForwardSubject(t *testing.T) {
+	tests := []struct {
+		name        string
+		subject     string
+		wantSubject string
+		wantOK      bool
+	}{
+		{"Forwarded", forwardSubject("replica", "job.id.stdout"), "job.id.stdout", true},
+		{"OtherReplica", forwardSubject("other", "job.id.stdout"), "", false},
+		{"NotForwarded", "job.id.stdout", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, ok := parseForwardSubject("replica", tt.subject)
+			if got, want := subject, tt.wantSubject; got != want {
+				t.Errorf("got subject %v, want %v", got, want)
+			}
+			if got, want := ok, tt.wantOK; got != want {
+				t.Errorf("got ok %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestForwardedHandler(t *testing.T) {
+	m := IOManager{id: "replica"}
+
+	var got []string
+	h := m.forwardedHandler(map[string]nats.MsgHandler{
+		"stdout": func(msg *nats.Msg) { got = append(got, "stdout "+msg.Subject+" "+string(msg.Data)) },
+		"stderr": func(msg *nats.Msg) { got = append(got, "stderr "+msg.Subject+" "+string(msg.Data)) },
+	})
+
+	h(&nats.Msg{Subject: forwardSubject("replica", "job.id.stdout"), Data: []byte("a")})
+	h(&nats.Msg{Subject: forwardSubject("replica", "job.id.stderr"), Data: []byte("b")})
+	h(&nats.Msg{Subject: forwardSubject("replica", "job.id.unknown"), Data: []byte("c")})
+	h(&nats.Msg{Subject: forwardSubject("other", "job.id.stdout"), Data: []byte("d")})
+
+	want := []string{"stdout job.id.stdout a", "stderr job.id.stderr b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestClaimSet(t *testing.T) {
+	cs := newClaimSet()
+	cs.add("a")
+	cs.add("b")
+	cs.add("a")
+	cs.remove("b")
+	cs.remove("c")
+	cs.add("c")
+
+	got := cs.list()
+	sort.Strings(got)
+	if want := []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -3,33 +3,82 @@
 package iomanager
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/nats-io/nats.go"
 	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
 	"github.com/sylabs/fuzzball-service/internal/pkg/rediskv"
 )
 
+// Persister is the interface that describes what is needed to persist job state.
+type Persister interface {
+	GetJob(context.Context, string) (core.Job, error)
+	SetJobOutputIncomplete(context.Context, string) error
+}
+
 // Config describes the IO manager configuration.
 type Config struct {
-	NATSConn  *nats.Conn
-	RedisConn *rediskv.Connection
+	NATSConn      *nats.Conn
+	RedisConn     *rediskv.Connection
+	Persister     Persister
+	Archive       Archive       // Durable store for output of finished jobs (optional).
+	MaxOutputSize int           // Maximum size of the output of a job, in bytes (zero for no limit).
+	OutputTTL     time.Duration // Time to retain output in Redis once a job finishes (zero for no limit).
+
+	MaxUserOutputSize    int64 // Maximum total size of output of the jobs of a user, in bytes (zero for no limit).
+	MaxProjectOutputSize int64 // Maximum total size of output of the jobs of a project, in bytes (zero for no limit).
 }
 
 // IOManager contains the state of the IO Manager.
 type IOManager struct {
-	nc   *nats.Conn
-	rc   *rediskv.Connection
-	subs []*nats.Subscription
+	nc            *nats.Conn
+	rc            *rediskv.Connection
+	p             Persister
+	a             Archive
+	maxOutputSize int
+	outputTTL     time.Duration
+	id            string // Identifies this replica of the IO manager.
+	claims        *claimSet
+	owners        *ownerCache
+	done          chan struct{}
+	stopOnce      *sync.Once // Held by pointer, as IOManager is passed by value.
+	seq           *sequencer
+	subs          *[]*nats.Subscription // Held by pointer, as IOManager is passed by value.
+
+	maxUserOutputSize    int64
+	maxProjectOutputSize int64
 }
 
 // New returns a new IO Manager.
 func New(c Config) (m IOManager, err error) {
-	return IOManager{
-		c.NATSConn,
-		c.RedisConn,
-		nil,
-	}, nil
+	m = IOManager{
+		nc:            c.NATSConn,
+		rc:            c.RedisConn,
+		p:             c.Persister,
+		a:             c.Archive,
+		maxOutputSize: c.MaxOutputSize,
+		outputTTL:     c.OutputTTL,
+		claims:        newClaimSet(),
+		owners:        newOwnerCache(),
+		done:          make(chan struct{}),
+		stopOnce:      new(sync.Once),
+		subs:          new([]*nats.Subscription),
+
+		maxUserOutputSize:    c.MaxUserOutputSize,
+		maxProjectOutputSize: c.MaxProjectOutputSize,
+	}
+	if m.id, err = newReplicaID(); err != nil {
+		return IOManager{}, fmt.Errorf("failed to generate replica ID: %w", err)
+	}
+	m.seq = newSequencer(reorderTimeout, m.storeJobOutput, m.jobOutputLost)
+	return m, nil
 }
 
 // Start starts the IO Manager by initializing handlers for
@@ -40,31 +89,60 @@ func (m IOManager) Start() {
 		logrus.WithError(err).Warn("failed to subscribe")
 		return
 	}
+
+	go m.renewClaims()
+	go m.releaseExpiredOutput()
+	go m.archivePendingOutput()
 }
 
 // Stop stops the IO Manager by putting NATS subscriptions
-// in a draining state.
-func (m IOManager) Stop() error {
-	return m.unsubscribe()
+// in a draining state, and releasing claimed jobs. Calls after the first have no effect.
+func (m IOManager) Stop() (err error) {
+	m.stopOnce.Do(func() {
+		defer close(m.done)
+
+		err = m.unsubscribe()
+	})
+	return err
 }
 
-// subscribe expresses interest in subjects that are relevant to the IO Manager.
+// subscribe expresses interest in subjects that are relevant to the IO Manager. Job messages are
+// received as part of a queue group, so that replicas of the service share the load. Messages
+// relating to the output of a job are routed to the replica that owns the job.
 func (m IOManager) subscribe() error {
+	// Handlers of messages that are routed to the owner of a job, by event.
+	routed := map[string]nats.MsgHandler{
+		"output":   m.jobOutputHandler,
+		"stdout":   m.jobStreamHandler(core.JobStdout),
+		"stderr":   m.jobStreamHandler(core.JobStderr),
+		"finished": m.jobFinishedHandler,
+	}
+
 	subs := []struct {
 		subject string
+		queue   string
 		handler nats.MsgHandler
 	}{
-		{"job.*.output", m.jobOutputHandler},
+		{"job.*.output", queueGroup, m.route("output", routed["output"])},
+		{"job.*.stdout", queueGroup, m.route("stdout", routed["stdout"])},
+		{"job.*.stderr", queueGroup, m.route("stderr", routed["stderr"])},
+		{"job.*.finished", queueGroup, m.route("finished", routed["finished"])},
+		{"job.*.updated", queueGroup, m.jobUpdatedHandler},
+		{"job.*.updated", "", m.jobReleaseHandler},
+		{forwardSubject(m.id, ">"), "", m.forwardedHandler(routed)},
 	}
 	for _, s := range subs {
-		sub, err := m.nc.Subscribe(s.subject, s.handler)
+		sub, err := m.nc.QueueSubscribe(s.subject, s.queue, s.handler)
 		if err != nil {
 			logrus.WithField("subject", s.subject).WithError(err).Warn("failed to subscribe")
 			return err
 		}
-		logrus.WithField("subject", s.subject).Info("subscribed")
+		logrus.WithFields(logrus.Fields{
+			"subject": s.subject,
+			"queue":   s.queue,
+		}).Info("subscribed")
 
-		m.subs = append(m.subs, sub)
+		*m.subs = append(*m.subs, sub)
 	}
 	return nil
 }
@@ -72,7 +150,7 @@ func (m IOManager) subscribe() error {
 // subscribe removes interest in subjects that are relevant to the IO Manager.
 // NOTE: NATS will continue to handle callbacks until queue is empty.
 func (m IOManager) unsubscribe() error {
-	for _, s := range m.subs {
+	for _, s := range *m.subs {
 		err := s.Drain()
 		if err != nil {
 			logrus.WithField("subject", s.Subject).WithError(err).Warn("failed to unsubscribe")
@@ -83,17 +161,333 @@ func (m IOManager) unsubscribe() error {
 	return nil
 }
 
-// NOTE: If multiple jobOutputHandlers are spun off, output for a job could be placed
-// out of order in Redis.
-func (m IOManager) jobOutputHandler(msg *nats.Msg) {
-	// Parse subject for job ID
-	s := strings.Split(msg.Subject, ".")
+// jobOutputStoredSubject returns the subject on which output of the job with the supplied ID is
+// published once it has been stored.
+func jobOutputStoredSubject(id string) string {
+	return fmt.Sprintf("job.%v.output.stored", id)
+}
+
+// outputMessage is published by agents on the stdout and stderr subjects of a job. Output of each
+// run of a job is numbered in sequence across both streams, starting from one, so that it can be
+// stored in order, and lost output detected. Output without a sequence number is stored as it is
+// received. The attempt number of a run is one more than the number of attempts included in the
+// request to start the job.
+type outputMessage struct {
+	Attempt int       `json:"attempt"` // Attempt number of the run that wrote the output.
+	Seq     uint64    `json:"seq"`     // Sequence number of the output within the run.
+	Time    time.Time `json:"time"`    // When the output was written.
+	Data    string    `json:"data"`    // Output data.
+}
+
+// finishedMessage is published by agents on the finished subject of a job. If the output of the
+// run is numbered in sequence, the sequence number of its last output is included, so that output
+// lost from the end of the run can be detected.
+type finishedMessage struct {
+	Attempt int    `json:"attempt"` // Attempt number of the run that finished.
+	LastSeq uint64 `json:"lastSeq"` // Sequence number of the last output of the run, if any.
+}
+
+// parseJobID parses the job ID from a job subject of the form "job.<id>.<event>".
+func parseJobID(subject string) (string, bool) {
+	s := strings.Split(subject, ".")
 	if len(s) != 3 {
-		logrus.Errorf("malformed job output subject: %s, skipping", msg.Subject)
+		logrus.Errorf("malformed job subject: %s, skipping", subject)
+		return "", false
+	}
+	return s[1], true
+}
+
+// jobOutputHandler handles untimestamped output published on the legacy output subject of a job,
+// which is treated as output written to stdout when it is received.
+func (m IOManager) jobOutputHandler(msg *nats.Msg) {
+	id, ok := parseJobID(msg.Subject)
+	if !ok {
+		return
+	}
+
+	m.storeJobOutput(id, core.JobLogLine{
+		Stream: core.JobStdout,
+		Time:   time.Now(),
+		Data:   string(msg.Data),
+	})
+}
+
+// jobStreamHandler returns a handler for output published on the subject of output stream s of a
+// job.
+func (m IOManager) jobStreamHandler(s core.JobOutputStream) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		id, ok := parseJobID(msg.Subject)
+		if !ok {
+			return
+		}
+
+		var om outputMessage
+		if err := json.Unmarshal(msg.Data, &om); err != nil {
+			logrus.Errorf("malformed job %s output: %v, skipping", id, err)
+			handlerErrorsTotal.WithLabelValues(strings.ToLower(s.String())).Inc()
+			return
+		}
+		if om.Time.IsZero() {
+			om.Time = time.Now()
+		}
+
+		l := core.JobLogLine{
+			Stream: s,
+			Time:   om.Time,
+			Data:   om.Data,
+		}
+		if om.Seq == 0 {
+			m.storeJobOutput(id, l)
+		} else {
+			m.seq.add(runKey{id, om.Attempt}, om.Seq, l)
+		}
+	}
+}
+
+// jobFinishedHandler handles a run of a job finishing, after which no further output is expected
+// from the run.
+func (m IOManager) jobFinishedHandler(msg *nats.Msg) {
+	id, ok := parseJobID(msg.Subject)
+	if !ok {
+		return
+	}
+
+	var fm finishedMessage
+	if err := json.Unmarshal(msg.Data, &fm); err != nil {
+		logrus.Errorf("malformed job %s finished event: %v, skipping", id, err)
+		handlerErrorsTotal.WithLabelValues("finished").Inc()
+		return
+	}
+
+	m.seq.finish(runKey{id, fm.Attempt}, fm.LastSeq)
+}
+
+// jobOutputLost records that output of the job with the supplied id was lost.
+func (m IOManager) jobOutputLost(id string) {
+	if err := m.p.SetJobOutputIncomplete(context.Background(), id); err != nil {
+		logrus.Errorf("failed to flag job %s output incomplete: %v", id, err)
+		handlerErrorsTotal.WithLabelValues("lost").Inc()
+	}
+}
+
+// truncationMarker is appended to the output of a job in place of output that exceeds the
+// maximum output size.
+const truncationMarker = "\n[output truncated]\n"
+
+// capOutput returns the part of data that fits within max bytes of output, given size bytes of
+// output are already stored. If data does not fit, it is truncated at the start of a UTF-8
+// character, so that no character is split, and the truncation marker is appended. Once output has
+// been truncated, ok is false.
+func capOutput(data string, size, max int) (string, bool) {
+	if size > max {
+		return "", false
 	}
+	if size+len(data) > max {
+		n := max - size
+		for n > 0 && !utf8.RuneStart(data[n]) {
+			n--
+		}
+		return data[:n] + truncationMarker, true
+	}
+	return data, true
+}
 
-	id := s[1]
-	if err := m.rc.Append(id, string(msg.Data)); err != nil {
+// storeJobOutput stores output l of the job with the supplied id, both in the combined output of
+// the job and as a record in its log. If a maximum output size is configured, output beyond the
+// limit is discarded. Output is accounted to the user and project the job belongs to, and output
+// beyond their quotas is likewise discarded.
+//
+// NOTE: If multiple handlers are spun off, output for a job could be placed out of order in Redis.
+func (m IOManager) storeJobOutput(id string, l core.JobLogLine) {
+	if m.maxOutputSize > 0 {
+		size, err := m.rc.GetJobOutputSize(id)
+		if err != nil {
+			logrus.Errorf("failed to get job %s output size: %v", id, err)
+			handlerErrorsTotal.WithLabelValues("store").Inc()
+			return
+		}
+
+		var ok bool
+		if l.Data, ok = capOutput(l.Data, int(size), m.maxOutputSize); !ok {
+			return
+		}
+	}
+
+	// If the owner of the job cannot be determined, the output is stored without being accounted.
+	o, err := m.jobOwner(id)
+	if err != nil {
+		logrus.Errorf("failed to get job %s owner: %v", id, err)
+		handlerErrorsTotal.WithLabelValues("store").Inc()
+	} else {
+		var ok bool
+		l.Data, ok, err = m.capOutputQuota(o, l.Data)
+		if err != nil {
+			logrus.Errorf("failed to get job %s owner output size: %v", id, err)
+			handlerErrorsTotal.WithLabelValues("store").Inc()
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+
+	n, err := m.rc.AppendJobOutput(id, l.Data)
+	if err != nil {
 		logrus.Errorf("failed to append job %s output: %v", id, err)
+		handlerErrorsTotal.WithLabelValues("store").Inc()
+		return
+	}
+	if o.UserID != "" {
+		m.chargeOutput(id, o, len(l.Data))
+	}
+	stream := strings.ToLower(l.Stream.String())
+	outputChunksTotal.WithLabelValues(stream).Inc()
+	outputBytesTotal.WithLabelValues(stream).Add(float64(len(l.Data)))
+
+	if _, err := m.rc.AddJobLogLine(id, l); err != nil {
+		logrus.Errorf("failed to add job %s log line: %v", id, err)
+		handlerErrorsTotal.WithLabelValues("store").Inc()
+	}
+
+	// Publish the stored chunk, along with its offset, to those watching the job output.
+	b, err := json.Marshal(core.JobOutputChunk{
+		Offset: int(n) - len(l.Data),
+		Data:   l.Data,
+	})
+	if err != nil {
+		logrus.Errorf("failed to encode job %s output: %v", id, err)
+		handlerErrorsTotal.WithLabelValues("store").Inc()
+		return
+	}
+	if err := m.nc.Publish(jobOutputStoredSubject(id), b); err != nil {
+		logrus.Errorf("failed to publish job %s output: %v", id, err)
+		handlerErrorsTotal.WithLabelValues("store").Inc()
+	}
+}
+
+// GetJobOutput retrieves the stored output of the job with the supplied id. If the output is no
+// longer held in Redis, it is retrieved from the archive.
+func (m IOManager) GetJobOutput(id string) (string, error) {
+	n, err := m.GetJobOutputSize(id)
+	if err != nil {
+		return "", err
+	}
+	return m.GetJobOutputRange(id, 0, n)
+}
+
+// GetJobOutputRange retrieves up to limit bytes of the stored output of the job with the supplied
+// id, starting at byte offset. If the output is no longer held in Redis, it is retrieved from the
+// archive.
+func (m IOManager) GetJobOutputRange(id string, offset, limit int) (string, error) {
+	n, err := m.rc.GetJobOutputSize(id)
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		size, err := m.getArchivedJobOutputSize(id)
+		if err != nil {
+			return "", err
+		}
+		if size > 0 {
+			return m.a.GetArchivedJobOutputRange(id, offset, limit)
+		}
+	}
+	return m.rc.GetJobOutputRange(id, int64(offset), int64(limit))
+}
+
+// GetJobOutputSize returns the size of the stored output of the job with the supplied id, in
+// bytes. If the output is no longer held in Redis, the size of the archived output is returned.
+func (m IOManager) GetJobOutputSize(id string) (int, error) {
+	n, err := m.rc.GetJobOutputSize(id)
+	if err != nil || n > 0 {
+		return int(n), err
 	}
+	return m.getArchivedJobOutputSize(id)
+}
+
+// GetJobStreamOutput retrieves the output the job with the supplied id wrote to stream s. If the
+// output is no longer held in Redis, it is retrieved from the archive.
+func (m IOManager) GetJobStreamOutput(id string, s core.JobOutputStream) (string, error) {
+	out, err := m.rc.GetJobStreamOutput(id, s)
+	if err != nil || out != "" {
+		return out, err
+	}
+
+	key := streamKey(id, s)
+	n, err := m.getArchivedJobOutputSize(key)
+	if err != nil || n == 0 {
+		return "", err
+	}
+	return m.a.GetArchivedJobOutputRange(key, 0, n)
+}
+
+// GetJobLogLines retrieves a page of the log of the job with the supplied id. If the log is no
+// longer held in Redis, it is retrieved from the archive.
+func (m IOManager) GetJobLogLines(id string, pa core.PageArgs) (core.JobLogLinesPage, error) {
+	p, err := m.rc.GetJobLogLines(id, pa)
+	if err != nil || p.TotalCount > 0 {
+		return p, err
+	}
+
+	key := logKey(id)
+	n, err := m.getArchivedJobOutputSize(key)
+	if err != nil || n == 0 {
+		return p, err
+	}
+	s, err := m.a.GetArchivedJobOutputRange(key, 0, n)
+	if err != nil {
+		return core.JobLogLinesPage{}, err
+	}
+	ls, err := readArchivedLog(strings.NewReader(s))
+	if err != nil {
+		return core.JobLogLinesPage{}, err
+	}
+	return rediskv.PageJobLogLines(ls, pa)
+}
+
+// WatchJobOutput returns a channel on which output of the job with the supplied id is sent as it
+// is stored. Chunks are dropped if the receiver does not keep up, in which case a later chunk
+// will not follow on from the last one received. The channel is closed once ctx is done.
+func (m IOManager) WatchJobOutput(ctx context.Context, id string) (<-chan core.JobOutputChunk, error) {
+	c := make(chan core.JobOutputChunk, 64)
+
+	var mu sync.Mutex
+	closed := false
+
+	sub, err := m.nc.Subscribe(jobOutputStoredSubject(id), func(msg *nats.Msg) {
+		var ch core.JobOutputChunk
+		if err := json.Unmarshal(msg.Data, &ch); err != nil {
+			logrus.Errorf("malformed job %s output: %v, skipping", id, err)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !closed {
+			select {
+			case c <- ch:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		if err := sub.Unsubscribe(); err != nil {
+			logrus.WithField("subject", sub.Subject).WithError(err).Warn("failed to unsubscribe")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		closed = true
+		close(c)
+	}()
+
+	return c, nil
 }
@@ -0,0 +1,27 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"testing"
+)
+
+func TestStop(t *testing.T) {
+	m, err := New(Config{})
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// Stopping more than once should have no further effect.
+	for i := 0; i < 2; i++ {
+		if err := m.Stop(); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	}
+
+	select {
+	case <-m.done:
+	default:
+		t.Error("done not closed")
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metricsNamespace = "fuzzballserver"
+	metricsSubsystem = "iomanager"
+)
+
+var (
+	outputChunksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "output_chunks_total",
+		Help:      "Total number of job output chunks stored by stream.",
+	}, []string{"stream"})
+	outputBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "output_bytes_total",
+		Help:      "Total number of job output bytes stored by stream.",
+	}, []string{"stream"})
+	forwardedMessagesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "forwarded_messages_total",
+		Help:      "Total number of job messages forwarded to the replica that owns the job.",
+	})
+	handlerErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "handler_errors_total",
+		Help:      "Total number of errors handling job messages by handler.",
+	}, []string{"handler"})
+)
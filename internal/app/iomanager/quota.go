@@ -0,0 +1,166 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"github.com/sylabs/fuzzball-service/internal/pkg/rediskv"
+)
+
+// outputReleaseInterval is the interval at which output that has expired is removed from the
+// output quotas of its owner.
+const outputReleaseInterval = time.Minute
+
+// ownerCache caches the owners of jobs whose output is being stored.
+type ownerCache struct {
+	mu     sync.Mutex
+	owners map[string]rediskv.OutputOwner
+}
+
+func newOwnerCache() *ownerCache {
+	return &ownerCache{owners: make(map[string]rediskv.OutputOwner)}
+}
+
+func (oc *ownerCache) get(id string) (rediskv.OutputOwner, bool) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	o, ok := oc.owners[id]
+	return o, ok
+}
+
+func (oc *ownerCache) set(id string, o rediskv.OutputOwner) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	oc.owners[id] = o
+}
+
+func (oc *ownerCache) remove(id string) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	delete(oc.owners, id)
+}
+
+// outputOwner returns the owner that output of job j is accounted to.
+func outputOwner(j core.Job) rediskv.OutputOwner {
+	return rediskv.OutputOwner{UserID: j.CreatedByID, ProjectID: j.ProjectID}
+}
+
+// jobOwner returns the owner of the job with the supplied id.
+func (m IOManager) jobOwner(id string) (rediskv.OutputOwner, error) {
+	if o, ok := m.owners.get(id); ok {
+		return o, nil
+	}
+
+	j, err := m.p.GetJob(context.Background(), id)
+	if err != nil {
+		return rediskv.OutputOwner{}, err
+	}
+
+	o := outputOwner(j)
+	m.owners.set(id, o)
+	return o, nil
+}
+
+// capOutputQuota returns the part of data that fits within the output quotas of owner o. If data
+// does not fit, it is truncated and the truncation marker is appended. Once the output of the
+// owner exceeds a quota, ok is false.
+func (m IOManager) capOutputQuota(o rediskv.OutputOwner, data string) (string, bool, error) {
+	if m.maxUserOutputSize > 0 {
+		size, err := m.rc.GetUserOutputSize(o.UserID)
+		if err != nil {
+			return "", false, err
+		}
+
+		var ok bool
+		if data, ok = capOutput(data, int(size), int(m.maxUserOutputSize)); !ok {
+			return "", false, nil
+		}
+	}
+
+	if m.maxProjectOutputSize > 0 && o.ProjectID != "" {
+		size, err := m.rc.GetProjectOutputSize(o.ProjectID)
+		if err != nil {
+			return "", false, err
+		}
+
+		var ok bool
+		if data, ok = capOutput(data, int(size), int(m.maxProjectOutputSize)); !ok {
+			return "", false, nil
+		}
+	}
+	return data, true, nil
+}
+
+// chargeOutput adds n bytes of output of the job with the supplied id to the total size of output
+// stored for jobs of owner o.
+func (m IOManager) chargeOutput(id string, o rediskv.OutputOwner, n int) {
+	if err := m.rc.ChargeJobOutput(id, o, int64(n)); err != nil {
+		logrus.WithField("jobID", id).WithError(err).Warn("failed to charge job output")
+	}
+}
+
+// releaseExpiredOutput periodically removes output that has expired from the output quotas of
+// its owner, until done is closed. Releases are recorded in Redis, so that they take effect even
+// if the replica that set the output to expire has stopped.
+func (m IOManager) releaseExpiredOutput() {
+	t := time.NewTicker(outputReleaseInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if _, err := m.rc.ReleaseDueJobOutput(time.Now()); err != nil {
+				logrus.WithError(err).Warn("failed to release expired job output")
+			}
+
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// DeleteJobOutput deletes the output of job j, including the output of previous attempts, both
+// held in Redis and archived, and removes it from the output quotas of its owner.
+func (m IOManager) DeleteJobOutput(j core.Job) error {
+	for _, key := range jobOutputKeys(j) {
+		if err := m.rc.DeleteJobOutput(key); err != nil {
+			return fmt.Errorf("failed to delete job output: %w", err)
+		}
+		if m.a == nil {
+			continue
+		}
+		for _, ak := range archivedOutputKeys(key) {
+			if err := m.a.DeleteArchivedJobOutput(ak); err != nil {
+				return fmt.Errorf("failed to delete archived job output: %w", err)
+			}
+		}
+	}
+	m.owners.remove(j.ID)
+
+	// Once deleted, there is no output left to archive.
+	if err := m.rc.RemoveJobOutputArchival(j.ID); err != nil {
+		return fmt.Errorf("failed to remove job output archival: %w", err)
+	}
+	return m.rc.ReleaseJobOutput(j.ID, outputOwner(j))
+}
+
+// GetUserOutputSize returns the total size of output stored for jobs of the user with the
+// supplied id, in bytes.
+func (m IOManager) GetUserOutputSize(id string) (int64, error) {
+	return m.rc.GetUserOutputSize(id)
+}
+
+// GetProjectOutputSize returns the total size of output stored for jobs of the project with the
+// supplied id, in bytes.
+func (m IOManager) GetProjectOutputSize(id string) (int64, error) {
+	return m.rc.GetProjectOutputSize(id)
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"testing"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/rediskv"
+)
+
+func TestOwnerCache(t *testing.T) {
+	oc := newOwnerCache()
+	oc.set("a", rediskv.OutputOwner{UserID: "u1"})
+	oc.set("b", rediskv.OutputOwner{UserID: "u2", ProjectID: "p"})
+	oc.remove("a")
+	oc.remove("c")
+
+	if _, ok := oc.get("a"); ok {
+		t.Error("removed owner found")
+	}
+
+	o, ok := oc.get("b")
+	if !ok {
+		t.Fatal("owner not found")
+	}
+	if got, want := o, (rediskv.OutputOwner{UserID: "u2", ProjectID: "p"}); got != want {
+		t.Errorf("got owner %v, want %v", got, want)
+	}
+}
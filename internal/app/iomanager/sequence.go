@@ -0,0 +1,268 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// reorderTimeout is how long out-of-sequence output is held awaiting the output that precedes it,
+// before the missing output is considered lost. It is also how long the sequence of a run is
+// retained after the run finishes, to allow for output that arrives late.
+const reorderTimeout = 5 * time.Second
+
+// maxPendingOutput is the maximum number of out-of-sequence outputs held for a run of a job, beyond
+// which missing output is considered lost.
+const maxPendingOutput = 1024
+
+// runKey identifies a run (attempt) of a job.
+type runKey struct {
+	id      string
+	attempt int
+}
+
+// runSequence tracks the sequence of output received for a run of a job.
+type runSequence struct {
+	mu       sync.Mutex
+	next     uint64                     // Sequence number of the next output to store.
+	pending  map[uint64]core.JobLogLine // Out-of-sequence output, by sequence number.
+	timer    *time.Timer                // Pending expiry, if any.
+	gen      int                        // Incremented each time timer is set.
+	finished bool                       // Set once the run has finished.
+	last     uint64                     // Sequence number of the last output of the run, if known.
+	removed  bool                       // Set once the sequence has been discarded.
+}
+
+// sequencer orders output received for runs of jobs by sequence number before storing it,
+// detecting output that is lost along the way.
+type sequencer struct {
+	timeout time.Duration
+	store   func(id string, l core.JobLogLine) // Stores output of a job, in sequence.
+	lost    func(id string)                    // Records that output of a job was lost.
+
+	mu      sync.Mutex
+	runs    map[runKey]*runSequence
+	current map[string]int // Latest attempt of each job with a sequence, by job ID.
+}
+
+// newSequencer returns a sequencer that stores output using store, and records lost output using
+// lost. Out-of-sequence output is held for up to timeout.
+func newSequencer(timeout time.Duration, store func(string, core.JobLogLine), lost func(string)) *sequencer {
+	return &sequencer{
+		timeout: timeout,
+		store:   store,
+		lost:    lost,
+		runs:    make(map[runKey]*runSequence),
+		current: make(map[string]int),
+	}
+}
+
+// get returns the sequence of run k, creating it if necessary. The sequence is returned locked. If
+// a later attempt of the job has started, run k is stale, and nil is returned. Otherwise, the
+// sequences of earlier attempts are discarded, along with any output they still hold, since it
+// would otherwise be stored as output of the later attempt.
+func (s *sequencer) get(k runKey) *runSequence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cur, ok := s.current[k.id]; ok && k.attempt != cur {
+		if k.attempt < cur {
+			return nil
+		}
+		if rs, ok := s.runs[runKey{k.id, cur}]; ok {
+			rs.mu.Lock()
+			if n := len(rs.pending); n > 0 {
+				logrus.Warnf("job %s attempt %d superseded, discarding %d pending outputs", k.id, cur, n)
+			}
+			s.remove(runKey{k.id, cur}, rs)
+			rs.mu.Unlock()
+		}
+	}
+	s.current[k.id] = k.attempt
+
+	rs, ok := s.runs[k]
+	if ok {
+		rs.mu.Lock()
+		if !rs.removed {
+			return rs
+		}
+		rs.mu.Unlock()
+	}
+
+	rs = &runSequence{
+		next:    1,
+		pending: make(map[uint64]core.JobLogLine),
+	}
+	s.runs[k] = rs
+	rs.mu.Lock()
+	return rs
+}
+
+// remove discards the sequence rs of run k. s.mu and rs must be locked.
+func (s *sequencer) remove(k runKey, rs *runSequence) {
+	if rs.timer != nil {
+		rs.timer.Stop()
+		rs.timer = nil
+	}
+	rs.removed = true
+
+	if s.runs[k] == rs {
+		delete(s.runs, k)
+		if s.current[k.id] == k.attempt {
+			delete(s.current, k.id)
+		}
+	}
+}
+
+// add adds output l, with sequence number seq, to run k. Output is stored once all output that
+// precedes it has been stored, or considered lost. Output of an attempt that has been superseded
+// by a later attempt of the job is discarded.
+func (s *sequencer) add(k runKey, seq uint64, l core.JobLogLine) {
+	rs := s.get(k)
+	if rs == nil {
+		logrus.Warnf("job %s output %d of superseded attempt %d, skipping", k.id, seq, k.attempt)
+		return
+	}
+	defer rs.mu.Unlock()
+
+	if _, ok := rs.pending[seq]; ok || seq < rs.next {
+		logrus.Warnf("duplicate job %s output %d, skipping", k.id, seq)
+		return
+	}
+
+	if seq > rs.next {
+		rs.pending[seq] = l
+		if len(rs.pending) > maxPendingOutput {
+			s.skip(k, rs)
+		}
+		if len(rs.pending) > 0 && rs.timer == nil {
+			s.arm(k, rs)
+		}
+		return
+	}
+
+	s.store(k.id, l)
+	rs.next++
+	s.flush(k, rs)
+}
+
+// flush stores pending output of run k that is now in sequence. rs must be locked.
+func (s *sequencer) flush(k runKey, rs *runSequence) {
+	for {
+		l, ok := rs.pending[rs.next]
+		if !ok {
+			break
+		}
+		delete(rs.pending, rs.next)
+
+		s.store(k.id, l)
+		rs.next++
+	}
+
+	// Once nothing is pending, expiry is only needed to discard the sequence of a finished run.
+	if len(rs.pending) == 0 && !rs.finished && rs.timer != nil {
+		rs.timer.Stop()
+		rs.timer = nil
+	}
+}
+
+// skip considers output missing from the start of the pending output of run k to be lost, and
+// stores pending output that follows it. rs must be locked.
+func (s *sequencer) skip(k runKey, rs *runSequence) {
+	first := true
+	for seq := range rs.pending {
+		if first || seq < rs.next {
+			rs.next, first = seq, false
+		}
+	}
+
+	logrus.Warnf("job %s output lost", k.id)
+	s.lost(k.id)
+
+	s.flush(k, rs)
+}
+
+// arm sets the expiry timer of run k. rs must be locked.
+func (s *sequencer) arm(k runKey, rs *runSequence) {
+	rs.gen++
+	gen := rs.gen
+	rs.timer = time.AfterFunc(s.timeout, func() { s.expire(k, rs, gen) })
+}
+
+// expire handles expiry of the timer of run k. Output still pending is stored, with the output
+// missing before it considered lost. If the run has finished and no output remains pending, output
+// missing from the end of the run is considered lost, and its sequence is discarded.
+func (s *sequencer) expire(k runKey, rs *runSequence, gen int) {
+	rs.mu.Lock()
+	if rs.gen != gen || rs.timer == nil {
+		rs.mu.Unlock()
+		return
+	}
+	rs.timer = nil
+
+	if len(rs.pending) > 0 {
+		s.skip(k, rs)
+	}
+
+	if len(rs.pending) > 0 {
+		s.arm(k, rs)
+		rs.mu.Unlock()
+		return
+	}
+	if !rs.finished {
+		rs.mu.Unlock()
+		return
+	}
+
+	if rs.next <= rs.last {
+		logrus.Warnf("job %s output lost", k.id)
+		s.lost(k.id)
+		rs.next = rs.last + 1
+	}
+	rs.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.gen == gen {
+		s.remove(k, rs)
+	}
+}
+
+// finish records that run k has finished, with last the sequence number of its last output, or
+// zero if not known. Its sequence is discarded once any late output has had time to arrive, at
+// which point output still missing up to last is considered lost.
+func (s *sequencer) finish(k runKey, last uint64) {
+	if last == 0 {
+		s.mu.Lock()
+		_, ok := s.runs[k]
+		s.mu.Unlock()
+
+		// With no output expected, there is nothing to detect.
+		if !ok {
+			return
+		}
+	}
+
+	rs := s.get(k)
+	if rs == nil {
+		return
+	}
+	defer rs.mu.Unlock()
+
+	rs.finished = true
+	rs.last = last
+
+	// Allow late output the full timeout to arrive, even if the timer is already set.
+	if rs.timer != nil {
+		rs.timer.Stop()
+	}
+	s.arm(k, rs)
+}
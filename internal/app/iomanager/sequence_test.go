@@ -0,0 +1,211 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package iomanager
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+const testReorderTimeout = 10 * time.Millisecond
+
+// recorder records output stored, and output lost, by a sequencer.
+type recorder struct {
+	mu     sync.Mutex
+	stored []string
+	lost   int
+}
+
+func (r *recorder) store(id string, l core.JobLogLine) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stored = append(r.stored, l.Data)
+}
+
+func (r *recorder) lose(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lost++
+}
+
+func (r *recorder) get() ([]string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stored, r.lost
+}
+
+func TestSequencerAdd(t *testing.T) {
+	tests := []struct {
+		name       string
+		seqs       []uint64
+		wantStored []string
+		wantLost   int
+	}{
+		{"InOrder", []uint64{1, 2, 3}, []string{"1", "2", "3"}, 0},
+		{"Reordered", []uint64{3, 1, 2}, []string{"1", "2", "3"}, 0},
+		{"Duplicate", []uint64{1, 1, 2, 2}, []string{"1", "2"}, 0},
+		{"Gap", []uint64{1, 3, 4}, []string{"1", "3", "4"}, 1},
+		{"Gaps", []uint64{1, 3, 5}, []string{"1", "3", "5"}, 2},
+		{"MissingFirst", []uint64{2, 3}, []string{"2", "3"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r recorder
+			s := newSequencer(testReorderTimeout, r.store, r.lose)
+
+			for _, seq := range tt.seqs {
+				s.add(runKey{"jobID", 1}, seq, core.JobLogLine{Data: strconv.FormatUint(seq, 10)})
+			}
+
+			// Allow time for missing output to be considered lost.
+			time.Sleep(5 * testReorderTimeout)
+
+			stored, lost := r.get()
+			if got, want := stored, tt.wantStored; !reflect.DeepEqual(got, want) {
+				t.Errorf("got stored %v, want %v", got, want)
+			}
+			if got, want := lost, tt.wantLost; got != want {
+				t.Errorf("got lost %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSequencerMaxPending(t *testing.T) {
+	var r recorder
+	s := newSequencer(time.Hour, r.store, r.lose)
+
+	// Exceeding the pending limit should consider the missing output lost without waiting.
+	for seq := uint64(2); seq <= maxPendingOutput+2; seq++ {
+		s.add(runKey{"jobID", 1}, seq, core.JobLogLine{})
+	}
+
+	stored, lost := r.get()
+	if got, want := len(stored), maxPendingOutput+1; got != want {
+		t.Errorf("got %v stored, want %v", got, want)
+	}
+	if got, want := lost, 1; got != want {
+		t.Errorf("got lost %v, want %v", got, want)
+	}
+}
+
+func TestSequencerAttempts(t *testing.T) {
+	var r recorder
+	s := newSequencer(testReorderTimeout, r.store, r.lose)
+
+	// Each attempt should be sequenced independently.
+	s.add(runKey{"jobID", 1}, 1, core.JobLogLine{Data: "a"})
+	s.add(runKey{"jobID", 1}, 2, core.JobLogLine{Data: "b"})
+	s.add(runKey{"jobID", 2}, 1, core.JobLogLine{Data: "c"})
+
+	stored, lost := r.get()
+	if got, want := stored, []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stored %v, want %v", got, want)
+	}
+	if got, want := lost, 0; got != want {
+		t.Errorf("got lost %v, want %v", got, want)
+	}
+}
+
+func TestSequencerFinish(t *testing.T) {
+	var r recorder
+	s := newSequencer(testReorderTimeout, r.store, r.lose)
+
+	k := runKey{"jobID", 1}
+	s.add(k, 1, core.JobLogLine{Data: "a"})
+	s.add(k, 3, core.JobLogLine{Data: "c"})
+	s.finish(k, 3)
+
+	// Output arriving shortly after the run finishes should still be sequenced.
+	s.add(k, 2, core.JobLogLine{Data: "b"})
+
+	// The sequence should be discarded once the run has finished.
+	time.Sleep(5 * testReorderTimeout)
+
+	s.mu.Lock()
+	n := len(s.runs)
+	s.mu.Unlock()
+
+	if got, want := n, 0; got != want {
+		t.Errorf("got %v runs, want %v", got, want)
+	}
+
+	stored, lost := r.get()
+	if got, want := stored, []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stored %v, want %v", got, want)
+	}
+	if got, want := lost, 0; got != want {
+		t.Errorf("got lost %v, want %v", got, want)
+	}
+}
+
+func TestSequencerFinishLost(t *testing.T) {
+	tests := []struct {
+		name       string
+		seqs       []uint64
+		last       uint64
+		wantStored []string
+		wantLost   int
+	}{
+		{"Complete", []uint64{1, 2}, 2, []string{"1", "2"}, 0},
+		{"Unknown", []uint64{1, 2}, 0, []string{"1", "2"}, 0},
+		{"MissingLast", []uint64{1}, 3, []string{"1"}, 1},
+		{"MissingAll", nil, 2, nil, 1},
+		{"GapAndMissingLast", []uint64{1, 3}, 4, []string{"1", "3"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r recorder
+			s := newSequencer(testReorderTimeout, r.store, r.lose)
+
+			k := runKey{"jobID", 1}
+			for _, seq := range tt.seqs {
+				s.add(k, seq, core.JobLogLine{Data: strconv.FormatUint(seq, 10)})
+			}
+			s.finish(k, tt.last)
+
+			// Allow time for missing output to be considered lost.
+			time.Sleep(5 * testReorderTimeout)
+
+			stored, lost := r.get()
+			if got, want := stored, tt.wantStored; !reflect.DeepEqual(got, want) {
+				t.Errorf("got stored %v, want %v", got, want)
+			}
+			if got, want := lost, tt.wantLost; got != want {
+				t.Errorf("got lost %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSequencerSupersededAttempt(t *testing.T) {
+	var r recorder
+	s := newSequencer(testReorderTimeout, r.store, r.lose)
+
+	// Output of the first attempt is pending when the second attempt starts.
+	s.add(runKey{"jobID", 1}, 1, core.JobLogLine{Data: "a"})
+	s.add(runKey{"jobID", 1}, 3, core.JobLogLine{Data: "c"})
+	s.add(runKey{"jobID", 2}, 1, core.JobLogLine{Data: "d"})
+
+	// Output of the first attempt arriving late should not be stored as output of the second.
+	s.add(runKey{"jobID", 1}, 2, core.JobLogLine{Data: "b"})
+	s.finish(runKey{"jobID", 1}, 3)
+
+	time.Sleep(5 * testReorderTimeout)
+
+	stored, lost := r.get()
+	if got, want := stored, []string{"a", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stored %v, want %v", got, want)
+	}
+	if got, want := lost, 0; got != want {
+		t.Errorf("got lost %v, want %v", got, want)
+	}
+}
@@ -3,12 +3,15 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
 	"github.com/friendsofgo/graphiql"
+	"github.com/graph-gophers/graphql-transport-ws/graphqlws"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/token"
 )
 
 // getMetricsHandler returns a Prometheus metrics handler.
@@ -25,7 +28,17 @@ func (*Server) getMetricsHandler(c Config) (http.Handler, error) {
 	return http.HandlerFunc(h), nil
 }
 
-// getGraphQLHandler returns a GraphQL handler.
+// tokenContext returns a context containing the token found in the context of r, if any. It is
+// used to authenticate subscriptions, which outlive the request used to establish them.
+func tokenContext(ctx context.Context, r *http.Request) (context.Context, error) {
+	if t, ok := token.FromContext(r.Context()); ok {
+		ctx = token.NewContext(ctx, t)
+	}
+	return ctx, nil
+}
+
+// getGraphQLHandler returns a GraphQL handler. Queries and mutations are served over HTTP, and
+// subscriptions over a WebSocket using the graphql-ws protocol.
 func (s *Server) getGraphQLHandler(c Config) (http.Handler, error) {
 	h := func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -50,7 +63,9 @@ func (s *Server) getGraphQLHandler(c Config) (http.Handler, error) {
 			logrus.WithError(err).Warning("failed to write response")
 		}
 	}
-	return http.HandlerFunc(h), nil
+	return graphqlws.NewHandlerFunc(s.schema, http.HandlerFunc(h),
+		graphqlws.WithContextGenerator(graphqlws.ContextGeneratorFunc(tokenContext)),
+	), nil
 }
 
 // getGraphiQLHandler returns a GraphiQL handler.
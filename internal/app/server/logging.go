@@ -3,6 +3,8 @@
 package server
 
 import (
+	"bufio"
+	"errors"
 	"net"
 	"net/http"
 	"strings"
@@ -30,6 +32,16 @@ func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Hijack lets the caller take over the connection, as is required to upgrade to a WebSocket.
+func (lw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	lw.code = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
 // remoteIP attempts to find the remote IP associated with a HTTP request.
 func remoteIP(req *http.Request) string {
 	if ip := req.Header.Get("X-Forwarded-For"); ip != "" {
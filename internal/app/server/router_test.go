@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/gorilla/websocket"
 )
 
 const (
@@ -257,3 +260,32 @@ func TestRouterNotFound(t *testing.T) {
 		t.Fatalf("unexpected status code: %v/%v", res.StatusCode, http.StatusNotFound)
 	}
 }
+
+func TestRouterGraphQLWebSocket(t *testing.T) {
+	sr := Server{}
+	h, err := sr.NewRouter(Config{})
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	// Wrap the router as the server does, to ensure the connection can be upgraded through it.
+	s := httptest.NewServer(loggingHandler(h))
+	defer s.Close()
+
+	d := websocket.Dialer{
+		Subprotocols: []string{"graphql-ws"},
+	}
+	url := fmt.Sprintf("%s%s", strings.Replace(s.URL, "http", "ws", 1), "/graphql")
+	c, res, err := d.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer c.Close()
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code: %v/%v", res.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got, want := c.Subprotocol(), "graphql-ws"; got != want {
+		t.Errorf("got subprotocol %v, want %v", got, want)
+	}
+}
@@ -16,6 +16,17 @@ import (
 var (
 	// ErrNotAuthenticated is returned when authentication is required but not supplied.
 	ErrNotAuthenticated = errors.New("not authenticated")
+	// ErrForbidden is returned when the authenticated user does not have access to a resource.
+	ErrForbidden = errors.New("forbidden")
+	// ErrWorkflowNotRunning is returned when a workflow is not being run by the scheduler.
+	ErrWorkflowNotRunning = errors.New("workflow not running")
+	// ErrWorkflowRunning is returned when an operation requires a workflow that is not being run
+	// by the scheduler.
+	ErrWorkflowRunning = errors.New("workflow still running")
+	// ErrWorkflowFinished is returned when an operation requires a workflow that has not finished.
+	ErrWorkflowFinished = errors.New("workflow already finished")
+	// ErrJobFinished is returned when an operation requires a job that has not finished.
+	ErrJobFinished = errors.New("job already finished")
 )
 
 // Persister is the interface by which all data is persisted.
@@ -23,16 +34,27 @@ type Persister interface {
 	WorkflowPersister
 	JobPersister
 	VolumePersister
+	ProjectPersister
+	SecretPersister
 }
 
 // IOFetcher is the interface where IO data is retrieved.
 type IOFetcher interface {
 	JobOutputFetcher
+	JobOutputDeleter
+	OutputUsageFetcher
 }
 
 // Scheduler is the interface by which all workflows are scheduled.
 type Scheduler interface {
 	AddWorkflow(context.Context, Workflow, []Job, map[string]Volume) error
+	CancelWorkflow(context.Context, Workflow) error
+	CancelJob(context.Context, Job) error
+	WatchWorkflow(context.Context, string) (<-chan struct{}, error)
+	WatchJob(context.Context, string) (<-chan struct{}, error)
+	JobQueuePosition(context.Context, string) (int, bool)
+	UserUsage(context.Context, string) (Usage, error)
+	ProjectUsage(context.Context, string) (Usage, error)
 }
 
 // Core represents core business logic.
@@ -41,6 +63,8 @@ type Core struct {
 	f  IOFetcher
 	s  Scheduler
 	bi BuildInfo
+	ul Limits // Limits on the resources consumed by each user.
+	pl Limits // Limits on the resources consumed by each project.
 }
 
 // OptGitVersion sets the core version to v.
@@ -136,17 +160,37 @@ func (c *Core) Viewer(ctx context.Context) (User, error) {
 
 // WorkflowSpec represents a workflow specification.
 type WorkflowSpec struct {
-	Name    string        `bson:"name"`
-	Jobs    []jobSpec     `bson:"jobs"`
-	Volumes *[]volumeSpec `bson:"volumes"`
+	Name           string        `bson:"name"`
+	Jobs           []jobSpec     `bson:"jobs"`
+	Volumes        *[]volumeSpec `bson:"volumes"`
+	Env            *[]envVarSpec `bson:"env"`
+	MaxConcurrency *int32        `bson:"maxConcurrency"`
+	Timeout        *string       `bson:"timeout"`
+	Priority       *int32        `bson:"priority"`
+	ProjectID      *string       `bson:"projectID"`
 }
 
 type jobSpec struct {
-	Name     string                   `bson:"name"`
-	Image    string                   `bson:"image"`
-	Command  []string                 `bson:"command"`
-	Requires *[]string                `bson:"requires"`
-	Volumes  *[]volumeRequirementSpec `bson:"volumes"`
+	Name           string                   `bson:"name"`
+	Image          string                   `bson:"image"`
+	Command        []string                 `bson:"command"`
+	Requires       *[]string                `bson:"requires"`
+	Volumes        *[]volumeRequirementSpec `bson:"volumes"`
+	Retries        *int32                   `bson:"retries"`
+	RetryBackoff   *string                  `bson:"retryBackoff"`
+	RetryExitCodes *[]int32                 `bson:"retryExitCodes"`
+	Timeout        *string                  `bson:"timeout"`
+	Env            *[]envVarSpec            `bson:"env"`
+	Resources      *resourcesSpec           `bson:"resources"`
+	NodeLabels     *[]nodeLabelSpec         `bson:"nodeLabels"`
+	Priority       *int32                   `bson:"priority"`
+	Array          *arraySpec               `bson:"array"`
+	When           *string                  `bson:"when"`
+}
+
+type arraySpec struct {
+	Count  *int32    `bson:"count"`
+	Values *[]string `bson:"values"`
 }
 
 type volumeRequirementSpec struct {
@@ -154,28 +198,81 @@ type volumeRequirementSpec struct {
 	Location string
 }
 
+type resourcesSpec struct {
+	CPUs     *int32  `bson:"cpus"`
+	Memory   *string `bson:"memory"`
+	Walltime *string `bson:"walltime"`
+}
+
+type nodeLabelSpec struct {
+	Key   string `bson:"key"`
+	Value string `bson:"value"`
+}
+
+type envVarSpec struct {
+	Name   string  `bson:"name"`
+	Value  *string `bson:"value"`
+	Secret *string `bson:"secret"`
+}
+
 // CreateWorkflow creates a new workflow. If an ID is provided in w, it is ignored and replaced
-// with a unique identifier in the returned workflow.
+// with a unique identifier in the returned workflow. The workflow is owned by the authenticated
+// user. If s targets a project, the authenticated user must be permitted to submit workflows to
+// it. If the workflow would exceed the quota of the user or project, a *QuotaExceededError is
+// returned.
 func (c *Core) CreateWorkflow(ctx context.Context, s WorkflowSpec) (Workflow, error) {
-	if _, ok := token.FromContext(ctx); !ok {
-		return Workflow{}, ErrNotAuthenticated
+	u, err := c.Viewer(ctx)
+	if err != nil {
+		return Workflow{}, err
+	}
+
+	nw := Workflow{
+		Name:           s.Name,
+		Status:         WorkflowPending,
+		CreatedByID:    u.ID,
+		CreatedByLogin: u.Login,
+	}
+	if s.ProjectID != nil {
+		p, err := c.getProject(ctx, *s.ProjectID, ProjectSubmitter)
+		if err != nil {
+			return Workflow{}, err
+		}
+		nw.ProjectID = p.ID
+	}
+	if err := c.checkWorkflowQuota(ctx, s, u.ID, nw.ProjectID); err != nil {
+		return Workflow{}, err
+	}
+	if s.MaxConcurrency != nil {
+		if *s.MaxConcurrency < 0 {
+			return Workflow{}, fmt.Errorf("invalid max concurrency: %v", *s.MaxConcurrency)
+		}
+		nw.MaxConcurrency = int(*s.MaxConcurrency)
+	}
+	if s.Timeout != nil {
+		d, err := parseTimeout(*s.Timeout)
+		if err != nil {
+			return Workflow{}, err
+		}
+		nw.Timeout = d
 	}
 
-	w, err := c.p.CreateWorkflow(ctx, Workflow{Name: s.Name})
+	w, err := c.p.CreateWorkflow(ctx, nw)
 	if err != nil {
 		return Workflow{}, err
 	}
 
+	// If the spec is invalid, the workflow is removed along with any volumes and jobs already
+	// created, so that it does not count towards quotas.
 	volumes, err := createVolumes(ctx, c.p, w, s.Volumes)
 	if err != nil {
-		return Workflow{}, err
+		return Workflow{}, c.abortWorkflow(ctx, w.ID, err)
 	}
 
 	// Jobs must be created after volumes to allow them to reference
 	// generated volume IDs
-	jobs, err := c.createJobs(ctx, w, volumes, s.Jobs)
+	jobs, err := c.createJobs(ctx, w, volumes, s)
 	if err != nil {
-		return Workflow{}, err
+		return Workflow{}, c.abortWorkflow(ctx, w.ID, err)
 	}
 
 	// Schedule the workflow.
@@ -187,13 +284,53 @@ func (c *Core) CreateWorkflow(ctx context.Context, s WorkflowSpec) (Workflow, er
 	return w, err
 }
 
-// DeleteWorkflow deletes a workflow by ID. If the supplied ID is not valid, or there there is not
-// a workflow with a matching ID in the database, an error is returned.
+// DeleteWorkflow deletes a workflow by ID. If the workflow has not finished, it is cancelled first.
+// If the supplied ID is not valid, there there is not a workflow with a matching ID in the
+// database, the authenticated user is not permitted to manage the workflow, or a replica of the
+// scheduler still holds a lease on the workflow, an error is returned.
 func (c *Core) DeleteWorkflow(ctx context.Context, id string) (Workflow, error) {
-	if _, ok := token.FromContext(ctx); !ok {
-		return Workflow{}, ErrNotAuthenticated
+	w, err := c.getWorkflow(ctx, id, ProjectAdmin)
+	if err != nil {
+		return Workflow{}, err
+	}
+
+	// Stop the workflow before removing it. A replica of the scheduler releases its lease on the
+	// workflow once the workflow stops, so the workflow must not be removed while leased.
+	if !w.Status.IsTerminal() {
+		if err := c.s.CancelWorkflow(ctx, w); err != nil && !errors.Is(err, ErrWorkflowNotRunning) {
+			return Workflow{}, err
+		}
+		if w, err = c.p.GetWorkflow(ctx, id); err != nil {
+			return Workflow{}, err
+		}
+	}
+	if w.isLeased(time.Now()) {
+		return Workflow{}, ErrWorkflowRunning
+	}
+
+	// Delete job output, so that it no longer counts towards quotas.
+	jobs, err := c.getWorkflowJobs(ctx, w.ID)
+	if err != nil {
+		return Workflow{}, err
 	}
+	for _, j := range jobs {
+		if err := c.f.DeleteJobOutput(j); err != nil {
+			return Workflow{}, err
+		}
+	}
+
+	w, err = c.removeWorkflow(ctx, id)
+	if err != nil {
+		return Workflow{}, err
+	}
+
+	w.setCore(c)
+	return w, nil
+}
 
+// removeWorkflow removes the workflow with the supplied ID from the database, along with its jobs
+// and volumes.
+func (c *Core) removeWorkflow(ctx context.Context, id string) (Workflow, error) {
 	w, err := c.p.DeleteWorkflow(ctx, id)
 	if err != nil {
 		return Workflow{}, err
@@ -209,18 +346,123 @@ func (c *Core) DeleteWorkflow(ctx context.Context, id string) (Workflow, error)
 		return Workflow{}, err
 	}
 
-	w.setCore(c)
 	return w, nil
 }
 
-// GetWorkflow retrieves a workflow by ID. If the supplied ID is not valid, or there there is not a
-// workflow with a matching ID in the database, an error is returned.
+// abortWorkflow removes the workflow with the supplied ID, which could not be created due to err,
+// and returns err.
+func (c *Core) abortWorkflow(ctx context.Context, id string, err error) error {
+	if _, rerr := c.removeWorkflow(ctx, id); rerr != nil {
+		return fmt.Errorf("%w (failed to remove workflow: %v)", err, rerr)
+	}
+	return err
+}
+
+// CancelWorkflow cancels a workflow by ID, stopping running jobs and preventing further jobs from
+// being run. If the supplied ID is not valid, there is not a workflow with a matching ID in the
+// database, the authenticated user is not permitted to manage the workflow, or the workflow has
+// already finished, an error is returned.
+func (c *Core) CancelWorkflow(ctx context.Context, id string) (Workflow, error) {
+	w, err := c.getWorkflow(ctx, id, ProjectAdmin)
+	if err != nil {
+		return Workflow{}, err
+	}
+	if w.Status.IsTerminal() {
+		return Workflow{}, ErrWorkflowFinished
+	}
+
+	if err := c.s.CancelWorkflow(ctx, w); err != nil {
+		return Workflow{}, err
+	}
+
+	// Retrieve the workflow to reflect the updated status.
+	return c.GetWorkflow(ctx, id)
+}
+
+// GetWorkflow retrieves a workflow by ID. If the supplied ID is not valid, there there is not a
+// workflow with a matching ID in the database, or the authenticated user is not permitted to view
+// the workflow, an error is returned.
 func (c *Core) GetWorkflow(ctx context.Context, id string) (Workflow, error) {
-	if _, ok := token.FromContext(ctx); !ok {
-		return Workflow{}, ErrNotAuthenticated
+	w, err := c.getWorkflow(ctx, id, ProjectViewer)
+	if err != nil {
+		return Workflow{}, err
 	}
 
-	w, err := c.p.GetWorkflow(ctx, id)
 	w.setCore(c)
-	return w, err
+	return w, nil
+}
+
+// WorkflowUpdates returns a channel on which the workflow with the supplied ID is sent, initially
+// and then each time its status changes. The channel is closed once the workflow finishes, or ctx
+// is done. If the supplied ID is not valid, there is not a workflow with a matching ID in the
+// database, or the authenticated user is not permitted to view the workflow, an error is returned.
+func (c *Core) WorkflowUpdates(ctx context.Context, id string) (<-chan Workflow, error) {
+	// Register interest before retrieving the workflow, so that updates are not missed.
+	ctx, cancel := context.WithCancel(ctx)
+	updated, err := c.s.WatchWorkflow(ctx, id)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	w, err := c.getWorkflow(ctx, id, ProjectViewer)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	w.setCore(c)
+
+	ws := make(chan Workflow)
+	go func() {
+		defer cancel()
+		defer close(ws)
+
+		for {
+			select {
+			case ws <- w:
+			case <-ctx.Done():
+				return
+			}
+			if w.Status.IsTerminal() {
+				return
+			}
+
+			// Wait for the status to change.
+			for status := w.Status; w.Status == status; {
+				if _, ok := <-updated; !ok {
+					return
+				}
+				if w, err = c.p.GetWorkflow(ctx, id); err != nil {
+					return
+				}
+				w.setCore(c)
+			}
+		}
+	}()
+	return ws, nil
+}
+
+// getWorkflow retrieves a workflow by ID, ensuring the authenticated user either created it, or
+// holds at least role in the project it belongs to.
+func (c *Core) getWorkflow(ctx context.Context, id string, role ProjectRole) (Workflow, error) {
+	w, err := c.p.GetWorkflow(ctx, id)
+	if err != nil {
+		return Workflow{}, err
+	}
+	if err := c.authorize(ctx, w.CreatedByID, w.ProjectID, role); err != nil {
+		return Workflow{}, err
+	}
+	return w, nil
+}
+
+// duration returns the time elapsed between startedAt and finishedAt. If finishedAt is nil, the
+// time elapsed between startedAt and now is returned. If startedAt is nil, ok is false.
+func duration(startedAt, finishedAt *time.Time, now time.Time) (d time.Duration, ok bool) {
+	if startedAt == nil {
+		return 0, false
+	}
+	if finishedAt != nil {
+		now = *finishedAt
+	}
+	return now.Sub(*startedAt), true
 }
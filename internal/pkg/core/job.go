@@ -11,11 +11,53 @@ import (
 type JobPersister interface {
 	CreateJob(context.Context, Job) (Job, error)
 	DeleteJobsByWorkflowID(context.Context, string) error
-	GetJobs(context.Context, PageArgs) (JobsPage, error)
+	GetJob(context.Context, string) (Job, error)
+	GetJobsByUserID(context.Context, PageArgs, string) (JobsPage, error)
 	GetJobsByWorkflowID(context.Context, PageArgs, string) (JobsPage, error)
 	GetJobsByID(context.Context, PageArgs, string, []string) (JobsPage, error)
 }
 
+// JobStatus describes the state of a job.
+type JobStatus string
+
+// Job states.
+const (
+	JobPending   JobStatus = "PENDING"   // Waiting for required jobs to complete.
+	JobRunning   JobStatus = "RUNNING"   // Running.
+	JobSucceeded JobStatus = "SUCCEEDED" // Completed with a zero exit code.
+	JobFailed    JobStatus = "FAILED"    // Completed with a non-zero exit code, or could not be run.
+	JobSkipped   JobStatus = "SKIPPED"   // Not run, due to the outcome of required jobs not meeting its condition.
+	JobCancelled JobStatus = "CANCELLED" // Cancelled before completion.
+	JobTimedOut  JobStatus = "TIMED_OUT" // Did not complete within its timeout.
+)
+
+func (s JobStatus) String() string {
+	return string(s)
+}
+
+// IsTerminal returns true if s is a final state, from which no further transitions occur.
+func (s JobStatus) IsTerminal() bool {
+	switch s {
+	case JobSucceeded, JobFailed, JobSkipped, JobCancelled, JobTimedOut:
+		return true
+	}
+	return false
+}
+
+// JobCondition describes the outcomes of required jobs under which a job is run.
+type JobCondition string
+
+// Job conditions.
+const (
+	JobOnSuccess JobCondition = "ON_SUCCESS" // Run if all required jobs succeed.
+	JobOnFailure JobCondition = "ON_FAILURE" // Run if any required job fails, directly or indirectly.
+	JobAlways    JobCondition = "ALWAYS"     // Run once all required jobs complete, regardless of outcome.
+)
+
+func (c JobCondition) String() string {
+	return string(c)
+}
+
 // Job contains information about an indivisual job.
 type Job struct {
 	ID         string              `bson:"_id,omitempty"`
@@ -26,14 +68,75 @@ type Job struct {
 	Name       string              `bson:"name"`
 	Image      string              `bson:"image"`
 	Command    []string            `bson:"command"`
-	Status     string              `bson:"status"`
+	Status     JobStatus           `bson:"status"`
 	ExitCode   *int                `bson:"exitCode,omitempty"`
+	NodeID     string              `bson:"nodeID,omitempty"`
 	Requires   []string            `bson:"requires"`
 	Volumes    []VolumeRequirement `bson:"volumes"`
+	Retry      RetryPolicy         `bson:"retry"`
+	Timeout    time.Duration       `bson:"timeout,omitempty"` // Unbounded if zero.
+	Env        []EnvVar            `bson:"env,omitempty"`
+	Resources  Resources           `bson:"resources"`
+	NodeLabels map[string]string   `bson:"nodeLabels,omitempty"` // Labels a node must have for the job to be placed on it.
+	Priority   int                 `bson:"priority,omitempty"`   // Relative to other queued jobs.
+	ArrayIndex *int                `bson:"arrayIndex,omitempty"` // Index within the job array, if any.
+	When       JobCondition        `bson:"when,omitempty"`       // Condition under which the job is run (JobOnSuccess if empty).
+
+	OutputIncomplete bool `bson:"outputIncomplete,omitempty"` // Set if captured output was lost.
+
+	CreatedByID    string       `bson:"createdByID"`         // ID of the user that created the job.
+	CreatedByLogin string       `bson:"createdByLogin"`      // Login of the user that created the job.
+	ProjectID      string       `bson:"projectID,omitempty"` // ID of the project the job belongs to, if any.
+	Attempts       []JobAttempt `bson:"attempts,omitempty"`
+
+	c *Core // Used internally for lazy loading.
+}
+
+// RetryPolicy describes how a job is retried on failure.
+type RetryPolicy struct {
+	Retries   int           `bson:"retries"`             // Maximum number of times to retry.
+	Backoff   time.Duration `bson:"backoff,omitempty"`   // Delay before the first retry.
+	ExitCodes []int         `bson:"exitCodes,omitempty"` // If not empty, the exit codes to retry.
+}
+
+// JobAttempt describes a completed attempt to run a job.
+type JobAttempt struct {
+	Number     int       `bson:"number"`
+	StartedAt  time.Time `bson:"startedAt"`
+	FinishedAt time.Time `bson:"finishedAt"`
+	NodeID     string    `bson:"nodeID,omitempty"`
+	ExitCode   *int      `bson:"exitCode,omitempty"`
+	Error      string    `bson:"error,omitempty"`
+	OutputKey  string    `bson:"outputKey"`
 
 	c *Core // Used internally for lazy loading.
 }
 
+// GetOutput retrieves the output of the attempt.
+func (a JobAttempt) GetOutput() (string, error) {
+	return a.c.f.GetJobOutput(a.OutputKey)
+}
+
+// GetStreamOutput retrieves the output the attempt wrote to stream s.
+func (a JobAttempt) GetStreamOutput(s JobOutputStream) (string, error) {
+	return a.c.f.GetJobStreamOutput(a.OutputKey, s)
+}
+
+// Resources describes the resources required to run a job.
+type Resources struct {
+	CPUs     int           `bson:"cpus,omitempty"`     // Number of CPUs (unconstrained if zero).
+	Memory   int64         `bson:"memory,omitempty"`   // Amount of memory, in bytes (unconstrained if zero).
+	Walltime time.Duration `bson:"walltime,omitempty"` // Maximum time each attempt may run for (unbounded if zero).
+}
+
+// EnvVar describes an environment variable set for a job. The value is either supplied literally,
+// or taken from the named secret of the user that created the job when the job is run.
+type EnvVar struct {
+	Name   string `bson:"name"`
+	Value  string `bson:"value,omitempty"`
+	Secret string `bson:"secret,omitempty"`
+}
+
 // VolumeRequirement describes a required volume.
 type VolumeRequirement struct {
 	VolumeID string `bson:"volumeID"`
@@ -44,6 +147,9 @@ type VolumeRequirement struct {
 // setCore sets the core of j to c.
 func (j *Job) setCore(c *Core) {
 	j.c = c
+	for i := range j.Attempts {
+		j.Attempts[i].c = c
+	}
 }
 
 // GetOutput retrieves the output of the job.
@@ -51,11 +157,37 @@ func (j Job) GetOutput() (string, error) {
 	return j.c.f.GetJobOutput(j.ID)
 }
 
+// GetStreamOutput retrieves the output the job wrote to stream s.
+func (j Job) GetStreamOutput(s JobOutputStream) (string, error) {
+	return j.c.f.GetJobStreamOutput(j.ID, s)
+}
+
+// LogLinesPage retrieves a page of the timestamped output of the job, in the order it was
+// captured.
+func (j Job) LogLinesPage(pa PageArgs) (JobLogLinesPage, error) {
+	return j.c.f.GetJobLogLines(j.ID, pa)
+}
+
+// QueuePosition returns the position of job j in the queue of jobs waiting to be placed on a node,
+// starting from one. If the job is not waiting, ok is false.
+func (j Job) QueuePosition(ctx context.Context) (pos int, ok bool) {
+	if j.Status != JobPending {
+		return 0, false
+	}
+	return j.c.s.JobQueuePosition(ctx, j.ID)
+}
+
+// Duration returns how long job j ran for. If the job has started but not finished, the time
+// elapsed as of now is returned. If the job has not started, ok is false.
+func (j Job) Duration(now time.Time) (d time.Duration, ok bool) {
+	return duration(j.StartedAt, j.FinishedAt, now)
+}
+
 // CreatedBy retrieves the user that created job j.
 func (j Job) CreatedBy(ctx context.Context) (User, error) {
 	u := User{
-		ID:    "507f1f77bcf86cd799439011",
-		Login: "jimbob",
+		ID:    j.CreatedByID,
+		Login: j.CreatedByLogin,
 	}
 	u.setCore(j.c)
 	return u, nil
@@ -81,3 +213,83 @@ func (j Job) RequiredJobsPage(ctx context.Context, pa PageArgs) (JobsPage, error
 	p.setCore(j.c)
 	return p, err
 }
+
+// CancelJob cancels a job by ID. If the job is running, it is stopped. If the job has not yet
+// been run, it will not be run. If the supplied ID is not valid, there is not a job with a
+// matching ID in the database, the authenticated user is not permitted to manage the job, or the
+// job has already finished, an error is returned.
+func (c *Core) CancelJob(ctx context.Context, id string) (Job, error) {
+	j, err := c.p.GetJob(ctx, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := c.authorize(ctx, j.CreatedByID, j.ProjectID, ProjectAdmin); err != nil {
+		return Job{}, err
+	}
+	if j.Status.IsTerminal() {
+		return Job{}, ErrJobFinished
+	}
+
+	if err := c.s.CancelJob(ctx, j); err != nil {
+		return Job{}, err
+	}
+
+	// Retrieve the job to reflect the updated status.
+	j, err = c.p.GetJob(ctx, id)
+	j.setCore(c)
+	return j, err
+}
+
+// JobUpdates returns a channel on which the job with the supplied ID is sent, initially and then
+// each time its status changes. The channel is closed once the job finishes, or ctx is done. If
+// the supplied ID is not valid, there is not a job with a matching ID in the database, or the
+// authenticated user is not permitted to view the job, an error is returned.
+func (c *Core) JobUpdates(ctx context.Context, id string) (<-chan Job, error) {
+	// Register interest before retrieving the job, so that updates are not missed.
+	ctx, cancel := context.WithCancel(ctx)
+	updated, err := c.s.WatchJob(ctx, id)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	j, err := c.p.GetJob(ctx, id)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := c.authorize(ctx, j.CreatedByID, j.ProjectID, ProjectViewer); err != nil {
+		cancel()
+		return nil, err
+	}
+	j.setCore(c)
+
+	js := make(chan Job)
+	go func() {
+		defer cancel()
+		defer close(js)
+
+		for {
+			select {
+			case js <- j:
+			case <-ctx.Done():
+				return
+			}
+			if j.Status.IsTerminal() {
+				return
+			}
+
+			// Wait for the status to change.
+			for status := j.Status; j.Status == status; {
+				if _, ok := <-updated; !ok {
+					return
+				}
+				if j, err = c.p.GetJob(ctx, id); err != nil {
+					return
+				}
+				j.setCore(c)
+			}
+		}
+	}()
+	return js, nil
+}
@@ -2,7 +2,340 @@
 
 package core
 
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxJobOutputRangeSize is the maximum size of a range of job output, in bytes.
+const MaxJobOutputRangeSize = 1 << 20
+
+// tailBlockSize is the size of the blocks in which job output is read when searching backwards for
+// the start of a line.
+const tailBlockSize = 64 << 10
+
 // JobOutputFetcher is the interface to fetch job output.
 type JobOutputFetcher interface {
 	GetJobOutput(string) (string, error)
+	GetJobOutputRange(id string, offset, limit int) (string, error)
+	GetJobOutputSize(string) (int, error)
+	GetJobStreamOutput(string, JobOutputStream) (string, error)
+	GetJobLogLines(string, PageArgs) (JobLogLinesPage, error)
+	WatchJobOutput(context.Context, string) (<-chan JobOutputChunk, error)
+}
+
+// OutputUsageFetcher is the interface to fetch the total size of output stored for the jobs of a
+// user or project.
+type OutputUsageFetcher interface {
+	GetUserOutputSize(string) (int64, error)
+	GetProjectOutputSize(string) (int64, error)
+}
+
+// JobOutputDeleter is the interface to delete job output.
+type JobOutputDeleter interface {
+	DeleteJobOutput(Job) error
+}
+
+// JobOutputStream identifies a stream of job output.
+type JobOutputStream string
+
+// Job output streams.
+const (
+	JobStdout JobOutputStream = "STDOUT" // Standard output.
+	JobStderr JobOutputStream = "STDERR" // Standard error.
+)
+
+func (s JobOutputStream) String() string {
+	return string(s)
+}
+
+// JobLogLine is a timestamped record of output written by a job to one of its output streams.
+type JobLogLine struct {
+	ID     string          // Unique ID of the record, in the order the records were captured.
+	Stream JobOutputStream // Stream the output was written to.
+	Time   time.Time       // When the output was written.
+	Data   string          // Output data.
+}
+
+// JobLogLinesPage represents a page of job log lines resulting from a query, and associated
+// metadata.
+type JobLogLinesPage struct {
+	LogLines   []JobLogLine // Slice of results.
+	PageInfo   PageInfo     // Information to aid in pagination.
+	TotalCount int          // Identifies the total count of items in the connection.
+}
+
+// JobOutputChunk is a contiguous chunk of job output.
+type JobOutputChunk struct {
+	Offset int    // Byte offset of the chunk within the output of the job.
+	Data   string // Output data.
+}
+
+// JobOutput returns a channel on which the output of the job with the supplied ID is sent, starting
+// at byte offset fromOffset. Output that has already been stored is sent first, followed by new
+// output as it is received. If the job is retried, the output of the next attempt starts afresh
+// once the output of the previous attempt has been archived, and is sent from byte offset zero.
+// The channel is closed once the job finishes and all of its output has been sent, or ctx is done.
+// If the supplied ID is not valid, there is not a job with a matching ID in the database, or the
+// authenticated user is not permitted to view the job, an error is returned.
+func (c *Core) JobOutput(ctx context.Context, id string, fromOffset int) (<-chan JobOutputChunk, error) {
+	if fromOffset < 0 {
+		return nil, fmt.Errorf("invalid offset: %v", fromOffset)
+	}
+
+	// Register interest before retrieving the job and its output, so that updates are not missed.
+	ctx, cancel := context.WithCancel(ctx)
+	chunks, err := c.f.WatchJobOutput(ctx, id)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	updated, err := c.s.WatchJob(ctx, id)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	j, err := c.p.GetJob(ctx, id)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := c.authorize(ctx, j.CreatedByID, j.ProjectID, ProjectViewer); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	oc := make(chan JobOutputChunk)
+	go func() {
+		defer cancel()
+		defer close(oc)
+
+		pos := fromOffset
+		archived := archivedAttempts(j)
+
+		// send sends the output in data that follows pos, where data starts at offset. It returns
+		// false if ctx is done before the output is sent.
+		send := func(offset int, data string) bool {
+			if offset+len(data) <= pos {
+				return true
+			}
+			if offset < pos {
+				data = data[pos-offset:]
+				offset = pos
+			}
+			select {
+			case oc <- JobOutputChunk{Offset: offset, Data: data}:
+				pos = offset + len(data)
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// sendStored sends stored output that follows pos, reading it in ranges of at most
+		// MaxJobOutputRangeSize bytes.
+		sendStored := func() bool {
+			for {
+				out, err := c.f.GetJobOutputRange(id, pos, MaxJobOutputRangeSize)
+				if err != nil {
+					return false
+				}
+				if out == "" {
+					return true
+				}
+				if !send(pos, out) {
+					return false
+				}
+				if len(out) < MaxJobOutputRangeSize {
+					return true
+				}
+			}
+		}
+
+		if !sendStored() {
+			return
+		}
+
+		for !j.Status.IsTerminal() {
+			select {
+			case ch, ok := <-chunks:
+				if !ok {
+					return
+				}
+
+				// If output was missed, fall back to the stored output, which includes the chunk.
+				var sent bool
+				if ch.Offset > pos {
+					sent = sendStored()
+				} else {
+					sent = send(ch.Offset, ch.Data)
+				}
+				if !sent {
+					return
+				}
+
+			case _, ok := <-updated:
+				if !ok {
+					return
+				}
+				if j, err = c.p.GetJob(ctx, id); err != nil {
+					return
+				}
+
+				// Once the output of an attempt is archived, output of the next attempt is stored
+				// from the start. Output of the next attempt that was received before the update is
+				// included in the stored output.
+				if n := archivedAttempts(j); n != archived {
+					archived, pos = n, 0
+					if !sendStored() {
+						return
+					}
+				}
+			}
+		}
+
+		// Send any output stored after the last chunk was received.
+		sendStored()
+	}()
+	return oc, nil
+}
+
+// archivedAttempts returns the number of attempts of job j whose output was archived, rather than
+// left in place as the output of the job.
+func archivedAttempts(j Job) int {
+	n := 0
+	for _, a := range j.Attempts {
+		if a.OutputKey != j.ID {
+			n++
+		}
+	}
+	return n
+}
+
+// JobOutputRange is a range of job output.
+type JobOutputRange struct {
+	Offset    int    // Byte offset of the range within the output of the job.
+	Data      string // Output data.
+	TotalSize int    // Total size of the output of the job, in bytes.
+}
+
+// NextOffset returns the byte offset that follows range r.
+func (r JobOutputRange) NextOffset() int {
+	return r.Offset + len(r.Data)
+}
+
+// checkLimit validates limit, and reduces it to MaxJobOutputRangeSize if required.
+func checkLimit(limit int) (int, error) {
+	if limit < 0 {
+		return 0, fmt.Errorf("invalid limit: %v", limit)
+	}
+	if limit > MaxJobOutputRangeSize {
+		limit = MaxJobOutputRangeSize
+	}
+	return limit, nil
+}
+
+// GetOutputRange retrieves up to limit bytes of the output of the job, starting at byte offset.
+// Limits greater than MaxJobOutputRangeSize are reduced to MaxJobOutputRangeSize.
+func (j Job) GetOutputRange(offset, limit int) (JobOutputRange, error) {
+	if offset < 0 {
+		return JobOutputRange{}, fmt.Errorf("invalid offset: %v", offset)
+	}
+	limit, err := checkLimit(limit)
+	if err != nil {
+		return JobOutputRange{}, err
+	}
+
+	// Retrieve the size first, so that the range read does not extend past it.
+	size, err := j.c.f.GetJobOutputSize(j.ID)
+	if err != nil {
+		return JobOutputRange{}, err
+	}
+	if n := size - offset; n < limit {
+		limit = n
+	}
+
+	r := JobOutputRange{
+		Offset:    offset,
+		TotalSize: size,
+	}
+	if limit > 0 {
+		if r.Data, err = j.c.f.GetJobOutputRange(j.ID, offset, limit); err != nil {
+			return JobOutputRange{}, err
+		}
+	}
+	return r, nil
+}
+
+// tailStart returns the index in data at which the last n lines begin. A trailing newline does not
+// begin an additional line. If data contains fewer than n complete lines, ok is false.
+func tailStart(data string, n int) (i int, ok bool) {
+	if n == 0 {
+		return len(data), true
+	}
+
+	s := strings.TrimSuffix(data, "\n")
+
+	i = len(s)
+	for ; n > 0; n-- {
+		if i = strings.LastIndexByte(s[:i], '\n'); i < 0 {
+			return 0, false
+		}
+	}
+	return i + 1, true
+}
+
+// GetOutputTail retrieves the last lines of the output of the job, up to a maximum of limit
+// bytes. Limits greater than MaxJobOutputRangeSize are reduced to MaxJobOutputRangeSize.
+func (j Job) GetOutputTail(lines, limit int) (JobOutputRange, error) {
+	if lines < 0 {
+		return JobOutputRange{}, fmt.Errorf("invalid line count: %v", lines)
+	}
+	limit, err := checkLimit(limit)
+	if err != nil {
+		return JobOutputRange{}, err
+	}
+
+	size, err := j.c.f.GetJobOutputSize(j.ID)
+	if err != nil {
+		return JobOutputRange{}, err
+	}
+
+	// Read backwards from the end of the output until the start of the requested lines is found,
+	// the start of the output is reached, or enough output has been read to satisfy limit.
+	start, data := size, ""
+	for {
+		if i, ok := tailStart(data, lines); ok {
+			data = data[i:]
+			break
+		}
+		if start == 0 || len(data) >= limit {
+			break
+		}
+
+		n := tailBlockSize
+		if n > start {
+			n = start
+		}
+		start -= n
+
+		b, err := j.c.f.GetJobOutputRange(j.ID, start, n)
+		if err != nil {
+			return JobOutputRange{}, err
+		}
+		data = b + data
+	}
+
+	if len(data) > limit {
+		data = data[len(data)-limit:]
+	}
+
+	return JobOutputRange{
+		Offset:    size - len(data),
+		Data:      data,
+		TotalSize: size,
+	}, nil
 }
@@ -0,0 +1,269 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLastProjectAdmin is returned when an operation would leave a project without an admin.
+var ErrLastProjectAdmin = errors.New("project must have at least one admin")
+
+// ProjectPersister is the interface by which projects are persisted.
+type ProjectPersister interface {
+	CreateProject(context.Context, Project) (Project, error)
+	GetProject(context.Context, string) (Project, error)
+	GetProjectsByUserID(context.Context, PageArgs, string) (ProjectsPage, error)
+	SetProjectMember(context.Context, string, ProjectMember) (Project, error)
+	RemoveProjectMember(context.Context, string, string) (Project, error)
+}
+
+// ProjectRole describes the permissions a member holds within a project.
+type ProjectRole string
+
+// Project roles, in increasing order of permission. Each role grants the permissions of the roles
+// before it.
+const (
+	ProjectViewer    ProjectRole = "VIEWER"    // May view workflows in the project.
+	ProjectSubmitter ProjectRole = "SUBMITTER" // May submit workflows to the project.
+	ProjectAdmin     ProjectRole = "ADMIN"     // May manage workflows and members of the project.
+)
+
+func (r ProjectRole) String() string {
+	return string(r)
+}
+
+// rank returns the relative permission level of r, or zero if r is not a valid role.
+func (r ProjectRole) rank() int {
+	switch r {
+	case ProjectViewer:
+		return 1
+	case ProjectSubmitter:
+		return 2
+	case ProjectAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Valid returns true if r is a known role.
+func (r ProjectRole) Valid() bool {
+	return r.rank() > 0
+}
+
+// allows returns true if role r grants the permissions of role o.
+func (r ProjectRole) allows(o ProjectRole) bool {
+	return r.Valid() && r.rank() >= o.rank()
+}
+
+// ProjectMember describes a user's membership of a project.
+type ProjectMember struct {
+	UserID string      `bson:"userID"`
+	Login  string      `bson:"login"`
+	Role   ProjectRole `bson:"role"`
+}
+
+// Project represents a group of users that share workflows.
+type Project struct {
+	ID        string          `bson:"_id,omitempty"`
+	CreatedAt time.Time       `bson:"createdAt"`
+	Name      string          `bson:"name"`
+	Members   []ProjectMember `bson:"members"`
+
+	CreatedByID    string `bson:"createdByID"`    // ID of the user that created the project.
+	CreatedByLogin string `bson:"createdByLogin"` // Login of the user that created the project.
+
+	c *Core // Used internally for lazy loading.
+}
+
+// setCore sets the core of p to c.
+func (p *Project) setCore(c *Core) {
+	p.c = c
+}
+
+// Role returns the role held by the user with the supplied ID in project p. If the user is not a
+// member of the project, ok is false.
+func (p Project) Role(uid string) (r ProjectRole, ok bool) {
+	for _, m := range p.Members {
+		if m.UserID == uid {
+			return m.Role, true
+		}
+	}
+	return "", false
+}
+
+// adminCount returns the number of admins in project p.
+func (p Project) adminCount() (n int) {
+	for _, m := range p.Members {
+		if m.Role == ProjectAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+// CreatedBy retrieves the user that created project p.
+func (p Project) CreatedBy(ctx context.Context) (User, error) {
+	u := User{
+		ID:    p.CreatedByID,
+		Login: p.CreatedByLogin,
+	}
+	u.setCore(p.c)
+	return u, nil
+}
+
+// MemberUser retrieves the user that holds membership m of project p.
+func (p Project) MemberUser(m ProjectMember) User {
+	u := User{
+		ID:    m.UserID,
+		Login: m.Login,
+	}
+	u.setCore(p.c)
+	return u
+}
+
+// WorkflowsPage retrieves a page of workflows submitted to project p.
+func (p Project) WorkflowsPage(ctx context.Context, pa PageArgs) (WorkflowsPage, error) {
+	wp, err := p.c.p.GetWorkflowsByProjectID(ctx, pa, p.ID)
+	if err != nil {
+		return WorkflowsPage{}, err
+	}
+	wp.setCore(p.c)
+	return wp, nil
+}
+
+// ProjectsPage represents a page of projects resulting from a query, and associated metadata.
+type ProjectsPage struct {
+	Projects   []Project // Slice of results.
+	PageInfo   PageInfo  // Information to aid in pagination.
+	TotalCount int       // Identifies the total count of items in the connection.
+}
+
+// setCore sets the core field of each project in page p to c.
+func (p *ProjectsPage) setCore(c *Core) {
+	for i := range p.Projects {
+		p.Projects[i].setCore(c)
+	}
+}
+
+// getProject retrieves a project by ID, ensuring the authenticated user holds at least role in it.
+func (c *Core) getProject(ctx context.Context, id string, role ProjectRole) (Project, error) {
+	u, err := c.Viewer(ctx)
+	if err != nil {
+		return Project{}, err
+	}
+
+	p, err := c.p.GetProject(ctx, id)
+	if err != nil {
+		return Project{}, err
+	}
+	if r, ok := p.Role(u.ID); !ok || !r.allows(role) {
+		return Project{}, ErrForbidden
+	}
+
+	p.setCore(c)
+	return p, nil
+}
+
+// authorize ensures the authenticated user may access a resource that was created by the user with
+// ID createdByID, and optionally belongs to the project with ID projectID. The creator of a
+// resource may always access it. Otherwise, the user must hold at least role in the project.
+func (c *Core) authorize(ctx context.Context, createdByID, projectID string, role ProjectRole) error {
+	u, err := c.Viewer(ctx)
+	if err != nil {
+		return err
+	}
+	if createdByID == u.ID {
+		return nil
+	}
+	if projectID == "" {
+		return ErrForbidden
+	}
+	_, err = c.getProject(ctx, projectID, role)
+	return err
+}
+
+// CreateProject creates a new project with the supplied name. The authenticated user is made an
+// admin of the project.
+func (c *Core) CreateProject(ctx context.Context, name string) (Project, error) {
+	u, err := c.Viewer(ctx)
+	if err != nil {
+		return Project{}, err
+	}
+	if name == "" {
+		return Project{}, errors.New("project name must not be empty")
+	}
+
+	p, err := c.p.CreateProject(ctx, Project{
+		Name: name,
+		Members: []ProjectMember{
+			{UserID: u.ID, Login: u.Login, Role: ProjectAdmin},
+		},
+		CreatedByID:    u.ID,
+		CreatedByLogin: u.Login,
+	})
+	if err != nil {
+		return Project{}, err
+	}
+
+	p.setCore(c)
+	return p, nil
+}
+
+// AddProjectMember adds member m to the project with the supplied ID. If the user is already a
+// member, their role is updated. The authenticated user must be an admin of the project.
+func (c *Core) AddProjectMember(ctx context.Context, id string, m ProjectMember) (Project, error) {
+	if !m.Role.Valid() {
+		return Project{}, fmt.Errorf("unknown project role: %s", m.Role)
+	}
+	if m.UserID == "" {
+		return Project{}, errors.New("user ID must not be empty")
+	}
+
+	p, err := c.getProject(ctx, id, ProjectAdmin)
+	if err != nil {
+		return Project{}, err
+	}
+
+	// Ensure demoting an admin does not leave the project without one.
+	if r, ok := p.Role(m.UserID); ok && r == ProjectAdmin && m.Role != ProjectAdmin && p.adminCount() == 1 {
+		return Project{}, ErrLastProjectAdmin
+	}
+
+	p, err = c.p.SetProjectMember(ctx, id, m)
+	if err != nil {
+		return Project{}, err
+	}
+
+	p.setCore(c)
+	return p, nil
+}
+
+// RemoveProjectMember removes the user with ID uid from the project with the supplied ID. The
+// authenticated user must be an admin of the project. The last admin of a project cannot be
+// removed.
+func (c *Core) RemoveProjectMember(ctx context.Context, id, uid string) (Project, error) {
+	p, err := c.getProject(ctx, id, ProjectAdmin)
+	if err != nil {
+		return Project{}, err
+	}
+
+	r, ok := p.Role(uid)
+	if !ok {
+		return Project{}, fmt.Errorf("user %v is not a member of project", uid)
+	}
+	if r == ProjectAdmin && p.adminCount() == 1 {
+		return Project{}, ErrLastProjectAdmin
+	}
+
+	p, err = c.p.RemoveProjectMember(ctx, id, uid)
+	if err != nil {
+		return Project{}, err
+	}
+
+	p.setCore(c)
+	return p, nil
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is returned when an operation would exceed a limit on the resources consumed by
+// a user or project. Errors that describe the limit exceeded are of type *QuotaExceededError, and
+// match ErrQuotaExceeded using errors.Is.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaExceededError describes a limit on the resources consumed by a user or project that an
+// operation would exceed.
+type QuotaExceededError struct {
+	Owner    string // Kind of owner of the quota ("user" or "project").
+	Resource string // Resource limited by the quota.
+	Limit    int64  // Limit on the resource.
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%v quota exceeded: limit of %v %v", e.Owner, e.Limit, e.Resource)
+}
+
+// Is returns true if target is ErrQuotaExceeded.
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// Extensions returns additional information about the error, which is included in GraphQL
+// responses so that clients can identify the limit exceeded.
+func (e *QuotaExceededError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":     "QUOTA_EXCEEDED",
+		"owner":    e.Owner,
+		"resource": e.Resource,
+		"limit":    e.Limit,
+	}
+}
+
+// Kinds of quota owner.
+const (
+	quotaOwnerUser    = "user"
+	quotaOwnerProject = "project"
+)
+
+// Limits describes limits on the resources that may be consumed by a user or project. A limit of
+// zero is unlimited.
+type Limits struct {
+	RunningJobs     int   // Maximum number of jobs running concurrently.
+	QueuedWorkflows int   // Maximum number of workflows that have not finished.
+	CPUs            int   // Maximum number of CPUs allocated to running jobs.
+	OutputBytes     int64 // Maximum total size of output stored for jobs, in bytes.
+}
+
+// Usage describes the resources consumed by a user or project.
+type Usage struct {
+	RunningJobs     int   // Number of jobs running.
+	QueuedWorkflows int   // Number of workflows that have not finished.
+	CPUs            int   // Number of CPUs allocated to running jobs (at least one per job).
+	OutputBytes     int64 // Total size of output stored for jobs, in bytes.
+}
+
+// check returns a *QuotaExceededError if usage u exceeds limits l, which apply to an owner of the
+// supplied kind.
+func (l Limits) check(owner string, u Usage) error {
+	check := []struct {
+		resource string
+		limit    int64
+		used     int64
+	}{
+		{"running jobs", int64(l.RunningJobs), int64(u.RunningJobs)},
+		{"queued workflows", int64(l.QueuedWorkflows), int64(u.QueuedWorkflows)},
+		{"CPUs", int64(l.CPUs), int64(u.CPUs)},
+		{"output bytes", l.OutputBytes, u.OutputBytes},
+	}
+	for _, c := range check {
+		if c.limit > 0 && c.used > c.limit {
+			return &QuotaExceededError{Owner: owner, Resource: c.resource, Limit: c.limit}
+		}
+	}
+	return nil
+}
+
+// CheckUser returns a *QuotaExceededError if usage u exceeds limits l, which apply to a user.
+func (l Limits) CheckUser(u Usage) error {
+	return l.check(quotaOwnerUser, u)
+}
+
+// CheckProject returns a *QuotaExceededError if usage u exceeds limits l, which apply to a
+// project.
+func (l Limits) CheckProject(u Usage) error {
+	return l.check(quotaOwnerProject, u)
+}
+
+// Quota describes the resources consumed by a user or project, and the limits on them.
+type Quota struct {
+	Limits Limits
+	Usage  Usage
+}
+
+// OptUserLimits sets the limits on the resources that may be consumed by each user to l.
+func OptUserLimits(l Limits) func(*Core) error {
+	return func(c *Core) error {
+		c.ul = l
+		return nil
+	}
+}
+
+// OptProjectLimits sets the limits on the resources that may be consumed by each project to l.
+func OptProjectLimits(l Limits) func(*Core) error {
+	return func(c *Core) error {
+		c.pl = l
+		return nil
+	}
+}
+
+// userUsage returns the resources consumed by the user with ID uid.
+func (c *Core) userUsage(ctx context.Context, uid string) (Usage, error) {
+	u, err := c.s.UserUsage(ctx, uid)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	n, err := c.p.CountUnfinishedWorkflowsByUserID(ctx, uid)
+	if err != nil {
+		return Usage{}, err
+	}
+	u.QueuedWorkflows = n
+
+	if u.OutputBytes, err = c.f.GetUserOutputSize(uid); err != nil {
+		return Usage{}, err
+	}
+	return u, nil
+}
+
+// projectUsage returns the resources consumed by the project with ID pid.
+func (c *Core) projectUsage(ctx context.Context, pid string) (Usage, error) {
+	u, err := c.s.ProjectUsage(ctx, pid)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	n, err := c.p.CountUnfinishedWorkflowsByProjectID(ctx, pid)
+	if err != nil {
+		return Usage{}, err
+	}
+	u.QueuedWorkflows = n
+
+	if u.OutputBytes, err = c.f.GetProjectOutputSize(pid); err != nil {
+		return Usage{}, err
+	}
+	return u, nil
+}
+
+// checkWorkflowQuota ensures workflow spec s may be submitted by the user with ID uid, optionally
+// to the project with ID pid, without exceeding the limits of either. The workflow must not exceed
+// the limit on queued workflows, and no job may require more CPUs than permitted to run at once.
+// Once the output of jobs exceeds its limit, no further workflows may be submitted.
+func (c *Core) checkWorkflowQuota(ctx context.Context, s WorkflowSpec, uid, pid string) error {
+	// Ensure each job could run within the limits.
+	for _, js := range s.Jobs {
+		if js.Resources == nil || js.Resources.CPUs == nil {
+			continue
+		}
+		ju := Usage{RunningJobs: 1, CPUs: int(*js.Resources.CPUs)}
+		if err := c.ul.CheckUser(ju); err != nil {
+			return err
+		}
+		if pid != "" {
+			if err := c.pl.CheckProject(ju); err != nil {
+				return err
+			}
+		}
+	}
+
+	// withWorkflow returns usage u, with the workflow added.
+	withWorkflow := func(u Usage) Usage {
+		return Usage{
+			QueuedWorkflows: u.QueuedWorkflows + 1,
+			OutputBytes:     u.OutputBytes,
+		}
+	}
+
+	if c.ul.QueuedWorkflows > 0 || c.ul.OutputBytes > 0 {
+		u, err := c.userUsage(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if err := c.ul.CheckUser(withWorkflow(u)); err != nil {
+			return err
+		}
+	}
+
+	if pid != "" && (c.pl.QueuedWorkflows > 0 || c.pl.OutputBytes > 0) {
+		u, err := c.projectUsage(ctx, pid)
+		if err != nil {
+			return err
+		}
+		if err := c.pl.CheckProject(withWorkflow(u)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Quota retrieves the resources consumed by user u, and the limits on them. Only the
+// authenticated user may retrieve their quota.
+func (u User) Quota(ctx context.Context) (Quota, error) {
+	if err := u.checkViewer(ctx); err != nil {
+		return Quota{}, err
+	}
+
+	usage, err := u.c.userUsage(ctx, u.ID)
+	if err != nil {
+		return Quota{}, err
+	}
+	return Quota{Limits: u.c.ul, Usage: usage}, nil
+}
+
+// Quota retrieves the resources consumed by project p, and the limits on them.
+func (p Project) Quota(ctx context.Context) (Quota, error) {
+	usage, err := p.c.projectUsage(ctx, p.ID)
+	if err != nil {
+		return Quota{}, err
+	}
+	return Quota{Limits: p.c.pl, Usage: usage}, nil
+}
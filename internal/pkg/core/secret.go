@@ -0,0 +1,79 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// ErrSecretNotFound is returned when a secret with the requested name does not exist.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretPersister is the interface by which secrets are persisted.
+type SecretPersister interface {
+	SetSecret(context.Context, Secret) (Secret, error)
+	DeleteSecret(context.Context, string, string) (Secret, error)
+	GetSecretByName(context.Context, string, string) (Secret, error)
+	GetSecretsByUserID(context.Context, PageArgs, string) (SecretsPage, error)
+}
+
+// Secret is a named value registered by a user, which jobs created by the user may reference. The
+// value is never returned to clients.
+type Secret struct {
+	ID        string    `bson:"_id,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	Name      string    `bson:"name"`
+	Value     string    `bson:"-"` // Encrypted at rest by the persister.
+
+	CreatedByID string `bson:"createdByID"` // ID of the user that created the secret.
+}
+
+// SecretsPage represents a page of secrets resulting from a query, and associated metadata.
+type SecretsPage struct {
+	Secrets    []Secret // Slice of results.
+	PageInfo   PageInfo // Information to aid in pagination.
+	TotalCount int      // Identifies the total count of items in the connection.
+}
+
+// secretNameRegexp matches valid secret names.
+var secretNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validateSecretName returns an error if name is not a valid secret name.
+func validateSecretName(name string) error {
+	if !secretNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid secret name: %q", name)
+	}
+	return nil
+}
+
+// SetSecret registers a secret with the supplied name and value on behalf of the authenticated
+// user. If the user already has a secret with the supplied name, its value is replaced.
+func (c *Core) SetSecret(ctx context.Context, name, value string) (Secret, error) {
+	u, err := c.Viewer(ctx)
+	if err != nil {
+		return Secret{}, err
+	}
+	if err := validateSecretName(name); err != nil {
+		return Secret{}, err
+	}
+
+	return c.p.SetSecret(ctx, Secret{
+		Name:        name,
+		Value:       value,
+		CreatedByID: u.ID,
+	})
+}
+
+// DeleteSecret deletes the secret of the authenticated user with the supplied name.
+func (c *Core) DeleteSecret(ctx context.Context, name string) (Secret, error) {
+	u, err := c.Viewer(ctx)
+	if err != nil {
+		return Secret{}, err
+	}
+	return c.p.DeleteSecret(ctx, u.ID, name)
+}
@@ -19,23 +19,67 @@ func (u *User) setCore(c *Core) {
 	u.c = c
 }
 
-// WorkflowsPage retrieves a page of workflows created by user u.
+// checkViewer returns ErrForbidden unless user u is the authenticated user.
+func (u User) checkViewer(ctx context.Context) error {
+	v, err := u.c.Viewer(ctx)
+	if err != nil {
+		return err
+	}
+	if v.ID != u.ID {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// WorkflowsPage retrieves a page of workflows created by user u. Only the authenticated user may
+// retrieve their workflows.
 func (u User) WorkflowsPage(ctx context.Context, pa PageArgs) (WorkflowsPage, error) {
-	p, err := u.c.p.GetWorkflows(ctx, pa)
+	if err := u.checkViewer(ctx); err != nil {
+		return WorkflowsPage{}, err
+	}
+	p, err := u.c.p.GetWorkflowsByUserID(ctx, pa, u.ID)
 	p.setCore(u.c)
 	return p, err
 }
 
-// JobsPage retrieves a page of jobs created by user u.
+// JobsPage retrieves a page of jobs created by user u. Only the authenticated user may
+// retrieve their jobs.
 func (u User) JobsPage(ctx context.Context, pa PageArgs) (JobsPage, error) {
-	p, err := u.c.p.GetJobs(ctx, pa)
+	if err := u.checkViewer(ctx); err != nil {
+		return JobsPage{}, err
+	}
+	p, err := u.c.p.GetJobsByUserID(ctx, pa, u.ID)
 	p.setCore(u.c)
 	return p, err
 }
 
-// VolumesPage retrieves a page of volumes created by user u.
+// VolumesPage retrieves a page of volumes created by user u. Only the authenticated user may
+// retrieve their volumes.
 func (u User) VolumesPage(ctx context.Context, pa PageArgs) (VolumesPage, error) {
-	p, err := u.c.p.GetVolumes(ctx, pa)
+	if err := u.checkViewer(ctx); err != nil {
+		return VolumesPage{}, err
+	}
+	p, err := u.c.p.GetVolumesByUserID(ctx, pa, u.ID)
+	p.setCore(u.c)
+	return p, err
+}
+
+// ProjectsPage retrieves a page of projects user u is a member of. Only the authenticated user may
+// retrieve their projects.
+func (u User) ProjectsPage(ctx context.Context, pa PageArgs) (ProjectsPage, error) {
+	if err := u.checkViewer(ctx); err != nil {
+		return ProjectsPage{}, err
+	}
+	p, err := u.c.p.GetProjectsByUserID(ctx, pa, u.ID)
 	p.setCore(u.c)
 	return p, err
 }
+
+// SecretsPage retrieves a page of secrets registered by user u. Only the authenticated user may
+// retrieve their secrets.
+func (u User) SecretsPage(ctx context.Context, pa PageArgs) (SecretsPage, error) {
+	if err := u.checkViewer(ctx); err != nil {
+		return SecretsPage{}, err
+	}
+	return u.c.p.GetSecretsByUserID(ctx, pa, u.ID)
+}
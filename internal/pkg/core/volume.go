@@ -38,6 +38,9 @@ type Volume struct {
 	Name       string     `bson:"name"`
 	Type       VolumeType `bson:"type"`
 
+	CreatedByID string `bson:"createdByID"`         // ID of the user that created the volume.
+	ProjectID   string `bson:"projectID,omitempty"` // ID of the project the volume belongs to, if any.
+
 	c *Core // Used internally for lazy loading.
 }
 
@@ -70,7 +73,7 @@ type volumeSpec struct {
 type VolumePersister interface {
 	CreateVolume(context.Context, Volume) (Volume, error)
 	DeleteVolumesByWorkflowID(context.Context, string) error
-	GetVolumes(context.Context, PageArgs) (VolumesPage, error)
+	GetVolumesByUserID(context.Context, PageArgs, string) (VolumesPage, error)
 	GetVolumesByWorkflowID(context.Context, PageArgs, string) (VolumesPage, error)
 }
 
@@ -87,9 +90,11 @@ func createVolumes(ctx context.Context, p Persister, w Workflow, specs *[]volume
 			}
 
 			v, err := p.CreateVolume(ctx, Volume{
-				WorkflowID: w.ID,
-				Name:       vs.Name,
-				Type:       vs.Type,
+				WorkflowID:  w.ID,
+				Name:        vs.Name,
+				Type:        vs.Type,
+				CreatedByID: w.CreatedByID,
+				ProjectID:   w.ProjectID,
 			})
 			if err != nil {
 				return nil, err
@@ -4,7 +4,11 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/sylabs/fuzzball-service/internal/pkg/graph"
@@ -15,31 +19,120 @@ type WorkflowPersister interface {
 	CreateWorkflow(context.Context, Workflow) (Workflow, error)
 	DeleteWorkflow(context.Context, string) (Workflow, error)
 	GetWorkflow(context.Context, string) (Workflow, error)
-	GetWorkflows(context.Context, PageArgs) (WorkflowsPage, error)
+	GetWorkflowsByUserID(context.Context, PageArgs, string) (WorkflowsPage, error)
+	GetWorkflowsByProjectID(context.Context, PageArgs, string) (WorkflowsPage, error)
+	CountUnfinishedWorkflowsByUserID(context.Context, string) (int, error)
+	CountUnfinishedWorkflowsByProjectID(context.Context, string) (int, error)
+}
+
+// WorkflowStatus describes the state of a workflow.
+type WorkflowStatus string
+
+// Workflow states.
+const (
+	WorkflowPending   WorkflowStatus = "PENDING"   // Created, but not yet scheduled.
+	WorkflowScheduled WorkflowStatus = "SCHEDULED" // Scheduled, but not yet running.
+	WorkflowRunning   WorkflowStatus = "RUNNING"   // Running.
+	WorkflowSucceeded WorkflowStatus = "SUCCEEDED" // All jobs completed successfully.
+	WorkflowFailed    WorkflowStatus = "FAILED"    // One or more jobs did not complete successfully.
+	WorkflowCancelled WorkflowStatus = "CANCELLED" // Cancelled before completion.
+	WorkflowTimedOut  WorkflowStatus = "TIMED_OUT" // Did not complete within its timeout.
+)
+
+func (s WorkflowStatus) String() string {
+	return string(s)
+}
+
+// IsTerminal returns true if s is a final state, from which no further transitions occur.
+func (s WorkflowStatus) IsTerminal() bool {
+	switch s {
+	case WorkflowSucceeded, WorkflowFailed, WorkflowCancelled, WorkflowTimedOut:
+		return true
+	}
+	return false
 }
 
 // Workflow represents a workflow.
 type Workflow struct {
-	ID         string     `bson:"_id,omitempty"`
-	CreatedAt  time.Time  `bson:"createdAt"`
-	StartedAt  *time.Time `bson:"startedAt,omitempty"`
-	FinishedAt *time.Time `bson:"finishedAt,omitempty"`
-	Name       string     `bson:"name"`
-	Status     string     `bson:"status"`
+	ID         string         `bson:"_id,omitempty"`
+	CreatedAt  time.Time      `bson:"createdAt"`
+	StartedAt  *time.Time     `bson:"startedAt,omitempty"`
+	FinishedAt *time.Time     `bson:"finishedAt,omitempty"`
+	Name       string         `bson:"name"`
+	Status     WorkflowStatus `bson:"status"`
+
+	// Maximum number of jobs to run concurrently (unbounded if zero).
+	MaxConcurrency int `bson:"maxConcurrency,omitempty"`
+
+	// Maximum time the workflow may run for (unbounded if zero).
+	Timeout time.Duration `bson:"timeout,omitempty"`
+
+	// If not empty, the node that the workflow's volumes were created on.
+	NodeID string `bson:"nodeID,omitempty"`
+
+	CreatedByID    string `bson:"createdByID"`         // ID of the user that created the workflow.
+	CreatedByLogin string `bson:"createdByLogin"`      // Login of the user that created the workflow.
+	ProjectID      string `bson:"projectID,omitempty"` // ID of the project the workflow belongs to, if any.
+
+	// The replica of the scheduler running the workflow, if any, and when its lease expires.
+	LeaseOwner     string     `bson:"owner,omitempty"`
+	LeaseExpiresAt *time.Time `bson:"leaseExpiresAt,omitempty"`
 
 	c *Core // Used internally for lazy loading.
 }
 
+// isLeased returns true if a replica of the scheduler holds a lease on workflow w as of t.
+func (w Workflow) isLeased(t time.Time) bool {
+	return w.LeaseOwner != "" && w.LeaseExpiresAt != nil && w.LeaseExpiresAt.After(t)
+}
+
+// Duration returns how long workflow w ran for. If the workflow has started but not finished, the
+// time elapsed as of now is returned. If the workflow has not started, ok is false.
+func (w Workflow) Duration(now time.Time) (d time.Duration, ok bool) {
+	return duration(w.StartedAt, w.FinishedAt, now)
+}
+
+// getWorkflowJobs returns all jobs in the workflow with the supplied ID.
+func (c *Core) getWorkflowJobs(ctx context.Context, wid string) ([]Job, error) {
+	var jobs []Job
+	var pa PageArgs
+	for {
+		p, err := c.p.GetJobsByWorkflowID(ctx, pa, wid)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, p.Jobs...)
+
+		if !p.PageInfo.HasNextPage {
+			return jobs, nil
+		}
+		pa.After = p.PageInfo.EndCursor
+	}
+}
+
 // CreatedBy retrieves the user that created workflow w.
 func (w Workflow) CreatedBy(ctx context.Context) (User, error) {
 	u := User{
-		ID:    "507f1f77bcf86cd799439011",
-		Login: "jimbob",
+		ID:    w.CreatedByID,
+		Login: w.CreatedByLogin,
 	}
 	u.setCore(w.c)
 	return u, nil
 }
 
+// Project retrieves the project workflow w belongs to. If the workflow does not belong to a
+// project, ok is false.
+func (w Workflow) Project(ctx context.Context) (p Project, ok bool, err error) {
+	if w.ProjectID == "" {
+		return Project{}, false, nil
+	}
+	p, err = w.c.getProject(ctx, w.ProjectID, ProjectViewer)
+	if err != nil {
+		return Project{}, false, err
+	}
+	return p, true, nil
+}
+
 // JobsPage retrieves a page of jobs related to workflow w.
 func (w Workflow) JobsPage(ctx context.Context, pa PageArgs) (JobsPage, error) {
 	p, err := w.c.p.GetJobsByWorkflowID(ctx, pa, w.ID)
@@ -79,7 +172,251 @@ func (p *WorkflowsPage) setCore(c *Core) {
 	}
 }
 
-func (c *Core) createJobs(ctx context.Context, w Workflow, volumes map[string]Volume, specs []jobSpec) ([]Job, error) {
+// parseTimeout parses a timeout duration string, such as "1h30m".
+func parseTimeout(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout: %w", err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid timeout: %v", d)
+	}
+	return d, nil
+}
+
+// getRetryPolicy returns the retry policy declared by job spec js.
+func getRetryPolicy(js jobSpec) (p RetryPolicy, err error) {
+	if js.Retries != nil {
+		if *js.Retries < 0 {
+			return RetryPolicy{}, fmt.Errorf("job %q has negative retries", js.Name)
+		}
+		p.Retries = int(*js.Retries)
+	}
+
+	if js.RetryBackoff != nil {
+		if p.Backoff, err = time.ParseDuration(*js.RetryBackoff); err != nil {
+			return RetryPolicy{}, fmt.Errorf("job %q has invalid retry backoff: %w", js.Name, err)
+		}
+		if p.Backoff < 0 {
+			return RetryPolicy{}, fmt.Errorf("job %q has negative retry backoff", js.Name)
+		}
+	}
+
+	if js.RetryExitCodes != nil {
+		for _, rc := range *js.RetryExitCodes {
+			p.ExitCodes = append(p.ExitCodes, int(rc))
+		}
+	}
+	return p, nil
+}
+
+// memoryRegexp matches an amount of memory, with an optional binary unit suffix.
+var memoryRegexp = regexp.MustCompile(`^([0-9]+)(?:([KMGT])(?:i?B)?)?$`)
+
+// parseMemory parses an amount of memory, such as "512MiB" or "4G", returning the number of bytes.
+// Unit suffixes are interpreted as powers of 1024.
+func parseMemory(s string) (int64, error) {
+	m := memoryRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid memory: %q", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory: %w", err)
+	}
+
+	var shift uint
+	switch m[2] {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	case "T":
+		shift = 40
+	}
+	if n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid memory: %q", s)
+	}
+	return n << shift, nil
+}
+
+// maxArrayLength is the maximum number of jobs a job array may expand into.
+const maxArrayLength = 10000
+
+// Environment variables set for each job of a job array.
+const (
+	ArrayIndexEnv = "FUZZBALL_ARRAY_INDEX" // Index of the job within the array, starting from zero.
+	ArrayValueEnv = "FUZZBALL_ARRAY_VALUE" // Parameter value of the job, if values are supplied.
+)
+
+// getArray returns the number of jobs job spec js expands into, and the parameter value of each,
+// if supplied. If js does not describe a job array, n is zero.
+func getArray(js jobSpec) (n int, values []string, err error) {
+	if js.Array == nil {
+		return 0, nil, nil
+	}
+
+	switch a := js.Array; {
+	case a.Count != nil && a.Values != nil:
+		return 0, nil, fmt.Errorf("job %q: array must not specify both count and values", js.Name)
+	case a.Count != nil:
+		n = int(*a.Count)
+	case a.Values != nil:
+		values = *a.Values
+		n = len(values)
+	default:
+		return 0, nil, fmt.Errorf("job %q: array must specify count or values", js.Name)
+	}
+
+	if n < 1 || n > maxArrayLength {
+		return 0, nil, fmt.Errorf("job %q: invalid array length %v (must be between 1 and %v)", js.Name, n, maxArrayLength)
+	}
+	return n, values, nil
+}
+
+// getCondition returns the condition under which the job described by job spec js is run. A
+// condition other than JobOnSuccess is only permitted if the job requires other jobs.
+func getCondition(js jobSpec) (JobCondition, error) {
+	if js.When == nil {
+		return JobOnSuccess, nil
+	}
+
+	switch c := JobCondition(*js.When); c {
+	case JobOnSuccess:
+		return c, nil
+	case JobOnFailure, JobAlways:
+		if js.Requires == nil || len(*js.Requires) == 0 {
+			return "", fmt.Errorf("job %q: condition %v requires at least one required job", js.Name, c)
+		}
+		return c, nil
+	default:
+		return "", fmt.Errorf("job %q has invalid condition %q", js.Name, c)
+	}
+}
+
+// getResources returns the resources required by job spec js.
+func getResources(js jobSpec) (r Resources, err error) {
+	if js.Resources == nil {
+		return Resources{}, nil
+	}
+
+	if js.Resources.CPUs != nil {
+		if *js.Resources.CPUs < 0 {
+			return Resources{}, fmt.Errorf("job %q has negative cpus", js.Name)
+		}
+		r.CPUs = int(*js.Resources.CPUs)
+	}
+
+	if js.Resources.Memory != nil {
+		if r.Memory, err = parseMemory(*js.Resources.Memory); err != nil {
+			return Resources{}, fmt.Errorf("job %q: %w", js.Name, err)
+		}
+	}
+
+	if js.Resources.Walltime != nil {
+		if r.Walltime, err = time.ParseDuration(*js.Resources.Walltime); err != nil {
+			return Resources{}, fmt.Errorf("job %q has invalid walltime: %w", js.Name, err)
+		}
+		if r.Walltime < 0 {
+			return Resources{}, fmt.Errorf("job %q has negative walltime", js.Name)
+		}
+	}
+	return r, nil
+}
+
+// getNodeLabels returns the labels a node must have for the job described by job spec js to be
+// placed on it. Each key must be non-empty, and declared once.
+func getNodeLabels(js jobSpec) (map[string]string, error) {
+	if js.NodeLabels == nil || len(*js.NodeLabels) == 0 {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	for _, l := range *js.NodeLabels {
+		if l.Key == "" {
+			return nil, fmt.Errorf("job %q has node label with empty key", js.Name)
+		}
+		if _, ok := labels[l.Key]; ok {
+			return nil, fmt.Errorf("job %q has duplicate node label %q", js.Name, l.Key)
+		}
+		labels[l.Key] = l.Value
+	}
+	return labels, nil
+}
+
+// envVarNameRegexp matches valid environment variable names.
+var envVarNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnv returns the environment variables declared by specs. Each variable must have a valid
+// name, and either a value or a secret that is registered by the user with ID uid.
+func (c *Core) parseEnv(ctx context.Context, uid string, specs *[]envVarSpec) ([]EnvVar, error) {
+	if specs == nil {
+		return nil, nil
+	}
+
+	var env []EnvVar
+	seen := make(map[string]bool)
+	for _, s := range *specs {
+		if !envVarNameRegexp.MatchString(s.Name) {
+			return nil, fmt.Errorf("invalid environment variable name: %q", s.Name)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("multiple environment variables with same name: %s", s.Name)
+		}
+		seen[s.Name] = true
+
+		if (s.Value == nil) == (s.Secret == nil) {
+			return nil, fmt.Errorf("environment variable %q must have exactly one of value or secret", s.Name)
+		}
+
+		e := EnvVar{Name: s.Name}
+		if s.Value != nil {
+			e.Value = *s.Value
+		} else {
+			if _, err := c.p.GetSecretByName(ctx, uid, *s.Secret); errors.Is(err, ErrSecretNotFound) {
+				return nil, fmt.Errorf("environment variable %q references nonexistent secret %q", s.Name, *s.Secret)
+			} else if err != nil {
+				return nil, err
+			}
+			e.Secret = *s.Secret
+		}
+		env = append(env, e)
+	}
+	return env, nil
+}
+
+// mergeEnv returns the environment variables in base, extended and overridden by those in env.
+func mergeEnv(base, env []EnvVar) []EnvVar {
+	var merged []EnvVar
+	override := make(map[string]bool)
+	for _, e := range env {
+		override[e.Name] = true
+	}
+	for _, e := range base {
+		if !override[e.Name] {
+			merged = append(merged, e)
+		}
+	}
+	return append(merged, env...)
+}
+
+func (c *Core) createJobs(ctx context.Context, w Workflow, volumes map[string]Volume, ws WorkflowSpec) ([]Job, error) {
+	specs := ws.Jobs
+
+	// environment shared by all jobs in the workflow
+	wenv, err := c.parseEnv(ctx, w.CreatedByID, ws.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	// priority shared by all jobs in the workflow, unless overridden
+	var wpriority int
+	if ws.Priority != nil {
+		wpriority = int(*ws.Priority)
+	}
+
 	// iterate through jobSpecs and add them to the graph and a map by name for later
 	g := graph.New()
 	jobNameMapping := make(map[string]int)
@@ -121,22 +458,22 @@ func (c *Core) createJobs(ctx context.Context, w Workflow, volumes map[string]Vo
 
 	// create jobs in persistent storage
 	var jobs []Job
-	jobNameToID := make(map[string]string)
+	jobNameToIDs := make(map[string][]string)
 	for _, name := range s {
 		// lookup job by name
 		js := specs[jobNameMapping[name]]
 
-		// construct list of required job IDs
+		// construct list of required job IDs, including every job of a required job array
 		requires := []string{}
 		if js.Requires != nil {
 			// convert requires job name to job IDs
 			for _, name := range *js.Requires {
-				id, ok := jobNameToID[name]
+				ids, ok := jobNameToIDs[name]
 				if !ok {
 					return nil, fmt.Errorf("jobs created in invalid order")
 				}
 
-				requires = append(requires, id)
+				requires = append(requires, ids...)
 			}
 		}
 
@@ -152,20 +489,100 @@ func (c *Core) createJobs(ctx context.Context, w Workflow, volumes map[string]Vo
 			}
 		}
 
-		j, err := c.p.CreateJob(ctx, Job{
-			WorkflowID: w.ID,
-			Name:       js.Name,
-			Image:      js.Image,
-			Command:    js.Command,
-			Requires:   requires,
-			Volumes:    volumeReqs,
-		})
+		retry, err := getRetryPolicy(js)
+		if err != nil {
+			return nil, err
+		}
+
+		var timeout time.Duration
+		if js.Timeout != nil {
+			if timeout, err = parseTimeout(*js.Timeout); err != nil {
+				return nil, fmt.Errorf("job %q: %w", js.Name, err)
+			}
+		}
+
+		resources, err := getResources(js)
+		if err != nil {
+			return nil, err
+		}
+
+		labels, err := getNodeLabels(js)
+		if err != nil {
+			return nil, err
+		}
+
+		priority := wpriority
+		if js.Priority != nil {
+			priority = int(*js.Priority)
+		}
+
+		env, err := c.parseEnv(ctx, w.CreatedByID, js.Env)
+		if err != nil {
+			return nil, fmt.Errorf("job %q: %w", js.Name, err)
+		}
+
+		when, err := getCondition(js)
+		if err != nil {
+			return nil, err
+		}
+
+		n, values, err := getArray(js)
 		if err != nil {
 			return nil, err
 		}
 
-		jobNameToID[j.Name] = j.ID
-		jobs = append(jobs, j)
+		nj := Job{
+			WorkflowID:     w.ID,
+			CreatedByID:    w.CreatedByID,
+			CreatedByLogin: w.CreatedByLogin,
+			ProjectID:      w.ProjectID,
+			Name:           js.Name,
+			Image:          js.Image,
+			Command:        js.Command,
+			Status:         JobPending,
+			Requires:       requires,
+			Volumes:        volumeReqs,
+			Retry:          retry,
+			Timeout:        timeout,
+			Env:            mergeEnv(wenv, env),
+			Resources:      resources,
+			NodeLabels:     labels,
+			Priority:       priority,
+			When:           when,
+		}
+
+		// a job array expands into a job per index, each named after its index
+		if n == 0 {
+			j, err := c.p.CreateJob(ctx, nj)
+			if err != nil {
+				return nil, err
+			}
+
+			jobNameToIDs[js.Name] = []string{j.ID}
+			jobs = append(jobs, j)
+			continue
+		}
+
+		for i := 0; i < n; i++ {
+			aj := nj
+			index := i
+			aj.ArrayIndex = &index
+			aj.Name = fmt.Sprintf("%s[%d]", js.Name, i)
+
+			aenv := []EnvVar{{Name: ArrayIndexEnv, Value: strconv.Itoa(i)}}
+			if values != nil {
+				aenv = append(aenv, EnvVar{Name: ArrayValueEnv, Value: values[i]})
+			}
+			aj.Env = mergeEnv(nj.Env, aenv)
+
+			j, err := c.p.CreateJob(ctx, aj)
+			if err != nil {
+				return nil, err
+			}
+
+			jobNameToIDs[js.Name] = append(jobNameToIDs[js.Name], j.ID)
+			jobs = append(jobs, j)
+		}
 	}
 
 	return jobs, nil
@@ -0,0 +1,112 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// Package fsarchive archives job output in a local filesystem directory.
+package fsarchive
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Archive is a job output archive backed by a directory.
+type Archive struct {
+	dir string
+}
+
+// New returns an archive that stores job output in dir, creating it if necessary.
+func New(dir string) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Archive{dir}, nil
+}
+
+// path returns the path at which job output archived under key is stored.
+func (a *Archive) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("invalid key: %q", key)
+	}
+	return filepath.Join(a.dir, key), nil
+}
+
+// PutArchivedJobOutput archives job output read from r under key, replacing any output previously
+// archived under key.
+func (a *Archive) PutArchivedJobOutput(key string, r io.Reader) error {
+	path, err := a.path(key)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file, and rename it into place once complete.
+	f, err := ioutil.TempFile(a.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// DeleteArchivedJobOutput deletes the job output archived under key. If no output is archived under
+// key, no action is taken.
+func (a *Archive) DeleteArchivedJobOutput(key string) error {
+	path, err := a.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// GetArchivedJobOutputSize returns the size of the job output archived under key, in bytes. If no
+// output is archived under key, ok is false.
+func (a *Archive) GetArchivedJobOutputSize(key string) (size int, ok bool, err error) {
+	path, err := a.path(key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return int(fi.Size()), true, nil
+}
+
+// GetArchivedJobOutputRange retrieves up to limit bytes of the job output archived under key,
+// starting at byte offset. If no output is archived under key, an error is returned.
+func (a *Archive) GetArchivedJobOutputRange(key string, offset, limit int) (string, error) {
+	path, err := a.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	b := make([]byte, limit)
+	n, err := f.ReadAt(b, int64(offset))
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
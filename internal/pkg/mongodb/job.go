@@ -70,9 +70,9 @@ func (c *Connection) GetJob(ctx context.Context, id string) (j core.Job, err err
 	return j, nil
 }
 
-// GetJobs returns a list of all jobs.
-func (c *Connection) GetJobs(ctx context.Context, pa core.PageArgs) (p core.JobsPage, err error) {
-	pi, tc, err := findPageEx(ctx, c.db.Collection(jobCollectionName), maxPageSize, bson.M{}, pa, &p.Jobs)
+// GetJobsByUserID returns a list of all jobs created by the user with the supplied ID.
+func (c *Connection) GetJobsByUserID(ctx context.Context, pa core.PageArgs, uid string) (p core.JobsPage, err error) {
+	pi, tc, err := findPageEx(ctx, c.db.Collection(jobCollectionName), maxPageSize, bson.M{"createdByID": uid}, pa, &p.Jobs)
 	if err != nil {
 		return p, err
 	}
@@ -120,6 +120,18 @@ func (c *Connection) GetJobsByWorkflowID(ctx context.Context, pa core.PageArgs,
 	return p, nil
 }
 
+// GetJobsFinishedBetween returns a list of all jobs that finished at or after from, and before to.
+func (c *Connection) GetJobsFinishedBetween(ctx context.Context, pa core.PageArgs, from, to time.Time) (p core.JobsPage, err error) {
+	filter := bson.M{"finishedAt": bson.M{"$gte": from, "$lt": to}}
+	pi, tc, err := findPageEx(ctx, c.db.Collection(jobCollectionName), maxPageSize, filter, pa, &p.Jobs)
+	if err != nil {
+		return p, err
+	}
+	p.PageInfo = pi
+	p.TotalCount = tc
+	return p, nil
+}
+
 // updateJob applies update to the job with ID id in collection col. If the supplied ID is not
 // valid, or there there is not a job with a matching ID in the database, an error is returned.
 func updateJob(ctx context.Context, col *mongo.Collection, id string, update bson.M) error {
@@ -136,7 +148,7 @@ func updateJob(ctx context.Context, col *mongo.Collection, id string, update bso
 
 // SetJobStatus updates a job's status. If the supplied ID is not valid, or there there is not a
 // job with a matching ID in the database, an error is returned.
-func (c *Connection) SetJobStatus(ctx context.Context, id, status string) error {
+func (c *Connection) SetJobStatus(ctx context.Context, id string, status core.JobStatus) error {
 	update := bson.M{"$set": bson.M{"status": status}}
 	return updateJob(ctx, c.db.Collection(jobCollectionName), id, update)
 }
@@ -147,3 +159,39 @@ func (c *Connection) SetJobExitCode(ctx context.Context, id string, exitCode int
 	update := bson.M{"$set": bson.M{"exitCode": exitCode}}
 	return updateJob(ctx, c.db.Collection(jobCollectionName), id, update)
 }
+
+// SetJobNodeID updates the ID of the node a job was placed on. If the supplied ID is not valid, or
+// there there is not a job with a matching ID in the database, an error is returned.
+func (c *Connection) SetJobNodeID(ctx context.Context, id, nodeID string) error {
+	update := bson.M{"$set": bson.M{"nodeID": nodeID}}
+	return updateJob(ctx, c.db.Collection(jobCollectionName), id, update)
+}
+
+// SetJobOutputIncomplete records that output captured from a job was lost. If the supplied ID is
+// not valid, or there there is not a job with a matching ID in the database, an error is returned.
+func (c *Connection) SetJobOutputIncomplete(ctx context.Context, id string) error {
+	update := bson.M{"$set": bson.M{"outputIncomplete": true}}
+	return updateJob(ctx, c.db.Collection(jobCollectionName), id, update)
+}
+
+// AddJobAttempt records an attempt to run a job. If the supplied ID is not valid, or there there
+// is not a job with a matching ID in the database, an error is returned.
+func (c *Connection) AddJobAttempt(ctx context.Context, id string, a core.JobAttempt) error {
+	update := bson.M{"$push": bson.M{"attempts": a}}
+	return updateJob(ctx, c.db.Collection(jobCollectionName), id, update)
+}
+
+// SetJobStartedAt records when a job started. If the job has already started, the earlier time is
+// retained. If the supplied ID is not valid, or there there is not a job with a matching ID in the
+// database, an error is returned.
+func (c *Connection) SetJobStartedAt(ctx context.Context, id string, t time.Time) error {
+	update := bson.M{"$min": bson.M{"startedAt": t}}
+	return updateJob(ctx, c.db.Collection(jobCollectionName), id, update)
+}
+
+// SetJobFinishedAt records when a job finished. If the supplied ID is not valid, or there there
+// is not a job with a matching ID in the database, an error is returned.
+func (c *Connection) SetJobFinishedAt(ctx context.Context, id string, t time.Time) error {
+	update := bson.M{"$set": bson.M{"finishedAt": t}}
+	return updateJob(ctx, c.db.Collection(jobCollectionName), id, update)
+}
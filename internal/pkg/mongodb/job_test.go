@@ -12,6 +12,7 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/sylabs/fuzzball-service/internal/pkg/core"
 	"go.mongodb.org/mongo-driver/bson"
@@ -25,8 +26,9 @@ var testWorkflowID = "testWorkflowID"
 // varied based on the value of i.
 func getTestJob(i int32) core.Job {
 	return core.Job{
-		Name:       fmt.Sprintf("job-%02d", i),
-		WorkflowID: testWorkflowID,
+		Name:        fmt.Sprintf("job-%02d", i),
+		WorkflowID:  testWorkflowID,
+		CreatedByID: testUserID,
 	}
 }
 
@@ -233,3 +235,195 @@ func TestSetJobStatus(t *testing.T) {
 		t.Errorf("unexpected exit code: got %+v, want %d", got, want)
 	}
 }
+
+func TestSetJobNodeID(t *testing.T) {
+	j := insertTestJob(t, testConnection.db)
+	defer deleteTestJob(t, testConnection.db, j.ID)
+
+	if err := testConnection.SetJobNodeID(context.Background(), j.ID, "nodeID"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// Get should return the node ID.
+	j, err := testConnection.GetJob(context.Background(), j.ID)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	if got, want := j.NodeID, "nodeID"; got != want {
+		t.Errorf("unexpected node ID: got %q, want %q", got, want)
+	}
+
+	// Set should fail with bad BSON ID.
+	if err := testConnection.SetJobNodeID(context.Background(), "oops", "nodeID"); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestSetJobOutputIncomplete(t *testing.T) {
+	j := insertTestJob(t, testConnection.db)
+	defer deleteTestJob(t, testConnection.db, j.ID)
+
+	if err := testConnection.SetJobOutputIncomplete(context.Background(), j.ID); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// Get should return the flag.
+	j, err := testConnection.GetJob(context.Background(), j.ID)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	if !j.OutputIncomplete {
+		t.Error("output not flagged incomplete")
+	}
+
+	// Set should fail with bad BSON ID.
+	if err := testConnection.SetJobOutputIncomplete(context.Background(), "oops"); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestAddJobAttempt(t *testing.T) {
+	j := insertTestJob(t, testConnection.db)
+	defer deleteTestJob(t, testConnection.db, j.ID)
+
+	rc := 1
+	want := []core.JobAttempt{
+		{
+			Number:     1,
+			StartedAt:  time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+			FinishedAt: time.Date(2020, 01, 20, 19, 21, 31, 0, time.UTC),
+			NodeID:     "nodeID",
+			ExitCode:   &rc,
+			OutputKey:  "outputKey",
+		},
+		{
+			Number:     2,
+			StartedAt:  time.Date(2020, 01, 20, 19, 21, 32, 0, time.UTC),
+			FinishedAt: time.Date(2020, 01, 20, 19, 21, 33, 0, time.UTC),
+			Error:      "node lost",
+			OutputKey:  "outputKey",
+		},
+	}
+	for _, a := range want {
+		if err := testConnection.AddJobAttempt(context.Background(), j.ID, a); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	}
+
+	// Get should return the attempts, in order.
+	j, err := testConnection.GetJob(context.Background(), j.ID)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	if got := j.Attempts; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected attempts: got %+v, want %+v", got, want)
+	}
+
+	// Add should fail with bad BSON ID.
+	if err := testConnection.AddJobAttempt(context.Background(), "oops", want[0]); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestSetJobTimes(t *testing.T) {
+	j := insertTestJob(t, testConnection.db)
+	defer deleteTestJob(t, testConnection.db, j.ID)
+
+	first := time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC)
+	second := time.Date(2020, 01, 20, 19, 21, 31, 0, time.UTC)
+
+	// When set more than once, the earliest start time should be retained.
+	for _, t2 := range []time.Time{second, first, second} {
+		if err := testConnection.SetJobStartedAt(context.Background(), j.ID, t2); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	}
+	if err := testConnection.SetJobFinishedAt(context.Background(), j.ID, second); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	j, err := testConnection.GetJob(context.Background(), j.ID)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	if got, want := j.StartedAt, first; got == nil || !got.Equal(want) {
+		t.Errorf("unexpected start time: got %v, want %v", got, want)
+	}
+	if got, want := j.FinishedAt, second; got == nil || !got.Equal(want) {
+		t.Errorf("unexpected finish time: got %v, want %v", got, want)
+	}
+
+	// Set should fail with bad BSON ID.
+	if err := testConnection.SetJobStartedAt(context.Background(), "oops", first); err == nil {
+		t.Error("unexpected success")
+	}
+	if err := testConnection.SetJobFinishedAt(context.Background(), "oops", first); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestGetJobsByUserID(t *testing.T) {
+	j := insertTestJob(t, testConnection.db)
+	defer deleteTestJob(t, testConnection.db, j.ID)
+
+	testFindByUser(t, j.ID, func(t *testing.T, uid string) []string {
+		p, err := testConnection.GetJobsByUserID(context.Background(), core.PageArgs{}, uid)
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+
+		var ids []string
+		for _, x := range p.Jobs {
+			ids = append(ids, x.ID)
+			if got, want := x.CreatedByID, uid; got != want {
+				t.Errorf("got created by ID %v, want %v", got, want)
+			}
+		}
+		return ids
+	})
+}
+
+func TestGetJobsFinishedBetween(t *testing.T) {
+	j := insertTestJob(t, testConnection.db)
+	defer deleteTestJob(t, testConnection.db, j.ID)
+
+	finished := time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC)
+	if err := testConnection.SetJobFinishedAt(context.Background(), j.ID, finished); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		from      time.Time
+		to        time.Time
+		wantFound bool
+	}{
+		{"Within", finished.Add(-time.Second), finished.Add(time.Second), true},
+		{"From", finished, finished.Add(time.Second), true},
+		{"To", finished.Add(-time.Second), finished, false},
+		{"Before", finished.Add(time.Second), finished.Add(2 * time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := testConnection.GetJobsFinishedBetween(context.Background(), core.PageArgs{}, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+
+			found := false
+			for _, x := range p.Jobs {
+				if x.ID == j.ID {
+					found = true
+				}
+			}
+			if got, want := found, tt.wantFound; got != want {
+				t.Errorf("got found %v, want %v", got, want)
+			}
+		})
+	}
+}
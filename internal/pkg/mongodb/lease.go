@@ -0,0 +1,57 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const leaseCollectionName = "leases"
+
+// duplicateKeyCode is the code of the error returned when a write violates a unique index.
+const duplicateKeyCode = 11000
+
+// isDuplicateKey returns true if err results from a write that violates a unique index.
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if !errors.As(err, &we) {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == duplicateKeyCode {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimLease claims the named lease on behalf of owner, which expires at time expires. The lease is
+// granted if it is not held, is already held by owner, or the lease of its holder expired before
+// now. If the lease is granted, ok is true.
+func (c *Connection) ClaimLease(ctx context.Context, name, owner string, now, expires time.Time) (ok bool, err error) {
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expiresAt": expires}}
+
+	// If the lease is held by another owner, the filter does not match, and the upsert fails.
+	_, err = c.db.Collection(leaseCollectionName).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim lease: %w", err)
+	}
+	return true, nil
+}
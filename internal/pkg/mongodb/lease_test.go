@@ -0,0 +1,41 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// +build integration
+
+package mongodb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClaimLease(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Round(time.Millisecond)
+	name := "testLease"
+
+	// claim claims the lease for owner, as of time t.
+	claim := func(t *testing.T, owner string, at time.Time) bool {
+		t.Helper()
+
+		ok, err := testConnection.ClaimLease(ctx, name, owner, at, at.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+		return ok
+	}
+
+	// An unheld lease may be claimed, and the claim renewed.
+	if !claim(t, "a", now) || !claim(t, "a", now) {
+		t.Error("claim not granted")
+	}
+
+	// A competing claim should not be granted until the lease expires.
+	if claim(t, "b", now) {
+		t.Error("competing claim granted")
+	}
+	if !claim(t, "b", now.Add(2*time.Minute)) {
+		t.Error("claim not granted once lease expired")
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package mongodb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// legacyCompletedStatus is the status recorded for finished workflows and jobs before their
+// outcome was recorded.
+const legacyCompletedStatus = "COMPLETED"
+
+// MigrateLegacyStatuses replaces the legacy COMPLETED status of workflows and jobs with the outcome
+// they completed with. A job that exited with a non-zero exit code failed, as did a workflow
+// containing such a job. Otherwise, the workflow or job succeeded. Migration is idempotent.
+func (c *Connection) MigrateLegacyStatuses(ctx context.Context) error {
+	jobs := c.db.Collection(jobCollectionName)
+	workflows := c.db.Collection(workflowCollectionName)
+
+	// Find the workflows that contain a failed job. Jobs refer to their workflow by hex ID.
+	legacy, err := workflows.Distinct(ctx, "_id", bson.M{"status": legacyCompletedStatus})
+	if err != nil {
+		return fmt.Errorf("failed to get workflows: %w", err)
+	}
+	if len(legacy) > 0 {
+		ids := make([]string, 0, len(legacy))
+		for _, v := range legacy {
+			if oid, ok := v.(primitive.ObjectID); ok {
+				ids = append(ids, oid.Hex())
+			}
+		}
+		failed, err := jobs.Distinct(ctx, "workflowID", bson.M{
+			"workflowID": bson.M{"$in": ids},
+			"exitCode":   bson.M{"$exists": true, "$ne": 0},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get failed jobs: %w", err)
+		}
+		oids := make([]primitive.ObjectID, 0, len(failed))
+		for _, v := range failed {
+			if id, ok := v.(string); ok {
+				if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+					oids = append(oids, oid)
+				}
+			}
+		}
+
+		if _, err := workflows.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": oids}, "status": legacyCompletedStatus},
+			bson.M{"$set": bson.M{"status": core.WorkflowFailed}},
+		); err != nil {
+			return fmt.Errorf("failed to migrate workflows: %w", err)
+		}
+		if _, err := workflows.UpdateMany(ctx,
+			bson.M{"status": legacyCompletedStatus},
+			bson.M{"$set": bson.M{"status": core.WorkflowSucceeded}},
+		); err != nil {
+			return fmt.Errorf("failed to migrate workflows: %w", err)
+		}
+	}
+
+	if _, err := jobs.UpdateMany(ctx,
+		bson.M{"status": legacyCompletedStatus, "exitCode": bson.M{"$exists": true, "$ne": 0}},
+		bson.M{"$set": bson.M{"status": core.JobFailed}},
+	); err != nil {
+		return fmt.Errorf("failed to migrate jobs: %w", err)
+	}
+	if _, err := jobs.UpdateMany(ctx,
+		bson.M{"status": legacyCompletedStatus},
+		bson.M{"$set": bson.M{"status": core.JobSucceeded}},
+	); err != nil {
+		return fmt.Errorf("failed to migrate jobs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// +build integration
+
+package mongodb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertLegacyDocument inserts a document with the legacy COMPLETED status into the named
+// collection, and returns its ID.
+func insertLegacyDocument(t *testing.T, collection string, doc bson.M) string {
+	doc["status"] = legacyCompletedStatus
+	ir, err := testConnection.db.Collection(collection).InsertOne(context.Background(), doc)
+	if err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	return ir.InsertedID.(primitive.ObjectID).Hex()
+}
+
+func TestMigrateLegacyStatuses(t *testing.T) {
+	ctx := context.Background()
+
+	succeededID := insertLegacyDocument(t, workflowCollectionName, bson.M{"name": "succeeded"})
+	defer deleteTestWorkflow(t, testConnection.db, succeededID)
+	failedID := insertLegacyDocument(t, workflowCollectionName, bson.M{"name": "failed"})
+	defer deleteTestWorkflow(t, testConnection.db, failedID)
+
+	zeroID := insertLegacyDocument(t, jobCollectionName, bson.M{"workflowID": succeededID, "exitCode": 0})
+	defer testConnection.deleteJob(ctx, zeroID)
+	noExitID := insertLegacyDocument(t, jobCollectionName, bson.M{"workflowID": failedID})
+	defer testConnection.deleteJob(ctx, noExitID)
+	nonZeroID := insertLegacyDocument(t, jobCollectionName, bson.M{"workflowID": failedID, "exitCode": 1})
+	defer testConnection.deleteJob(ctx, nonZeroID)
+
+	// Migration is idempotent, so run it twice.
+	for i := 0; i < 2; i++ {
+		if err := testConnection.MigrateLegacyStatuses(ctx); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+	}
+
+	workflows := []struct {
+		name       string
+		id         string
+		wantStatus core.WorkflowStatus
+	}{
+		{"WorkflowSucceeded", succeededID, core.WorkflowSucceeded},
+		{"WorkflowFailed", failedID, core.WorkflowFailed},
+	}
+	for _, tt := range workflows {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := testConnection.GetWorkflow(ctx, tt.id)
+			if err != nil {
+				t.Fatalf("failed to get workflow: %v", err)
+			}
+			if got, want := w.Status, tt.wantStatus; got != want {
+				t.Errorf("got status %v, want %v", got, want)
+			}
+		})
+	}
+
+	jobs := []struct {
+		name       string
+		id         string
+		wantStatus core.JobStatus
+	}{
+		{"JobZeroExitCode", zeroID, core.JobSucceeded},
+		{"JobNoExitCode", noExitID, core.JobSucceeded},
+		{"JobNonZeroExitCode", nonZeroID, core.JobFailed},
+	}
+	for _, tt := range jobs {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := testConnection.GetJob(ctx, tt.id)
+			if err != nil {
+				t.Fatalf("failed to get job: %v", err)
+			}
+			if got, want := j.Status, tt.wantStatus; got != want {
+				t.Errorf("got status %v, want %v", got, want)
+			}
+		})
+	}
+}
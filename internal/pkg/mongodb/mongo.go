@@ -4,6 +4,8 @@ package mongodb
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -13,11 +15,32 @@ const maxPageSize = 100
 
 // Connection is an active connection to a MongoDB database.
 type Connection struct {
-	db *mongo.Database
+	db   *mongo.Database
+	aead cipher.AEAD // Used to encrypt secrets, if set.
+}
+
+// OptSecretKey sets the key used to encrypt secrets at rest. The key must be 16, 24 or 32 bytes
+// long, to select AES-128, AES-192 or AES-256. If no key is set, secrets cannot be stored.
+func OptSecretKey(key []byte) func(*Connection) error {
+	return func(c *Connection) error {
+		b, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		c.aead, err = cipher.NewGCM(b)
+		return err
+	}
 }
 
 // NewConnection opens a new connection to a MongoDB database.
-func NewConnection(ctx context.Context, mongoURI, dbName string) (c *Connection, err error) {
+func NewConnection(ctx context.Context, mongoURI, dbName string, opts ...func(*Connection) error) (c *Connection, err error) {
+	c = &Connection{}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
 	o := options.Client().ApplyURI(mongoURI)
 	if err := o.Validate(); err != nil {
 		return nil, err
@@ -33,9 +56,7 @@ func NewConnection(ctx context.Context, mongoURI, dbName string) (c *Connection,
 		return nil, err
 	}
 
-	c = &Connection{
-		db: mc.Database(dbName),
-	}
+	c.db = mc.Database(dbName)
 	return c, nil
 }
 
@@ -21,6 +21,39 @@ var (
 	testConnection *Connection
 )
 
+const (
+	testUserID  = "testUserID"  // Owner of test documents.
+	otherUserID = "otherUserID" // User that does not own test documents.
+)
+
+// testFindByUser checks that the document with the supplied ID, created by testUserID, is found
+// by find when looked up by its owner, and not when looked up by another user. find returns the
+// IDs of the documents found for the supplied user ID.
+func testFindByUser(t *testing.T, id string, find func(t *testing.T, uid string) []string) {
+	tests := []struct {
+		name      string
+		uid       string
+		wantFound bool
+	}{
+		{"Owner", testUserID, true},
+		{"OtherUser", otherUserID, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := false
+			for _, x := range find(t, tt.uid) {
+				if x == id {
+					found = true
+				}
+			}
+			if got, want := found, tt.wantFound; got != want {
+				t.Errorf("got found %v, want %v", got, want)
+			}
+		})
+	}
+}
+
 func TestNewDisconnect(t *testing.T) {
 	ctx := context.Background()
 	expiredCtx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Hour))
@@ -57,7 +90,7 @@ func run(m *testing.M) int {
 	testDBName = fmt.Sprintf("fuzzball-service-%09d", time.Now().UnixNano()%time.Second.Nanoseconds())
 
 	// Create test connection.
-	c, err := NewConnection(ctx, *mongoURI, testDBName)
+	c, err := NewConnection(ctx, *mongoURI, testDBName, OptSecretKey(make([]byte, 32)))
 	if err != nil {
 		log.Printf("failed to create new connection: %v", err)
 		return -1
@@ -0,0 +1,171 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const outputBucketName = "jobOutput"
+
+// outputBucket returns the GridFS bucket job output is archived in. A bucket is not safe for
+// concurrent use, so a new one is returned each time.
+func (c *Connection) outputBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(c.db, options.GridFSBucket().SetName(outputBucketName))
+}
+
+// PutArchivedJobOutput archives job output read from r under key, replacing any output previously
+// archived under key.
+func (c *Connection) PutArchivedJobOutput(key string, r io.Reader) error {
+	b, err := c.outputBucket()
+	if err != nil {
+		return err
+	}
+
+	id, err := b.UploadFromStream(key, r)
+	if err != nil {
+		return fmt.Errorf("failed to upload: %w", err)
+	}
+
+	// Remove previous revisions.
+	if err := deleteOutputFiles(b, bson.M{"filename": key, "_id": bson.M{"$ne": id}}); err != nil {
+		return fmt.Errorf("failed to delete previous revision: %w", err)
+	}
+	return nil
+}
+
+// DeleteArchivedJobOutput deletes the job output archived under key. If no output is archived under
+// key, no action is taken.
+func (c *Connection) DeleteArchivedJobOutput(key string) error {
+	b, err := c.outputBucket()
+	if err != nil {
+		return err
+	}
+	return deleteOutputFiles(b, bson.M{"filename": key})
+}
+
+// deleteOutputFiles deletes the files in bucket b that match filter.
+func deleteOutputFiles(b *gridfs.Bucket, filter interface{}) error {
+	ctx := context.TODO()
+	cur, err := b.Find(filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var f struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&f); err != nil {
+			return err
+		}
+		if err := b.Delete(f.ID); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// outputFile describes a file in the GridFS bucket job output is archived in.
+type outputFile struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Length    int64              `bson:"length"`
+	ChunkSize int64              `bson:"chunkSize"`
+}
+
+// findOutputFile finds the latest revision of the job output archived under key. If no output is
+// archived under key, ok is false.
+func (c *Connection) findOutputFile(ctx context.Context, key string) (f outputFile, ok bool, err error) {
+	b, err := c.outputBucket()
+	if err != nil {
+		return outputFile{}, false, err
+	}
+
+	opts := options.GridFSFind().SetSort(bson.M{"uploadDate": -1}).SetLimit(1)
+	cur, err := b.Find(bson.M{"filename": key}, opts)
+	if err != nil {
+		return outputFile{}, false, err
+	}
+	defer cur.Close(ctx)
+
+	if !cur.Next(ctx) {
+		return outputFile{}, false, cur.Err()
+	}
+	if err := cur.Decode(&f); err != nil {
+		return outputFile{}, false, err
+	}
+	return f, true, nil
+}
+
+// GetArchivedJobOutputSize returns the size of the job output archived under key, in bytes. If no
+// output is archived under key, ok is false.
+func (c *Connection) GetArchivedJobOutputSize(key string) (size int, ok bool, err error) {
+	f, ok, err := c.findOutputFile(context.TODO(), key)
+	return int(f.Length), ok, err
+}
+
+// GetArchivedJobOutputRange retrieves up to limit bytes of the job output archived under key,
+// starting at byte offset. If no output is archived under key, an error is returned.
+func (c *Connection) GetArchivedJobOutputRange(key string, offset, limit int) (string, error) {
+	ctx := context.TODO()
+
+	f, ok, err := c.findOutputFile(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("job output %v not archived", key)
+	}
+
+	// Bound the range by the length of the file.
+	start, end := int64(offset), int64(offset)+int64(limit)
+	if end > f.Length {
+		end = f.Length
+	}
+	if start >= end {
+		return "", nil
+	}
+
+	// Read only the chunks that contain the range.
+	first, last := start/f.ChunkSize, (end-1)/f.ChunkSize
+	filter := bson.M{
+		"files_id": f.ID,
+		"n":        bson.M{"$gte": first, "$lte": last},
+	}
+	opts := options.Find().SetSort(bson.M{"n": 1})
+	cur, err := c.db.Collection(outputBucketName+".chunks").Find(ctx, filter, opts)
+	if err != nil {
+		return "", err
+	}
+	defer cur.Close(ctx)
+
+	var buf bytes.Buffer
+	for cur.Next(ctx) {
+		var ch struct {
+			Data []byte `bson:"data"`
+		}
+		if err := cur.Decode(&ch); err != nil {
+			return "", err
+		}
+		buf.Write(ch.Data)
+	}
+	if err := cur.Err(); err != nil {
+		return "", err
+	}
+
+	data := buf.Bytes()
+	lo, hi := start-first*f.ChunkSize, end-first*f.ChunkSize
+	if hi > int64(len(data)) {
+		return "", fmt.Errorf("job output %v truncated", key)
+	}
+	return string(data[lo:hi]), nil
+}
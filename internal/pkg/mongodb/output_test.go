@@ -0,0 +1,86 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// +build integration
+
+package mongodb
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestArchivedJobOutput(t *testing.T) {
+	key := primitive.NewObjectID().Hex()
+
+	// Size should report output not archived.
+	if _, ok, err := testConnection.GetArchivedJobOutputSize(key); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	} else if ok {
+		t.Fatalf("unexpected archived output")
+	}
+
+	// Range should fail.
+	if _, err := testConnection.GetArchivedJobOutputRange(key, 0, 1); err == nil {
+		t.Fatalf("unexpected success")
+	}
+
+	// Put should succeed, including when replacing previously archived output.
+	for _, s := range []string{"old", "hello world"} {
+		if err := testConnection.PutArchivedJobOutput(key, strings.NewReader(s)); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	}
+
+	// Size should return the length of the latest output.
+	size, ok, err := testConnection.GetArchivedJobOutputSize(key)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if !ok {
+		t.Fatalf("output not archived")
+	}
+	if got, want := size, 11; got != want {
+		t.Errorf("got size %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		want   string
+	}{
+		{"All", 0, 11, "hello world"},
+		{"Start", 0, 5, "hello"},
+		{"Middle", 3, 5, "lo wo"},
+		{"End", 6, 100, "world"},
+		{"PastEnd", 20, 5, ""},
+		{"ZeroLimit", 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testConnection.GetArchivedJobOutputRange(key, tt.offset, tt.limit)
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Delete should succeed, including once no output is archived.
+	for i := 0; i < 2; i++ {
+		if err := testConnection.DeleteArchivedJobOutput(key); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	}
+
+	// Size should report output not archived.
+	if _, ok, err := testConnection.GetArchivedJobOutputSize(key); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	} else if ok {
+		t.Fatalf("unexpected archived output")
+	}
+}
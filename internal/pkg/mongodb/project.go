@@ -0,0 +1,116 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const projectCollectionName = "projects"
+
+// CreateProject creates a new project. If an ID is provided in p, it is ignored and replaced with
+// a unique identifier in the returned project.
+func (c *Connection) CreateProject(ctx context.Context, p core.Project) (core.Project, error) {
+	// We want the DB cluster to generate an ID, to ensure it's globally unique.
+	p.ID = ""
+	// Set the creation time, with the precision that MongoDB stores.
+	p.CreatedAt = time.Now().UTC().Round(time.Millisecond)
+
+	ir, err := c.db.Collection(projectCollectionName).InsertOne(ctx, p)
+	if err != nil {
+		return core.Project{}, fmt.Errorf("failed to create project: %w", err)
+	}
+
+	p.ID = ir.InsertedID.(primitive.ObjectID).Hex()
+	return p, nil
+}
+
+// GetProject retrieves a project by ID. If the supplied ID is not valid, or there there is not a
+// project with a matching ID in the database, an error is returned.
+func (c *Connection) GetProject(ctx context.Context, id string) (p core.Project, err error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return core.Project{}, fmt.Errorf("failed to convert object ID: %w", err)
+	}
+	err = c.db.Collection(projectCollectionName).FindOne(ctx, bson.M{"_id": oid}).Decode(&p)
+	if err != nil {
+		return core.Project{}, fmt.Errorf("failed to get project: %w", err)
+	}
+	return p, nil
+}
+
+// GetProjectsByUserID returns a list of all projects the user with the supplied ID is a member of.
+func (c *Connection) GetProjectsByUserID(ctx context.Context, pa core.PageArgs, uid string) (p core.ProjectsPage, err error) {
+	filter := bson.M{"members.userID": uid}
+	pi, tc, err := findPageEx(ctx, c.db.Collection(projectCollectionName), maxPageSize, filter, pa, &p.Projects)
+	if err != nil {
+		return p, err
+	}
+	p.PageInfo = pi
+	p.TotalCount = tc
+	return p, nil
+}
+
+// updateProject applies update to the project matching filter in collection col, and returns the
+// updated project.
+func updateProject(ctx context.Context, col *mongo.Collection, filter, update bson.M) (p core.Project, err error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&p)
+	return p, err
+}
+
+// SetProjectMember adds member m to a project. If the user is already a member of the project,
+// their membership is replaced. If the supplied ID is not valid, or there there is not a project
+// with a matching ID in the database, an error is returned.
+func (c *Connection) SetProjectMember(ctx context.Context, id string, m core.ProjectMember) (core.Project, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return core.Project{}, fmt.Errorf("failed to convert object ID: %w", err)
+	}
+	col := c.db.Collection(projectCollectionName)
+
+	// Replace the existing membership, if there is one.
+	filter := bson.M{"_id": oid, "members.userID": m.UserID}
+	update := bson.M{"$set": bson.M{"members.$": m}}
+	p, err := updateProject(ctx, col, filter, update)
+	if err == nil {
+		return p, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return core.Project{}, fmt.Errorf("failed to update project: %w", err)
+	}
+
+	// Otherwise, add a new membership.
+	filter = bson.M{"_id": oid, "members.userID": bson.M{"$ne": m.UserID}}
+	update = bson.M{"$push": bson.M{"members": m}}
+	p, err = updateProject(ctx, col, filter, update)
+	if err != nil {
+		return core.Project{}, fmt.Errorf("failed to update project: %w", err)
+	}
+	return p, nil
+}
+
+// RemoveProjectMember removes the user with ID uid from a project. If the supplied ID is not
+// valid, or there there is not a project with a matching ID in the database, an error is returned.
+func (c *Connection) RemoveProjectMember(ctx context.Context, id, uid string) (core.Project, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return core.Project{}, fmt.Errorf("failed to convert object ID: %w", err)
+	}
+
+	update := bson.M{"$pull": bson.M{"members": bson.M{"userID": uid}}}
+	p, err := updateProject(ctx, c.db.Collection(projectCollectionName), bson.M{"_id": oid}, update)
+	if err != nil {
+		return core.Project{}, fmt.Errorf("failed to update project: %w", err)
+	}
+	return p, nil
+}
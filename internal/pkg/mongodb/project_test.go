@@ -0,0 +1,186 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// +build integration
+
+package mongodb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// insertTestProject inserts a project into the DB, with the test user as its admin.
+func insertTestProject(t *testing.T, db *mongo.Database) core.Project {
+	p := core.Project{
+		Name: "project",
+		Members: []core.ProjectMember{
+			{UserID: testUserID, Login: "jimbob", Role: core.ProjectAdmin},
+		},
+		CreatedByID:    testUserID,
+		CreatedByLogin: "jimbob",
+	}
+	sr, err := db.Collection(projectCollectionName).InsertOne(context.Background(), p)
+	if err != nil {
+		t.Fatalf("failed to insert: %s", err)
+	}
+	p.ID = sr.InsertedID.(primitive.ObjectID).Hex()
+	return p
+}
+
+// deleteTestProject deletes a project.
+func deleteTestProject(t *testing.T, db *mongo.Database, id string) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		t.Fatalf("failed to parse object ID: %v", err)
+	}
+	m := bson.M{"_id": oid}
+	if err := db.Collection(projectCollectionName).FindOneAndDelete(context.Background(), m).Err(); err != nil {
+		t.Fatalf("failed to delete: %s", err)
+	}
+}
+
+func TestCreateProject(t *testing.T) {
+	orig := core.Project{
+		ID:   "blah",
+		Name: "test",
+		Members: []core.ProjectMember{
+			{UserID: testUserID, Login: "jimbob", Role: core.ProjectAdmin},
+		},
+		CreatedByID:    testUserID,
+		CreatedByLogin: "jimbob",
+	}
+
+	// Create should succeed.
+	p, err := testConnection.CreateProject(context.Background(), orig)
+	if err != nil {
+		t.Fatalf("failed to create: %s", err)
+	}
+	defer deleteTestProject(t, testConnection.db, p.ID)
+
+	// Verify returned project. Force ID and CreatedAt since they are set by CreateProject.
+	orig.ID = p.ID
+	orig.CreatedAt = p.CreatedAt
+	if _, err := primitive.ObjectIDFromHex(p.ID); err != nil {
+		t.Fatalf("project has invalid ID")
+	}
+	if got, want := p, orig; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Get should succeed.
+	p, err = testConnection.GetProject(context.Background(), p.ID)
+	if err != nil {
+		t.Fatalf("failed to get: %s", err)
+	}
+
+	// Verify returned project.
+	if got, want := p, orig; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGetProject(t *testing.T) {
+	p := insertTestProject(t, testConnection.db)
+	defer deleteTestProject(t, testConnection.db, p.ID)
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{"Found", p.ID, false},
+		{"NotFound", primitive.NewObjectID().Hex(), true},
+		{"BadID", "1234", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testConnection.GetProject(context.Background(), tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetProjectsByUserID(t *testing.T) {
+	p := insertTestProject(t, testConnection.db)
+	defer deleteTestProject(t, testConnection.db, p.ID)
+
+	testFindByUser(t, p.ID, func(t *testing.T, uid string) []string {
+		pp, err := testConnection.GetProjectsByUserID(context.Background(), core.PageArgs{}, uid)
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+
+		var ids []string
+		for _, x := range pp.Projects {
+			ids = append(ids, x.ID)
+			if _, ok := x.Role(uid); !ok {
+				t.Errorf("user %v not a member of project %v", uid, x.ID)
+			}
+		}
+		return ids
+	})
+}
+
+func TestSetProjectMember(t *testing.T) {
+	p := insertTestProject(t, testConnection.db)
+	defer deleteTestProject(t, testConnection.db, p.ID)
+
+	tests := []struct {
+		name        string
+		id          string
+		m           core.ProjectMember
+		wantErr     bool
+		wantMembers int
+	}{
+		{"Add", p.ID, core.ProjectMember{UserID: otherUserID, Role: core.ProjectViewer}, false, 2},
+		{"Update", p.ID, core.ProjectMember{UserID: otherUserID, Role: core.ProjectSubmitter}, false, 2},
+		{"NotFound", primitive.NewObjectID().Hex(), core.ProjectMember{UserID: otherUserID}, true, 0},
+		{"BadID", "1234", core.ProjectMember{UserID: otherUserID}, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := testConnection.SetProjectMember(context.Background(), tt.id, tt.m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil {
+				if got, want := len(p.Members), tt.wantMembers; got != want {
+					t.Errorf("got %v members, want %v", got, want)
+				}
+				if got, _ := p.Role(tt.m.UserID); got != tt.m.Role {
+					t.Errorf("got role %v, want %v", got, tt.m.Role)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveProjectMember(t *testing.T) {
+	p := insertTestProject(t, testConnection.db)
+	defer deleteTestProject(t, testConnection.db, p.ID)
+
+	// Remove should succeed.
+	p, err := testConnection.RemoveProjectMember(context.Background(), p.ID, testUserID)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if _, ok := p.Role(testUserID); ok {
+		t.Error("member not removed")
+	}
+
+	// Remove should fail with bad BSON ID.
+	if _, err := testConnection.RemoveProjectMember(context.Background(), "oops", testUserID); err == nil {
+		t.Error("unexpected success")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package mongodb
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const secretCollectionName = "secrets"
+
+// errNoSecretKey is returned when a secret is stored or retrieved without a secret key.
+var errNoSecretKey = errors.New("secret key not configured")
+
+// secretDocument is the representation of a secret in the database. The value of the secret is
+// stored encrypted.
+type secretDocument struct {
+	core.Secret `bson:",inline"`
+	Ciphertext  []byte `bson:"value"`
+}
+
+// secretAD returns the additional data authenticated along with the value of secret s, which binds
+// the encrypted value to the owner and name of the secret.
+func secretAD(s core.Secret) []byte {
+	return []byte(s.CreatedByID + "/" + s.Name)
+}
+
+// sealSecret encrypts the value of secret s. The nonce is prepended to the returned ciphertext.
+func (c *Connection) sealSecret(s core.Secret) ([]byte, error) {
+	if c.aead == nil {
+		return nil, errNoSecretKey
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, []byte(s.Value), secretAD(s)), nil
+}
+
+// openSecret decrypts the value of the secret stored in document d.
+func (c *Connection) openSecret(d secretDocument) (core.Secret, error) {
+	if c.aead == nil {
+		return core.Secret{}, errNoSecretKey
+	}
+	n := c.aead.NonceSize()
+	if len(d.Ciphertext) < n {
+		return core.Secret{}, errors.New("malformed secret")
+	}
+	b, err := c.aead.Open(nil, d.Ciphertext[:n], d.Ciphertext[n:], secretAD(d.Secret))
+	if err != nil {
+		return core.Secret{}, fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	s := d.Secret
+	s.Value = string(b)
+	return s, nil
+}
+
+// SetSecret stores secret s. If the user that created s already has a secret with the same name,
+// its value is replaced. If an ID is provided in s, it is ignored and replaced with the identifier
+// of the stored secret in the returned secret.
+func (c *Connection) SetSecret(ctx context.Context, s core.Secret) (core.Secret, error) {
+	ciphertext, err := c.sealSecret(s)
+	if err != nil {
+		return core.Secret{}, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+
+	// Set the modification time, with the precision that MongoDB stores.
+	now := time.Now().UTC().Round(time.Millisecond)
+
+	filter := bson.M{"createdByID": s.CreatedByID, "name": s.Name}
+	update := bson.M{
+		"$set":         bson.M{"value": ciphertext, "updatedAt": now},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var d secretDocument
+	err = c.db.Collection(secretCollectionName).FindOneAndUpdate(ctx, filter, update, opts).Decode(&d)
+	if err != nil {
+		return core.Secret{}, fmt.Errorf("failed to set secret: %w", err)
+	}
+
+	d.Secret.Value = s.Value
+	return d.Secret, nil
+}
+
+// DeleteSecret deletes the secret with the supplied name created by the user with ID uid. If there
+// is not a matching secret in the database, core.ErrSecretNotFound is returned.
+func (c *Connection) DeleteSecret(ctx context.Context, uid, name string) (core.Secret, error) {
+	filter := bson.M{"createdByID": uid, "name": name}
+
+	var d secretDocument
+	err := c.db.Collection(secretCollectionName).FindOneAndDelete(ctx, filter).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return core.Secret{}, core.ErrSecretNotFound
+	} else if err != nil {
+		return core.Secret{}, fmt.Errorf("failed to delete secret: %w", err)
+	}
+	return d.Secret, nil
+}
+
+// GetSecretByName retrieves the secret with the supplied name created by the user with ID uid,
+// including its decrypted value. If there is not a matching secret in the database,
+// core.ErrSecretNotFound is returned.
+func (c *Connection) GetSecretByName(ctx context.Context, uid, name string) (core.Secret, error) {
+	filter := bson.M{"createdByID": uid, "name": name}
+
+	var d secretDocument
+	err := c.db.Collection(secretCollectionName).FindOne(ctx, filter).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return core.Secret{}, core.ErrSecretNotFound
+	} else if err != nil {
+		return core.Secret{}, fmt.Errorf("failed to get secret: %w", err)
+	}
+	return c.openSecret(d)
+}
+
+// GetSecretsByUserID returns a list of all secrets created by the user with the supplied ID. The
+// values of the secrets are not retrieved.
+func (c *Connection) GetSecretsByUserID(ctx context.Context, pa core.PageArgs, uid string) (p core.SecretsPage, err error) {
+	pi, tc, err := findPageEx(ctx, c.db.Collection(secretCollectionName), maxPageSize, bson.M{"createdByID": uid}, pa, &p.Secrets)
+	if err != nil {
+		return p, err
+	}
+	p.PageInfo = pi
+	p.TotalCount = tc
+	return p, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// +build integration
+
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSecret(t *testing.T) {
+	ctx := context.Background()
+
+	// Set should create the secret.
+	s, err := testConnection.SetSecret(ctx, core.Secret{
+		ID:          "blah",
+		Name:        "token",
+		Value:       "one",
+		CreatedByID: testUserID,
+	})
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if s.ID == "blah" {
+		t.Errorf("failed to generate unique ID")
+	}
+	if s.CreatedAt.IsZero() {
+		t.Errorf("created time not set")
+	}
+
+	// The value should not be stored in plaintext.
+	var d bson.M
+	if err := testConnection.db.Collection(secretCollectionName).FindOne(ctx, bson.M{"name": "token"}).Decode(&d); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if v, ok := d["value"].(string); ok && v == "one" {
+		t.Errorf("secret value stored in plaintext")
+	}
+
+	// Set should replace the value of an existing secret.
+	u, err := testConnection.SetSecret(ctx, core.Secret{
+		Name:        "token",
+		Value:       "two",
+		CreatedByID: testUserID,
+	})
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := u.ID, s.ID; got != want {
+		t.Errorf("got ID %v, want %v", got, want)
+	}
+	if got, want := u.CreatedAt, s.CreatedAt; !got.Equal(want) {
+		t.Errorf("got created at %v, want %v", got, want)
+	}
+
+	// Get should return the decrypted value.
+	g, err := testConnection.GetSecretByName(ctx, testUserID, "token")
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := g.Value, "two"; got != want {
+		t.Errorf("got value %v, want %v", got, want)
+	}
+
+	// Secrets of other users should not be visible.
+	if _, err := testConnection.GetSecretByName(ctx, otherUserID, "token"); !errors.Is(err, core.ErrSecretNotFound) {
+		t.Errorf("got err %v, want %v", err, core.ErrSecretNotFound)
+	}
+
+	// Listing should not return values.
+	p, err := testConnection.GetSecretsByUserID(ctx, core.PageArgs{}, testUserID)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := p.TotalCount, 1; got != want {
+		t.Fatalf("got total count %v, want %v", got, want)
+	}
+	if got, want := p.Secrets[0].Name, "token"; got != want {
+		t.Errorf("got name %v, want %v", got, want)
+	}
+	if got := p.Secrets[0].Value; got != "" {
+		t.Errorf("got value %v, want none", got)
+	}
+
+	// Delete should remove the secret.
+	if _, err := testConnection.DeleteSecret(ctx, testUserID, "token"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if _, err := testConnection.DeleteSecret(ctx, testUserID, "token"); !errors.Is(err, core.ErrSecretNotFound) {
+		t.Errorf("got err %v, want %v", err, core.ErrSecretNotFound)
+	}
+}
+
+func TestSecretNoKey(t *testing.T) {
+	c := &Connection{db: testConnection.db}
+
+	if _, err := c.SetSecret(context.Background(), core.Secret{Name: "token", CreatedByID: testUserID}); err == nil {
+		t.Errorf("unexpected success")
+	}
+}
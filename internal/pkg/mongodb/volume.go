@@ -69,9 +69,9 @@ func (c *Connection) getVolume(ctx context.Context, id string) (v core.Volume, e
 	return v, nil
 }
 
-// GetVolumes returns a list of all volumes.
-func (c *Connection) GetVolumes(ctx context.Context, pa core.PageArgs) (p core.VolumesPage, err error) {
-	pi, tc, err := findPageEx(ctx, c.db.Collection(volumeCollectionName), maxPageSize, bson.M{}, pa, &p.Volumes)
+// GetVolumesByUserID returns a list of all volumes created by the user with the supplied ID.
+func (c *Connection) GetVolumesByUserID(ctx context.Context, pa core.PageArgs, uid string) (p core.VolumesPage, err error) {
+	pi, tc, err := findPageEx(ctx, c.db.Collection(volumeCollectionName), maxPageSize, bson.M{"createdByID": uid}, pa, &p.Volumes)
 	if err != nil {
 		return p, err
 	}
@@ -23,8 +23,9 @@ import (
 // varied based on the value of i.
 func getTestVolume(i int32) core.Volume {
 	return core.Volume{
-		Name:       fmt.Sprintf("volume-%02d", i),
-		WorkflowID: testWorkflowID,
+		Name:        fmt.Sprintf("volume-%02d", i),
+		WorkflowID:  testWorkflowID,
+		CreatedByID: testUserID,
 	}
 }
 
@@ -110,3 +111,24 @@ func TestDeleteVolumesByWorkflowID(t *testing.T) {
 		t.Error("unexpected success")
 	}
 }
+
+func TestGetVolumesByUserID(t *testing.T) {
+	v := insertTestVolume(t, testConnection.db)
+	defer deleteTestVolume(t, testConnection.db, v.ID)
+
+	testFindByUser(t, v.ID, func(t *testing.T, uid string) []string {
+		p, err := testConnection.GetVolumesByUserID(context.Background(), core.PageArgs{}, uid)
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+
+		var ids []string
+		for _, x := range p.Volumes {
+			ids = append(ids, x.ID)
+			if got, want := x.CreatedByID, uid; got != want {
+				t.Errorf("got created by ID %v, want %v", got, want)
+			}
+		}
+		return ids
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/sylabs/fuzzball-service/internal/pkg/core"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const workflowCollectionName = "workflows"
@@ -60,9 +61,9 @@ func (c *Connection) GetWorkflow(ctx context.Context, id string) (w core.Workflo
 	return w, nil
 }
 
-// GetWorkflows returns a list of all workflows.
-func (c *Connection) GetWorkflows(ctx context.Context, pa core.PageArgs) (p core.WorkflowsPage, err error) {
-	pi, tc, err := findPageEx(ctx, c.db.Collection(workflowCollectionName), maxPageSize, bson.M{}, pa, &p.Workflows)
+// GetWorkflowsByUserID returns a list of all workflows created by the user with the supplied ID.
+func (c *Connection) GetWorkflowsByUserID(ctx context.Context, pa core.PageArgs, uid string) (p core.WorkflowsPage, err error) {
+	pi, tc, err := findPageEx(ctx, c.db.Collection(workflowCollectionName), maxPageSize, bson.M{"createdByID": uid}, pa, &p.Workflows)
 	if err != nil {
 		return p, err
 	}
@@ -71,19 +72,149 @@ func (c *Connection) GetWorkflows(ctx context.Context, pa core.PageArgs) (p core
 	return p, nil
 }
 
-// SetWorkflowStatus updates a workflow's status.
-// If the supplied ID is not valid, or there there is
-// not a workflow with a matching ID in the database, an error is returned.
-func (c *Connection) SetWorkflowStatus(ctx context.Context, id, status string) (err error) {
+// GetWorkflowsByProjectID returns a list of all workflows submitted to the project with the
+// supplied ID.
+func (c *Connection) GetWorkflowsByProjectID(ctx context.Context, pa core.PageArgs, pid string) (p core.WorkflowsPage, err error) {
+	pi, tc, err := findPageEx(ctx, c.db.Collection(workflowCollectionName), maxPageSize, bson.M{"projectID": pid}, pa, &p.Workflows)
+	if err != nil {
+		return p, err
+	}
+	p.PageInfo = pi
+	p.TotalCount = tc
+	return p, nil
+}
+
+// unfinishedWorkflowStatuses are the statuses of workflows that have not finished.
+var unfinishedWorkflowStatuses = []core.WorkflowStatus{
+	core.WorkflowPending,
+	core.WorkflowScheduled,
+	core.WorkflowRunning,
+}
+
+// countUnfinishedWorkflows returns the number of workflows that match filter and have not
+// finished.
+func (c *Connection) countUnfinishedWorkflows(ctx context.Context, filter bson.M) (int, error) {
+	filter["status"] = bson.M{"$in": unfinishedWorkflowStatuses}
+	n, err := c.db.Collection(workflowCollectionName).CountDocuments(ctx, filter)
+	return int(n), err
+}
+
+// CountUnfinishedWorkflowsByUserID returns the number of workflows created by the user with ID uid
+// that have not finished.
+func (c *Connection) CountUnfinishedWorkflowsByUserID(ctx context.Context, uid string) (int, error) {
+	return c.countUnfinishedWorkflows(ctx, bson.M{"createdByID": uid})
+}
+
+// CountUnfinishedWorkflowsByProjectID returns the number of workflows submitted to the project
+// with ID pid that have not finished.
+func (c *Connection) CountUnfinishedWorkflowsByProjectID(ctx context.Context, pid string) (int, error) {
+	return c.countUnfinishedWorkflows(ctx, bson.M{"projectID": pid})
+}
+
+// GetWorkflowsByStatus returns a list of all workflows with one of the supplied statuses.
+func (c *Connection) GetWorkflowsByStatus(ctx context.Context, pa core.PageArgs, statuses []core.WorkflowStatus) (p core.WorkflowsPage, err error) {
+	// short circuit if we have no statuses to look up
+	// mongo does not like an empty array passed
+	// with the $in parameter
+	if len(statuses) == 0 {
+		return p, nil
+	}
+
+	filter := bson.M{"status": bson.M{"$in": statuses}}
+	pi, tc, err := findPageEx(ctx, c.db.Collection(workflowCollectionName), maxPageSize, filter, pa, &p.Workflows)
+	if err != nil {
+		return p, err
+	}
+	p.PageInfo = pi
+	p.TotalCount = tc
+	return p, nil
+}
+
+// updateWorkflow applies update to the workflow with ID id in collection col. If the supplied ID
+// is not valid, or there there is not a workflow with a matching ID in the database, an error is
+// returned.
+func updateWorkflow(ctx context.Context, col *mongo.Collection, id string, update bson.M) error {
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return fmt.Errorf("failed to convert object ID: %w", err)
 	}
+	err = col.FindOneAndUpdate(ctx, bson.M{"_id": oid}, update).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update workflow: %w", err)
+	}
+	return nil
+}
 
+// SetWorkflowStatus updates a workflow's status. If the supplied ID is not valid, or there there
+// is not a workflow with a matching ID in the database, an error is returned.
+func (c *Connection) SetWorkflowStatus(ctx context.Context, id string, status core.WorkflowStatus) error {
 	update := bson.M{"$set": bson.M{"status": status}}
-	err = c.db.Collection(workflowCollectionName).FindOneAndUpdate(ctx, bson.M{"_id": oid}, update).Err()
+	return updateWorkflow(ctx, c.db.Collection(workflowCollectionName), id, update)
+}
+
+// SetWorkflowNodeID updates the ID of the node a workflow's volumes were created on. If the
+// supplied ID is not valid, or there there is not a workflow with a matching ID in the database,
+// an error is returned.
+func (c *Connection) SetWorkflowNodeID(ctx context.Context, id, nodeID string) error {
+	update := bson.M{"$set": bson.M{"nodeID": nodeID}}
+	return updateWorkflow(ctx, c.db.Collection(workflowCollectionName), id, update)
+}
+
+// SetWorkflowStartedAt records when a workflow started. If the workflow has already started, the
+// earlier time is retained. If the supplied ID is not valid, or there there is not a workflow with
+// a matching ID in the database, an error is returned.
+func (c *Connection) SetWorkflowStartedAt(ctx context.Context, id string, t time.Time) error {
+	update := bson.M{"$min": bson.M{"startedAt": t}}
+	return updateWorkflow(ctx, c.db.Collection(workflowCollectionName), id, update)
+}
+
+// SetWorkflowFinishedAt records when a workflow finished. If the supplied ID is not valid, or
+// there there is not a workflow with a matching ID in the database, an error is returned.
+func (c *Connection) SetWorkflowFinishedAt(ctx context.Context, id string, t time.Time) error {
+	update := bson.M{"$set": bson.M{"finishedAt": t}}
+	return updateWorkflow(ctx, c.db.Collection(workflowCollectionName), id, update)
+}
+
+// ClaimWorkflow claims a lease on the workflow with the supplied ID on behalf of owner, which
+// expires at time expires. The lease is granted if the workflow has no owner, is already owned by
+// owner, or the lease of its owner expired before now. If the lease is granted, ok is true. If the
+// supplied ID is not valid, an error is returned.
+func (c *Connection) ClaimWorkflow(ctx context.Context, id, owner string, now, expires time.Time) (ok bool, err error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, fmt.Errorf("failed to convert object ID: %w", err)
+	}
+	filter := bson.M{
+		"_id": oid,
+		"$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"owner": bson.M{"$exists": false}},
+			bson.M{"leaseExpiresAt": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "leaseExpiresAt": expires}}
+	err = c.db.Collection(workflowCollectionName).FindOneAndUpdate(ctx, filter, update).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim workflow: %w", err)
+	}
+	return true, nil
+}
+
+// ReleaseWorkflow releases the lease owner holds on the workflow with the supplied ID. If owner
+// does not hold the lease, no action is taken. If the supplied ID is not valid, an error is
+// returned.
+func (c *Connection) ReleaseWorkflow(ctx context.Context, id, owner string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return fmt.Errorf("failed to update workflow status: %w", err)
+		return fmt.Errorf("failed to convert object ID: %w", err)
+	}
+	filter := bson.M{"_id": oid, "owner": owner}
+	update := bson.M{"$unset": bson.M{"owner": "", "leaseExpiresAt": ""}}
+	if _, err := c.db.Collection(workflowCollectionName).UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("failed to release workflow: %w", err)
 	}
 	return nil
 }
@@ -12,6 +12,7 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/sylabs/fuzzball-service/internal/pkg/core"
 	"go.mongodb.org/mongo-driver/bson"
@@ -23,7 +24,8 @@ import (
 // varied based on the value of i.
 func getTestWorkflow(i int32) core.Workflow {
 	return core.Workflow{
-		Name: fmt.Sprintf("workflow-%02d", i),
+		Name:        fmt.Sprintf("workflow-%02d", i),
+		CreatedByID: testUserID,
 	}
 }
 
@@ -165,3 +167,256 @@ func TestSetWorkflowStatus(t *testing.T) {
 		t.Errorf("unexpected status: got %q, want %q", w.Status, "newStatus")
 	}
 }
+
+func TestGetWorkflowsByStatus(t *testing.T) {
+	w := insertTestWorkflow(t, testConnection.db)
+	defer deleteTestWorkflow(t, testConnection.db, w.ID)
+
+	if err := testConnection.SetWorkflowStatus(context.Background(), w.ID, core.WorkflowRunning); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		statuses []core.WorkflowStatus
+		wantIDs  []string
+	}{
+		{"None", nil, nil},
+		{"NoMatch", []core.WorkflowStatus{core.WorkflowSucceeded}, nil},
+		{"Match", []core.WorkflowStatus{core.WorkflowScheduled, core.WorkflowRunning}, []string{w.ID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := testConnection.GetWorkflowsByStatus(context.Background(), core.PageArgs{}, tt.statuses)
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+
+			var ids []string
+			for _, w := range p.Workflows {
+				ids = append(ids, w.ID)
+			}
+			if got, want := ids, tt.wantIDs; !reflect.DeepEqual(got, want) {
+				t.Errorf("got IDs %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSetWorkflowNodeID(t *testing.T) {
+	w := insertTestWorkflow(t, testConnection.db)
+	defer deleteTestWorkflow(t, testConnection.db, w.ID)
+
+	if err := testConnection.SetWorkflowNodeID(context.Background(), w.ID, "nodeID"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// Get should return the node ID.
+	w, err := testConnection.GetWorkflow(context.Background(), w.ID)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	if got, want := w.NodeID, "nodeID"; got != want {
+		t.Errorf("unexpected node ID: got %q, want %q", got, want)
+	}
+
+	// Set should fail with bad BSON ID.
+	if err := testConnection.SetWorkflowNodeID(context.Background(), "oops", "nodeID"); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestClaimWorkflow(t *testing.T) {
+	w := insertTestWorkflow(t, testConnection.db)
+	defer deleteTestWorkflow(t, testConnection.db, w.ID)
+
+	ctx := context.Background()
+	now := time.Now().UTC().Round(time.Millisecond)
+
+	// claim claims a lease on the workflow for owner, as of time t.
+	claim := func(t *testing.T, owner string, at time.Time) bool {
+		t.Helper()
+
+		ok, err := testConnection.ClaimWorkflow(ctx, w.ID, owner, at, at.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+		return ok
+	}
+
+	// An unowned workflow may be claimed, and the claim renewed.
+	if !claim(t, "a", now) || !claim(t, "a", now) {
+		t.Error("claim not granted")
+	}
+
+	// A competing claim should not be granted until the lease expires.
+	if claim(t, "b", now) {
+		t.Error("competing claim granted")
+	}
+	if !claim(t, "b", now.Add(2*time.Minute)) {
+		t.Error("claim not granted once lease expired")
+	}
+
+	// Release by a non-owner should have no effect.
+	if err := testConnection.ReleaseWorkflow(ctx, w.ID, "a"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if claim(t, "a", now.Add(2*time.Minute)) {
+		t.Error("competing claim granted")
+	}
+
+	// Once released, the workflow may be claimed by another owner.
+	if err := testConnection.ReleaseWorkflow(ctx, w.ID, "b"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if !claim(t, "a", now) {
+		t.Error("claim not granted once released")
+	}
+
+	// Claim should fail with bad BSON ID.
+	if _, err := testConnection.ClaimWorkflow(ctx, "oops", "a", now, now); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestSetWorkflowTimes(t *testing.T) {
+	w := insertTestWorkflow(t, testConnection.db)
+	defer deleteTestWorkflow(t, testConnection.db, w.ID)
+
+	first := time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC)
+	second := time.Date(2020, 01, 20, 19, 21, 31, 0, time.UTC)
+
+	// When set more than once, the earliest start time should be retained.
+	for _, t2 := range []time.Time{second, first, second} {
+		if err := testConnection.SetWorkflowStartedAt(context.Background(), w.ID, t2); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	}
+	if err := testConnection.SetWorkflowFinishedAt(context.Background(), w.ID, second); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	w, err := testConnection.GetWorkflow(context.Background(), w.ID)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	if got, want := w.StartedAt, first; got == nil || !got.Equal(want) {
+		t.Errorf("unexpected start time: got %v, want %v", got, want)
+	}
+	if got, want := w.FinishedAt, second; got == nil || !got.Equal(want) {
+		t.Errorf("unexpected finish time: got %v, want %v", got, want)
+	}
+
+	// Set should fail with bad BSON ID.
+	if err := testConnection.SetWorkflowStartedAt(context.Background(), "oops", first); err == nil {
+		t.Error("unexpected success")
+	}
+	if err := testConnection.SetWorkflowFinishedAt(context.Background(), "oops", first); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestGetWorkflowsByUserID(t *testing.T) {
+	w := insertTestWorkflow(t, testConnection.db)
+	defer deleteTestWorkflow(t, testConnection.db, w.ID)
+
+	testFindByUser(t, w.ID, func(t *testing.T, uid string) []string {
+		p, err := testConnection.GetWorkflowsByUserID(context.Background(), core.PageArgs{}, uid)
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+
+		var ids []string
+		for _, x := range p.Workflows {
+			ids = append(ids, x.ID)
+			if got, want := x.CreatedByID, uid; got != want {
+				t.Errorf("got created by ID %v, want %v", got, want)
+			}
+		}
+		return ids
+	})
+}
+
+func TestGetWorkflowsByProjectID(t *testing.T) {
+	w := getTestWorkflow(1)
+	w.ProjectID = "projectID"
+	sr, err := testConnection.db.Collection(workflowCollectionName).InsertOne(context.Background(), w)
+	if err != nil {
+		t.Fatalf("failed to insert: %s", err)
+	}
+	w.ID = sr.InsertedID.(primitive.ObjectID).Hex()
+	defer deleteTestWorkflow(t, testConnection.db, w.ID)
+
+	tests := []struct {
+		name      string
+		pid       string
+		wantFound bool
+	}{
+		{"Project", "projectID", true},
+		{"OtherProject", "otherProjectID", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := testConnection.GetWorkflowsByProjectID(context.Background(), core.PageArgs{}, tt.pid)
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+
+			found := false
+			for _, x := range p.Workflows {
+				if x.ID == w.ID {
+					found = true
+				}
+				if got, want := x.ProjectID, tt.pid; got != want {
+					t.Errorf("got project ID %v, want %v", got, want)
+				}
+			}
+			if got, want := found, tt.wantFound; got != want {
+				t.Errorf("got found %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCountUnfinishedWorkflows(t *testing.T) {
+	statuses := []core.WorkflowStatus{core.WorkflowPending, core.WorkflowRunning, core.WorkflowSucceeded}
+	for i, s := range statuses {
+		w := getTestWorkflow(int32(i))
+		w.CreatedByID = "countUserID"
+		w.ProjectID = "countProjectID"
+		w.Status = s
+		sr, err := testConnection.db.Collection(workflowCollectionName).InsertOne(context.Background(), w)
+		if err != nil {
+			t.Fatalf("failed to insert: %s", err)
+		}
+		defer deleteTestWorkflow(t, testConnection.db, sr.InsertedID.(primitive.ObjectID).Hex())
+	}
+
+	tests := []struct {
+		name  string
+		count func(context.Context, string) (int, error)
+		id    string
+		want  int
+	}{
+		{"User", testConnection.CountUnfinishedWorkflowsByUserID, "countUserID", 2},
+		{"OtherUser", testConnection.CountUnfinishedWorkflowsByUserID, otherUserID, 0},
+		{"Project", testConnection.CountUnfinishedWorkflowsByProjectID, "countProjectID", 2},
+		{"OtherProject", testConnection.CountUnfinishedWorkflowsByProjectID, "otherProjectID", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := tt.count(context.Background(), tt.id)
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+			if got, want := n, tt.want; got != want {
+				t.Errorf("got count %v, want %v", got, want)
+			}
+		})
+	}
+}
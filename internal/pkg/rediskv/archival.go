@@ -0,0 +1,65 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package rediskv
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// outputArchivalsKey is the key of the sorted set that holds the IDs of jobs whose output is due
+// to be archived, scored by the Unix time at which archival is due.
+const outputArchivalsKey = "output.archivals"
+
+// claimArchivalsScript returns the members of the sorted set at KEYS[1] with a score of at most
+// ARGV[1], and sets the score of each to ARGV[2], so that they are not returned again until then.
+var claimArchivalsScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, id in ipairs(ids) do
+	redis.call('ZADD', KEYS[1], ARGV[2], id)
+end
+return ids
+`)
+
+// ScheduleJobOutputArchival schedules the output of the job with the supplied id to be archived at
+// time t. The archival is pending until RemoveJobOutputArchival is called, so it survives a
+// restart of the service.
+func (c *Connection) ScheduleJobOutputArchival(id string, t time.Time) error {
+	return c.rc.ZAdd(outputArchivalsKey, redis.Z{
+		Score:  float64(t.Unix()),
+		Member: id,
+	}).Err()
+}
+
+// ClaimDueJobOutputArchivals returns the IDs of jobs whose output is due to be archived as of time
+// t. Each is deferred until t+lease, so that it is not claimed again in the meantime, including
+// concurrently. If the archival is not removed using RemoveJobOutputArchival before the lease
+// expires, it is due once more.
+func (c *Connection) ClaimDueJobOutputArchivals(t time.Time, lease time.Duration) ([]string, error) {
+	v, err := claimArchivalsScript.Run(
+		c.rc,
+		[]string{outputArchivalsKey},
+		strconv.FormatInt(t.Unix(), 10),
+		strconv.FormatInt(t.Add(lease).Unix(), 10),
+	).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	vs, _ := v.([]interface{})
+	ids := make([]string, 0, len(vs))
+	for _, v := range vs {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// RemoveJobOutputArchival removes the pending archival of the output of the job with the supplied
+// id, such as once the output is archived. If no archival is pending, no action is taken.
+func (c *Connection) RemoveJobOutputArchival(id string) error {
+	return c.rc.ZRem(outputArchivalsKey, id).Err()
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// +build integration
+
+package rediskv
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestJobOutputArchival(t *testing.T) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(math.MaxInt32)))
+	if err != nil {
+		t.Fatalf("failed to generate random int: %v", err)
+	}
+	id := fmt.Sprintf("testjob-%d", n.Int64())
+
+	// claimed returns true if the archival of the job is claimed as of time t.
+	claimed := func(t *testing.T, now time.Time) bool {
+		t.Helper()
+
+		ids, err := testConnection.ClaimDueJobOutputArchivals(now, time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+		for _, got := range ids {
+			if got == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	now := time.Now()
+	if err := testConnection.ScheduleJobOutputArchival(id, now.Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// The archival is not yet due.
+	if claimed(t, now) {
+		t.Error("archival claimed before due")
+	}
+
+	// Once due, the archival should be claimed once, until the lease expires.
+	if !claimed(t, now.Add(2*time.Minute)) {
+		t.Error("due archival not claimed")
+	}
+	if claimed(t, now.Add(2*time.Minute)) {
+		t.Error("archival claimed during lease")
+	}
+	if !claimed(t, now.Add(3*time.Hour)) {
+		t.Error("archival not claimed after lease expired")
+	}
+
+	// Once removed, the archival should not be claimed.
+	if err := testConnection.RemoveJobOutputArchival(id); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if claimed(t, now.Add(6*time.Hour)) {
+		t.Error("removed archival claimed")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package rediskv
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ownerKey returns the key that holds the owner of the job with the supplied id.
+func ownerKey(id string) string {
+	return id + ".owner"
+}
+
+// claimScript sets the owner of a job if it has no owner, or renews the claim if it is already
+// owned by the claimant. The owner of the job is returned.
+var claimScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if not cur or cur == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return ARGV[1]
+end
+return cur
+`)
+
+// takeOverScript sets the owner of a job to ARGV[2] if it has no owner, or is owned by ARGV[1]. The
+// owner of the job is returned.
+var takeOverScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if not cur or cur == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+	return ARGV[2]
+end
+return cur
+`)
+
+// releaseScript removes the owner of a job, if it is owned by the releaser.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// ClaimJob attempts to claim ownership of the job with the supplied id on behalf of owner, for
+// duration ttl. If owner already holds the claim, it is renewed. The current owner of the job is
+// returned.
+func (c *Connection) ClaimJob(id, owner string, ttl time.Duration) (string, error) {
+	ms := int64(ttl / time.Millisecond)
+	return claimScript.Run(c.rc, []string{ownerKey(id)}, owner, ms).String()
+}
+
+// TakeOverJob claims ownership of the job with the supplied id on behalf of owner, for duration
+// ttl, if the job is owned by prev, such as when prev has failed. If the job has since been claimed
+// by another owner, no action is taken. The current owner of the job is returned.
+func (c *Connection) TakeOverJob(id, prev, owner string, ttl time.Duration) (string, error) {
+	ms := int64(ttl / time.Millisecond)
+	return takeOverScript.Run(c.rc, []string{ownerKey(id)}, prev, owner, ms).String()
+}
+
+// ReleaseJob releases the claim owner holds on the job with the supplied id. If owner does not
+// hold the claim, no action is taken.
+func (c *Connection) ReleaseJob(id, owner string) error {
+	return releaseScript.Run(c.rc, []string{ownerKey(id)}, owner).Err()
+}
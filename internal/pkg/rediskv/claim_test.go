@@ -0,0 +1,77 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// +build integration
+
+package rediskv
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestClaimJob(t *testing.T) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(math.MaxInt32)))
+	if err != nil {
+		t.Fatalf("failed to generate random int: %v", err)
+	}
+	id := fmt.Sprintf("testjob-%d", n.Int64())
+
+	// First claim should succeed.
+	owner, err := testConnection.ClaimJob(id, "a", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := owner, "a"; got != want {
+		t.Errorf("got owner %v, want %v", got, want)
+	}
+
+	// Competing claim should return the current owner.
+	if owner, err = testConnection.ClaimJob(id, "b", time.Minute); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := owner, "a"; got != want {
+		t.Errorf("got owner %v, want %v", got, want)
+	}
+
+	// Release by a non-owner should have no effect.
+	if err := testConnection.ReleaseJob(id, "b"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if owner, err = testConnection.ClaimJob(id, "b", time.Minute); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := owner, "a"; got != want {
+		t.Errorf("got owner %v, want %v", got, want)
+	}
+
+	// Once released, the job should be claimable by another owner.
+	if err := testConnection.ReleaseJob(id, "a"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if owner, err = testConnection.ClaimJob(id, "b", time.Minute); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := owner, "b"; got != want {
+		t.Errorf("got owner %v, want %v", got, want)
+	}
+
+	// Take over from a replica other than the owner should have no effect.
+	if owner, err = testConnection.TakeOverJob(id, "a", "c", time.Minute); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := owner, "b"; got != want {
+		t.Errorf("got owner %v, want %v", got, want)
+	}
+
+	// Take over from the owner should succeed.
+	if owner, err = testConnection.TakeOverJob(id, "b", "c", time.Minute); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := owner, "c"; got != want {
+		t.Errorf("got owner %v, want %v", got, want)
+	}
+}
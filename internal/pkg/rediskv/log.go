@@ -0,0 +1,379 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package rediskv
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// maxLogLinesPageSize is the maximum number of log lines returned in a page.
+const maxLogLinesPageSize = 1000
+
+// logBatchSize is the number of log lines read at a time when scanning a log.
+const logBatchSize = 1000
+
+// logKey returns the key of the stream that holds the log of the job output stored at key.
+func logKey(key string) string {
+	return key + ".log"
+}
+
+// parseStreamID parses a Redis stream entry ID of the form "<ms>-<seq>".
+func parseStreamID(id string) (ms, seq uint64, err error) {
+	s := strings.SplitN(id, "-", 2)
+	if len(s) != 2 {
+		return 0, 0, fmt.Errorf("malformed stream ID: %v", id)
+	}
+	if ms, err = strconv.ParseUint(s[0], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("malformed stream ID: %w", err)
+	}
+	if seq, err = strconv.ParseUint(s[1], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("malformed stream ID: %w", err)
+	}
+	return ms, seq, nil
+}
+
+// nextStreamID returns the smallest stream entry ID greater than id. If there is no such ID, ok is
+// false.
+func nextStreamID(id string) (next string, ok bool, err error) {
+	ms, seq, err := parseStreamID(id)
+	if err != nil {
+		return "", false, err
+	}
+	switch {
+	case seq < math.MaxUint64:
+		seq++
+	case ms < math.MaxUint64:
+		ms, seq = ms+1, 0
+	default:
+		return "", false, nil
+	}
+	return fmt.Sprintf("%v-%v", ms, seq), true, nil
+}
+
+// prevStreamID returns the largest stream entry ID less than id. If there is no such ID, ok is
+// false.
+func prevStreamID(id string) (prev string, ok bool, err error) {
+	ms, seq, err := parseStreamID(id)
+	if err != nil {
+		return "", false, err
+	}
+	switch {
+	case seq > 0:
+		seq--
+	case ms > 0:
+		ms, seq = ms-1, math.MaxUint64
+	default:
+		return "", false, nil
+	}
+	return fmt.Sprintf("%v-%v", ms, seq), true, nil
+}
+
+// parseLogLine converts stream entry m to a log line.
+func parseLogLine(m redis.XMessage) (core.JobLogLine, error) {
+	l := core.JobLogLine{ID: m.ID}
+	if v, ok := m.Values["stream"].(string); ok {
+		l.Stream = core.JobOutputStream(v)
+	}
+	if v, ok := m.Values["data"].(string); ok {
+		l.Data = v
+	}
+	if v, ok := m.Values["time"].(string); ok {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return core.JobLogLine{}, fmt.Errorf("malformed log line %v: %w", m.ID, err)
+		}
+		l.Time = t
+	}
+	return l, nil
+}
+
+// AddJobLogLine adds l to the log of the job with the supplied id. The ID assigned to the log line
+// is returned.
+func (c *Connection) AddJobLogLine(id string, l core.JobLogLine) (string, error) {
+	return c.rc.XAdd(&redis.XAddArgs{
+		Stream: logKey(id),
+		ID:     "*",
+		Values: map[string]interface{}{
+			"stream": l.Stream.String(),
+			"time":   l.Time.Format(time.RFC3339Nano),
+			"data":   l.Data,
+		},
+	}).Result()
+}
+
+// moveScript renames each key in KEYS that exists to the key that follows it, atomically.
+var moveScript = redis.NewScript(`
+for i = 1, #KEYS, 2 do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		redis.call('RENAME', KEYS[i], KEYS[i+1])
+	end
+end
+return 0
+`)
+
+// MoveJobOutput atomically moves the stored output of the job with the supplied id, along with its
+// log, to key, leaving the job with no output. If the job has no output or log, no action is taken
+// for it.
+func (c *Connection) MoveJobOutput(id, key string) error {
+	return moveScript.Run(c.rc, []string{id, key, logKey(id), logKey(key)}).Err()
+}
+
+// ScanJobLog calls fn with each line of the log of the job with the supplied id, in order. If fn
+// returns an error, the scan stops and the error is returned.
+func (c *Connection) ScanJobLog(id string, fn func(core.JobLogLine) error) error {
+	start := "-"
+	for {
+		ms, err := c.rc.XRangeN(logKey(id), start, "+", logBatchSize).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range ms {
+			l, err := parseLogLine(m)
+			if err != nil {
+				return err
+			}
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+
+		if len(ms) < logBatchSize {
+			return nil
+		}
+
+		next, ok, err := nextStreamID(ms[len(ms)-1].ID)
+		if err != nil || !ok {
+			return err
+		}
+		start = next
+	}
+}
+
+// WriteJobStreamOutput writes the output the job with the supplied id wrote to stream s to w, as
+// it is read from the log.
+func (c *Connection) WriteJobStreamOutput(w io.Writer, id string, s core.JobOutputStream) error {
+	return c.ScanJobLog(id, func(l core.JobLogLine) error {
+		if l.Stream != s {
+			return nil
+		}
+		_, err := io.WriteString(w, l.Data)
+		return err
+	})
+}
+
+// GetJobStreamOutput retrieves the output the job with the supplied id wrote to stream s.
+func (c *Connection) GetJobStreamOutput(id string, s core.JobOutputStream) (string, error) {
+	var b strings.Builder
+	err := c.WriteJobStreamOutput(&b, id, s)
+	return b.String(), err
+}
+
+// parsePageOpts parses the page options, validating and converting fields as needed.
+func parsePageOpts(pa core.PageArgs) (first, last int, start, stop string, ok bool, err error) {
+	// Validate first.
+	if pa.First != nil {
+		if *pa.First < 0 {
+			return 0, 0, "", "", false, fmt.Errorf("invalid 'first' field value: %v", *pa.First)
+		}
+		if first = *pa.First; maxLogLinesPageSize < first {
+			first = maxLogLinesPageSize
+		}
+	}
+
+	// Validate last.
+	if pa.Last != nil {
+		if *pa.Last < 0 {
+			return 0, 0, "", "", false, fmt.Errorf("invalid 'last' field value: %v", *pa.Last)
+		}
+		if last = *pa.Last; maxLogLinesPageSize < last {
+			last = maxLogLinesPageSize
+		}
+	}
+
+	// If neither first nor last were supplied, return maxLogLinesPageSize elements.
+	if first == 0 && last == 0 {
+		first = maxLogLinesPageSize
+	}
+
+	// Convert the exclusive after and before cursors to an inclusive range.
+	start, stop, ok = "-", "+", true
+	if pa.After != nil {
+		if start, ok, err = nextStreamID(*pa.After); err != nil {
+			return 0, 0, "", "", false, fmt.Errorf("invalid 'after' field value: %w", err)
+		}
+	}
+	if ok && pa.Before != nil {
+		if stop, ok, err = prevStreamID(*pa.Before); err != nil {
+			return 0, 0, "", "", false, fmt.Errorf("invalid 'before' field value: %w", err)
+		}
+	}
+	return first, last, start, stop, ok, nil
+}
+
+// GetJobLogLines retrieves a page of the log of the job with the supplied id.
+func (c *Connection) GetJobLogLines(id string, pa core.PageArgs) (core.JobLogLinesPage, error) {
+	first, last, start, stop, ok, err := parsePageOpts(pa)
+	if err != nil {
+		return core.JobLogLinesPage{}, err
+	}
+
+	key := logKey(id)
+
+	n, err := c.rc.XLen(key).Result()
+	if err != nil {
+		return core.JobLogLinesPage{}, err
+	}
+	p := core.JobLogLinesPage{TotalCount: int(n)}
+
+	// If the range is empty, there is nothing more to do.
+	if !ok {
+		return p, nil
+	}
+
+	var ms []redis.XMessage
+	if first > 0 {
+		// Paginating forwards, read one more than required to determine if there is a next page.
+		if ms, err = c.rc.XRangeN(key, start, stop, int64(first+1)).Result(); err != nil {
+			return core.JobLogLinesPage{}, err
+		}
+		if len(ms) > first {
+			ms = ms[:first]
+			p.PageInfo.HasNextPage = true
+		}
+		if last > 0 && len(ms) > last {
+			ms = ms[len(ms)-last:]
+			p.PageInfo.HasPreviousPage = true
+		}
+	} else {
+		// Paginating backwards, read one more than required to determine if there is a previous
+		// page.
+		if ms, err = c.rc.XRevRangeN(key, stop, start, int64(last+1)).Result(); err != nil {
+			return core.JobLogLinesPage{}, err
+		}
+		if len(ms) > last {
+			ms = ms[:last]
+			p.PageInfo.HasPreviousPage = true
+		}
+		for i, j := 0, len(ms)-1; i < j; i, j = i+1, j-1 {
+			ms[i], ms[j] = ms[j], ms[i]
+		}
+	}
+
+	for _, m := range ms {
+		l, err := parseLogLine(m)
+		if err != nil {
+			return core.JobLogLinesPage{}, err
+		}
+		p.LogLines = append(p.LogLines, l)
+	}
+
+	setLogLinesCursors(&p)
+	return p, nil
+}
+
+// setLogLinesCursors sets the start and end cursors of page p from the log lines it holds.
+func setLogLinesCursors(p *core.JobLogLinesPage) {
+	if len(p.LogLines) > 0 {
+		sc := p.LogLines[0].ID
+		p.PageInfo.StartCursor = &sc
+		ec := p.LogLines[len(p.LogLines)-1].ID
+		p.PageInfo.EndCursor = &ec
+	}
+}
+
+// inStreamRange returns true if stream entry ID id lies within the inclusive range from start to
+// stop, where "-" and "+" denote the smallest and largest IDs respectively.
+func inStreamRange(id, start, stop string) (bool, error) {
+	ms, seq, err := parseStreamID(id)
+	if err != nil {
+		return false, err
+	}
+	if start != "-" {
+		sms, sseq, err := parseStreamID(start)
+		if err != nil {
+			return false, err
+		}
+		if ms < sms || (ms == sms && seq < sseq) {
+			return false, nil
+		}
+	}
+	if stop != "+" {
+		sms, sseq, err := parseStreamID(stop)
+		if err != nil {
+			return false, err
+		}
+		if ms > sms || (ms == sms && seq > sseq) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// PageJobLogLines returns the page of log lines ls selected by pa, as GetJobLogLines does for a
+// log that holds ls. The lines must be ordered by ID. This allows a log that is no longer held in
+// Redis, such as one that has been archived, to be paginated consistently.
+func PageJobLogLines(ls []core.JobLogLine, pa core.PageArgs) (core.JobLogLinesPage, error) {
+	first, last, start, stop, ok, err := parsePageOpts(pa)
+	if err != nil {
+		return core.JobLogLinesPage{}, err
+	}
+
+	p := core.JobLogLinesPage{TotalCount: len(ls)}
+
+	// If the range is empty, there is nothing more to do.
+	if !ok {
+		return p, nil
+	}
+
+	for _, l := range ls {
+		in, err := inStreamRange(l.ID, start, stop)
+		if err != nil {
+			return core.JobLogLinesPage{}, err
+		}
+		if in {
+			p.LogLines = append(p.LogLines, l)
+		}
+	}
+
+	if first > 0 {
+		if len(p.LogLines) > first {
+			p.LogLines = p.LogLines[:first]
+			p.PageInfo.HasNextPage = true
+		}
+		if last > 0 && len(p.LogLines) > last {
+			p.LogLines = p.LogLines[len(p.LogLines)-last:]
+			p.PageInfo.HasPreviousPage = true
+		}
+	} else if len(p.LogLines) > last {
+		p.LogLines = p.LogLines[len(p.LogLines)-last:]
+		p.PageInfo.HasPreviousPage = true
+	}
+
+	setLogLinesCursors(&p)
+	return p, nil
+}
+
+// ExpireJobOutput sets the stored output of the job with the supplied id, along with its log, to
+// expire after ttl.
+func (c *Connection) ExpireJobOutput(id string, ttl time.Duration) error {
+	for _, key := range []string{id, logKey(id)} {
+		if err := c.rc.Expire(key, ttl).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteJobOutput deletes the stored output of the job with the supplied id, along with its log.
+func (c *Connection) DeleteJobOutput(id string) error {
+	return c.rc.Del(id, logKey(id)).Err()
+}
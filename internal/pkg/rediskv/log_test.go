@@ -0,0 +1,235 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// +build integration
+
+package rediskv
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// addTestLogLines adds log lines to the log of a new job, and returns the job ID and the added
+// log lines.
+func addTestLogLines(t *testing.T) (string, []core.JobLogLine) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(math.MaxInt32)))
+	if err != nil {
+		t.Fatalf("failed to generate random int: %v", err)
+	}
+	id := fmt.Sprintf("testjob-%d", n.Int64())
+
+	now := time.Now().UTC()
+	ls := []core.JobLogLine{
+		{Stream: core.JobStdout, Time: now, Data: "one\n"},
+		{Stream: core.JobStderr, Time: now.Add(time.Second), Data: "two\n"},
+		{Stream: core.JobStdout, Time: now.Add(2 * time.Second), Data: "three\n"},
+	}
+	for i, l := range ls {
+		if ls[i].ID, err = testConnection.AddJobLogLine(id, l); err != nil {
+			t.Fatalf("failed to add log line: %v", err)
+		}
+	}
+	return id, ls
+}
+
+func TestStreamID(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		wantNext string
+		wantPrev string
+		wantErr  bool
+	}{
+		{"Zero", "0-0", "0-1", "", false},
+		{"Seq", "5-3", "5-4", "5-2", false},
+		{"SeqMax", fmt.Sprintf("5-%v", uint64(math.MaxUint64)), "6-0", fmt.Sprintf("5-%v", uint64(math.MaxUint64-1)), false},
+		{"SeqZero", "5-0", "5-1", fmt.Sprintf("4-%v", uint64(math.MaxUint64)), false},
+		{"Malformed", "5", "", "", true},
+		{"BadMS", "x-0", "", "", true},
+		{"BadSeq", "0-x", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, _, err := nextStreamID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if got, want := next, tt.wantNext; got != want {
+				t.Errorf("got next %v, want %v", got, want)
+			}
+
+			prev, ok, err := prevStreamID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if got, want := ok, tt.wantPrev != ""; got != want {
+				t.Errorf("got ok %v, want %v", got, want)
+			}
+			if got, want := prev, tt.wantPrev; got != want {
+				t.Errorf("got prev %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestGetJobStreamOutput(t *testing.T) {
+	id, _ := addTestLogLines(t)
+
+	tests := []struct {
+		name   string
+		id     string
+		stream core.JobOutputStream
+		want   string
+	}{
+		{"Stdout", id, core.JobStdout, "one\nthree\n"},
+		{"Stderr", id, core.JobStderr, "two\n"},
+		{"NotFound", "notfound", core.JobStdout, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testConnection.GetJobStreamOutput(tt.id, tt.stream)
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetJobLogLines(t *testing.T) {
+	id, ls := addTestLogLines(t)
+
+	intPtr := func(i int) *int { return &i }
+
+	tests := []struct {
+		name         string
+		pa           core.PageArgs
+		wantErr      bool
+		wantLines    []core.JobLogLine
+		wantNext     bool
+		wantPrevious bool
+	}{
+		{"All", core.PageArgs{}, false, ls, false, false},
+		{"First", core.PageArgs{First: intPtr(2)}, false, ls[:2], true, false},
+		{"FirstAfter", core.PageArgs{First: intPtr(2), After: &ls[1].ID}, false, ls[2:], false, false},
+		{"Last", core.PageArgs{Last: intPtr(2)}, false, ls[1:], false, true},
+		{"LastBefore", core.PageArgs{Last: intPtr(2), Before: &ls[1].ID}, false, ls[:1], false, false},
+		{"AfterBefore", core.PageArgs{After: &ls[0].ID, Before: &ls[2].ID}, false, ls[1:2], false, false},
+		{"BeforeFirst", core.PageArgs{Before: &ls[0].ID}, false, nil, false, false},
+		{"BadFirst", core.PageArgs{First: intPtr(-1)}, true, nil, false, false},
+		{"BadLast", core.PageArgs{Last: intPtr(-1)}, true, nil, false, false},
+		{"BadAfter", core.PageArgs{After: &id}, true, nil, false, false},
+		{"BadBefore", core.PageArgs{Before: &id}, true, nil, false, false},
+	}
+
+	// A log that is no longer held in Redis should paginate consistently.
+	gets := map[string]func(core.PageArgs) (core.JobLogLinesPage, error){
+		"Redis": func(pa core.PageArgs) (core.JobLogLinesPage, error) {
+			return testConnection.GetJobLogLines(id, pa)
+		},
+		"Memory": func(pa core.PageArgs) (core.JobLogLinesPage, error) {
+			return PageJobLogLines(ls, pa)
+		},
+	}
+	for _, tt := range tests {
+		for name, get := range gets {
+			tt, get := tt, get
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				p, err := get(tt.pa)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+				}
+
+				if err == nil {
+					if got, want := p.TotalCount, len(ls); got != want {
+						t.Errorf("got total count %v, want %v", got, want)
+					}
+					if got, want := len(p.LogLines), len(tt.wantLines); got != want {
+						t.Fatalf("got %v log lines, want %v", got, want)
+					}
+					for i, l := range p.LogLines {
+						want := tt.wantLines[i]
+						if l.ID != want.ID || l.Stream != want.Stream || l.Data != want.Data || !l.Time.Equal(want.Time) {
+							t.Errorf("got log line %+v, want %+v", l, want)
+						}
+					}
+					if got, want := p.PageInfo.HasNextPage, tt.wantNext; got != want {
+						t.Errorf("got next page %v, want %v", got, want)
+					}
+					if got, want := p.PageInfo.HasPreviousPage, tt.wantPrevious; got != want {
+						t.Errorf("got previous page %v, want %v", got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestMoveJobOutput(t *testing.T) {
+	id, ls := addTestLogLines(t)
+	if _, err := testConnection.AppendJobOutput(id, "output"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	key := id + ".attempt.1"
+
+	// Move should succeed.
+	if err := testConnection.MoveJobOutput(id, key); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// The output and log should be retrievable from the new key only.
+	for k, want := range map[string]string{key: "output", id: ""} {
+		if got, err := testConnection.GetJobOutput(k); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		} else if got != want {
+			t.Errorf("got output %q at %v, want %q", got, k, want)
+		}
+	}
+	p, err := testConnection.GetJobLogLines(key, core.PageArgs{})
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := len(p.LogLines), len(ls); got != want {
+		t.Errorf("got %v log lines, want %v", got, want)
+	}
+	if p, err = testConnection.GetJobLogLines(id, core.PageArgs{}); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got, want := len(p.LogLines), 0; got != want {
+		t.Errorf("got %v log lines, want %v", got, want)
+	}
+
+	// Moving a job with no output or log should succeed.
+	if err := testConnection.MoveJobOutput(id, key); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+}
+
+func TestExpireJobOutput(t *testing.T) {
+	id, _ := addTestLogLines(t)
+	if _, err := testConnection.AppendJobOutput(id, "output"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	if err := testConnection.ExpireJobOutput(id, time.Hour); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	for _, key := range []string{id, logKey(id)} {
+		ttl, err := testConnection.rc.TTL(key).Result()
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+		if ttl <= 0 || ttl > time.Hour {
+			t.Errorf("key %v: unexpected TTL %v", key, ttl)
+		}
+	}
+}
@@ -74,7 +74,28 @@ func (c *Connection) Get(key string) (string, error) {
 	return v, nil
 }
 
+// AppendJobOutput appends data to the stored output of the job with the supplied id, and returns
+// the length of the output after the append.
+func (c *Connection) AppendJobOutput(id, data string) (int64, error) {
+	return c.rc.Append(id, data).Result()
+}
+
 // GetJobOutput retrieves the stored output of the job with the supplied id.
 func (c *Connection) GetJobOutput(id string) (string, error) {
 	return c.Get(id)
 }
+
+// GetJobOutputRange retrieves up to limit bytes of the stored output of the job with the supplied
+// id, starting at byte offset.
+func (c *Connection) GetJobOutputRange(id string, offset, limit int64) (string, error) {
+	if limit <= 0 {
+		return "", nil
+	}
+	return c.rc.GetRange(id, offset, offset+limit-1).Result()
+}
+
+// GetJobOutputSize returns the size of the stored output of the job with the supplied id, in
+// bytes. If no output is stored, 0 is returned without an error.
+func (c *Connection) GetJobOutputSize(id string) (int64, error) {
+	return c.rc.StrLen(id).Result()
+}
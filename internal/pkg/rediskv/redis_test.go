@@ -84,3 +84,76 @@ func TestGetSetAppend(t *testing.T) {
 		t.Fatalf("want %q, got %q", want, val)
 	}
 }
+
+func TestAppendJobOutput(t *testing.T) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(math.MaxInt32)))
+	if err != nil {
+		t.Fatalf("failed to generate random int: %v", err)
+	}
+	id := fmt.Sprintf("testjob-%d", n.Int64())
+
+	for _, want := range []int64{5, 10} {
+		got, err := testConnection.AppendJobOutput(id, "hello")
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+		if got != want {
+			t.Fatalf("want length %v, got %v", want, got)
+		}
+	}
+
+	val, err := testConnection.GetJobOutput(id)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if want := "hellohello"; want != val {
+		t.Fatalf("want %q, got %q", want, val)
+	}
+}
+
+func TestGetJobOutputRange(t *testing.T) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(math.MaxInt32)))
+	if err != nil {
+		t.Fatalf("failed to generate random int: %v", err)
+	}
+	id := fmt.Sprintf("testjob-%d", n.Int64())
+
+	if _, err := testConnection.AppendJobOutput(id, "hello world"); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	size, err := testConnection.GetJobOutputSize(id)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if want := int64(11); size != want {
+		t.Fatalf("want size %v, got %v", want, size)
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		offset int64
+		limit  int64
+		want   string
+	}{
+		{"All", id, 0, 11, "hello world"},
+		{"Start", id, 0, 5, "hello"},
+		{"Middle", id, 3, 5, "lo wo"},
+		{"End", id, 6, 100, "world"},
+		{"PastEnd", id, 20, 5, ""},
+		{"ZeroLimit", id, 0, 0, ""},
+		{"NotFound", "notfound", 0, 5, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testConnection.GetJobOutputRange(tt.id, tt.offset, tt.limit)
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package rediskv
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// outputReleasesKey is the key of the sorted set that holds job output due to be released from
+// the output quotas of its owner, scored by the Unix time at which it is due.
+const outputReleasesKey = "output.releases"
+
+// userOutputSizeKey returns the key that holds the total size of output stored for jobs of the
+// user with the supplied id.
+func userOutputSizeKey(id string) string {
+	return "user." + id + ".outputSize"
+}
+
+// userOutputJobsKey returns the key of the hash that holds the size of output stored for each job
+// of the user with the supplied id.
+func userOutputJobsKey(id string) string {
+	return "user." + id + ".outputJobs"
+}
+
+// projectOutputSizeKey returns the key that holds the total size of output stored for jobs of the
+// project with the supplied id.
+func projectOutputSizeKey(id string) string {
+	return "project." + id + ".outputSize"
+}
+
+// projectOutputJobsKey returns the key of the hash that holds the size of output stored for each
+// job of the project with the supplied id.
+func projectOutputJobsKey(id string) string {
+	return "project." + id + ".outputJobs"
+}
+
+// OutputOwner identifies the user, and optionally the project, that output of a job is accounted
+// to.
+type OutputOwner struct {
+	UserID    string `json:"userID"`
+	ProjectID string `json:"projectID,omitempty"`
+}
+
+// keys returns the total size and per-job size keys of each owner in o.
+func (o OutputOwner) keys() []string {
+	keys := []string{userOutputSizeKey(o.UserID), userOutputJobsKey(o.UserID)}
+	if o.ProjectID != "" {
+		keys = append(keys, projectOutputSizeKey(o.ProjectID), projectOutputJobsKey(o.ProjectID))
+	}
+	return keys
+}
+
+// chargeScript adds ARGV[2] bytes of output of the job with ID ARGV[1] to each owner, whose total
+// size and per-job size keys are supplied in pairs.
+var chargeScript = redis.NewScript(`
+for i = 1, #KEYS, 2 do
+	redis.call('INCRBY', KEYS[i], ARGV[2])
+	redis.call('HINCRBY', KEYS[i+1], ARGV[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseOutputScript removes the output of the job with ID ARGV[1] from each owner, whose total
+// size and per-job size keys are supplied in pairs after KEYS[1]. If ARGV[2] is not empty, the
+// output is only released if ARGV[2] is removed from the sorted set at KEYS[1], so that a
+// scheduled release takes effect once.
+var releaseOutputScript = redis.NewScript(`
+if ARGV[2] ~= '' and redis.call('ZREM', KEYS[1], ARGV[2]) == 0 then
+	return 0
+end
+for i = 2, #KEYS, 2 do
+	local n = redis.call('HGET', KEYS[i+1], ARGV[1])
+	if n then
+		redis.call('HDEL', KEYS[i+1], ARGV[1])
+		redis.call('DECRBY', KEYS[i], n)
+	end
+end
+return 1
+`)
+
+// getInt retrieves the integer value at the supplied key. If the key is not found, 0 is returned
+// without an error.
+func (c *Connection) getInt(key string) (int64, error) {
+	n, err := c.rc.Get(key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// ChargeJobOutput adds n bytes of output of the job with the supplied id to the total size of
+// output stored for jobs of owner o.
+func (c *Connection) ChargeJobOutput(id string, o OutputOwner, n int64) error {
+	return chargeScript.Run(c.rc, o.keys(), id, n).Err()
+}
+
+// ReleaseJobOutput removes the output of the job with the supplied id from the total size of
+// output stored for jobs of owner o.
+func (c *Connection) ReleaseJobOutput(id string, o OutputOwner) error {
+	keys := append([]string{outputReleasesKey}, o.keys()...)
+	return releaseOutputScript.Run(c.rc, keys, id, "").Err()
+}
+
+// outputRelease describes output of a job due to be released from the output quotas of its owner.
+type outputRelease struct {
+	JobID string `json:"jobID"`
+	OutputOwner
+}
+
+// ScheduleJobOutputRelease schedules the output of the job with the supplied id to be removed from
+// the total size of output stored for jobs of owner o at time t, such as when the output expires.
+// Scheduled releases take effect when ReleaseDueJobOutput is called.
+func (c *Connection) ScheduleJobOutputRelease(id string, o OutputOwner, t time.Time) error {
+	b, err := json.Marshal(outputRelease{id, o})
+	if err != nil {
+		return err
+	}
+	return c.rc.ZAdd(outputReleasesKey, redis.Z{
+		Score:  float64(t.Unix()),
+		Member: string(b),
+	}).Err()
+}
+
+// ReleaseDueJobOutput applies releases scheduled using ScheduleJobOutputRelease that are due as
+// of time t. Each release takes effect once, even if called concurrently. The number of releases
+// applied is returned.
+func (c *Connection) ReleaseDueJobOutput(t time.Time) (int, error) {
+	ms, err := c.rc.ZRangeByScore(outputReleasesKey, redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(t.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, m := range ms {
+		var r outputRelease
+		if err := json.Unmarshal([]byte(m), &r); err != nil {
+			// A malformed release can never be applied, so is discarded.
+			if err := c.rc.ZRem(outputReleasesKey, m).Err(); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		keys := append([]string{outputReleasesKey}, r.keys()...)
+		released, err := releaseOutputScript.Run(c.rc, keys, r.JobID, m).Int()
+		if err != nil {
+			return n, err
+		}
+		n += released
+	}
+	return n, nil
+}
+
+// GetUserOutputSize returns the total size of output stored for jobs of the user with the supplied
+// id, in bytes.
+func (c *Connection) GetUserOutputSize(id string) (int64, error) {
+	return c.getInt(userOutputSizeKey(id))
+}
+
+// GetProjectOutputSize returns the total size of output stored for jobs of the project with the
+// supplied id, in bytes.
+func (c *Connection) GetProjectOutputSize(id string) (int64, error) {
+	return c.getInt(projectOutputSizeKey(id))
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+// +build integration
+
+package rediskv
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestOutputSize(t *testing.T) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(math.MaxInt32)))
+	if err != nil {
+		t.Fatalf("failed to generate random int: %v", err)
+	}
+	o := OutputOwner{
+		UserID:    fmt.Sprintf("testuser-%d", n.Int64()),
+		ProjectID: fmt.Sprintf("testproject-%d", n.Int64()),
+	}
+
+	// verifySize verifies the total size of output of the user and project of o.
+	verifySize := func(t *testing.T, want int64) {
+		t.Helper()
+
+		for _, get := range []func() (int64, error){
+			func() (int64, error) { return testConnection.GetUserOutputSize(o.UserID) },
+			func() (int64, error) { return testConnection.GetProjectOutputSize(o.ProjectID) },
+		} {
+			size, err := get()
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+			if got := size; got != want {
+				t.Errorf("got size %v, want %v", got, want)
+			}
+		}
+	}
+
+	// Size should be zero until output is charged.
+	verifySize(t, 0)
+
+	for _, c := range []struct {
+		id string
+		n  int64
+	}{
+		{"job1", 10},
+		{"job1", 15},
+		{"job2", 5},
+	} {
+		if err := testConnection.ChargeJobOutput(c.id, o, c.n); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	}
+	verifySize(t, 30)
+
+	// Output due to expire in future should not yet be released.
+	now := time.Now()
+	if err := testConnection.ScheduleJobOutputRelease("job1", o, now.Add(time.Hour)); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if _, err := testConnection.ReleaseDueJobOutput(now); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	verifySize(t, 30)
+
+	// Once expired, the output should be released, exactly once.
+	for _, want := range []int{1, 0} {
+		n, err := testConnection.ReleaseDueJobOutput(now.Add(2 * time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+		if got := n; got != want {
+			t.Errorf("got %v releases, want %v", got, want)
+		}
+		verifySize(t, 5)
+	}
+
+	// Output released directly, such as when deleted, should no longer count.
+	if err := testConnection.ReleaseJobOutput("job2", o); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	verifySize(t, 0)
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Int64 is a custom GraphQL type to represent a signed 64-bit integer. It is added to the schema
+// via "scalar Int64".
+type Int64 int64
+
+// ImplementsGraphQLType maps Int64 to the Int64 scalar type in the schema.
+func (Int64) ImplementsGraphQLType(name string) bool {
+	return name == "Int64"
+}
+
+// UnmarshalGraphQL unmarshals an Int64 supplied as input.
+func (i *Int64) UnmarshalGraphQL(input interface{}) error {
+	switch input := input.(type) {
+	case int:
+		*i = Int64(input)
+	case int32:
+		*i = Int64(input)
+	case int64:
+		*i = Int64(input)
+	case float64:
+		if input != math.Trunc(input) || input < math.MinInt64 || input > math.MaxInt64 {
+			return fmt.Errorf("invalid Int64: %v", input)
+		}
+		*i = Int64(input)
+	case string:
+		n, err := strconv.ParseInt(input, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid Int64: %w", err)
+		}
+		*i = Int64(n)
+	default:
+		return fmt.Errorf("wrong type for Int64: %T", input)
+	}
+	return nil
+}
+
+// MarshalJSON marshals an Int64 as a JSON number.
+func (i Int64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int64(i))
+}
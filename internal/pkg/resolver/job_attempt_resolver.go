@@ -0,0 +1,69 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"github.com/graph-gophers/graphql-go"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// JobAttemptResolver resolves an attempt to run a job.
+type JobAttemptResolver struct {
+	a core.JobAttempt
+}
+
+// Number resolves the attempt number.
+func (r *JobAttemptResolver) Number() int32 {
+	return int32(r.a.Number)
+}
+
+// StartedAt resolves when the attempt started.
+func (r *JobAttemptResolver) StartedAt() graphql.Time {
+	return graphql.Time{Time: r.a.StartedAt}
+}
+
+// FinishedAt resolves when the attempt finished.
+func (r *JobAttemptResolver) FinishedAt() graphql.Time {
+	return graphql.Time{Time: r.a.FinishedAt}
+}
+
+// NodeID resolves the ID of the node the attempt was placed on, if it was placed.
+func (r *JobAttemptResolver) NodeID() *graphql.ID {
+	if r.a.NodeID != "" {
+		id := graphql.ID(r.a.NodeID)
+		return &id
+	}
+	return nil
+}
+
+// ExitCode resolves the exit status of the process that ran the attempt, if it exited.
+func (r *JobAttemptResolver) ExitCode() *int32 {
+	if r.a.ExitCode != nil {
+		i := int32(*r.a.ExitCode)
+		return &i
+	}
+	return nil
+}
+
+// Error resolves the reason the attempt failed, if it did not exit.
+func (r *JobAttemptResolver) Error() *string {
+	if r.a.Error != "" {
+		return &r.a.Error
+	}
+	return nil
+}
+
+// Output resolves the captured Stdout/Stderr of the attempt.
+func (r *JobAttemptResolver) Output() (string, error) {
+	return r.a.GetOutput()
+}
+
+// Stdout resolves the captured Stdout of the attempt.
+func (r *JobAttemptResolver) Stdout() (string, error) {
+	return r.a.GetStreamOutput(core.JobStdout)
+}
+
+// Stderr resolves the captured Stderr of the attempt.
+func (r *JobAttemptResolver) Stderr() (string, error) {
+	return r.a.GetStreamOutput(core.JobStderr)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"github.com/graph-gophers/graphql-go"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// JobLogLineResolver resolves a timestamped line of job output.
+type JobLogLineResolver struct {
+	l core.JobLogLine
+}
+
+// Stream resolves the stream the output was written to.
+func (r *JobLogLineResolver) Stream() string {
+	return r.l.Stream.String()
+}
+
+// Time resolves when the output was written.
+func (r *JobLogLineResolver) Time() graphql.Time {
+	return graphql.Time{Time: r.l.Time}
+}
+
+// Data resolves the output data.
+func (r *JobLogLineResolver) Data() string {
+	return r.l.Data
+}
+
+// JobLogLineEdgeResolver resolves a job log line edge.
+type JobLogLineEdgeResolver struct {
+	l core.JobLogLine
+}
+
+// Cursor resolves a cursor for use in pagination.
+func (r *JobLogLineEdgeResolver) Cursor() string {
+	return r.l.ID
+}
+
+// Node resolves the item at the end of the edge.
+func (r *JobLogLineEdgeResolver) Node() *JobLogLineResolver {
+	return &JobLogLineResolver{r.l}
+}
+
+// JobLogLineConnectionResolver resolves a job log line connection.
+type JobLogLineConnectionResolver struct {
+	p core.JobLogLinesPage
+}
+
+// Edges resolves a list of edges.
+func (r *JobLogLineConnectionResolver) Edges() *[]*JobLogLineEdgeResolver {
+	ler := []*JobLogLineEdgeResolver{}
+	for _, l := range r.p.LogLines {
+		ler = append(ler, &JobLogLineEdgeResolver{l})
+	}
+	return &ler
+}
+
+// PageInfo resolves information to aid in pagination.
+func (r *JobLogLineConnectionResolver) PageInfo() *PageInfoResolver {
+	return &PageInfoResolver{r.p.PageInfo}
+}
+
+// TotalCount resolves the total count of items in the connection.
+func (r *JobLogLineConnectionResolver) TotalCount() int32 {
+	return int32(r.p.TotalCount)
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"context"
+)
+
+// CancelJob cancels a job.
+func (r Resolver) CancelJob(ctx context.Context, args struct {
+	ID string
+}) (*JobResolver, error) {
+	j, err := r.s.CancelJob(ctx, args.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &JobResolver{j}, nil
+}
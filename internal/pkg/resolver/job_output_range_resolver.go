@@ -0,0 +1,32 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// JobOutputRangeResolver resolves a range of job output.
+type JobOutputRangeResolver struct {
+	r core.JobOutputRange
+}
+
+// Offset resolves the byte offset of the range within the output of the job.
+func (r *JobOutputRangeResolver) Offset() Int64 {
+	return Int64(r.r.Offset)
+}
+
+// Data resolves the output data.
+func (r *JobOutputRangeResolver) Data() string {
+	return r.r.Data
+}
+
+// NextOffset resolves the byte offset from which to read the next range.
+func (r *JobOutputRangeResolver) NextOffset() Int64 {
+	return Int64(r.r.NextOffset())
+}
+
+// TotalSize resolves the total size of the output of the job, in bytes.
+func (r *JobOutputRangeResolver) TotalSize() Int64 {
+	return Int64(r.r.TotalSize)
+}
@@ -4,11 +4,21 @@ package resolver
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"time"
 
 	"github.com/graph-gophers/graphql-go"
 	"github.com/sylabs/fuzzball-service/internal/pkg/core"
 )
 
+// JobServicer is the interface by which jobs are serviced.
+type JobServicer interface {
+	CancelJob(context.Context, string) (core.Job, error)
+	JobUpdates(context.Context, string) (<-chan core.Job, error)
+	JobOutput(context.Context, string, int) (<-chan core.JobOutputChunk, error)
+}
+
 // JobResolver resolves a job.
 type JobResolver struct {
 	j core.Job
@@ -64,9 +74,18 @@ func (r *JobResolver) FinishedAt() *graphql.Time {
 	return nil
 }
 
+// Duration resolves how long the job ran for, in seconds, if it has started.
+func (r *JobResolver) Duration() *float64 {
+	if d, ok := r.j.Duration(time.Now()); ok {
+		s := d.Seconds()
+		return &s
+	}
+	return nil
+}
+
 // Status resolves the state of the job.
 func (r *JobResolver) Status() string {
-	return r.j.Status
+	return r.j.Status.String()
 }
 
 // ExitCode resolves the exit status process that ran the job.
@@ -78,11 +97,232 @@ func (r *JobResolver) ExitCode() *int32 {
 	return nil
 }
 
+// NodeID resolves the ID of the node the job was placed on, if it has been placed.
+func (r *JobResolver) NodeID() *graphql.ID {
+	if r.j.NodeID != "" {
+		id := graphql.ID(r.j.NodeID)
+		return &id
+	}
+	return nil
+}
+
 // Output resolves the captured Stdout/Stderr of the job.
 func (r *JobResolver) Output() (string, error) {
 	return r.j.GetOutput()
 }
 
+// OutputChunk resolves a range of the captured Stdout/Stderr of the job.
+func (r *JobResolver) OutputChunk(args struct {
+	Offset    *Int64
+	Limit     *int32
+	TailLines *int32
+}) (*JobOutputRangeResolver, error) {
+	limit := core.MaxJobOutputRangeSize
+	if args.Limit != nil {
+		limit = int(*args.Limit)
+	}
+
+	if args.TailLines != nil {
+		if args.Offset != nil {
+			return nil, errors.New("offset and tailLines are mutually exclusive")
+		}
+
+		or, err := r.j.GetOutputTail(int(*args.TailLines), limit)
+		if err != nil {
+			return nil, err
+		}
+		return &JobOutputRangeResolver{or}, nil
+	}
+
+	var offset int
+	if args.Offset != nil {
+		offset = int(*args.Offset)
+	}
+
+	or, err := r.j.GetOutputRange(offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &JobOutputRangeResolver{or}, nil
+}
+
+// OutputIncomplete resolves whether some of the captured Stdout/Stderr of the job was lost.
+func (r *JobResolver) OutputIncomplete() bool {
+	return r.j.OutputIncomplete
+}
+
+// Stdout resolves the captured Stdout of the job.
+func (r *JobResolver) Stdout() (string, error) {
+	return r.j.GetStreamOutput(core.JobStdout)
+}
+
+// Stderr resolves the captured Stderr of the job.
+func (r *JobResolver) Stderr() (string, error) {
+	return r.j.GetStreamOutput(core.JobStderr)
+}
+
+// LogLines resolves timestamped lines of the captured Stdout/Stderr of the job.
+func (r *JobResolver) LogLines(args pageArgs) (*JobLogLineConnectionResolver, error) {
+	p, err := r.j.LogLinesPage(convertPageArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return &JobLogLineConnectionResolver{p}, nil
+}
+
+// Retries resolves the maximum number of times the job is retried.
+func (r *JobResolver) Retries() int32 {
+	return int32(r.j.Retry.Retries)
+}
+
+// Attempts resolves the completed attempts to run the job.
+func (r *JobResolver) Attempts() []*JobAttemptResolver {
+	var rs []*JobAttemptResolver
+	for _, a := range r.j.Attempts {
+		rs = append(rs, &JobAttemptResolver{a})
+	}
+	return rs
+}
+
+// Timeout resolves the maximum time the job may run for, if limited.
+func (r *JobResolver) Timeout() *string {
+	if r.j.Timeout > 0 {
+		s := r.j.Timeout.String()
+		return &s
+	}
+	return nil
+}
+
+// Env resolves the environment variables set for the job.
+func (r *JobResolver) Env() []*EnvVarResolver {
+	er := []*EnvVarResolver{}
+	for _, e := range r.j.Env {
+		er = append(er, &EnvVarResolver{e})
+	}
+	return er
+}
+
+// Resources resolves the resources required to run the job.
+func (r *JobResolver) Resources() *ResourcesResolver {
+	return &ResourcesResolver{r.j.Resources}
+}
+
+// NodeLabels resolves the labels a node must have for the job to be placed on it, ordered by key.
+func (r *JobResolver) NodeLabels() []*NodeLabelResolver {
+	lr := []*NodeLabelResolver{}
+	for k, v := range r.j.NodeLabels {
+		lr = append(lr, &NodeLabelResolver{k, v})
+	}
+	sort.Slice(lr, func(i, j int) bool { return lr[i].key < lr[j].key })
+	return lr
+}
+
+// Priority resolves the priority of the job.
+func (r *JobResolver) Priority() int32 {
+	return int32(r.j.Priority)
+}
+
+// ArrayIndex resolves the index of the job within its job array, if it belongs to one.
+func (r *JobResolver) ArrayIndex() *int32 {
+	if r.j.ArrayIndex == nil {
+		return nil
+	}
+	i := int32(*r.j.ArrayIndex)
+	return &i
+}
+
+// When resolves the condition under which the job is run.
+func (r *JobResolver) When() string {
+	if r.j.When == "" {
+		return core.JobOnSuccess.String()
+	}
+	return r.j.When.String()
+}
+
+// QueuePosition resolves the position of the job in the queue of jobs waiting to be placed on a
+// node, if it is waiting.
+func (r *JobResolver) QueuePosition(ctx context.Context) *int32 {
+	if pos, ok := r.j.QueuePosition(ctx); ok {
+		n := int32(pos)
+		return &n
+	}
+	return nil
+}
+
+// ResourcesResolver resolves the resources required to run a job.
+type ResourcesResolver struct {
+	r core.Resources
+}
+
+// CPUs resolves the number of CPUs required, if constrained.
+func (r *ResourcesResolver) CPUs() *int32 {
+	if r.r.CPUs > 0 {
+		n := int32(r.r.CPUs)
+		return &n
+	}
+	return nil
+}
+
+// Memory resolves the amount of memory required, if constrained.
+func (r *ResourcesResolver) Memory() *Int64 {
+	if r.r.Memory > 0 {
+		n := Int64(r.r.Memory)
+		return &n
+	}
+	return nil
+}
+
+// Walltime resolves the maximum time each attempt may run for, if limited.
+func (r *ResourcesResolver) Walltime() *string {
+	if r.r.Walltime > 0 {
+		s := r.r.Walltime.String()
+		return &s
+	}
+	return nil
+}
+
+// NodeLabelResolver resolves a label a node must have for a job to be placed on it.
+type NodeLabelResolver struct {
+	key   string
+	value string
+}
+
+// Key resolves the key of the label.
+func (r *NodeLabelResolver) Key() string {
+	return r.key
+}
+
+// Value resolves the value of the label.
+func (r *NodeLabelResolver) Value() string {
+	return r.value
+}
+
+// EnvVarResolver resolves an environment variable.
+type EnvVarResolver struct {
+	e core.EnvVar
+}
+
+// Name resolves the name of the variable.
+func (r *EnvVarResolver) Name() string {
+	return r.e.Name
+}
+
+// Value resolves the value of the variable, if not taken from a secret.
+func (r *EnvVarResolver) Value() *string {
+	if r.e.Secret != "" {
+		return nil
+	}
+	return &r.e.Value
+}
+
+// Secret resolves the name of the secret the value of the variable is taken from, if any.
+func (r *EnvVarResolver) Secret() *string {
+	if r.e.Secret == "" {
+		return nil
+	}
+	return &r.e.Secret
+}
+
 // Requires looks up jobs that need to be executed before the current one.
 func (r *JobResolver) Requires(ctx context.Context, args pageArgs) (*JobConnectionResolver, error) {
 	p, err := r.j.RequiredJobsPage(ctx, convertPageArgs(args))
@@ -0,0 +1,78 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"context"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// JobUpdated subscribes to updates to the status of a job.
+func (r Resolver) JobUpdated(ctx context.Context, args struct {
+	ID string
+}) (<-chan *JobResolver, error) {
+	js, err := r.s.JobUpdates(ctx, args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan *JobResolver)
+	go func() {
+		defer close(c)
+
+		for j := range js {
+			select {
+			case c <- &JobResolver{j}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// JobOutputChunkResolver resolves a chunk of job output.
+type JobOutputChunkResolver struct {
+	c core.JobOutputChunk
+}
+
+// Offset resolves the byte offset of the chunk within the output of the job.
+func (r *JobOutputChunkResolver) Offset() Int64 {
+	return Int64(r.c.Offset)
+}
+
+// Data resolves the output data.
+func (r *JobOutputChunkResolver) Data() string {
+	return r.c.Data
+}
+
+// JobOutput subscribes to the output of a job.
+func (r Resolver) JobOutput(ctx context.Context, args struct {
+	ID         string
+	FromOffset *Int64
+}) (<-chan *JobOutputChunkResolver, error) {
+	var offset int
+	if args.FromOffset != nil {
+		offset = int(*args.FromOffset)
+	}
+
+	cs, err := r.s.JobOutput(ctx, args.ID, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan *JobOutputChunkResolver)
+	go func() {
+		defer close(c)
+
+		for ch := range cs {
+			select {
+			case c <- &JobOutputChunkResolver{ch}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
@@ -0,0 +1,769 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"github.com/sylabs/fuzzball-service/internal/pkg/schema"
+)
+
+func TestCancelJob(t *testing.T) {
+	tests := []struct {
+		name        string
+		createdByID string
+		status      core.JobStatus
+		id          string
+	}{
+		{"OK", testUserID, core.JobRunning, "jobID"},
+		{"Finished", testUserID, core.JobSucceeded, "jobID"},
+		{"BadID", testUserID, core.JobRunning, "bad"},
+		{"Forbidden", "otherUserID", core.JobRunning, "jobID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					j: core.Job{
+						CreatedByID:    tt.createdByID,
+						CreatedByLogin: "jimbob",
+						ID:             "jobID",
+						WorkflowID:     "workflowID",
+						Name:           "jobName",
+						Image:          "jobImage",
+						Command:        []string{"jobCommand"},
+						Status:         tt.status,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($id: ID!) {
+			  cancelJob(id: $id) {
+			    id
+			    name
+			    status
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": tt.id,
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobAttempts(t *testing.T) {
+	rc := 1
+	tests := []struct {
+		name     string
+		attempts []core.JobAttempt
+	}{
+		{"None", nil},
+		{"Retried", []core.JobAttempt{
+			{
+				Number:     1,
+				StartedAt:  time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+				FinishedAt: time.Date(2020, 01, 20, 19, 21, 31, 0, time.UTC),
+				Error:      "node lost",
+				OutputKey:  "jobID.attempt.1",
+			},
+			{
+				Number:     2,
+				StartedAt:  time.Date(2020, 01, 20, 19, 21, 32, 0, time.UTC),
+				FinishedAt: time.Date(2020, 01, 20, 19, 21, 33, 0, time.UTC),
+				NodeID:     "nodeID",
+				ExitCode:   &rc,
+				OutputKey:  "jobID",
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					jp: core.JobsPage{
+						Jobs: []core.Job{
+							{
+								ID:       "jobID",
+								Name:     "jobName",
+								Retry:    core.RetryPolicy{Retries: 1},
+								Attempts: tt.attempts,
+							},
+						},
+						TotalCount: 1,
+					},
+				},
+				f: mockIOFetcher{
+					output: "output",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    jobs {
+			      edges {
+			        node {
+			          id
+			          retries
+			          attempts {
+			            number
+			            startedAt
+			            finishedAt
+			            nodeID
+			            exitCode
+			            error
+			            output
+			          }
+			        }
+			      }
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []core.EnvVar
+	}{
+		{"None", nil},
+		{"Values", []core.EnvVar{
+			{Name: "A", Value: "a"},
+			{Name: "B", Value: ""},
+		}},
+		{"Secret", []core.EnvVar{
+			{Name: "A", Value: "a"},
+			{Name: "B", Secret: "secretName"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					jp: core.JobsPage{
+						Jobs: []core.Job{
+							{
+								ID:   "jobID",
+								Name: "jobName",
+								Env:  tt.env,
+							},
+						},
+						TotalCount: 1,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    jobs {
+			      edges {
+			        node {
+			          id
+			          env {
+			            name
+			            value
+			            secret
+			          }
+			        }
+			      }
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobResources(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources core.Resources
+	}{
+		{"None", core.Resources{}},
+		{"All", core.Resources{CPUs: 2, Memory: 512 << 20, Walltime: time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					jp: core.JobsPage{
+						Jobs: []core.Job{
+							{
+								ID:        "jobID",
+								Name:      "jobName",
+								Resources: tt.resources,
+							},
+						},
+						TotalCount: 1,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    jobs {
+			      edges {
+			        node {
+			          id
+			          resources {
+			            cpus
+			            memory
+			            walltime
+			          }
+			        }
+			      }
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobNodeLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+	}{
+		{"None", nil},
+		{"Labels", map[string]string{"zone": "a", "gpu": "v100"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					jp: core.JobsPage{
+						Jobs: []core.Job{
+							{
+								ID:         "jobID",
+								Name:       "jobName",
+								NodeLabels: tt.labels,
+							},
+						},
+						TotalCount: 1,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    jobs {
+			      edges {
+			        node {
+			          id
+			          nodeLabels {
+			            key
+			            value
+			          }
+			        }
+			      }
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobArrayIndex(t *testing.T) {
+	index := 1
+
+	tests := []struct {
+		name       string
+		arrayIndex *int
+	}{
+		{"NotArray", nil},
+		{"Array", &index},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					jp: core.JobsPage{
+						Jobs: []core.Job{
+							{
+								ID:         "jobID",
+								Name:       "jobName[1]",
+								ArrayIndex: tt.arrayIndex,
+							},
+						},
+						TotalCount: 1,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    jobs {
+			      edges {
+			        node {
+			          id
+			          name
+			          arrayIndex
+			        }
+			      }
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobWhen(t *testing.T) {
+	tests := []struct {
+		name string
+		when core.JobCondition
+	}{
+		{"Default", ""},
+		{"Always", core.JobAlways},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					jp: core.JobsPage{
+						Jobs: []core.Job{
+							{
+								ID:   "jobID",
+								Name: "jobName",
+								When: tt.when,
+							},
+						},
+						TotalCount: 1,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    jobs {
+			      edges {
+			        node {
+			          id
+			          name
+			          when
+			        }
+			      }
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobQueuePosition(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        core.JobStatus
+		queuePosition int
+	}{
+		{"Queued", core.JobPending, 3},
+		{"NotQueued", core.JobPending, 0},
+		{"Running", core.JobRunning, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					jp: core.JobsPage{
+						Jobs: []core.Job{
+							{
+								ID:       "jobID",
+								Name:     "jobName",
+								Status:   tt.status,
+								Priority: 2,
+							},
+						},
+						TotalCount: 1,
+					},
+				},
+				s: mockScheduler{
+					queuePosition: tt.queuePosition,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    jobs {
+			      edges {
+			        node {
+			          id
+			          status
+			          priority
+			          queuePosition
+			        }
+			      }
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobOutputChunk(t *testing.T) {
+	tests := []struct {
+		name string
+		args map[string]interface{}
+	}{
+		{"Default", map[string]interface{}{}},
+		{"Offset", map[string]interface{}{"offset": 6}},
+		{"OffsetLimit", map[string]interface{}{"offset": 6, "limit": 4}},
+		{"PastEnd", map[string]interface{}{"offset": 100}},
+		{"NegativeOffset", map[string]interface{}{"offset": -1}},
+		{"NegativeLimit", map[string]interface{}{"limit": -1}},
+		{"Tail", map[string]interface{}{"tailLines": 2}},
+		{"TailAll", map[string]interface{}{"tailLines": 10}},
+		{"TailNone", map[string]interface{}{"tailLines": 0}},
+		{"TailLimit", map[string]interface{}{"tailLines": 2, "limit": 5}},
+		{"TailOffset", map[string]interface{}{"tailLines": 2, "offset": 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					jp: core.JobsPage{
+						Jobs: []core.Job{
+							{
+								ID:   "jobID",
+								Name: "jobName",
+							},
+						},
+						TotalCount: 1,
+					},
+				},
+				f: mockIOFetcher{
+					output: "one\ntwo\nthree\nfour\n",
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!, $offset: Int64, $limit: Int, $tailLines: Int) {
+			  workflow(id: $id) {
+			    jobs {
+			      edges {
+			        node {
+			          id
+			          outputChunk(offset: $offset, limit: $limit, tailLines: $tailLines) {
+			            offset
+			            data
+			            nextOffset
+			            totalSize
+			          }
+			        }
+			      }
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobLogLines(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	logLines := []core.JobLogLine{
+		{ID: "1-0", Stream: core.JobStdout, Time: now, Data: "one\n"},
+		{ID: "2-0", Stream: core.JobStderr, Time: now.Add(time.Second), Data: "two\n"},
+		{ID: "3-0", Stream: core.JobStdout, Time: now.Add(2 * time.Second), Data: "three\n"},
+	}
+
+	tests := []struct {
+		name             string
+		logLines         []core.JobLogLine
+		outputIncomplete bool
+	}{
+		{"Empty", nil, false},
+		{"Lines", logLines, false},
+		{"Incomplete", logLines[1:], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					jp: core.JobsPage{
+						Jobs: []core.Job{
+							{
+								ID:               "jobID",
+								Name:             "jobName",
+								OutputIncomplete: tt.outputIncomplete,
+							},
+						},
+						TotalCount: 1,
+					},
+				},
+				f: mockIOFetcher{
+					logLines: tt.logLines,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    jobs {
+			      edges {
+			        node {
+			          id
+			          outputIncomplete
+			          stdout
+			          stderr
+			          logLines {
+			            edges {
+			              cursor
+			              node {
+			                stream
+			                time
+			                data
+			              }
+			            }
+			            totalCount
+			          }
+			        }
+			      }
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
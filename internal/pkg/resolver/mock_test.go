@@ -15,16 +15,28 @@ type mockPersister struct {
 	j      core.Job
 	v      core.Volume
 	w      core.Workflow
+	pr     core.Project
 	jp     core.JobsPage
 	vp     core.VolumesPage
 	wp     core.WorkflowsPage
+	pp     core.ProjectsPage
+	sec    core.Secret
+	sp     core.SecretsPage
+	queued int // Number of unfinished workflows.
 	err    error
+
+	created  *[]core.Job // If not nil, jobs created are recorded here.
+	versions *[]core.Job // If not nil, GetJob returns each in turn, and then the last repeatedly.
+	removed  *[]string   // If not nil, the kind and workflow ID of records removed are recorded here.
 }
 
 func (p mockPersister) CreateWorkflow(ctx context.Context, w core.Workflow) (core.Workflow, error) {
 	if got, want := w.Name, p.w.Name; got != want {
 		return core.Workflow{}, fmt.Errorf("got name %v, want %v", got, want)
 	}
+	if got, want := w.CreatedByID, testUserID; got != want {
+		return core.Workflow{}, fmt.Errorf("got created by ID %v, want %v", got, want)
+	}
 	return p.w, p.err
 }
 
@@ -32,9 +44,17 @@ func (p mockPersister) DeleteWorkflow(ctx context.Context, id string) (core.Work
 	if got, want := id, p.w.ID; got != want {
 		return core.Workflow{}, fmt.Errorf("got ID %v, want %v", got, want)
 	}
+	p.remove("workflow", id)
 	return p.w, p.err
 }
 
+// remove records the removal of records of the supplied kind belonging to workflow id.
+func (p mockPersister) remove(kind, id string) {
+	if p.removed != nil {
+		*p.removed = append(*p.removed, kind+" "+id)
+	}
+}
+
 func (p mockPersister) GetWorkflow(ctx context.Context, id string) (core.Workflow, error) {
 	if got, want := id, p.w.ID; got != want {
 		return core.Workflow{}, fmt.Errorf("got ID %v, want %v", got, want)
@@ -42,25 +62,75 @@ func (p mockPersister) GetWorkflow(ctx context.Context, id string) (core.Workflo
 	return p.w, p.err
 }
 
-func (p mockPersister) GetWorkflows(ctx context.Context, pa core.PageArgs) (core.WorkflowsPage, error) {
+func (p mockPersister) GetWorkflowsByUserID(ctx context.Context, pa core.PageArgs, uid string) (core.WorkflowsPage, error) {
+	if got, want := pa, p.wantPA; !reflect.DeepEqual(got, want) {
+		return core.WorkflowsPage{}, fmt.Errorf("got page args %v, want %v", got, want)
+	}
+	if got, want := uid, testUserID; got != want {
+		return core.WorkflowsPage{}, fmt.Errorf("got user ID %v, want %v", got, want)
+	}
+	return p.wp, p.err
+}
+
+func (p mockPersister) GetWorkflowsByProjectID(ctx context.Context, pa core.PageArgs, pid string) (core.WorkflowsPage, error) {
 	if got, want := pa, p.wantPA; !reflect.DeepEqual(got, want) {
 		return core.WorkflowsPage{}, fmt.Errorf("got page args %v, want %v", got, want)
 	}
+	if got, want := pid, p.pr.ID; got != want {
+		return core.WorkflowsPage{}, fmt.Errorf("got project ID %v, want %v", got, want)
+	}
 	return p.wp, p.err
 }
 
+func (p mockPersister) CountUnfinishedWorkflowsByUserID(ctx context.Context, uid string) (int, error) {
+	if got, want := uid, testUserID; got != want {
+		return 0, fmt.Errorf("got user ID %v, want %v", got, want)
+	}
+	return p.queued, p.err
+}
+
+func (p mockPersister) CountUnfinishedWorkflowsByProjectID(ctx context.Context, pid string) (int, error) {
+	if got, want := pid, p.pr.ID; got != want {
+		return 0, fmt.Errorf("got project ID %v, want %v", got, want)
+	}
+	return p.queued, p.err
+}
+
 func (p mockPersister) CreateJob(ctx context.Context, j core.Job) (core.Job, error) {
+	if p.created != nil {
+		j.ID = fmt.Sprintf("job%d", len(*p.created))
+		*p.created = append(*p.created, j)
+		return j, p.err
+	}
 	return p.j, p.err
 }
 
-func (p mockPersister) DeleteJobsByWorkflowID(context.Context, string) error {
+func (p mockPersister) DeleteJobsByWorkflowID(ctx context.Context, id string) error {
+	p.remove("jobs", id)
 	return p.err
 }
 
-func (p mockPersister) GetJobs(ctx context.Context, pa core.PageArgs) (core.JobsPage, error) {
+func (p mockPersister) GetJob(ctx context.Context, id string) (core.Job, error) {
+	if got, want := id, p.j.ID; got != want {
+		return core.Job{}, fmt.Errorf("got ID %v, want %v", got, want)
+	}
+	if p.versions != nil && len(*p.versions) > 0 {
+		j := (*p.versions)[0]
+		if len(*p.versions) > 1 {
+			*p.versions = (*p.versions)[1:]
+		}
+		return j, p.err
+	}
+	return p.j, p.err
+}
+
+func (p mockPersister) GetJobsByUserID(ctx context.Context, pa core.PageArgs, uid string) (core.JobsPage, error) {
 	if got, want := pa, p.wantPA; !reflect.DeepEqual(got, want) {
 		return core.JobsPage{}, fmt.Errorf("got page args %v, want %v", got, want)
 	}
+	if got, want := uid, testUserID; got != want {
+		return core.JobsPage{}, fmt.Errorf("got user ID %v, want %v", got, want)
+	}
 	return p.jp, p.err
 }
 
@@ -82,14 +152,18 @@ func (p mockPersister) CreateVolume(context.Context, core.Volume) (core.Volume,
 	return p.v, p.err
 }
 
-func (p mockPersister) DeleteVolumesByWorkflowID(context.Context, string) error {
+func (p mockPersister) DeleteVolumesByWorkflowID(ctx context.Context, id string) error {
+	p.remove("volumes", id)
 	return p.err
 }
 
-func (p mockPersister) GetVolumes(ctx context.Context, pa core.PageArgs) (core.VolumesPage, error) {
+func (p mockPersister) GetVolumesByUserID(ctx context.Context, pa core.PageArgs, uid string) (core.VolumesPage, error) {
 	if got, want := pa, p.wantPA; !reflect.DeepEqual(got, want) {
 		return core.VolumesPage{}, fmt.Errorf("got page args %v, want %v", got, want)
 	}
+	if got, want := uid, testUserID; got != want {
+		return core.VolumesPage{}, fmt.Errorf("got user ID %v, want %v", got, want)
+	}
 	return p.vp, p.err
 }
 
@@ -100,23 +174,197 @@ func (p mockPersister) GetVolumesByWorkflowID(ctx context.Context, pa core.PageA
 	return p.vp, p.err
 }
 
+func (p mockPersister) CreateProject(ctx context.Context, pr core.Project) (core.Project, error) {
+	if got, want := pr.Name, p.pr.Name; got != want {
+		return core.Project{}, fmt.Errorf("got name %v, want %v", got, want)
+	}
+	if got, want := pr.CreatedByID, testUserID; got != want {
+		return core.Project{}, fmt.Errorf("got created by ID %v, want %v", got, want)
+	}
+	return p.pr, p.err
+}
+
+func (p mockPersister) GetProject(ctx context.Context, id string) (core.Project, error) {
+	if got, want := id, p.pr.ID; got != want {
+		return core.Project{}, fmt.Errorf("got ID %v, want %v", got, want)
+	}
+	return p.pr, p.err
+}
+
+func (p mockPersister) GetProjectsByUserID(ctx context.Context, pa core.PageArgs, uid string) (core.ProjectsPage, error) {
+	if got, want := pa, p.wantPA; !reflect.DeepEqual(got, want) {
+		return core.ProjectsPage{}, fmt.Errorf("got page args %v, want %v", got, want)
+	}
+	if got, want := uid, testUserID; got != want {
+		return core.ProjectsPage{}, fmt.Errorf("got user ID %v, want %v", got, want)
+	}
+	return p.pp, p.err
+}
+
+func (p mockPersister) SetProjectMember(ctx context.Context, id string, m core.ProjectMember) (core.Project, error) {
+	if got, want := id, p.pr.ID; got != want {
+		return core.Project{}, fmt.Errorf("got ID %v, want %v", got, want)
+	}
+	pr := p.pr
+	pr.Members = nil
+	for _, x := range p.pr.Members {
+		if x.UserID != m.UserID {
+			pr.Members = append(pr.Members, x)
+		}
+	}
+	pr.Members = append(pr.Members, m)
+	return pr, p.err
+}
+
+func (p mockPersister) RemoveProjectMember(ctx context.Context, id, uid string) (core.Project, error) {
+	if got, want := id, p.pr.ID; got != want {
+		return core.Project{}, fmt.Errorf("got ID %v, want %v", got, want)
+	}
+	pr := p.pr
+	pr.Members = nil
+	for _, x := range p.pr.Members {
+		if x.UserID != uid {
+			pr.Members = append(pr.Members, x)
+		}
+	}
+	return pr, p.err
+}
+
 type mockIOFetcher struct {
-	output string
-	err    error
+	output     string
+	chunks     []core.JobOutputChunk
+	logLines   []core.JobLogLine
+	outputSize int64 // Total size of output of a user or project.
+	err        error
+}
+
+func (m mockIOFetcher) DeleteJobOutput(core.Job) error {
+	return m.err
+}
+
+func (m mockIOFetcher) GetUserOutputSize(string) (int64, error) {
+	return m.outputSize, m.err
+}
+
+func (m mockIOFetcher) GetProjectOutputSize(string) (int64, error) {
+	return m.outputSize, m.err
 }
 
 func (m mockIOFetcher) GetJobOutput(string) (string, error) {
 	return m.output, m.err
 }
 
+func (m mockIOFetcher) GetJobOutputRange(id string, offset, limit int) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	if offset > len(m.output) {
+		return "", nil
+	}
+	if end := offset + limit; end < len(m.output) {
+		return m.output[offset:end], nil
+	}
+	return m.output[offset:], nil
+}
+
+func (m mockIOFetcher) GetJobOutputSize(string) (int, error) {
+	return len(m.output), m.err
+}
+
+func (m mockIOFetcher) GetJobStreamOutput(id string, s core.JobOutputStream) (string, error) {
+	var out string
+	for _, l := range m.logLines {
+		if l.Stream == s {
+			out += l.Data
+		}
+	}
+	return out, m.err
+}
+
+func (m mockIOFetcher) GetJobLogLines(string, core.PageArgs) (core.JobLogLinesPage, error) {
+	return core.JobLogLinesPage{
+		LogLines:   m.logLines,
+		TotalCount: len(m.logLines),
+	}, m.err
+}
+
+// WatchJobOutput returns a channel on which the chunks in m are sent, and that is closed once ctx
+// is done.
+func (m mockIOFetcher) WatchJobOutput(ctx context.Context, id string) (<-chan core.JobOutputChunk, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	c := make(chan core.JobOutputChunk, len(m.chunks))
+	for _, ch := range m.chunks {
+		c <- ch
+	}
+	go func() {
+		<-ctx.Done()
+		close(c)
+	}()
+	return c, nil
+}
+
 type mockScheduler struct {
-	err error
+	queuePosition int
+	usage         core.Usage
+	updates       int // Number of updates received on each watch channel.
+	err           error
 }
 
 func (m mockScheduler) AddWorkflow(context.Context, core.Workflow, []core.Job, map[string]core.Volume) error {
 	return m.err
 }
 
+func (m mockScheduler) CancelWorkflow(context.Context, core.Workflow) error {
+	return m.err
+}
+
+func (m mockScheduler) CancelJob(context.Context, core.Job) error {
+	return m.err
+}
+
+// watch returns a channel that receives m.updates updates, and that is closed once ctx is done.
+func (m mockScheduler) watch(ctx context.Context) (<-chan struct{}, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	c := make(chan struct{})
+	go func() {
+		defer close(c)
+
+		for i := 0; i < m.updates; i++ {
+			select {
+			case c <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		<-ctx.Done()
+	}()
+	return c, nil
+}
+
+func (m mockScheduler) WatchWorkflow(ctx context.Context, id string) (<-chan struct{}, error) {
+	return m.watch(ctx)
+}
+
+func (m mockScheduler) WatchJob(ctx context.Context, id string) (<-chan struct{}, error) {
+	return m.watch(ctx)
+}
+
+func (m mockScheduler) JobQueuePosition(ctx context.Context, id string) (int, bool) {
+	return m.queuePosition, m.queuePosition > 0
+}
+
+func (m mockScheduler) UserUsage(ctx context.Context, id string) (core.Usage, error) {
+	return m.usage, nil
+}
+
+func (m mockScheduler) ProjectUsage(ctx context.Context, id string) (core.Usage, error) {
+	return m.usage, nil
+}
+
 type mockCore struct {
 	p mockPersister
 	f mockIOFetcher
@@ -126,3 +374,43 @@ type mockCore struct {
 func getMockCore(mc mockCore, opts ...func(*core.Core) error) (*core.Core, error) {
 	return core.New(&mc.p, &mc.f, &mc.s, opts...)
 }
+
+func (p mockPersister) SetSecret(ctx context.Context, s core.Secret) (core.Secret, error) {
+	if got, want := s.Name, p.sec.Name; got != want {
+		return core.Secret{}, fmt.Errorf("got name %v, want %v", got, want)
+	}
+	if got, want := s.CreatedByID, testUserID; got != want {
+		return core.Secret{}, fmt.Errorf("got created by ID %v, want %v", got, want)
+	}
+	return p.sec, p.err
+}
+
+func (p mockPersister) DeleteSecret(ctx context.Context, uid, name string) (core.Secret, error) {
+	if got, want := uid, testUserID; got != want {
+		return core.Secret{}, fmt.Errorf("got user ID %v, want %v", got, want)
+	}
+	if got, want := name, p.sec.Name; got != want {
+		return core.Secret{}, core.ErrSecretNotFound
+	}
+	return p.sec, p.err
+}
+
+func (p mockPersister) GetSecretByName(ctx context.Context, uid, name string) (core.Secret, error) {
+	if got, want := uid, testUserID; got != want {
+		return core.Secret{}, fmt.Errorf("got user ID %v, want %v", got, want)
+	}
+	if got, want := name, p.sec.Name; got != want {
+		return core.Secret{}, core.ErrSecretNotFound
+	}
+	return p.sec, p.err
+}
+
+func (p mockPersister) GetSecretsByUserID(ctx context.Context, pa core.PageArgs, uid string) (core.SecretsPage, error) {
+	if got, want := pa, p.wantPA; !reflect.DeepEqual(got, want) {
+		return core.SecretsPage{}, fmt.Errorf("got page args %v, want %v", got, want)
+	}
+	if got, want := uid, testUserID; got != want {
+		return core.SecretsPage{}, fmt.Errorf("got user ID %v, want %v", got, want)
+	}
+	return p.sp, p.err
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import "github.com/sylabs/fuzzball-service/internal/pkg/core"
+
+// ProjectEdgeResolver resolves a project edge.
+type ProjectEdgeResolver struct {
+	p core.Project
+}
+
+// Cursor resolves a cursor for use in pagination.
+func (r *ProjectEdgeResolver) Cursor() string {
+	return r.p.ID
+}
+
+// Node resolves the item at the end of the edge.
+func (r *ProjectEdgeResolver) Node() *ProjectResolver {
+	return &ProjectResolver{r.p}
+}
+
+// ProjectConnectionResolver resolves a project connection.
+type ProjectConnectionResolver struct {
+	pp core.ProjectsPage
+}
+
+// Edges resolves a list of edges.
+func (r *ProjectConnectionResolver) Edges() *[]*ProjectEdgeResolver {
+	per := []*ProjectEdgeResolver{}
+	for _, p := range r.pp.Projects {
+		per = append(per, &ProjectEdgeResolver{p})
+	}
+	return &per
+}
+
+// PageInfo resolves information to aid in pagination.
+func (r *ProjectConnectionResolver) PageInfo() *PageInfoResolver {
+	return &PageInfoResolver{r.pp.PageInfo}
+}
+
+// TotalCount resolves the total count of items in the connection.
+func (r *ProjectConnectionResolver) TotalCount() int32 {
+	return int32(r.pp.TotalCount)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"context"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// CreateProject creates a new project.
+func (r Resolver) CreateProject(ctx context.Context, args struct {
+	Name string
+}) (*ProjectResolver, error) {
+	p, err := r.s.CreateProject(ctx, args.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &ProjectResolver{p}, nil
+}
+
+// AddProjectMember adds a member to a project.
+func (r Resolver) AddProjectMember(ctx context.Context, args struct {
+	ProjectID string
+	Member    struct {
+		UserID string
+		Login  string
+		Role   string
+	}
+}) (*ProjectResolver, error) {
+	m := core.ProjectMember{
+		UserID: args.Member.UserID,
+		Login:  args.Member.Login,
+		Role:   core.ProjectRole(args.Member.Role),
+	}
+	p, err := r.s.AddProjectMember(ctx, args.ProjectID, m)
+	if err != nil {
+		return nil, err
+	}
+	return &ProjectResolver{p}, nil
+}
+
+// RemoveProjectMember removes a member from a project.
+func (r Resolver) RemoveProjectMember(ctx context.Context, args struct {
+	ProjectID string
+	UserID    string
+}) (*ProjectResolver, error) {
+	p, err := r.s.RemoveProjectMember(ctx, args.ProjectID, args.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &ProjectResolver{p}, nil
+}
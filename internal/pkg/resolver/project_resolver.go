@@ -0,0 +1,89 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"context"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// ProjectServicer is the interface by which projects are serviced.
+type ProjectServicer interface {
+	CreateProject(context.Context, string) (core.Project, error)
+	AddProjectMember(context.Context, string, core.ProjectMember) (core.Project, error)
+	RemoveProjectMember(context.Context, string, string) (core.Project, error)
+}
+
+// ProjectResolver resolves a project.
+type ProjectResolver struct {
+	p core.Project
+}
+
+// ID resolves the project ID.
+func (r *ProjectResolver) ID() graphql.ID {
+	return graphql.ID(r.p.ID)
+}
+
+// Name resolves the project name.
+func (r *ProjectResolver) Name() string {
+	return r.p.Name
+}
+
+// CreatedBy resolves the user who created the project.
+func (r *ProjectResolver) CreatedBy(ctx context.Context) (*UserResolver, error) {
+	u, err := r.p.CreatedBy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &UserResolver{u: &u}, nil
+}
+
+// CreatedAt resolves when the project was created.
+func (r *ProjectResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.p.CreatedAt}
+}
+
+// Members resolves the members of the project.
+func (r *ProjectResolver) Members() []*ProjectMemberResolver {
+	mr := []*ProjectMemberResolver{}
+	for _, m := range r.p.Members {
+		mr = append(mr, &ProjectMemberResolver{m, r.p.MemberUser(m)})
+	}
+	return mr
+}
+
+// Quota resolves the resources consumed by the project, and the limits on them.
+func (r *ProjectResolver) Quota(ctx context.Context) (*QuotaResolver, error) {
+	q, err := r.p.Quota(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &QuotaResolver{q}, nil
+}
+
+// Workflows looks up workflows submitted to the project.
+func (r *ProjectResolver) Workflows(ctx context.Context, args pageArgs) (*WorkflowConnectionResolver, error) {
+	p, err := r.p.WorkflowsPage(ctx, convertPageArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return &WorkflowConnectionResolver{p}, nil
+}
+
+// ProjectMemberResolver resolves a project member.
+type ProjectMemberResolver struct {
+	m core.ProjectMember
+	u core.User
+}
+
+// User resolves the member.
+func (r *ProjectMemberResolver) User() *UserResolver {
+	return &UserResolver{u: &r.u}
+}
+
+// Role resolves the role the member holds within the project.
+func (r *ProjectMemberResolver) Role() string {
+	return r.m.Role.String()
+}
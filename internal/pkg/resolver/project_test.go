@@ -0,0 +1,395 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"github.com/sylabs/fuzzball-service/internal/pkg/schema"
+)
+
+// getTestProject returns a project in which the test user holds role.
+func getTestProject(role core.ProjectRole) core.Project {
+	return core.Project{
+		ID:        "projectID",
+		Name:      "projectName",
+		CreatedAt: time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+		Members: []core.ProjectMember{
+			{UserID: "adminID", Login: "admin", Role: core.ProjectAdmin},
+			{UserID: testUserID, Login: "jimbob", Role: role},
+		},
+		CreatedByID:    "adminID",
+		CreatedByLogin: "admin",
+	}
+}
+
+// getSoleAdminProject returns a project in which the test user is the only admin.
+func getSoleAdminProject() core.Project {
+	p := getTestProject(core.ProjectAdmin)
+	p.Members = p.Members[1:]
+	return p
+}
+
+func TestCreateProject(t *testing.T) {
+	tests := []struct {
+		name        string
+		projectName string
+	}{
+		{"OK", "projectName"},
+		{"EmptyName", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					pr: core.Project{
+						ID:        "projectID",
+						Name:      tt.projectName,
+						CreatedAt: time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+						Members: []core.ProjectMember{
+							{UserID: testUserID, Login: "jimbob", Role: core.ProjectAdmin},
+						},
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($name: String!) {
+			  createProject(name: $name) {
+			    id
+			    name
+			    createdBy {
+			      id
+			      login
+			    }
+			    createdAt
+			    members {
+			      user {
+			        id
+			        login
+			      }
+			      role
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"name": tt.projectName,
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAddProjectMember(t *testing.T) {
+	admin := getTestProject(core.ProjectAdmin)
+	soleAdmin := getSoleAdminProject()
+	submitter := getTestProject(core.ProjectSubmitter)
+
+	tests := []struct {
+		name   string
+		pr     core.Project
+		member map[string]interface{}
+	}{
+		{"OK", admin, map[string]interface{}{"userID": "otherID", "login": "other", "role": "SUBMITTER"}},
+		{"ChangeRole", admin, map[string]interface{}{"userID": "adminID", "login": "admin", "role": "VIEWER"}},
+		{"LastAdmin", soleAdmin, map[string]interface{}{"userID": testUserID, "login": "jimbob", "role": "VIEWER"}},
+		{"Forbidden", submitter, map[string]interface{}{"userID": "otherID", "login": "other", "role": "VIEWER"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					pr: tt.pr,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($projectID: ID!, $member: ProjectMemberSpec!) {
+			  addProjectMember(projectID: $projectID, member: $member) {
+			    id
+			    members {
+			      user {
+			        id
+			        login
+			      }
+			      role
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"projectID": "projectID",
+				"member":    tt.member,
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRemoveProjectMember(t *testing.T) {
+	admin := getTestProject(core.ProjectAdmin)
+	soleAdmin := getSoleAdminProject()
+	submitter := getTestProject(core.ProjectSubmitter)
+
+	tests := []struct {
+		name   string
+		pr     core.Project
+		userID string
+	}{
+		{"OK", admin, "adminID"},
+		{"LastAdmin", soleAdmin, testUserID},
+		{"NotMember", admin, "otherID"},
+		{"Forbidden", submitter, testUserID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					pr: tt.pr,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($projectID: ID!, $userID: ID!) {
+			  removeProjectMember(projectID: $projectID, userID: $userID) {
+			    id
+			    members {
+			      user {
+			        id
+			        login
+			      }
+			      role
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"projectID": "projectID",
+				"userID":    tt.userID,
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestWorkflowProject(t *testing.T) {
+	tests := []struct {
+		name        string
+		createdByID string
+		projectID   string
+		role        core.ProjectRole
+	}{
+		{"Private", testUserID, "", core.ProjectViewer},
+		{"Owner", testUserID, "projectID", core.ProjectViewer},
+		{"ProjectViewer", "adminID", "projectID", core.ProjectViewer},
+		{"NotMember", "adminID", "projectID", ""},
+		{"OtherUser", "adminID", "", core.ProjectAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pr := getTestProject(tt.role)
+			if tt.role == "" {
+				pr.Members = pr.Members[:1]
+			}
+
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    tt.createdByID,
+						CreatedByLogin: "creator",
+						ProjectID:      tt.projectID,
+						ID:             "workflowID",
+						Name:           "workflowName",
+						CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+					},
+					pr: pr,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    id
+			    name
+			    project {
+			      id
+			      name
+			    }
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUserConnections(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+	}{
+		{"CreatedByOwner", `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    createdBy {
+			      login
+			      workflows {
+			        totalCount
+			      }
+			    }
+			  }
+			}`},
+		{"CreatedByOther", `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    project {
+			      createdBy {
+			        login
+			        workflows {
+			          totalCount
+			        }
+			      }
+			    }
+			  }
+			}`},
+		{"MembersJobs", `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    project {
+			      members {
+			        user {
+			          login
+			          jobs {
+			            totalCount
+			          }
+			        }
+			      }
+			    }
+			  }
+			}`},
+		{"MembersVolumes", `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    project {
+			      members {
+			        user {
+			          login
+			          volumes {
+			            totalCount
+			          }
+			        }
+			      }
+			    }
+			  }
+			}`},
+		{"MembersProjects", `
+			query OpName($id: ID!) {
+			  workflow(id: $id) {
+			    project {
+			      members {
+			        user {
+			          login
+			          projects {
+			            totalCount
+			          }
+			        }
+			      }
+			    }
+			  }
+			}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ProjectID:      "projectID",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					pr: getTestProject(core.ProjectViewer),
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			args := map[string]interface{}{
+				"id": "workflowID",
+			}
+
+			res := s.Exec(getTokenContext(), tt.q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
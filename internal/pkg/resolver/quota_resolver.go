@@ -0,0 +1,56 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// QuotaResolver resolves the resources consumed by a user or project, and the limits on them.
+type QuotaResolver struct {
+	q core.Quota
+}
+
+// RunningJobs resolves the number of jobs running.
+func (r *QuotaResolver) RunningJobs() *QuotaUsageResolver {
+	return newQuotaUsageResolver(int64(r.q.Usage.RunningJobs), int64(r.q.Limits.RunningJobs))
+}
+
+// QueuedWorkflows resolves the number of workflows that have not finished.
+func (r *QuotaResolver) QueuedWorkflows() *QuotaUsageResolver {
+	return newQuotaUsageResolver(int64(r.q.Usage.QueuedWorkflows), int64(r.q.Limits.QueuedWorkflows))
+}
+
+// CPUs resolves the number of CPUs allocated to running jobs.
+func (r *QuotaResolver) CPUs() *QuotaUsageResolver {
+	return newQuotaUsageResolver(int64(r.q.Usage.CPUs), int64(r.q.Limits.CPUs))
+}
+
+// OutputBytes resolves the total size of output stored for jobs.
+func (r *QuotaResolver) OutputBytes() *QuotaUsageResolver {
+	return newQuotaUsageResolver(r.q.Usage.OutputBytes, r.q.Limits.OutputBytes)
+}
+
+// QuotaUsageResolver resolves the usage of a resource against its limit.
+type QuotaUsageResolver struct {
+	used  int64
+	limit int64 // Unlimited if zero.
+}
+
+func newQuotaUsageResolver(used, limit int64) *QuotaUsageResolver {
+	return &QuotaUsageResolver{used, limit}
+}
+
+// Used resolves the amount of the resource used.
+func (r *QuotaUsageResolver) Used() Int64 {
+	return Int64(r.used)
+}
+
+// Limit resolves the limit on the resource, if limited.
+func (r *QuotaUsageResolver) Limit() *Int64 {
+	if r.limit <= 0 {
+		return nil
+	}
+	l := Int64(r.limit)
+	return &l
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"github.com/sylabs/fuzzball-service/internal/pkg/schema"
+)
+
+// testLimits are limits used for testing.
+var testLimits = core.Limits{
+	RunningJobs:     4,
+	QueuedWorkflows: 2,
+	CPUs:            8,
+	OutputBytes:     1 << 20,
+}
+
+func TestViewerQuota(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits core.Limits
+	}{
+		{"Unlimited", core.Limits{}},
+		{"Limited", testLimits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{queued: 1},
+				f: mockIOFetcher{outputSize: 1024},
+				s: mockScheduler{usage: core.Usage{RunningJobs: 2, CPUs: 3}},
+			}, core.OptUserLimits(tt.limits))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			query OpName {
+			  viewer {
+			    quota {
+			      runningJobs {
+			        used
+			        limit
+			      }
+			      queuedWorkflows {
+			        used
+			        limit
+			      }
+			      cpus {
+			        used
+			        limit
+			      }
+			      outputBytes {
+			        used
+			        limit
+			      }
+			    }
+			  }
+			}`
+
+			res := s.Exec(getTokenContext(), q, "", nil)
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestProjectQuota(t *testing.T) {
+	mc, err := getMockCore(mockCore{
+		p: mockPersister{
+			w: core.Workflow{
+				CreatedByID: testUserID,
+				ProjectID:   "projectID",
+				ID:          "workflowID",
+				Name:        "workflowName",
+				CreatedAt:   time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+			},
+			pr:     getTestProject(core.ProjectViewer),
+			queued: 1,
+		},
+		f: mockIOFetcher{outputSize: 1024},
+		s: mockScheduler{usage: core.Usage{RunningJobs: 2, CPUs: 3}},
+	}, core.OptProjectLimits(testLimits))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := schema.Get(&Resolver{s: mc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `
+	query OpName($id: ID!) {
+	  workflow(id: $id) {
+	    project {
+	      quota {
+	        runningJobs {
+	          used
+	          limit
+	        }
+	        queuedWorkflows {
+	          used
+	          limit
+	        }
+	        cpus {
+	          used
+	          limit
+	        }
+	        outputBytes {
+	          used
+	          limit
+	        }
+	      }
+	    }
+	  }
+	}`
+
+	args := map[string]interface{}{
+		"id": "workflowID",
+	}
+
+	res := s.Exec(getTokenContext(), q, "", args)
+	if err := verifyGoldenJSON(t.Name(), res); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateWorkflowQuota(t *testing.T) {
+	spec := func(cpus int, projectID interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"spec": map[string]interface{}{
+				"name": "workflowName",
+				"jobs": map[string]interface{}{
+					"name":      "jobName",
+					"image":     "jobImage",
+					"command":   "jobCommand",
+					"resources": map[string]interface{}{"cpus": cpus},
+				},
+				"projectID": projectID,
+			},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		userLimits    core.Limits
+		projectLimits core.Limits
+		queued        int
+		outputSize    int64
+		vars          map[string]interface{}
+	}{
+		{"OK", testLimits, testLimits, 1, 1024, spec(8, "projectID")},
+		{"UserQueuedWorkflows", testLimits, core.Limits{}, 2, 0, spec(1, nil)},
+		{"UserCPUs", testLimits, core.Limits{}, 0, 0, spec(9, nil)},
+		{"UserOutputBytes", testLimits, core.Limits{}, 0, testLimits.OutputBytes + 1, spec(1, nil)},
+		{"ProjectQueuedWorkflows", core.Limits{}, testLimits, 2, 0, spec(1, "projectID")},
+		{"ProjectCPUs", core.Limits{}, testLimits, 0, 0, spec(9, "projectID")},
+		{"ProjectIgnored", core.Limits{}, testLimits, 2, 0, spec(9, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+						CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+					},
+					j: core.Job{
+						ID:         "jobID",
+						WorkflowID: "workflowID",
+						Name:       "jobName",
+						Image:      "jobImage",
+						Command:    []string{"jobCommand"},
+					},
+					pr:     getTestProject(core.ProjectSubmitter),
+					queued: tt.queued,
+				},
+				f: mockIOFetcher{outputSize: tt.outputSize},
+			}, core.OptUserLimits(tt.userLimits), core.OptProjectLimits(tt.projectLimits))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($spec: WorkflowSpec!) {
+			  createWorkflow(spec: $spec) {
+			    id
+			    name
+			  }
+			}`
+
+			res := s.Exec(getTokenContext(), q, "", tt.vars)
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCreateWorkflowInvalidQuota(t *testing.T) {
+	tests := []struct {
+		name string
+		spec map[string]interface{}
+	}{
+		{"DuplicateVolume", map[string]interface{}{
+			"name": "workflowName",
+			"volumes": []interface{}{
+				map[string]interface{}{"name": "v", "type": "EPHEMERAL"},
+				map[string]interface{}{"name": "v", "type": "EPHEMERAL"},
+			},
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+			},
+		}},
+		{"MissingVolume", map[string]interface{}{
+			"name": "workflowName",
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+				"volumes": map[string]interface{}{"name": "v", "location": "/v"},
+			},
+		}},
+		{"InvalidJob", map[string]interface{}{
+			"name": "workflowName",
+			"jobs": []interface{}{
+				map[string]interface{}{
+					"name":    "first",
+					"image":   "jobImage",
+					"command": "jobCommand",
+				},
+				map[string]interface{}{
+					"name":     "second",
+					"image":    "jobImage",
+					"command":  "jobCommand",
+					"requires": "first",
+					"timeout":  "-1h",
+				},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []core.Job
+			var removed []string
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+					},
+					v:       core.Volume{ID: "volumeID", WorkflowID: "workflowID", Name: "v"},
+					created: &created,
+					removed: &removed,
+				},
+			}, core.OptUserLimits(testLimits))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($spec: WorkflowSpec!) {
+			  createWorkflow(spec: $spec) {
+			    id
+			  }
+			}`
+
+			res := s.Exec(getTokenContext(), q, "", map[string]interface{}{"spec": tt.spec})
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+
+			// The workflow should be removed along with its jobs and volumes, so that it does not
+			// count towards quotas.
+			want := []string{"workflow workflowID", "jobs workflowID", "volumes workflowID"}
+			if !reflect.DeepEqual(removed, want) {
+				t.Errorf("got removed %v, want %v", removed, want)
+			}
+		})
+	}
+}
@@ -5,6 +5,9 @@ package resolver
 // Servicer is the interface required to service GraphQL queries.
 type Servicer interface {
 	BuildInfoServicer
+	JobServicer
+	ProjectServicer
+	SecretServicer
 	UserServicer
 	WorkflowServicer
 }
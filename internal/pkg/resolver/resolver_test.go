@@ -20,6 +20,9 @@ import (
 
 var update = flag.Bool("update", false, "update .golden files")
 
+// testUserID is the ID of the user associated with the context returned by getTokenContext.
+const testUserID = "507f1f77bcf86cd799439011"
+
 // goldenPath returns the path of the golden file corresponding to name.
 func goldenPath(name string) string {
 	// Replace test name separator with OS-specific path separator.
@@ -71,7 +74,7 @@ func getTokenContext() context.Context {
 			StandardClaims: jwt.StandardClaims{
 				Subject: "jimbob",
 			},
-			UserID: "507f1f77bcf86cd799439011",
+			UserID: testUserID,
 		}),
 	}
 	return token.NewContext(context.Background(), &tok)
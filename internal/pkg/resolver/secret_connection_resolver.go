@@ -0,0 +1,44 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import "github.com/sylabs/fuzzball-service/internal/pkg/core"
+
+// SecretEdgeResolver resolves a secret edge.
+type SecretEdgeResolver struct {
+	s core.Secret
+}
+
+// Cursor resolves a cursor for use in pagination.
+func (r *SecretEdgeResolver) Cursor() string {
+	return r.s.ID
+}
+
+// Node resolves the item at the end of the edge.
+func (r *SecretEdgeResolver) Node() *SecretResolver {
+	return &SecretResolver{r.s}
+}
+
+// SecretConnectionResolver resolves a secret connection.
+type SecretConnectionResolver struct {
+	sp core.SecretsPage
+}
+
+// Edges resolves a list of edges.
+func (r *SecretConnectionResolver) Edges() *[]*SecretEdgeResolver {
+	ser := []*SecretEdgeResolver{}
+	for _, s := range r.sp.Secrets {
+		ser = append(ser, &SecretEdgeResolver{s})
+	}
+	return &ser
+}
+
+// PageInfo resolves information to aid in pagination.
+func (r *SecretConnectionResolver) PageInfo() *PageInfoResolver {
+	return &PageInfoResolver{r.sp.PageInfo}
+}
+
+// TotalCount resolves the total count of items in the connection.
+func (r *SecretConnectionResolver) TotalCount() int32 {
+	return int32(r.sp.TotalCount)
+}
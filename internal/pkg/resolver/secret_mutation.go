@@ -0,0 +1,30 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"context"
+)
+
+// SetSecret registers a secret, or replaces the value of an existing secret.
+func (r Resolver) SetSecret(ctx context.Context, args struct {
+	Name  string
+	Value string
+}) (*SecretResolver, error) {
+	s, err := r.s.SetSecret(ctx, args.Name, args.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretResolver{s}, nil
+}
+
+// DeleteSecret deletes a secret.
+func (r Resolver) DeleteSecret(ctx context.Context, args struct {
+	Name string
+}) (*SecretResolver, error) {
+	s, err := r.s.DeleteSecret(ctx, args.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretResolver{s}, nil
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"context"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// SecretServicer is the interface by which secrets are serviced.
+type SecretServicer interface {
+	SetSecret(context.Context, string, string) (core.Secret, error)
+	DeleteSecret(context.Context, string) (core.Secret, error)
+}
+
+// SecretResolver resolves a secret. The value of the secret is never resolved.
+type SecretResolver struct {
+	s core.Secret
+}
+
+// ID resolves the secret ID.
+func (r *SecretResolver) ID() graphql.ID {
+	return graphql.ID(r.s.ID)
+}
+
+// Name resolves the secret name.
+func (r *SecretResolver) Name() string {
+	return r.s.Name
+}
+
+// CreatedAt resolves when the secret was created.
+func (r *SecretResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.s.CreatedAt}
+}
+
+// UpdatedAt resolves when the value of the secret was last set.
+func (r *SecretResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.s.UpdatedAt}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"github.com/sylabs/fuzzball-service/internal/pkg/schema"
+)
+
+// getTestSecret returns a secret registered by the test user.
+func getTestSecret(name string) core.Secret {
+	return core.Secret{
+		ID:          "secretID",
+		Name:        name,
+		Value:       "secretValue",
+		CreatedByID: testUserID,
+		CreatedAt:   time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+		UpdatedAt:   time.Date(2020, 01, 21, 19, 21, 30, 0, time.UTC),
+	}
+}
+
+func TestSetSecret(t *testing.T) {
+	tests := []struct {
+		name       string
+		secretName string
+	}{
+		{"OK", "secretName"},
+		{"EmptyName", ""},
+		{"BadName", "secret/name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					sec: getTestSecret(tt.secretName),
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($name: String!, $value: String!) {
+			  setSecret(name: $name, value: $value) {
+			    id
+			    name
+			    createdAt
+			    updatedAt
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"name":  tt.secretName,
+				"value": "secretValue",
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDeleteSecret(t *testing.T) {
+	tests := []struct {
+		name       string
+		secretName string
+	}{
+		{"OK", "secretName"},
+		{"NotFound", "otherName"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					sec: getTestSecret("secretName"),
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($name: String!) {
+			  deleteSecret(name: $name) {
+			    id
+			    name
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"name": tt.secretName,
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+	"github.com/sylabs/fuzzball-service/internal/pkg/schema"
+)
+
+// subscribe executes subscription q with args, and returns the responses received until the
+// subscription completes. Since subscriptions to unfinished items do not complete on their own,
+// the subscription is cancelled once n responses are received.
+func subscribe(t *testing.T, mc *core.Core, q string, args map[string]interface{}, n int) []interface{} {
+	s, err := schema.Get(&Resolver{s: mc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(getTokenContext())
+	defer cancel()
+
+	c, err := s.Subscribe(ctx, q, "", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res []interface{}
+	for r := range c {
+		if res = append(res, r); len(res) == n {
+			cancel()
+		}
+	}
+	return res
+}
+
+func TestWorkflowUpdated(t *testing.T) {
+	tests := []struct {
+		name        string
+		createdByID string
+		status      core.WorkflowStatus
+		id          string
+	}{
+		{"Running", testUserID, core.WorkflowRunning, "workflowID"},
+		{"Finished", testUserID, core.WorkflowSucceeded, "workflowID"},
+		{"BadID", testUserID, core.WorkflowRunning, "bad"},
+		{"Forbidden", "otherUserID", core.WorkflowRunning, "workflowID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    tt.createdByID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+						CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+						Status:         tt.status,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			subscription OpName($id: ID!) {
+			  workflowUpdated(id: $id) {
+			    id
+			    name
+			    status
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": tt.id,
+			}
+
+			res := subscribe(t, mc, q, args, 1)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobUpdated(t *testing.T) {
+	tests := []struct {
+		name        string
+		createdByID string
+		status      core.JobStatus
+		id          string
+	}{
+		{"Running", testUserID, core.JobRunning, "jobID"},
+		{"Finished", testUserID, core.JobSucceeded, "jobID"},
+		{"BadID", testUserID, core.JobRunning, "bad"},
+		{"Forbidden", "otherUserID", core.JobRunning, "jobID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					j: core.Job{
+						CreatedByID:    tt.createdByID,
+						CreatedByLogin: "jimbob",
+						ID:             "jobID",
+						WorkflowID:     "workflowID",
+						Name:           "jobName",
+						Image:          "jobImage",
+						Command:        []string{"jobCommand"},
+						Status:         tt.status,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			subscription OpName($id: ID!) {
+			  jobUpdated(id: $id) {
+			    id
+			    name
+			    status
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": tt.id,
+			}
+
+			res := subscribe(t, mc, q, args, 1)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		createdByID string
+		status      core.JobStatus
+		output      string
+		chunks      []core.JobOutputChunk
+		fromOffset  int
+		n           int
+	}{
+		{"Finished", testUserID, core.JobSucceeded, "hello world", nil, 0, 1},
+		{"FinishedFromOffset", testUserID, core.JobSucceeded, "hello world", nil, 6, 1},
+		{"FinishedPastEnd", testUserID, core.JobSucceeded, "hello world", nil, 20, 1},
+		{"Running", testUserID, core.JobRunning, "hello ", []core.JobOutputChunk{
+			{Offset: 6, Data: "world"},
+		}, 0, 2},
+		{"RunningOverlap", testUserID, core.JobRunning, "hello ", []core.JobOutputChunk{
+			{Offset: 0, Data: "hello "},
+			{Offset: 3, Data: "lo world"},
+		}, 0, 2},
+		{"RunningFromOffset", testUserID, core.JobRunning, "hello ", []core.JobOutputChunk{
+			{Offset: 6, Data: "world"},
+		}, 8, 1},
+		{"NegativeOffset", testUserID, core.JobRunning, "", nil, -1, 1},
+		{"Forbidden", "otherUserID", core.JobRunning, "", nil, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					j: core.Job{
+						CreatedByID:    tt.createdByID,
+						CreatedByLogin: "jimbob",
+						ID:             "jobID",
+						WorkflowID:     "workflowID",
+						Name:           "jobName",
+						Image:          "jobImage",
+						Command:        []string{"jobCommand"},
+						Status:         tt.status,
+					},
+				},
+				f: mockIOFetcher{
+					output: tt.output,
+					chunks: tt.chunks,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			subscription OpName($id: ID!, $fromOffset: Int64) {
+			  jobOutput(id: $id, fromOffset: $fromOffset) {
+			    offset
+			    data
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id":         "jobID",
+				"fromOffset": tt.fromOffset,
+			}
+
+			res := subscribe(t, mc, q, args, tt.n)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobOutputRetried(t *testing.T) {
+	j := core.Job{
+		CreatedByID:    testUserID,
+		CreatedByLogin: "jimbob",
+		ID:             "jobID",
+		WorkflowID:     "workflowID",
+		Name:           "jobName",
+		Image:          "jobImage",
+		Command:        []string{"jobCommand"},
+		Status:         core.JobRunning,
+	}
+
+	// Once the job is updated, the output of its first attempt has been archived, and the output
+	// stored is that of its second attempt.
+	retried := j
+	retried.Status = core.JobSucceeded
+	retried.Attempts = []core.JobAttempt{{Number: 1, OutputKey: "jobID.attempt.1"}}
+
+	mc, err := getMockCore(mockCore{
+		p: mockPersister{
+			j:        j,
+			versions: &[]core.Job{j, retried},
+		},
+		f: mockIOFetcher{output: "hello world"},
+		s: mockScheduler{updates: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `
+	subscription OpName($id: ID!, $fromOffset: Int64) {
+	  jobOutput(id: $id, fromOffset: $fromOffset) {
+	    offset
+	    data
+	  }
+	}`
+
+	args := map[string]interface{}{
+		"id":         "jobID",
+		"fromOffset": 6,
+	}
+
+	// The output of the second attempt should be sent from the start.
+	res := subscribe(t, mc, q, args, 2)
+
+	if err := verifyGoldenJSON(t.Name(), res); err != nil {
+		t.Fatal(err)
+	}
+}
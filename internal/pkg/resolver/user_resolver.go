@@ -29,6 +29,15 @@ func (r *UserResolver) Login() string {
 	return r.u.Login
 }
 
+// Quota resolves the resources consumed by the user, and the limits on them.
+func (r *UserResolver) Quota(ctx context.Context) (*QuotaResolver, error) {
+	q, err := r.u.Quota(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &QuotaResolver{q}, nil
+}
+
 // Workflows looks up workflows associated with the user.
 func (r *UserResolver) Workflows(ctx context.Context, args pageArgs) (*WorkflowConnectionResolver, error) {
 	p, err := r.u.WorkflowsPage(ctx, convertPageArgs(args))
@@ -55,3 +64,21 @@ func (r *UserResolver) Volumes(ctx context.Context, args pageArgs) (*VolumeConne
 	}
 	return &VolumeConnectionResolver{p}, nil
 }
+
+// Projects looks up projects the user is a member of.
+func (r *UserResolver) Projects(ctx context.Context, args pageArgs) (*ProjectConnectionResolver, error) {
+	p, err := r.u.ProjectsPage(ctx, convertPageArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return &ProjectConnectionResolver{p}, nil
+}
+
+// Secrets looks up secrets registered by the user.
+func (r *UserResolver) Secrets(ctx context.Context, args pageArgs) (*SecretConnectionResolver, error) {
+	p, err := r.u.SecretsPage(ctx, convertPageArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return &SecretConnectionResolver{p}, nil
+}
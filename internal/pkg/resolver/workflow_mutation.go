@@ -29,3 +29,14 @@ func (r Resolver) DeleteWorkflow(ctx context.Context, args struct {
 	}
 	return &WorkflowResolver{w}, nil
 }
+
+// CancelWorkflow cancels a workflow.
+func (r Resolver) CancelWorkflow(ctx context.Context, args struct {
+	ID string
+}) (*WorkflowResolver, error) {
+	w, err := r.s.CancelWorkflow(ctx, args.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &WorkflowResolver{w}, nil
+}
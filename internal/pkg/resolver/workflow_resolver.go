@@ -4,6 +4,7 @@ package resolver
 
 import (
 	"context"
+	"time"
 
 	"github.com/graph-gophers/graphql-go"
 	"github.com/sylabs/fuzzball-service/internal/pkg/core"
@@ -13,7 +14,9 @@ import (
 type WorkflowServicer interface {
 	CreateWorkflow(context.Context, core.WorkflowSpec) (core.Workflow, error)
 	DeleteWorkflow(context.Context, string) (core.Workflow, error)
+	CancelWorkflow(context.Context, string) (core.Workflow, error)
 	GetWorkflow(context.Context, string) (core.Workflow, error)
+	WorkflowUpdates(context.Context, string) (<-chan core.Workflow, error)
 }
 
 // WorkflowResolver resolves a workflow.
@@ -40,6 +43,15 @@ func (r *WorkflowResolver) CreatedBy(ctx context.Context) (*UserResolver, error)
 	return &UserResolver{u: &u}, nil
 }
 
+// Project resolves the project the workflow was submitted to, if any.
+func (r *WorkflowResolver) Project(ctx context.Context) (*ProjectResolver, error) {
+	p, ok, err := r.w.Project(ctx)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &ProjectResolver{p}, nil
+}
+
 // CreatedAt resolves when the workflow was created.
 func (r *WorkflowResolver) CreatedAt() graphql.Time {
 	return graphql.Time{Time: r.w.CreatedAt}
@@ -61,9 +73,27 @@ func (r *WorkflowResolver) FinishedAt() *graphql.Time {
 	return nil
 }
 
+// Duration resolves how long the workflow ran for, in seconds, if it has started.
+func (r *WorkflowResolver) Duration() *float64 {
+	if d, ok := r.w.Duration(time.Now()); ok {
+		s := d.Seconds()
+		return &s
+	}
+	return nil
+}
+
 // Status resolves the state of the workflow.
 func (r *WorkflowResolver) Status() string {
-	return r.w.Status
+	return r.w.Status.String()
+}
+
+// Timeout resolves the maximum time the workflow may run for, if limited.
+func (r *WorkflowResolver) Timeout() *string {
+	if r.w.Timeout > 0 {
+		s := r.w.Timeout.String()
+		return &s
+	}
+	return nil
 }
 
 // Jobs looks up jobs associated with the workflow.
@@ -0,0 +1,31 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package resolver
+
+import (
+	"context"
+)
+
+// WorkflowUpdated subscribes to updates to the status of a workflow.
+func (r Resolver) WorkflowUpdated(ctx context.Context, args struct {
+	ID string
+}) (<-chan *WorkflowResolver, error) {
+	ws, err := r.s.WorkflowUpdates(ctx, args.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan *WorkflowResolver)
+	go func() {
+		defer close(c)
+
+		for w := range ws {
+			select {
+			case c <- &WorkflowResolver{w}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
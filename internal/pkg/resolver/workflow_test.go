@@ -3,6 +3,7 @@
 package resolver
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -14,12 +15,14 @@ func TestWorkflowJobs(t *testing.T) {
 	startedAt := time.Date(2020, 01, 20, 19, 21, 31, 0, time.UTC)
 	finishedAt := time.Date(2020, 01, 20, 19, 21, 32, 0, time.UTC)
 	w := core.Workflow{
-		ID:         "workflowID",
-		CreatedAt:  time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
-		StartedAt:  &startedAt,
-		FinishedAt: &finishedAt,
-		Name:       "workflowName",
-		Status:     "COMPLETED",
+		CreatedByID:    testUserID,
+		CreatedByLogin: "jimbob",
+		ID:             "workflowID",
+		CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+		StartedAt:      &startedAt,
+		FinishedAt:     &finishedAt,
+		Name:           "workflowName",
+		Status:         core.WorkflowSucceeded,
 	}
 
 	sc := "startCursor"
@@ -27,8 +30,10 @@ func TestWorkflowJobs(t *testing.T) {
 	jp := core.JobsPage{
 		Jobs: []core.Job{
 			{
-				ID:   "id1",
-				Name: "name1",
+				ID:         "id1",
+				Name:       "name1",
+				StartedAt:  &startedAt,
+				FinishedAt: &finishedAt,
 			},
 			{
 				ID:   "id2",
@@ -91,6 +96,7 @@ func TestWorkflowJobs(t *testing.T) {
 			    createdAt
 			    startedAt
 			    finishedAt
+			    duration
 			    status
 			    jobs(after: $after, before: $before, first: $first, last: $last) {
 			      edges {
@@ -98,6 +104,9 @@ func TestWorkflowJobs(t *testing.T) {
 			        node {
 			          id
 			          name
+			          startedAt
+			          finishedAt
+			          duration
 			        }
 			      }
 			      pageInfo {
@@ -124,12 +133,14 @@ func TestWorkflowVolumes(t *testing.T) {
 	startedAt := time.Date(2020, 01, 20, 19, 21, 31, 0, time.UTC)
 	finishedAt := time.Date(2020, 01, 20, 19, 21, 32, 0, time.UTC)
 	w := core.Workflow{
-		ID:         "workflowID",
-		CreatedAt:  time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
-		StartedAt:  &startedAt,
-		FinishedAt: &finishedAt,
-		Name:       "workflowName",
-		Status:     "COMPLETED",
+		CreatedByID:    testUserID,
+		CreatedByLogin: "jimbob",
+		ID:             "workflowID",
+		CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+		StartedAt:      &startedAt,
+		FinishedAt:     &finishedAt,
+		Name:           "workflowName",
+		Status:         core.WorkflowSucceeded,
 	}
 
 	sc := "startCursor"
@@ -234,9 +245,11 @@ func TestCreateWorkflow(t *testing.T) {
 	mc, err := getMockCore(mockCore{
 		p: mockPersister{
 			w: core.Workflow{
-				ID:        "workflowID",
-				Name:      "workflowName",
-				CreatedAt: time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+				CreatedByID:    testUserID,
+				CreatedByLogin: "jimbob",
+				ID:             "workflowID",
+				Name:           "workflowName",
+				CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
 			},
 			j: core.Job{
 				ID:         "jobID",
@@ -245,6 +258,13 @@ func TestCreateWorkflow(t *testing.T) {
 				Image:      "jobImage",
 				Command:    []string{"jobCommand"},
 			},
+			pr: core.Project{
+				ID: "projectID",
+				Members: []core.ProjectMember{
+					{UserID: testUserID, Login: "jimbob", Role: core.ProjectViewer},
+				},
+			},
+			sec: getTestSecret("secretName"),
 		},
 	})
 	if err != nil {
@@ -278,12 +298,194 @@ func TestCreateWorkflow(t *testing.T) {
 		},
 	}
 
+	badRetryMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"jobs": map[string]interface{}{
+				"name":         "jobName",
+				"image":        "jobImage",
+				"command":      "jobCommand",
+				"retries":      1,
+				"retryBackoff": "soon",
+			},
+		},
+	}
+
+	badJobTimeoutMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+				"timeout": "-1h",
+			},
+		},
+	}
+
+	badWorkflowTimeoutMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name":    "workflowName",
+			"timeout": "forever",
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+			},
+		},
+	}
+
+	projectViewerMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name":      "workflowName",
+			"projectID": "projectID",
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+			},
+		},
+	}
+
+	envMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"env": []interface{}{
+				map[string]interface{}{"name": "A", "value": "a"},
+			},
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+				"env": []interface{}{
+					map[string]interface{}{"name": "B", "secret": "secretName"},
+				},
+			},
+		},
+	}
+
+	badEnvNameMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"env": []interface{}{
+				map[string]interface{}{"name": "1A", "value": "a"},
+			},
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+			},
+		},
+	}
+
+	badEnvSecretMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+				"env": []interface{}{
+					map[string]interface{}{"name": "B", "secret": "otherName"},
+				},
+			},
+		},
+	}
+
+	resourcesMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+				"resources": map[string]interface{}{
+					"cpus":     2,
+					"memory":   "512MiB",
+					"walltime": "1h",
+				},
+			},
+		},
+	}
+
+	badMemoryMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+				"resources": map[string]interface{}{
+					"memory": "lots",
+				},
+			},
+		},
+	}
+
+	badWalltimeMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+				"resources": map[string]interface{}{
+					"walltime": "-1h",
+				},
+			},
+		},
+	}
+
+	whenMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"jobs": []interface{}{
+				map[string]interface{}{
+					"name":    "jobName",
+					"image":   "jobImage",
+					"command": "jobCommand",
+				},
+				map[string]interface{}{
+					"name":     "cleanup",
+					"image":    "jobImage",
+					"command":  "jobCommand",
+					"requires": []interface{}{"jobName"},
+					"when":     "ALWAYS",
+				},
+			},
+		},
+	}
+
+	badWhenMap := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name": "workflowName",
+			"jobs": map[string]interface{}{
+				"name":    "jobName",
+				"image":   "jobImage",
+				"command": "jobCommand",
+				"when":    "ON_FAILURE",
+			},
+		},
+	}
+
 	tests := []struct {
 		name string
 		vars map[string]interface{}
 	}{
 		{"OK", okMap},
+		{"When", whenMap},
+		{"BadWhen", badWhenMap},
+		{"Env", envMap},
+		{"BadEnvName", badEnvNameMap},
+		{"BadEnvSecret", badEnvSecretMap},
+		{"Resources", resourcesMap},
+		{"BadMemory", badMemoryMap},
+		{"BadWalltime", badWalltimeMap},
 		{"BadName", badMap},
+		{"BadRetryBackoff", badRetryMap},
+		{"BadJobTimeout", badJobTimeoutMap},
+		{"BadWorkflowTimeout", badWorkflowTimeoutMap},
+		{"ProjectViewer", projectViewerMap},
 	}
 
 	for _, tt := range tests {
@@ -311,13 +513,214 @@ func TestCreateWorkflow(t *testing.T) {
 	}
 }
 
+func TestCreateWorkflowArray(t *testing.T) {
+	tests := []struct {
+		name     string
+		array    map[string]interface{}
+		wantJobs []core.Job
+	}{
+		{"Count", map[string]interface{}{"count": 2}, []core.Job{
+			{ID: "job0", Name: "sweep[0]", Env: []core.EnvVar{{Name: core.ArrayIndexEnv, Value: "0"}}},
+			{ID: "job1", Name: "sweep[1]", Env: []core.EnvVar{{Name: core.ArrayIndexEnv, Value: "1"}}},
+			{ID: "job2", Name: "reduce", Requires: []string{"job0", "job1"}},
+		}},
+		{"Values", map[string]interface{}{"values": []interface{}{"a", "b"}}, []core.Job{
+			{ID: "job0", Name: "sweep[0]", Env: []core.EnvVar{
+				{Name: core.ArrayIndexEnv, Value: "0"},
+				{Name: core.ArrayValueEnv, Value: "a"},
+			}},
+			{ID: "job1", Name: "sweep[1]", Env: []core.EnvVar{
+				{Name: core.ArrayIndexEnv, Value: "1"},
+				{Name: core.ArrayValueEnv, Value: "b"},
+			}},
+			{ID: "job2", Name: "reduce", Requires: []string{"job0", "job1"}},
+		}},
+		{"BadBoth", map[string]interface{}{"count": 1, "values": []interface{}{"a"}}, nil},
+		{"BadNeither", map[string]interface{}{}, nil},
+		{"BadEmpty", map[string]interface{}{"values": []interface{}{}}, nil},
+		{"BadTooLong", map[string]interface{}{"count": 10001}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []core.Job
+
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+						CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+					},
+					created: &created,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($spec: WorkflowSpec!) {
+			  createWorkflow(spec: $spec) {
+			    id
+			    name
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"spec": map[string]interface{}{
+					"name": "workflowName",
+					"jobs": []interface{}{
+						map[string]interface{}{
+							"name":    "sweep",
+							"image":   "jobImage",
+							"command": "jobCommand",
+							"array":   tt.array,
+						},
+						map[string]interface{}{
+							"name":     "reduce",
+							"image":    "jobImage",
+							"command":  "jobCommand",
+							"requires": []interface{}{"sweep"},
+						},
+					},
+				},
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(created) != len(tt.wantJobs) {
+				t.Fatalf("got %v jobs, want %v", len(created), len(tt.wantJobs))
+			}
+			for i, want := range tt.wantJobs {
+				got := created[i]
+
+				if got.ID != want.ID || got.Name != want.Name {
+					t.Errorf("got job %v %q, want %v %q", got.ID, got.Name, want.ID, want.Name)
+				}
+				if want.Name != "reduce" && (got.ArrayIndex == nil || *got.ArrayIndex != i) {
+					t.Errorf("job %v: got array index %v, want %v", got.ID, got.ArrayIndex, i)
+				}
+				if len(got.Env) != len(want.Env) || len(got.Requires) != len(want.Requires) {
+					t.Fatalf("job %v: got env %v requires %v, want env %v requires %v", got.ID, got.Env, got.Requires, want.Env, want.Requires)
+				}
+				for j := range want.Env {
+					if got.Env[j] != want.Env[j] {
+						t.Errorf("job %v: got env %v, want %v", got.ID, got.Env[j], want.Env[j])
+					}
+				}
+				for j := range want.Requires {
+					if got.Requires[j] != want.Requires[j] {
+						t.Errorf("job %v: got requires %v, want %v", got.ID, got.Requires[j], want.Requires[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCreateWorkflowNodeLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     []interface{}
+		wantLabels map[string]string
+		wantJobs   int
+	}{
+		{"None", []interface{}{}, nil, 1},
+		{"Labels", []interface{}{
+			map[string]interface{}{"key": "zone", "value": "a"},
+			map[string]interface{}{"key": "gpu", "value": "v100"},
+		}, map[string]string{"zone": "a", "gpu": "v100"}, 1},
+		{"BadEmptyKey", []interface{}{
+			map[string]interface{}{"key": "", "value": "a"},
+		}, nil, 0},
+		{"BadDuplicate", []interface{}{
+			map[string]interface{}{"key": "zone", "value": "a"},
+			map[string]interface{}{"key": "zone", "value": "b"},
+		}, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []core.Job
+
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    testUserID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+						CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+					},
+					created: &created,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($spec: WorkflowSpec!) {
+			  createWorkflow(spec: $spec) {
+			    id
+			    name
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"spec": map[string]interface{}{
+					"name": "workflowName",
+					"jobs": []interface{}{
+						map[string]interface{}{
+							"name":       "jobName",
+							"image":      "jobImage",
+							"command":    "jobCommand",
+							"nodeLabels": tt.labels,
+						},
+					},
+				},
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := len(created), tt.wantJobs; got != want {
+				t.Fatalf("got %v jobs, want %v", got, want)
+			}
+			if tt.wantJobs > 0 {
+				if got, want := created[0].NodeLabels, tt.wantLabels; !reflect.DeepEqual(got, want) {
+					t.Errorf("got node labels %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestDeleteWorkflow(t *testing.T) {
 	mc, err := getMockCore(mockCore{
 		p: mockPersister{
 			w: core.Workflow{
-				ID:        "workflowID",
-				Name:      "workflowName",
-				CreatedAt: time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+				CreatedByID:    testUserID,
+				CreatedByLogin: "jimbob",
+				ID:             "workflowID",
+				Name:           "workflowName",
+				CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
 			},
 			j: core.Job{
 				ID:         "jobID",
@@ -379,3 +782,104 @@ func TestDeleteWorkflow(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteWorkflowLeased(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+
+	mc, err := getMockCore(mockCore{
+		p: mockPersister{
+			w: core.Workflow{
+				CreatedByID:    testUserID,
+				CreatedByLogin: "jimbob",
+				ID:             "workflowID",
+				Name:           "workflowName",
+				CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+				Status:         core.WorkflowRunning,
+				LeaseOwner:     "replicaID",
+				LeaseExpiresAt: &expires,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := schema.Get(&Resolver{s: mc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := `
+	mutation OpName($id: ID!) {
+	  deleteWorkflow(id: $id) {
+	    id
+	  }
+	}`
+
+	args := map[string]interface{}{
+		"id": "workflowID",
+	}
+
+	res := s.Exec(getTokenContext(), q, "", args)
+
+	if err := verifyGoldenJSON(t.Name(), res); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelWorkflow(t *testing.T) {
+	tests := []struct {
+		name        string
+		createdByID string
+		status      core.WorkflowStatus
+		id          string
+	}{
+		{"OK", testUserID, core.WorkflowRunning, "workflowID"},
+		{"Finished", testUserID, core.WorkflowSucceeded, "workflowID"},
+		{"BadID", testUserID, core.WorkflowRunning, "bad"},
+		{"Forbidden", "otherUserID", core.WorkflowRunning, "workflowID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mc, err := getMockCore(mockCore{
+				p: mockPersister{
+					w: core.Workflow{
+						CreatedByID:    tt.createdByID,
+						CreatedByLogin: "jimbob",
+						ID:             "workflowID",
+						Name:           "workflowName",
+						CreatedAt:      time.Date(2020, 01, 20, 19, 21, 30, 0, time.UTC),
+						Status:         tt.status,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s, err := schema.Get(&Resolver{s: mc})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := `
+			mutation OpName($id: ID!) {
+			  cancelWorkflow(id: $id) {
+			    id
+			    name
+			    status
+			  }
+			}`
+
+			args := map[string]interface{}{
+				"id": tt.id,
+			}
+
+			res := s.Exec(getTokenContext(), q, "", args)
+
+			if err := verifyGoldenJSON(t.Name(), res); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// eventSubjects are the subjects on which agents publish the completion of operations. The
+// wildcard token in each subject is the ID that correlates the event with the operation.
+var eventSubjects = []string{
+	"job.*.finished",
+	"volume.*.create",
+	"volume.*.delete",
+	"image.*.download",
+	"image.*.cached",
+}
+
+// dispatcher routes events published by agents to the operations waiting on them.
+type dispatcher struct {
+	mu      sync.Mutex
+	waiting map[string][]chan []byte // Channels of waiting operations, by event subject.
+}
+
+// newDispatcher returns a new dispatcher with no waiting operations.
+func newDispatcher() *dispatcher {
+	return &dispatcher{
+		waiting: make(map[string][]chan []byte),
+	}
+}
+
+// expect registers interest in the event published on subject. The event data is sent on the
+// returned channel. The returned function must be called once the event is no longer of interest.
+func (d *dispatcher) expect(subject string) (<-chan []byte, func()) {
+	c := make(chan []byte, 1)
+
+	d.mu.Lock()
+	d.waiting[subject] = append(d.waiting[subject], c)
+	d.mu.Unlock()
+
+	return c, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		cs := d.waiting[subject]
+		for i := range cs {
+			if cs[i] == c {
+				cs = append(cs[:i], cs[i+1:]...)
+				break
+			}
+		}
+		if len(cs) == 0 {
+			delete(d.waiting, subject)
+		} else {
+			d.waiting[subject] = cs
+		}
+	}
+}
+
+// eventHandler routes an event published on subject to the operations waiting on it. Events that
+// no operation is waiting on are dropped.
+func (d *dispatcher) eventHandler(subject string, data []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cs, ok := d.waiting[subject]
+	if !ok {
+		logrus.WithField("subject", subject).Debug("dropping unexpected event")
+		return
+	}
+	for _, c := range cs {
+		select {
+		case c <- data:
+		default:
+			logrus.WithField("subject", subject).Warn("dropping duplicate event")
+		}
+	}
+}
+
+// newCorrelationID returns a unique ID used to correlate an operation with its completion event.
+func newCorrelationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate correlation ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// agentError converts an error reported by an agent into an error. A nil error is reported as
+// null, or omitted.
+func agentError(raw json.RawMessage) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var msg string
+	if err := json.Unmarshal(raw, &msg); err == nil {
+		if msg == "" {
+			return nil
+		}
+		return errors.New(msg)
+	}
+	return fmt.Errorf("agent error: %s", raw)
+}
+
+// awaitEvent waits for event data to be received on c, and decodes it into v. If ctx is done
+// before the event is received, ctx.Err() is returned.
+func awaitEvent(ctx context.Context, c <-chan []byte, v interface{}) error {
+	select {
+	case data := <-c:
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
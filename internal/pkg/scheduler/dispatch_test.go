@@ -0,0 +1,68 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDispatcher(t *testing.T) {
+	d := newDispatcher()
+
+	a, doneA := d.expect("job.a.finished")
+	b, doneB := d.expect("job.a.finished")
+	defer doneB()
+
+	// Events no operation is waiting on should be dropped.
+	d.eventHandler("job.b.finished", []byte(`{"RC":1}`))
+
+	// Events should be routed to all waiting operations.
+	d.eventHandler("job.a.finished", []byte(`{"RC":0}`))
+	for _, c := range []<-chan []byte{a, b} {
+		select {
+		case data := <-c:
+			if got, want := string(data), `{"RC":0}`; got != want {
+				t.Errorf("got data %v, want %v", got, want)
+			}
+		default:
+			t.Error("event not routed")
+		}
+	}
+
+	// Once done, the operation should no longer receive events.
+	doneA()
+	d.eventHandler("job.a.finished", []byte(`{"RC":0}`))
+	select {
+	case <-a:
+		t.Error("event routed after done")
+	default:
+	}
+
+	// Once all operations are done, the subject should be cleaned up.
+	doneB()
+	if got, want := len(d.waiting), 0; got != want {
+		t.Errorf("got %v waiting subjects, want %v", got, want)
+	}
+}
+
+func TestAgentError(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     json.RawMessage
+		wantErr bool
+	}{
+		{"Omitted", nil, false},
+		{"Null", json.RawMessage(`null`), false},
+		{"EmptyString", json.RawMessage(`""`), false},
+		{"String", json.RawMessage(`"failed"`), true},
+		{"Object", json.RawMessage(`{}`), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := agentError(tt.raw); (err != nil) != tt.wantErr {
+				t.Errorf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
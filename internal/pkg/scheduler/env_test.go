@@ -0,0 +1,58 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// secretPersister is a Persister that only supports retrieval of secrets.
+type secretPersister struct {
+	Persister
+	secrets map[string]string // Secret values, by "<user ID>/<name>".
+}
+
+func (p secretPersister) GetSecretByName(ctx context.Context, uid, name string) (core.Secret, error) {
+	v, ok := p.secrets[uid+"/"+name]
+	if !ok {
+		return core.Secret{}, core.ErrSecretNotFound
+	}
+	return core.Secret{Name: name, Value: v, CreatedByID: uid}, nil
+}
+
+func TestJobEnv(t *testing.T) {
+	s := &Scheduler{p: secretPersister{secrets: map[string]string{
+		"user/token": "s3cr3t",
+		"other/key":  "other",
+	}}}
+
+	tests := []struct {
+		name    string
+		env     []core.EnvVar
+		want    map[string]string
+		wantErr bool
+	}{
+		{"None", nil, map[string]string{}, false},
+		{"Value", []core.EnvVar{{Name: "A", Value: "a"}}, map[string]string{"A": "a"}, false},
+		{"Secret", []core.EnvVar{{Name: "A", Value: "a"}, {Name: "T", Secret: "token"}}, map[string]string{"A": "a", "T": "s3cr3t"}, false},
+		{"OtherUser", []core.EnvVar{{Name: "K", Secret: "key"}}, nil, true},
+		{"NotFound", []core.EnvVar{{Name: "T", Secret: "missing"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := s.jobEnv(context.Background(), core.Job{CreatedByID: "user", Env: tt.env})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if got, want := env, tt.want; !reflect.DeepEqual(got, want) {
+					t.Errorf("got env %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// workflowUpdatedSubject returns the subject on which changes to the status of the workflow with
+// the supplied ID are published.
+func workflowUpdatedSubject(id string) string {
+	return fmt.Sprintf("workflow.%v.updated", id)
+}
+
+// jobUpdatedSubject returns the subject on which changes to the status of the job with the
+// supplied ID are published.
+func jobUpdatedSubject(id string) string {
+	return fmt.Sprintf("job.%v.updated", id)
+}
+
+// statusEvent is published when the status of a workflow or job changes.
+type statusEvent struct {
+	ID     string
+	Status string
+}
+
+// publishStatus publishes a status change event on subject. Failure to publish the event is
+// logged.
+func (s *Scheduler) publishStatus(subject, id, status string) {
+	if err := s.m.Publish(subject, statusEvent{ID: id, Status: status}); err != nil {
+		logrus.WithError(err).WithField("subject", subject).Warn("failed to publish status event")
+	}
+}
+
+// watch registers interest in events published on subject. A value is sent on the returned
+// channel each time an event is received. Events received while a previous value is pending are
+// coalesced. The channel is closed once ctx is done.
+func (s *Scheduler) watch(ctx context.Context, subject string) (<-chan struct{}, error) {
+	c := make(chan struct{}, 1)
+
+	var mu sync.Mutex
+	closed := false
+
+	sub, err := s.m.Subscribe(subject, func(subject string, data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !closed {
+			select {
+			case c <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		if err := sub.Unsubscribe(); err != nil {
+			logrus.WithError(err).WithField("subject", subject).Warn("failed to unsubscribe")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		closed = true
+		close(c)
+	}()
+
+	return c, nil
+}
+
+// WatchWorkflow returns a channel that receives a value each time the status of the workflow with
+// the supplied ID changes. The channel is closed once ctx is done.
+func (s *Scheduler) WatchWorkflow(ctx context.Context, id string) (<-chan struct{}, error) {
+	return s.watch(ctx, workflowUpdatedSubject(id))
+}
+
+// WatchJob returns a channel that receives a value each time the status of the job with the
+// supplied ID changes. The channel is closed once ctx is done.
+func (s *Scheduler) WatchJob(ctx context.Context, id string) (<-chan struct{}, error) {
+	return s.watch(ctx, jobUpdatedSubject(id))
+}
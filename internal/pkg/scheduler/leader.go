@@ -0,0 +1,134 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// Only one replica of the scheduler schedules workflows at once: the replica that holds the
+// scheduler lease, known as the leader. The registry of nodes, the queue of jobs waiting to be
+// placed, and the usage of users and projects are held in memory by the leader, so that placement
+// and the limits on users and projects account for every running job. Other replicas track node
+// heartbeats, so that they are ready to take over once the lease expires, but do not run
+// workflows.
+
+const (
+	// schedulerLease is the name of the lease held by the leader.
+	schedulerLease = "scheduler"
+
+	// workflowScheduledSubject is the subject on which replicas that are not the leader announce
+	// that a workflow has been scheduled, so that the leader runs it without delay.
+	workflowScheduledSubject = "scheduler.workflow.scheduled"
+
+	// Subjects on which replicas that are not the leader query the state held by the leader.
+	userUsageSubject     = "scheduler.usage.user"
+	projectUsageSubject  = "scheduler.usage.project"
+	queuePositionSubject = "scheduler.job.position"
+
+	// cancelSubject is the subject on which replicas that are not the leader request that the
+	// leader cancel a workflow or job.
+	cancelSubject = "scheduler.cancel"
+
+	// leaderRequestTimeout is how long to wait for the leader to reply to a request.
+	leaderRequestTimeout = 10 * time.Second
+
+	// cancelTimeout is how long the leader waits for a workflow or job to stop once cancelled on
+	// behalf of another replica.
+	cancelTimeout = 2 * time.Minute
+)
+
+// leaderQuery is a request for state held by the leader about the user, project or job with the
+// supplied ID.
+type leaderQuery struct {
+	ID string
+}
+
+// isLeader returns true if this replica holds the scheduler lease.
+func (s *Scheduler) isLeader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Now().Before(s.leaderExpires)
+}
+
+// claimLeadership claims the scheduler lease on behalf of this replica, or renews it if already
+// held. If this replica holds the lease, leader is true, and if it did not hold the lease before,
+// elected is also true. If the lease is lost, or cannot be renewed before it expires, the
+// workflows run by this replica are abandoned.
+func (s *Scheduler) claimLeadership(ctx context.Context) (leader, elected bool, err error) {
+	t := time.Now()
+	expires := t.Add(workflowLeaseTTL)
+	ok, err := s.p.ClaimLease(ctx, schedulerLease, s.id, t, expires)
+
+	s.mu.Lock()
+	wasLeader := t.Before(s.leaderExpires)
+	if err == nil {
+		if ok {
+			s.leaderExpires = expires
+		} else {
+			s.leaderExpires = time.Time{}
+		}
+	}
+	leader = t.Before(s.leaderExpires)
+	s.mu.Unlock()
+
+	switch {
+	case leader && !wasLeader:
+		logrus.WithField("replicaID", s.id).Info("elected scheduler leader")
+	case !leader && wasLeader:
+		logrus.WithField("replicaID", s.id).Warn("scheduler lease lost, abandoning workflows")
+	}
+	if !leader {
+		s.abandonWorkflows()
+	}
+	return leader, leader && !wasLeader, err
+}
+
+// abandonWorkflows abandons all workflows run by this replica.
+func (s *Scheduler) abandonWorkflows() {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.runs))
+	for id := range s.runs {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		s.abandonWorkflow(id)
+	}
+}
+
+// workflowScheduledHandler handles announcements that a workflow has been scheduled by another
+// replica. If this replica is the leader, it resumes the workflow.
+func (s *Scheduler) workflowScheduledHandler(subject string, data []byte) {
+	if !s.isLeader() {
+		return
+	}
+	if err := s.Resume(context.Background()); err != nil {
+		logrus.WithError(err).Warn("failed to resume workflows")
+	}
+}
+
+// requestLeader sends request req to the leader on subject, and decodes the reply into resp. Every
+// replica receives the request, but only the leader replies.
+func (s *Scheduler) requestLeader(subject string, req, resp interface{}) error {
+	return s.m.Request(subject, req, resp, leaderRequestTimeout)
+}
+
+// leaderHandler returns a handler for requests sent with requestLeader, which replies with the
+// value returned by f if this replica is the leader. Failure to reply is logged.
+func (s *Scheduler) leaderHandler(f func(leaderQuery) interface{}) nats.Handler {
+	return func(subject, reply string, q *leaderQuery) {
+		if !s.isLeader() {
+			return
+		}
+		if err := s.m.Publish(reply, f(*q)); err != nil {
+			logrus.WithError(err).WithField("subject", subject).Warn("failed to reply to request")
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// loopRequestTimeout is the maximum time a loopMessager waits for a reply to a request.
+const loopRequestTimeout = time.Second
+
+// loopReply is a pending reply to a request sent by a loopMessager.
+type loopReply struct {
+	v    interface{}   // Receives the decoded reply.
+	err  error         // Error decoding the reply.
+	done chan struct{} // Closed once the reply is received.
+}
+
+// loopMessager is a Messager that delivers messages to handlers subscribed to the same
+// loopMessager, encoded as JSON.
+type loopMessager struct {
+	mu      sync.Mutex
+	subs    map[string][]nats.Handler
+	replies map[string]*loopReply // Pending replies, by reply subject.
+	n       int
+}
+
+func newLoopMessager() *loopMessager {
+	return &loopMessager{
+		subs:    make(map[string][]nats.Handler),
+		replies: make(map[string]*loopReply),
+	}
+}
+
+func (m *loopMessager) Subscribe(subject string, cb nats.Handler) (*nats.Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subs[subject] = append(m.subs[subject], cb)
+	return &nats.Subscription{Subject: subject}, nil
+}
+
+// deliver calls handler h with a message published on subject, with the supplied reply subject.
+func deliver(h nats.Handler, subject, reply string, data []byte) error {
+	fn := reflect.ValueOf(h)
+	t := fn.Type()
+
+	// The last argument receives the message, decoded according to its type.
+	at := t.In(t.NumIn() - 1)
+	var arg reflect.Value
+	switch {
+	case at == reflect.TypeOf([]byte(nil)):
+		arg = reflect.ValueOf(data)
+	case at.Kind() == reflect.Ptr:
+		arg = reflect.New(at.Elem())
+		if err := json.Unmarshal(data, arg.Interface()); err != nil {
+			return err
+		}
+	default:
+		arg = reflect.New(at)
+		if err := json.Unmarshal(data, arg.Interface()); err != nil {
+			return err
+		}
+		arg = arg.Elem()
+	}
+
+	args := []reflect.Value{reflect.ValueOf(subject), reflect.ValueOf(reply), arg}
+	fn.Call(args[len(args)-t.NumIn():])
+	return nil
+}
+
+func (m *loopMessager) Publish(subject string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	r, ok := m.replies[subject]
+	delete(m.replies, subject)
+	subs := m.subs[subject]
+	m.mu.Unlock()
+
+	if ok {
+		r.err = json.Unmarshal(data, r.v)
+		close(r.done)
+		return nil
+	}
+	for _, h := range subs {
+		if err := deliver(h, subject, "", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *loopMessager) Request(subject string, v interface{}, vPtr interface{}, timeout time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.n++
+	reply := fmt.Sprintf("reply.%v", m.n)
+	r := &loopReply{v: vPtr, done: make(chan struct{})}
+	m.replies[reply] = r
+	subs := m.subs[subject]
+	m.mu.Unlock()
+
+	for _, h := range subs {
+		if err := deliver(h, subject, reply, data); err != nil {
+			return err
+		}
+	}
+
+	if timeout > loopRequestTimeout {
+		timeout = loopRequestTimeout
+	}
+	select {
+	case <-r.done:
+		return r.err
+	case <-time.After(timeout):
+		m.mu.Lock()
+		delete(m.replies, reply)
+		m.mu.Unlock()
+		return nats.ErrTimeout
+	}
+}
+
+func TestLeaderQueries(t *testing.T) {
+	p := newLeasePersister()
+	m := newLoopMessager()
+
+	a, err := New(m, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	b, err := New(m, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	for _, s := range []*Scheduler{a, b} {
+		if err := s.Start(); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+		defer s.Stop()
+	}
+
+	// Until a leader is elected, requests should fail.
+	if _, err := b.UserUsage(context.Background(), "u"); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	// Elect replica A, and record usage with it.
+	if err := a.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	want := core.Usage{RunningJobs: 1, CPUs: 2}
+	a.reg.mu.Lock()
+	a.reg.users["u"] = want
+	a.reg.projects["p"] = want
+	a.reg.mu.Unlock()
+
+	// Replica B should report the usage recorded by the leader, rather than its own.
+	tests := []struct {
+		name  string
+		usage func(context.Context, string) (core.Usage, error)
+		id    string
+	}{
+		{"User", b.UserUsage, "u"},
+		{"Project", b.ProjectUsage, "p"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.usage(context.Background(), tt.id)
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+			if got != want {
+				t.Errorf("got usage %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLeaderCancel(t *testing.T) {
+	p := newLeasePersister(core.Workflow{ID: "w", Status: core.WorkflowRunning})
+
+	// With no nodes registered, the job waits to be placed until it is cancelled.
+	p.jobs["w"] = []core.Job{{ID: "j", WorkflowID: "w"}}
+
+	m := newLoopMessager()
+
+	a, err := New(m, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	b, err := New(m, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	for _, s := range []*Scheduler{a, b} {
+		if err := s.Start(); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+		defer s.Stop()
+	}
+
+	// Replica A is elected, and runs the workflow.
+	if err := a.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// Replica B should have the leader cancel the workflow.
+	if err := b.CancelWorkflow(context.Background(), core.Workflow{ID: "w"}); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got := p.wait(t, "w"); got[len(got)-1] != core.WorkflowCancelled {
+		t.Errorf("got statuses %v, want final status %v", got, core.WorkflowCancelled)
+	}
+
+	// Once the workflow has stopped, it is no longer running.
+	if err := b.CancelWorkflow(context.Background(), core.Workflow{ID: "w"}); !errors.Is(err, core.ErrWorkflowNotRunning) {
+		t.Errorf("got error %v, want %v", err, core.ErrWorkflowNotRunning)
+	}
+}
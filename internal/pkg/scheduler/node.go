@@ -0,0 +1,401 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+const (
+	// DefaultNodeCPUs is the number of CPUs assumed for a node that does not report its number of
+	// CPUs, unless otherwise configured.
+	DefaultNodeCPUs = 4
+
+	// nodeHeartbeatTimeout is the time after which a node that has not sent a heartbeat is
+	// considered to be dead.
+	nodeHeartbeatTimeout = 30 * time.Second
+
+	// nodeReapInterval is the interval at which dead nodes are removed from the registry.
+	nodeReapInterval = 5 * time.Second
+)
+
+var (
+	// errNodeLost is returned when the node running a job stops sending heartbeats.
+	errNodeLost = errors.New("node lost")
+
+	// errNoNodeFits is returned when no registered node has the labels and resources a job
+	// requires.
+	errNoNodeFits = errors.New("no node has the labels and resources the job requires")
+)
+
+// Node describes a compute node, as reported by its agent on registration and on each heartbeat. If
+// the number of CPUs is not reported, the registry assumes a default number, so that the jobs
+// placed on the node at once are limited. If the amount of memory is not reported, it is treated
+// as unlimited.
+type Node struct {
+	ID     string            // Unique node ID.
+	Arch   string            // Architecture of the node (as per GOARCH).
+	CPUs   int               // Number of CPUs.
+	Memory int64             // Amount of memory, in bytes.
+	Labels map[string]string // Arbitrary labels describing the node, which jobs may require.
+}
+
+// HasLabels returns true if node n has each of the supplied labels, with the same value.
+func (n Node) HasLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if lv, ok := n.Labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeState tracks the state of a registered node.
+type nodeState struct {
+	NodeState
+	lastSeen time.Time     // Time of the last heartbeat.
+	gone     chan struct{} // Closed when the node is removed from the registry.
+}
+
+// subject returns a NATS subject used to send an operation to the node.
+func (n *nodeState) subject(op string) string {
+	return fmt.Sprintf("node.%v.%v", n.ID, op)
+}
+
+// registry tracks the compute nodes available to the scheduler, and the queue of jobs waiting to
+// be placed on them. The registry is held in memory, so jobs are only placed by the leader, which
+// is the only replica that runs workflows.
+type registry struct {
+	now           func() time.Time // Returns the current time.
+	graceEnd      time.Time        // Until this time, unknown nodes are assumed to be yet to register.
+	defaultCPUs   int              // Number of CPUs assumed for a node that does not report them.
+	policy        Policy           // Decides the order in which queued jobs are placed, and where.
+	userLimits    core.Limits      // Limits on the jobs of each user placed at once.
+	projectLimits core.Limits      // Limits on the jobs of each project placed at once.
+
+	mu       sync.Mutex
+	nodes    map[string]*nodeState // Live nodes, by node ID.
+	queue    []*waiter             // Jobs waiting to be placed, in the order they were queued.
+	seq      uint64                // Sequence number of the most recently queued job.
+	users    map[string]core.Usage // Jobs placed and CPUs allocated for each user, by user ID.
+	projects map[string]core.Usage // Jobs placed and CPUs allocated for each project, by project ID.
+	usage    map[string]usage      // Recent usage of each user, by user ID.
+}
+
+// newRegistry returns a new, empty registry, which places queued jobs according to PriorityPolicy.
+// For the supplied grace period, a request to acquire a specific node that has not registered
+// waits for it to do so, rather than treating it as lost. This gives agents time to re-register
+// after the scheduler restarts.
+func newRegistry(grace time.Duration) *registry {
+	return &registry{
+		now:         time.Now,
+		graceEnd:    time.Now().Add(grace),
+		defaultCPUs: DefaultNodeCPUs,
+		policy:      PriorityPolicy(),
+		nodes:       make(map[string]*nodeState),
+		users:       make(map[string]core.Usage),
+		projects:    make(map[string]core.Usage),
+		usage:       make(map[string]usage),
+	}
+}
+
+// heartbeat records that node n is alive at time t. If n is not known to the registry, it is
+// registered. If n does not report its number of CPUs, the default number is assumed.
+func (r *registry) heartbeat(n Node, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n.CPUs <= 0 {
+		n.CPUs = r.defaultCPUs
+	}
+
+	if ns, ok := r.nodes[n.ID]; ok {
+		ns.Node = n
+		ns.lastSeen = t
+
+		// The capacity of the node may have changed.
+		r.schedule(r.now())
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"nodeID": n.ID,
+		"arch":   n.Arch,
+		"cpus":   n.CPUs,
+		"memory": n.Memory,
+		"labels": n.Labels,
+	}).Print("node registered")
+
+	r.nodes[n.ID] = &nodeState{
+		NodeState: NodeState{Node: n},
+		lastSeen:  t,
+		gone:      make(chan struct{}),
+	}
+	r.schedule(r.now())
+}
+
+// reap removes nodes that have not sent a heartbeat since the heartbeat timeout prior to t.
+func (r *registry) reap(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, ns := range r.nodes {
+		if t.Sub(ns.lastSeen) > nodeHeartbeatTimeout {
+			logrus.WithFields(logrus.Fields{
+				"nodeID":   id,
+				"lastSeen": ns.lastSeen,
+			}).Warn("node lost")
+
+			delete(r.nodes, id)
+			close(ns.gone)
+		}
+	}
+
+	// Jobs queued for lost nodes may now fail.
+	r.schedule(r.now())
+}
+
+// addUsage adds n jobs like q to the usage of the owner with the supplied id in m.
+func addUsage(m map[string]core.Usage, id string, q QueuedJob, n int) {
+	u := m[id]
+	u.RunningJobs += n
+	u.CPUs += n * q.weight()
+	if u.RunningJobs <= 0 {
+		delete(m, id)
+		return
+	}
+	m[id] = u
+}
+
+// allocate records the placement of queued job q on node ns at time t. The caller must hold r.mu.
+func (r *registry) allocate(ns *nodeState, q QueuedJob, t time.Time) *allocation {
+	ns.allocate(q.Resources)
+	addUsage(r.users, q.UserID, q, 1)
+	if q.ProjectID != "" {
+		addUsage(r.projects, q.ProjectID, q, 1)
+	}
+	return &allocation{ns, q, t}
+}
+
+// checkLimits returns an error if placing job q would exceed the limits of the user or project it
+// belongs to, given the usage u of the user, and p of the project.
+func (r *registry) checkLimits(q QueuedJob, u, p core.Usage) error {
+	err := r.userLimits.CheckUser(core.Usage{
+		RunningJobs: u.RunningJobs + 1,
+		CPUs:        u.CPUs + q.weight(),
+	})
+	if err != nil || q.ProjectID == "" {
+		return err
+	}
+	return r.projectLimits.CheckProject(core.Usage{
+		RunningJobs: p.RunningJobs + 1,
+		CPUs:        p.CPUs + q.weight(),
+	})
+}
+
+// withinLimits returns true if placing job q would not exceed the limits of the user or project it
+// belongs to. The caller must hold r.mu.
+func (r *registry) withinLimits(q QueuedJob) bool {
+	return r.checkLimits(q, r.users[q.UserID], r.projects[q.ProjectID]) == nil
+}
+
+// couldFit returns true if job q could be placed on a registered node once no other jobs are
+// placed on it, or if no node it could be placed on has registered. The caller must hold r.mu.
+func (r *registry) couldFit(q QueuedJob) bool {
+	if q.NodeID != "" {
+		ns, ok := r.nodes[q.NodeID]
+		return !ok || NodeState{Node: ns.Node}.Accepts(q)
+	}
+	if len(r.nodes) == 0 {
+		return true
+	}
+	for _, ns := range r.nodes {
+		if (NodeState{Node: ns.Node}).Accepts(q) {
+			return true
+		}
+	}
+	return false
+}
+
+// schedule places queued jobs on nodes as of time t, as assigned by the policy. Invalid assignments
+// are logged and ignored. Once the registry's grace period has elapsed, a job queued for a
+// specific node that does not refer to a live node fails with errNodeLost, and a job that requires
+// labels or resources no registered node has fails with errNoNodeFits. A job that would exceed
+// the limits of its user or project by itself fails, while other jobs are withheld from the policy
+// until the limits permit. The caller must hold r.mu.
+func (r *registry) schedule(t time.Time) {
+	bySeq := make(map[uint64]*waiter)
+	for _, w := range r.queue {
+		if !t.Before(r.graceEnd) {
+			if _, ok := r.nodes[w.NodeID]; w.NodeID != "" && !ok {
+				w.finish(nil, errNodeLost)
+				continue
+			}
+			if !r.couldFit(w.QueuedJob) {
+				w.finish(nil, errNoNodeFits)
+				continue
+			}
+		}
+		if err := r.checkLimits(w.QueuedJob, core.Usage{}, core.Usage{}); err != nil {
+			w.finish(nil, err)
+			continue
+		}
+		bySeq[w.Seq] = w
+	}
+
+	// The policy is unaware of limits, so may assign several jobs of a user or project that
+	// together exceed them. If so, those that do not fit are withheld, and the policy is consulted
+	// again, as capacity it set aside for them may be used by other jobs.
+	for rejected := true; rejected; {
+		rejected = false
+
+		s := r.state(t)
+		queue := s.Queue[:0]
+		for _, q := range s.Queue {
+			if r.withinLimits(q) {
+				queue = append(queue, q)
+			}
+		}
+		s.Queue = queue
+
+		for _, a := range r.policy.Assign(s) {
+			w, ok := bySeq[a.Seq]
+			ns := r.nodes[a.NodeID]
+			if !ok || w.finished || ns == nil || (w.NodeID != "" && w.NodeID != a.NodeID) || !ns.Accepts(w.QueuedJob) {
+				logrus.WithFields(logrus.Fields{
+					"seq":    a.Seq,
+					"nodeID": a.NodeID,
+				}).Warn("ignoring invalid assignment from scheduling policy")
+				continue
+			}
+			if !r.withinLimits(w.QueuedJob) {
+				rejected = true
+				continue
+			}
+			w.finish(r.allocate(ns, w.QueuedJob, t), nil)
+		}
+	}
+
+	// Remove completed requests from the queue.
+	queue := r.queue[:0]
+	for _, w := range r.queue {
+		if !w.finished {
+			queue = append(queue, w)
+		}
+	}
+	for i := len(queue); i < len(r.queue); i++ {
+		r.queue[i] = nil
+	}
+	r.queue = queue
+}
+
+// enqueue queues job q, and attempts to place it immediately. The sequence number of q is
+// assigned by the registry.
+func (r *registry) enqueue(q QueuedJob) *waiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	q.Seq = r.seq
+	w := &waiter{
+		QueuedJob: q,
+		done:      make(chan struct{}),
+	}
+	r.queue = append(r.queue, w)
+	r.schedule(r.now())
+	return w
+}
+
+// acquire queues job q until it is placed by the policy on a node that accepts it, or until ctx is
+// done. Once the registry's grace period has elapsed, errNodeLost is returned if q
+// requires a specific node that does not refer to a live node, and errNoNodeFits is returned if q
+// requires labels or resources no registered node has.
+func (r *registry) acquire(ctx context.Context, q QueuedJob) (*allocation, error) {
+	w := r.enqueue(q)
+
+	// The job may fail once the grace period elapses.
+	var grace <-chan time.Time
+	if d := r.graceEnd.Sub(r.now()); d > 0 {
+		grace = time.After(d)
+	}
+
+	for {
+		select {
+		case <-w.done:
+			return w.a, w.err
+		case <-grace:
+			grace = nil
+
+			r.mu.Lock()
+			r.schedule(r.now())
+			r.mu.Unlock()
+		case <-ctx.Done():
+			r.mu.Lock()
+			if w.finished {
+				// Placed concurrently, so undo the placement.
+				if w.a != nil {
+					r.free(w.a, r.now())
+				}
+			} else {
+				w.finish(nil, ctx.Err())
+				r.schedule(r.now())
+			}
+			r.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// free records that the job placed according to allocation a completed at time t, and charges
+// the usage to the user the job belongs to. The caller must hold r.mu.
+func (r *registry) free(a *allocation, t time.Time) {
+	a.nodeState.free(a.q.Resources)
+
+	u := a.q.UserID
+	addUsage(r.users, u, a.q, -1)
+	if a.q.ProjectID != "" {
+		addUsage(r.projects, a.q.ProjectID, a.q, -1)
+	}
+	r.usage[u] = r.usage[u].add(float64(a.q.weight())*t.Sub(a.placedAt).Seconds(), t)
+
+	r.schedule(t)
+}
+
+// release records that the job placed according to allocation a has completed.
+func (r *registry) release(a *allocation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.free(a, r.now())
+}
+
+// nodeHeartbeatHandler handles registration and heartbeat messages from agents.
+func (s *Scheduler) nodeHeartbeatHandler(n Node) {
+	if n.ID == "" {
+		logrus.Warn("ignoring heartbeat with empty node ID")
+		return
+	}
+	s.reg.heartbeat(n, time.Now())
+}
+
+// reapNodes periodically removes dead nodes from the registry, until stop is closed.
+func (s *Scheduler) reapNodes(stop <-chan struct{}) {
+	t := time.NewTicker(nodeReapInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case now := <-t.C:
+			s.reg.reap(now)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+func TestRegistryReap(t *testing.T) {
+	r := newRegistry(0)
+
+	now := time.Now()
+	r.heartbeat(Node{ID: "one"}, now)
+	r.heartbeat(Node{ID: "two"}, now.Add(-2*nodeHeartbeatTimeout))
+
+	n, err := r.acquire(context.Background(), QueuedJob{NodeID: "two"})
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+
+	r.reap(now)
+
+	// Node two should be gone.
+	select {
+	case <-n.gone:
+	default:
+		t.Error("lost node not signalled")
+	}
+	if _, err := r.acquire(context.Background(), QueuedJob{NodeID: "two"}); !errors.Is(err, errNodeLost) {
+		t.Errorf("got err %v, want %v", err, errNodeLost)
+	}
+
+	// Node one should remain.
+	if _, err := r.acquire(context.Background(), QueuedJob{NodeID: "one"}); err != nil {
+		t.Errorf("failed to acquire: %v", err)
+	}
+}
+
+func TestRegistryAcquire(t *testing.T) {
+	r := newRegistry(0)
+
+	now := time.Now()
+	r.heartbeat(Node{ID: "one"}, now)
+	r.heartbeat(Node{ID: "two"}, now)
+
+	// Jobs should be spread across nodes.
+	a, err := r.acquire(context.Background(), QueuedJob{})
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	b, err := r.acquire(context.Background(), QueuedJob{})
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	if a.ID == b.ID {
+		t.Errorf("both jobs placed on node %v", a.ID)
+	}
+
+	// Once released, the node should be preferred again.
+	r.release(a)
+	c, err := r.acquire(context.Background(), QueuedJob{})
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	if got, want := c.ID, a.ID; got != want {
+		t.Errorf("got node %v, want %v", got, want)
+	}
+}
+
+func TestRegistryAcquireWait(t *testing.T) {
+	r := newRegistry(0)
+
+	// With no nodes registered, acquire should wait until ctx is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.acquire(ctx, QueuedJob{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got err %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Acquire should succeed once a node registers.
+	go r.heartbeat(Node{ID: "one"}, time.Now())
+
+	n, err := r.acquire(context.Background(), QueuedJob{})
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	if got, want := n.ID, "one"; got != want {
+		t.Errorf("got node %v, want %v", got, want)
+	}
+}
+
+func TestRegistryAcquireGrace(t *testing.T) {
+	r := newRegistry(time.Hour)
+
+	// Within the grace period, acquiring an unknown node should wait for it to register.
+	go r.heartbeat(Node{ID: "one"}, time.Now())
+
+	n, err := r.acquire(context.Background(), QueuedJob{NodeID: "one"})
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	if got, want := n.ID, "one"; got != want {
+		t.Errorf("got node %v, want %v", got, want)
+	}
+
+	// Once the grace period has elapsed, an unknown node should be considered lost.
+	r = newRegistry(10 * time.Millisecond)
+	if _, err := r.acquire(context.Background(), QueuedJob{NodeID: "two"}); !errors.Is(err, errNodeLost) {
+		t.Errorf("got err %v, want %v", err, errNodeLost)
+	}
+}
+
+func TestRegistryAcquireCapacity(t *testing.T) {
+	r := newRegistry(0)
+
+	now := time.Now()
+	r.heartbeat(Node{ID: "small", CPUs: 2, Memory: 1 << 30}, now)
+	r.heartbeat(Node{ID: "large", CPUs: 8, Memory: 4 << 30}, now)
+
+	// A job that only fits on the large node should be placed there.
+	big := core.Resources{CPUs: 4, Memory: 2 << 30}
+	a, err := r.acquire(context.Background(), QueuedJob{Resources: big})
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	if got, want := a.ID, "large"; got != want {
+		t.Errorf("got node %v, want %v", got, want)
+	}
+
+	// A job that fits on neither node, given the capacity already allocated, should wait.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.acquire(ctx, QueuedJob{Resources: core.Resources{CPUs: 6}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got err %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The same applies when a specific node is requested.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.acquire(ctx, QueuedJob{NodeID: "large", Resources: core.Resources{Memory: 3 << 30}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got err %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Once capacity is released, the waiting job should be placed.
+	go r.release(a)
+
+	b, err := r.acquire(context.Background(), QueuedJob{Resources: core.Resources{CPUs: 6}})
+	if err != nil {
+		t.Fatalf("failed to acquire: %v", err)
+	}
+	if got, want := b.ID, "large"; got != want {
+		t.Errorf("got node %v, want %v", got, want)
+	}
+
+	// Jobs that do not require resources may be placed on any node.
+	if _, err := r.acquire(context.Background(), QueuedJob{NodeID: "large"}); err != nil {
+		t.Errorf("failed to acquire: %v", err)
+	}
+}
+
+func TestRegistryAcquireLabels(t *testing.T) {
+	r := newRegistry(0)
+
+	now := time.Now()
+	r.heartbeat(Node{ID: "cpu", CPUs: 8, Labels: map[string]string{"zone": "a"}}, now)
+	r.heartbeat(Node{ID: "gpu", CPUs: 8, Labels: map[string]string{"zone": "a", "gpu": "v100"}}, now)
+
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		wantNodeID string
+		wantErr    error
+	}{
+		{"Shared", map[string]string{"zone": "a"}, "", nil},
+		{"Subset", map[string]string{"gpu": "v100"}, "gpu", nil},
+		{"All", map[string]string{"zone": "a", "gpu": "v100"}, "gpu", nil},
+		{"OtherValue", map[string]string{"gpu": "a100"}, "", errNoNodeFits},
+		{"Missing", map[string]string{"fpga": "yes"}, "", errNoNodeFits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := r.acquire(context.Background(), QueuedJob{NodeLabels: tt.labels})
+			if got, want := err, tt.wantErr; !errors.Is(got, want) {
+				t.Fatalf("got err %v, want %v", got, want)
+			}
+			if err != nil {
+				return
+			}
+			defer r.release(a)
+
+			if tt.wantNodeID != "" && a.ID != tt.wantNodeID {
+				t.Errorf("got node %v, want %v", a.ID, tt.wantNodeID)
+			}
+		})
+	}
+
+	// A job that requires a specific node should fail if that node does not have the labels.
+	if _, err := r.acquire(context.Background(), QueuedJob{NodeID: "cpu", NodeLabels: map[string]string{"gpu": "v100"}}); !errors.Is(err, errNoNodeFits) {
+		t.Errorf("got err %v, want %v", err, errNoNodeFits)
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// QueuedJob describes a job waiting to be placed on a node.
+type QueuedJob struct {
+	Seq        uint64            // Unique, and increasing in the order jobs were queued.
+	JobID      string            // ID of the job, if any.
+	UserID     string            // ID of the user the job belongs to.
+	ProjectID  string            // ID of the project the job belongs to, if any.
+	Priority   int               // Priority of the job, relative to other queued jobs.
+	NodeID     string            // If not empty, the job must be placed on the node with this ID.
+	Resources  core.Resources    // Resources required by the job.
+	NodeLabels map[string]string // Labels the node the job is placed on must have.
+}
+
+// requiredCPUs returns the number of CPUs allocated to a job that requires res. A job that does
+// not require CPUs is allocated one, so that it consumes capacity.
+func requiredCPUs(res core.Resources) int {
+	if res.CPUs > 1 {
+		return res.CPUs
+	}
+	return 1
+}
+
+// weight returns the share of capacity consumed by job q, for the purposes of fair-share.
+func (q QueuedJob) weight() int {
+	return requiredCPUs(q.Resources)
+}
+
+// NodeState describes a live node, and the capacity allocated to jobs placed on it.
+type NodeState struct {
+	Node
+	Running         int   // Number of jobs placed on the node.
+	AllocatedCPUs   int   // Number of CPUs allocated to jobs placed on the node.
+	AllocatedMemory int64 // Amount of memory allocated to jobs placed on the node.
+}
+
+// Fits returns true if node n has enough free capacity for a job that requires res. A job that
+// does not require CPUs requires one.
+func (n NodeState) Fits(res core.Resources) bool {
+	if n.CPUs > 0 && n.AllocatedCPUs+requiredCPUs(res) > n.CPUs {
+		return false
+	}
+	if res.Memory > 0 && n.Memory > 0 && n.AllocatedMemory+res.Memory > n.Memory {
+		return false
+	}
+	return true
+}
+
+// Accepts returns true if job q may be placed on node n now, as n has the labels q requires, and
+// enough free capacity.
+func (n NodeState) Accepts(q QueuedJob) bool {
+	return n.HasLabels(q.NodeLabels) && n.Fits(q.Resources)
+}
+
+// FreeCPUs returns the number of CPUs of node n not allocated to jobs. If the number of CPUs of the
+// node is not known, math.MaxInt32 is returned.
+func (n NodeState) FreeCPUs() int {
+	if n.CPUs <= 0 {
+		return math.MaxInt32
+	}
+	return n.CPUs - n.AllocatedCPUs
+}
+
+// allocate records that a job that requires res has been placed on node n.
+func (n *NodeState) allocate(res core.Resources) {
+	n.Running++
+	n.AllocatedCPUs += requiredCPUs(res)
+	n.AllocatedMemory += res.Memory
+}
+
+// free records that a job that required res, placed on node n, has completed.
+func (n *NodeState) free(res core.Resources) {
+	n.Running--
+	n.AllocatedCPUs -= requiredCPUs(res)
+	n.AllocatedMemory -= res.Memory
+}
+
+// UserState describes the usage of a user, for the purposes of fair-share.
+type UserState struct {
+	Allocated int     // Capacity allocated to running jobs of the user, in CPUs (at least one per job).
+	Usage     float64 // Recent usage of the user, in CPU seconds, decaying over time.
+}
+
+// State is the state of the scheduler presented to a policy.
+type State struct {
+	Queue []QueuedJob          // Jobs waiting to be placed, in the order they were queued.
+	Nodes []NodeState          // Live nodes, ordered by ID.
+	Users map[string]UserState // Usage of users with queued or running jobs, by user ID.
+}
+
+// Assignment describes the placement of a queued job on a node.
+type Assignment struct {
+	Seq    uint64 // Sequence number of the queued job.
+	NodeID string // ID of the node to place the job on.
+}
+
+// Policy decides the order in which queued jobs are placed on nodes, and the nodes they are placed
+// on.
+type Policy interface {
+	// Order returns the jobs queued in s, in the order in which they are considered for placement.
+	Order(s State) []QueuedJob
+
+	// Assign returns the jobs queued in s to place on nodes now, and the nodes to place them on.
+	// Assignments are applied in order. An assignment that places a job on a node without the
+	// labels it requires or enough free capacity, or on a node other than the one the job requires,
+	// is ignored.
+	Assign(s State) []Assignment
+}
+
+// orderPolicy is a Policy that considers jobs in the order returned by order, placing each on the
+// node returned by choose.
+type orderPolicy struct {
+	order func(State) []QueuedJob
+
+	// choose returns the best of candidate nodes to place job q on, all of which accept it.
+	choose func(q QueuedJob, candidates []*NodeState) *NodeState
+
+	// If strict is set, once a job cannot be placed, no further jobs are placed. Otherwise, jobs
+	// later in the order may be placed ahead of it.
+	strict bool
+}
+
+// Order returns the jobs queued in s, in the order in which they are considered for placement.
+func (p orderPolicy) Order(s State) []QueuedJob {
+	return p.order(s)
+}
+
+// Assign returns the jobs queued in s to place on nodes now, and the nodes to place them on.
+func (p orderPolicy) Assign(s State) []Assignment {
+	nodes := make(map[string]*NodeState)
+	all := make([]*NodeState, len(s.Nodes))
+	for i := range s.Nodes {
+		n := s.Nodes[i]
+		nodes[n.ID] = &n
+		all[i] = &n
+	}
+
+	var as []Assignment
+	for _, q := range p.order(s) {
+		var candidates []*NodeState
+		if q.NodeID != "" {
+			n, ok := nodes[q.NodeID]
+			if !ok {
+				// The node has not registered, so the job cannot yet be considered.
+				continue
+			}
+			if n.Accepts(q) {
+				candidates = append(candidates, n)
+			}
+		} else {
+			for _, n := range all {
+				if n.Accepts(q) {
+					candidates = append(candidates, n)
+				}
+			}
+		}
+
+		if len(candidates) == 0 {
+			if p.strict {
+				break
+			}
+			continue
+		}
+
+		n := p.choose(q, candidates)
+		n.allocate(q.Resources)
+		as = append(as, Assignment{Seq: q.Seq, NodeID: n.ID})
+	}
+	return as
+}
+
+// fifoOrder returns the jobs queued in s, in the order they were queued.
+func fifoOrder(s State) []QueuedJob {
+	return append([]QueuedJob(nil), s.Queue...)
+}
+
+// jobBefore returns true if job a should be placed before job b, among the jobs of a single user.
+// Jobs with higher priority are placed first, followed by jobs that were queued earlier.
+func jobBefore(a, b QueuedJob) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.Seq < b.Seq
+}
+
+// allocatedCapacity returns the capacity allocated to each of users, by user ID.
+func allocatedCapacity(users map[string]UserState) map[string]int {
+	allocated := make(map[string]int)
+	for u, us := range users {
+		allocated[u] = us.Allocated
+	}
+	return allocated
+}
+
+// fairShare returns queue in fair-share order, given the usage of users and the capacity allocated
+// to them. Between users, the user with the least capacity allocated is favoured, followed by the
+// user with the least recent usage, assuming each job is placed in turn. The jobs of each user are
+// ordered by priority, and then by the order in which they were queued. The capacity of each job is
+// added to allocated as it is ordered.
+func fairShare(queue []QueuedJob, users map[string]UserState, allocated map[string]int) []QueuedJob {
+	// Build up the queue of each user, in order.
+	queues := make(map[string][]QueuedJob)
+	for _, q := range queue {
+		queues[q.UserID] = append(queues[q.UserID], q)
+	}
+	for _, q := range queues {
+		q := q
+		sort.Slice(q, func(i, j int) bool { return jobBefore(q[i], q[j]) })
+	}
+
+	// userBefore returns true if the next job of user a should be placed before that of user b.
+	userBefore := func(a, b string) bool {
+		if allocated[a] != allocated[b] {
+			return allocated[a] < allocated[b]
+		}
+		if ua, ub := users[a].Usage, users[b].Usage; ua != ub {
+			return ua < ub
+		}
+		return jobBefore(queues[a][0], queues[b][0])
+	}
+
+	// Repeatedly take the next job of the user with the smallest share.
+	qs := make([]QueuedJob, 0, len(queue))
+	for len(queues) > 0 {
+		var next string
+		for u := range queues {
+			if next == "" || userBefore(u, next) {
+				next = u
+			}
+		}
+
+		q := queues[next][0]
+		qs = append(qs, q)
+		allocated[next] += q.weight()
+
+		if queues[next] = queues[next][1:]; len(queues[next]) == 0 {
+			delete(queues, next)
+		}
+	}
+	return qs
+}
+
+// priorityOrder returns the jobs queued in s in priority order, regardless of the user they belong
+// to. Jobs of equal priority are ordered by fair-share, assuming jobs of higher priority are placed
+// first.
+func priorityOrder(s State) []QueuedJob {
+	levels := make(map[int][]QueuedJob)
+	var priorities []int
+	for _, q := range s.Queue {
+		if _, ok := levels[q.Priority]; !ok {
+			priorities = append(priorities, q.Priority)
+		}
+		levels[q.Priority] = append(levels[q.Priority], q)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	allocated := allocatedCapacity(s.Users)
+	qs := make([]QueuedJob, 0, len(s.Queue))
+	for _, p := range priorities {
+		qs = append(qs, fairShare(levels[p], s.Users, allocated)...)
+	}
+	return qs
+}
+
+// leastLoaded returns the candidate node with the fewest jobs placed on it.
+func leastLoaded(q QueuedJob, candidates []*NodeState) *NodeState {
+	best := candidates[0]
+	for _, n := range candidates[1:] {
+		if n.Running < best.Running {
+			best = n
+		}
+	}
+	return best
+}
+
+// mostAllocated returns the candidate node with the fewest free CPUs, so that jobs are packed onto
+// as few nodes as possible, leaving other nodes free for larger jobs.
+func mostAllocated(q QueuedJob, candidates []*NodeState) *NodeState {
+	best := candidates[0]
+	for _, n := range candidates[1:] {
+		if n.FreeCPUs() < best.FreeCPUs() || (n.FreeCPUs() == best.FreeCPUs() && n.Running > best.Running) {
+			best = n
+		}
+	}
+	return best
+}
+
+// leastAllocated returns the candidate node with the most free CPUs, so that jobs are spread
+// across nodes, minimising contention.
+func leastAllocated(q QueuedJob, candidates []*NodeState) *NodeState {
+	best := candidates[0]
+	for _, n := range candidates[1:] {
+		if n.FreeCPUs() > best.FreeCPUs() || (n.FreeCPUs() == best.FreeCPUs() && n.Running < best.Running) {
+			best = n
+		}
+	}
+	return best
+}
+
+// FIFOPolicy returns a policy that places jobs strictly in the order they were queued. Once a job
+// cannot be placed, no further jobs are placed until it is. Each job is placed on the node with the
+// fewest jobs placed on it.
+func FIFOPolicy() Policy {
+	return orderPolicy{order: fifoOrder, choose: leastLoaded, strict: true}
+}
+
+// PriorityPolicy returns a policy that places jobs in priority order, regardless of the user they
+// belong to. Jobs of equal priority are placed in fair-share order, in which users with the least
+// capacity allocated and recent usage are favoured. Jobs that cannot be placed do not prevent jobs
+// later in the order from being placed. Each job is placed on the node with the fewest jobs placed
+// on it.
+func PriorityPolicy() Policy {
+	return orderPolicy{order: priorityOrder, choose: leastLoaded}
+}
+
+// BinPackPolicy returns a policy that places jobs in the same order as PriorityPolicy, placing each
+// job on the node with the fewest free CPUs.
+func BinPackPolicy() Policy {
+	return orderPolicy{order: priorityOrder, choose: mostAllocated}
+}
+
+// SpreadPolicy returns a policy that places jobs in the same order as PriorityPolicy, placing each
+// job on the node with the most free CPUs.
+func SpreadPolicy() Policy {
+	return orderPolicy{order: priorityOrder, choose: leastAllocated}
+}
+
+// policies are the built-in policies, by name.
+var policies = map[string]func() Policy{
+	"fifo":     FIFOPolicy,
+	"priority": PriorityPolicy,
+	"binpack":  BinPackPolicy,
+	"spread":   SpreadPolicy,
+}
+
+// PolicyNames returns the names of the built-in policies, in sorted order.
+func PolicyNames() []string {
+	var names []string
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PolicyByName returns the built-in policy with the supplied name.
+func PolicyByName(name string) (Policy, error) {
+	p, ok := policies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scheduling policy %q (valid policies: %v)", name, strings.Join(PolicyNames(), ", "))
+	}
+	return p(), nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+func TestPolicyAssign(t *testing.T) {
+	// Node "big" has two of four CPUs free, node "small" has one of two CPUs free.
+	nodes := []NodeState{
+		{Node: Node{ID: "big", CPUs: 4}, Running: 1, AllocatedCPUs: 2},
+		{Node: Node{ID: "small", CPUs: 2}, Running: 1, AllocatedCPUs: 1},
+	}
+
+	tests := []struct {
+		name  string
+		p     Policy
+		queue []QueuedJob
+		want  []Assignment
+	}{
+		{"FIFOBlocked", FIFOPolicy(), []QueuedJob{
+			{Seq: 1, UserID: "a", Resources: core.Resources{CPUs: 3}},
+			{Seq: 2, UserID: "a", Resources: core.Resources{CPUs: 1}},
+		}, nil},
+		{"PriorityBackfill", PriorityPolicy(), []QueuedJob{
+			{Seq: 1, UserID: "a", Resources: core.Resources{CPUs: 3}},
+			{Seq: 2, UserID: "a", Resources: core.Resources{CPUs: 1}},
+		}, []Assignment{{Seq: 2, NodeID: "big"}}},
+		{"PriorityOrder", PriorityPolicy(), []QueuedJob{
+			{Seq: 1, UserID: "a", Resources: core.Resources{CPUs: 2}},
+			{Seq: 2, UserID: "b", Resources: core.Resources{CPUs: 2}, Priority: 1},
+		}, []Assignment{{Seq: 2, NodeID: "big"}}},
+		{"BinPack", BinPackPolicy(), []QueuedJob{
+			{Seq: 1, UserID: "a", Resources: core.Resources{CPUs: 1}},
+		}, []Assignment{{Seq: 1, NodeID: "small"}}},
+		{"Spread", SpreadPolicy(), []QueuedJob{
+			{Seq: 1, UserID: "a", Resources: core.Resources{CPUs: 1}},
+		}, []Assignment{{Seq: 1, NodeID: "big"}}},
+		{"BinPackFull", BinPackPolicy(), []QueuedJob{
+			{Seq: 1, UserID: "a", Resources: core.Resources{CPUs: 1}},
+			{Seq: 2, UserID: "a", Resources: core.Resources{CPUs: 1}},
+		}, []Assignment{{Seq: 1, NodeID: "small"}, {Seq: 2, NodeID: "big"}}},
+		{"Pinned", SpreadPolicy(), []QueuedJob{
+			{Seq: 1, UserID: "a", NodeID: "small", Resources: core.Resources{CPUs: 1}},
+		}, []Assignment{{Seq: 1, NodeID: "small"}}},
+		{"PinnedMissing", PriorityPolicy(), []QueuedJob{
+			{Seq: 1, UserID: "a", NodeID: "gone"},
+			{Seq: 2, UserID: "a"},
+		}, []Assignment{{Seq: 2, NodeID: "big"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := State{
+				Queue: tt.queue,
+				Nodes: append([]NodeState(nil), nodes...),
+				Users: map[string]UserState{"a": {}},
+			}
+
+			if got, want := tt.p.Assign(s), tt.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("got assignments %v, want %v", got, want)
+			}
+
+			// The state presented to the policy must not be modified.
+			if got, want := s.Nodes, nodes; !reflect.DeepEqual(got, want) {
+				t.Errorf("got nodes %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPolicyByName(t *testing.T) {
+	for _, name := range PolicyNames() {
+		if _, err := PolicyByName(name); err != nil {
+			t.Errorf("policy %v: unexpected error: %v", name, err)
+		}
+	}
+
+	if _, err := PolicyByName("bad"); err == nil {
+		t.Error("unexpected success")
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// fairShareHalfLife is the time over which the recorded usage of a user decays by half.
+const fairShareHalfLife = time.Hour
+
+// allocation records the placement of a job on a node.
+type allocation struct {
+	*nodeState
+	q        QueuedJob
+	placedAt time.Time
+}
+
+// waiter tracks a job that is queued waiting for a node.
+type waiter struct {
+	QueuedJob
+
+	finished bool          // Set once the job is placed or the request fails.
+	done     chan struct{} // Closed once the job is placed or the request fails.
+	a        *allocation   // The placement, if placed.
+	err      error         // The reason the request failed, if failed.
+}
+
+// finish completes queued request w, with placement a or error err.
+func (w *waiter) finish(a *allocation, err error) {
+	w.finished = true
+	w.a = a
+	w.err = err
+	close(w.done)
+}
+
+// usage records the recent usage of a user, in CPU seconds, decaying exponentially over time.
+type usage struct {
+	v float64   // Usage as of t.
+	t time.Time // Time the usage was last updated.
+}
+
+// at returns the usage as of t.
+func (u usage) at(t time.Time) float64 {
+	return u.v * math.Exp2(-t.Sub(u.t).Seconds()/fairShareHalfLife.Seconds())
+}
+
+// add returns the usage as of t, with v added.
+func (u usage) add(v float64, t time.Time) usage {
+	return usage{u.at(t) + v, t}
+}
+
+// state returns the state of the registry as of time t, to present to a policy. The caller must
+// hold r.mu.
+func (r *registry) state(t time.Time) State {
+	s := State{
+		Users: make(map[string]UserState),
+	}
+
+	for _, w := range r.queue {
+		if !w.finished {
+			s.Queue = append(s.Queue, w.QueuedJob)
+			s.Users[w.UserID] = UserState{}
+		}
+	}
+
+	for _, ns := range r.nodes {
+		s.Nodes = append(s.Nodes, ns.NodeState)
+	}
+	sort.Slice(s.Nodes, func(i, j int) bool { return s.Nodes[i].ID < s.Nodes[j].ID })
+
+	for u := range r.users {
+		s.Users[u] = UserState{}
+	}
+	for u := range s.Users {
+		s.Users[u] = UserState{
+			Allocated: r.users[u].CPUs,
+			Usage:     r.usage[u].at(t),
+		}
+	}
+	return s
+}
+
+// position returns the position, starting from one, of the job with the supplied ID in the queue
+// of jobs awaiting placement, as ordered by the policy. If the job is not queued, ok is false.
+func (r *registry) position(jobID string) (pos int, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, q := range r.policy.Order(r.state(r.now())) {
+		if q.JobID == jobID {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// userUsage returns the number of jobs of the user with the supplied ID placed on nodes, and the
+// number of CPUs allocated to them.
+func (r *registry) userUsage(id string) core.Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.users[id]
+}
+
+// projectUsage returns the number of jobs of the project with the supplied ID placed on nodes, and
+// the number of CPUs allocated to them.
+func (r *registry) projectUsage(id string) core.Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.projects[id]
+}
+
+// UserUsage returns the number of jobs of the user with the supplied ID placed on nodes, and the
+// number of CPUs allocated to them. Jobs are only placed by the leader, so if this replica is not
+// the leader, the usage is requested from the leader.
+func (s *Scheduler) UserUsage(ctx context.Context, id string) (core.Usage, error) {
+	if s.isLeader() {
+		return s.reg.userUsage(id), nil
+	}
+
+	var u core.Usage
+	if err := s.requestLeader(userUsageSubject, leaderQuery{ID: id}, &u); err != nil {
+		return core.Usage{}, fmt.Errorf("failed to get user usage: %w", err)
+	}
+	return u, nil
+}
+
+// ProjectUsage returns the number of jobs of the project with the supplied ID placed on nodes, and
+// the number of CPUs allocated to them. Jobs are only placed by the leader, so if this replica is
+// not the leader, the usage is requested from the leader.
+func (s *Scheduler) ProjectUsage(ctx context.Context, id string) (core.Usage, error) {
+	if s.isLeader() {
+		return s.reg.projectUsage(id), nil
+	}
+
+	var u core.Usage
+	if err := s.requestLeader(projectUsageSubject, leaderQuery{ID: id}, &u); err != nil {
+		return core.Usage{}, fmt.Errorf("failed to get project usage: %w", err)
+	}
+	return u, nil
+}
+
+// queuePosition describes the position of a job in the queue of jobs waiting to be placed.
+type queuePosition struct {
+	Pos int  // Position, starting from one.
+	OK  bool // Set if the job is waiting.
+}
+
+// JobQueuePosition returns the position, starting from one, of the job with the supplied ID in the
+// queue of jobs waiting to be placed on a node. If the job is not waiting, ok is false. Jobs are
+// only queued by the leader, so if this replica is not the leader, the position is requested from
+// the leader. Failure to get the position is logged, and ok is false.
+func (s *Scheduler) JobQueuePosition(ctx context.Context, id string) (pos int, ok bool) {
+	if s.isLeader() {
+		return s.reg.position(id)
+	}
+
+	var p queuePosition
+	if err := s.requestLeader(queuePositionSubject, leaderQuery{ID: id}, &p); err != nil {
+		logrus.WithError(err).WithField("jobID", id).Warn("failed to get job queue position")
+		return 0, false
+	}
+	return p.Pos, p.OK
+}
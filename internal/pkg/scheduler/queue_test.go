@@ -0,0 +1,267 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// placed returns true if waiter w has been placed.
+func placed(w *waiter) bool {
+	select {
+	case <-w.done:
+		return w.a != nil
+	default:
+		return false
+	}
+}
+
+func TestRegistryFairShare(t *testing.T) {
+	r := newRegistry(0)
+	r.heartbeat(Node{ID: "one", CPUs: 1}, time.Now())
+
+	// User A has some recent usage.
+	r.usage["a"] = usage{1, time.Now()}
+
+	res := core.Resources{CPUs: 1}
+
+	// The first job of user A occupies the node.
+	a1 := r.enqueue(QueuedJob{JobID: "a1", UserID: "a", Resources: res})
+	if !placed(a1) {
+		t.Fatal("job not placed")
+	}
+
+	// Further jobs must queue.
+	a2 := r.enqueue(QueuedJob{JobID: "a2", UserID: "a", Resources: res})
+	a3 := r.enqueue(QueuedJob{JobID: "a3", UserID: "a", Resources: res, Priority: 1})
+	b1 := r.enqueue(QueuedJob{JobID: "b1", UserID: "b", Resources: res, Priority: 1})
+
+	// Among the jobs of equal priority, user B has no capacity allocated, so should be placed next.
+	// Jobs of lower priority should be placed last.
+	for i, id := range []string{"b1", "a3", "a2"} {
+		pos, ok := r.position(id)
+		if !ok {
+			t.Fatalf("job %v not queued", id)
+		}
+		if got, want := pos, i+1; got != want {
+			t.Errorf("job %v: got position %v, want %v", id, got, want)
+		}
+	}
+	if _, ok := r.position("a1"); ok {
+		t.Error("placed job reported as queued")
+	}
+
+	// Once the node is released, neither user has capacity allocated, but user B has less recent
+	// usage, so the job of user B should be placed.
+	r.release(a1.a)
+	if !placed(b1) {
+		t.Fatal("job not placed")
+	}
+	if placed(a2) || placed(a3) {
+		t.Error("job placed without capacity")
+	}
+
+	// User A has more recent usage than user B, but user B has capacity allocated, so the jobs of
+	// user A should be placed next.
+	r.release(b1.a)
+	if !placed(a3) {
+		t.Fatal("job not placed")
+	}
+	if placed(a2) {
+		t.Error("job placed without capacity")
+	}
+}
+
+func TestRegistryFlood(t *testing.T) {
+	r := newRegistry(0)
+
+	// The node does not report its capacity, and jobs do not require resources.
+	r.heartbeat(Node{ID: "one"}, time.Now())
+
+	// User A floods the queue. Only as many jobs as the default number of CPUs should be placed.
+	var as []*waiter
+	for i := 0; i < 100; i++ {
+		as = append(as, r.enqueue(QueuedJob{UserID: "a"}))
+	}
+	n := 0
+	for _, w := range as {
+		if placed(w) {
+			n++
+		}
+	}
+	if got, want := n, DefaultNodeCPUs; got != want {
+		t.Fatalf("got %v jobs placed, want %v", got, want)
+	}
+
+	// User B has no capacity allocated, so should be placed once capacity is released, ahead of
+	// the queued jobs of user A.
+	b := r.enqueue(QueuedJob{UserID: "b"})
+	if placed(b) {
+		t.Fatal("job placed without capacity")
+	}
+	r.release(as[0].a)
+	if !placed(b) {
+		t.Fatal("job not placed")
+	}
+	if placed(as[DefaultNodeCPUs]) {
+		t.Error("job placed without capacity")
+	}
+}
+
+func TestRegistryNoNodeFits(t *testing.T) {
+	r := newRegistry(0)
+
+	// With no nodes registered, the job should queue.
+	big := r.enqueue(QueuedJob{JobID: "big", UserID: "a", Resources: core.Resources{CPUs: 16}})
+	if placed(big) {
+		t.Fatal("job placed without nodes")
+	}
+	if _, ok := r.position("big"); !ok {
+		t.Fatal("job not queued")
+	}
+
+	// Once a node registers that cannot fit the job, it should fail.
+	r.heartbeat(Node{ID: "one", CPUs: 8, Memory: 1 << 30}, time.Now())
+	<-big.done
+	if !errors.Is(big.err, errNoNodeFits) {
+		t.Errorf("got error %v, want %v", big.err, errNoNodeFits)
+	}
+
+	tests := []struct {
+		name    string
+		res     core.Resources
+		wantErr error
+	}{
+		{"CPUs", core.Resources{CPUs: 9}, errNoNodeFits},
+		{"Memory", core.Resources{Memory: 2 << 30}, errNoNodeFits},
+		{"Fits", core.Resources{CPUs: 8, Memory: 1 << 30}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := r.enqueue(QueuedJob{UserID: "a", Resources: tt.res})
+			<-w.done
+			if got, want := w.err, tt.wantErr; got != want {
+				t.Errorf("got error %v, want %v", got, want)
+			}
+			if w.a != nil {
+				r.release(w.a)
+			}
+		})
+	}
+}
+
+func TestRegistryOrderUsage(t *testing.T) {
+	r := newRegistry(0)
+
+	now := time.Now()
+	r.usage["a"] = usage{100, now}
+	r.usage["b"] = usage{10, now.Add(-fairShareHalfLife)}
+	r.usage["c"] = usage{60, now.Add(-fairShareHalfLife)}
+
+	// With no nodes registered, all jobs should queue.
+	r.enqueue(QueuedJob{JobID: "a", UserID: "a"})
+	r.enqueue(QueuedJob{JobID: "b", UserID: "b"})
+	r.enqueue(QueuedJob{JobID: "c", UserID: "c"})
+	r.enqueue(QueuedJob{JobID: "d", UserID: "d"})
+
+	// Users should be ordered by decayed usage.
+	r.mu.Lock()
+	qs := r.policy.Order(r.state(now))
+	r.mu.Unlock()
+
+	var got []string
+	for _, q := range qs {
+		got = append(got, q.JobID)
+	}
+	want := []string{"d", "b", "c", "a"}
+	if len(got) != len(want) {
+		t.Fatalf("got order %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got order %v, want %v", got, want)
+		}
+	}
+}
+
+func TestUsageDecay(t *testing.T) {
+	now := time.Now()
+	u := usage{}.add(100, now)
+
+	if got, want := u.at(now.Add(fairShareHalfLife)), 50.0; got != want {
+		t.Errorf("got usage %v, want %v", got, want)
+	}
+	if got, want := u.add(50, now.Add(fairShareHalfLife)).at(now.Add(2*fairShareHalfLife)), 50.0; got != want {
+		t.Errorf("got usage %v, want %v", got, want)
+	}
+}
+
+func TestRegistryLimits(t *testing.T) {
+	r := newRegistry(0)
+	r.userLimits = core.Limits{RunningJobs: 2, CPUs: 3}
+	r.projectLimits = core.Limits{RunningJobs: 1}
+
+	cpus := func(n int) core.Resources { return core.Resources{CPUs: n} }
+
+	// A job that exceeds the limits by itself should fail.
+	big := r.enqueue(QueuedJob{JobID: "big", UserID: "d", Resources: cpus(4)})
+	<-big.done
+	if !errors.Is(big.err, core.ErrQuotaExceeded) {
+		t.Errorf("got error %v, want %v", big.err, core.ErrQuotaExceeded)
+	}
+
+	// Queue jobs before a node registers, so that they are assigned together.
+	a1 := r.enqueue(QueuedJob{JobID: "a1", UserID: "a", Resources: cpus(2)})
+	a2 := r.enqueue(QueuedJob{JobID: "a2", UserID: "a", Resources: cpus(2)})
+	a3 := r.enqueue(QueuedJob{JobID: "a3", UserID: "a", Resources: cpus(1)})
+	a4 := r.enqueue(QueuedJob{JobID: "a4", UserID: "a", Resources: cpus(1)})
+	b1 := r.enqueue(QueuedJob{JobID: "b1", UserID: "b", ProjectID: "p"})
+	b2 := r.enqueue(QueuedJob{JobID: "b2", UserID: "b", ProjectID: "p"})
+	c1 := r.enqueue(QueuedJob{JobID: "c1", UserID: "c", ProjectID: "q"})
+	r.heartbeat(Node{ID: "one", CPUs: 8}, time.Now())
+
+	tests := []struct {
+		name       string
+		w          *waiter
+		wantPlaced bool
+	}{
+		{"FirstJob", a1, true},
+		{"UserCPUs", a2, false},
+		{"Backfill", a3, true},
+		{"UserRunningJobs", a4, false},
+		{"Project", b1, true},
+		{"ProjectRunningJobs", b2, false},
+		{"OtherProject", c1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := placed(tt.w), tt.wantPlaced; got != want {
+				t.Errorf("got placed %v, want %v", got, want)
+			}
+		})
+	}
+
+	if got, want := r.users["a"], (core.Usage{RunningJobs: 2, CPUs: 3}); got != want {
+		t.Errorf("got usage %+v, want %+v", got, want)
+	}
+	if _, ok := r.position("a2"); !ok {
+		t.Error("withheld job not queued")
+	}
+
+	// Once capacity is released, withheld jobs should be placed.
+	r.release(a1.a)
+	if !placed(a2) {
+		t.Error("job not placed")
+	}
+	if placed(a4) {
+		t.Error("job placed beyond limits")
+	}
+	r.release(b1.a)
+	if !placed(b2) {
+		t.Error("job not placed")
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+const (
+	// workflowLeaseTTL is how long a lease on a workflow lasts, unless renewed. Once the lease of a
+	// replica expires, such as when the replica fails, another replica resumes the workflow.
+	workflowLeaseTTL = 30 * time.Second
+
+	// workflowLeaseRenewInterval is how often leases held by a replica are renewed.
+	workflowLeaseRenewInterval = workflowLeaseTTL / 3
+)
+
+// newReplicaID returns a random ID that identifies a replica of the scheduler.
+func newReplicaID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// claimWorkflow claims a lease on the workflow with the supplied ID on behalf of this replica, or
+// renews the lease if already held, and returns when the lease expires. If the workflow is leased
+// by another replica, ok is false.
+func (s *Scheduler) claimWorkflow(ctx context.Context, id string) (expires time.Time, ok bool, err error) {
+	t := time.Now()
+	expires = t.Add(workflowLeaseTTL)
+	ok, err = s.p.ClaimWorkflow(ctx, id, s.id, t, expires)
+	return expires, ok, err
+}
+
+// releaseWorkflow releases the lease this replica holds on the workflow with the supplied ID.
+// Failure to release the lease is logged.
+func (s *Scheduler) releaseWorkflow(ctx context.Context, id string) {
+	if err := s.p.ReleaseWorkflow(ctx, id, s.id); err != nil {
+		logrus.WithError(err).WithField("workflowID", id).Warn("failed to release workflow")
+	}
+}
+
+// abandonWorkflow abandons the run of the workflow with the supplied ID, on which this replica no
+// longer holds a lease, and removes it from the running workflows. The outcome of the workflow is
+// left to be recorded by the replica that now holds the lease.
+func (s *Scheduler) abandonWorkflow(id string) {
+	s.mu.Lock()
+	r, ok := s.runs[id]
+	delete(s.runs, id)
+	s.mu.Unlock()
+
+	if ok {
+		r.abandon()
+	}
+}
+
+// renewLeases renews the leases this replica holds on the workflows it is running. If a lease is
+// taken by another replica, or cannot be renewed before it expires, the workflow is abandoned.
+func (s *Scheduler) renewLeases(ctx context.Context) {
+	s.mu.Lock()
+	runs := make(map[string]*workflowRun, len(s.runs))
+	for id, r := range s.runs {
+		runs[id] = r
+	}
+	s.mu.Unlock()
+
+	for id, r := range runs {
+		log := logrus.WithField("workflowID", id)
+
+		expires, ok, err := s.claimWorkflow(ctx, id)
+		switch {
+		case err != nil && r.leaseExpired(time.Now()):
+			log.WithError(err).Warn("failed to renew workflow lease before expiry, abandoning workflow")
+			s.abandonWorkflow(id)
+		case err != nil:
+			log.WithError(err).Warn("failed to renew workflow lease")
+		case !ok:
+			log.Warn("workflow lease lost, abandoning workflow")
+			s.abandonWorkflow(id)
+		default:
+			r.leaseRenewed(expires)
+		}
+	}
+}
+
+// maintainLeases periodically claims or renews the scheduler lease and the leases this replica
+// holds on workflows, and resumes workflows whose lease has expired, until stop is closed.
+func (s *Scheduler) maintainLeases(stop <-chan struct{}) {
+	renew := time.NewTicker(workflowLeaseRenewInterval)
+	defer renew.Stop()
+
+	resume := time.NewTicker(workflowLeaseTTL)
+	defer resume.Stop()
+
+	for {
+		select {
+		case <-renew.C:
+			ctx := context.Background()
+
+			_, elected, err := s.claimLeadership(ctx)
+			if err != nil {
+				logrus.WithError(err).Warn("failed to claim scheduler lease")
+			}
+			s.renewLeases(ctx)
+
+			// Once elected, resume the workflows of the previous leader without delay.
+			if elected {
+				if err := s.Resume(ctx); err != nil {
+					logrus.WithError(err).Warn("failed to resume workflows")
+				}
+			}
+		case <-resume.C:
+			if err := s.Resume(context.Background()); err != nil {
+				logrus.WithError(err).Warn("failed to resume workflows")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// agentJobStatus describes the state of a job, as reported by an agent.
+type agentJobStatus struct {
+	Running  bool // Job is running.
+	Finished bool // Job has exited.
+	RC       int  // Exit code, if the job has exited.
+}
+
+// resumeJob reconciles the state of job j, which was running on node n when the scheduler last
+// stopped, with the agent on that node, and then waits for it to complete. If the agent has no
+// record of the job, it is run again.
+func (s *Scheduler) resumeJob(ctx context.Context, n *nodeState, j core.Job) error {
+	log := logrus.WithFields(logrus.Fields{
+		"jobID":   j.ID,
+		"jobName": j.Name,
+		"nodeID":  n.ID,
+	})
+
+	// Register interest before querying the agent, so that completion is not missed.
+	jobFinished, done := s.d.expect(fmt.Sprintf("job.%v.finished", j.ID))
+	defer done()
+
+	var st agentJobStatus
+	if err := s.m.Request(n.subject("job.status"), j, &st, jobStatusAckTimeout); err != nil {
+		log.WithError(err).Print("failed to get job status")
+		return err
+	}
+
+	switch {
+	case st.Finished:
+		log.WithField("rc", st.RC).Print("job exited while scheduler was stopped")
+		return s.jobExited(ctx, j, st.RC)
+
+	case st.Running:
+		log.Print("job resumed")
+		return s.waitJob(ctx, n, j, jobFinished)
+	}
+
+	log.Print("agent has no record of job, restarting")
+	return s.prepAndRunJob(ctx, n, j)
+}
+
+// getWorkflowsByStatus returns all workflows with one of the supplied statuses.
+func (s *Scheduler) getWorkflowsByStatus(ctx context.Context, statuses ...core.WorkflowStatus) ([]core.Workflow, error) {
+	var ws []core.Workflow
+	var pa core.PageArgs
+	for {
+		p, err := s.p.GetWorkflowsByStatus(ctx, pa, statuses)
+		if err != nil {
+			return nil, err
+		}
+		ws = append(ws, p.Workflows...)
+
+		if !p.PageInfo.HasNextPage {
+			return ws, nil
+		}
+		pa.After = p.PageInfo.EndCursor
+	}
+}
+
+// getJobs returns all jobs in the workflow with the supplied ID.
+func (s *Scheduler) getJobs(ctx context.Context, wid string) ([]core.Job, error) {
+	var jobs []core.Job
+	var pa core.PageArgs
+	for {
+		p, err := s.p.GetJobsByWorkflowID(ctx, pa, wid)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, p.Jobs...)
+
+		if !p.PageInfo.HasNextPage {
+			return jobs, nil
+		}
+		pa.After = p.PageInfo.EndCursor
+	}
+}
+
+// getVolumes returns all volumes in the workflow with the supplied ID, by name.
+func (s *Scheduler) getVolumes(ctx context.Context, wid string) (map[string]core.Volume, error) {
+	volumes := make(map[string]core.Volume)
+	var pa core.PageArgs
+	for {
+		p, err := s.p.GetVolumesByWorkflowID(ctx, pa, wid)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range p.Volumes {
+			volumes[v.Name] = v
+		}
+
+		if !p.PageInfo.HasNextPage {
+			return volumes, nil
+		}
+		pa.After = p.PageInfo.EndCursor
+	}
+}
+
+// Resume resumes workflows that were scheduled or running when the scheduler last stopped, or
+// whose lease has expired because the replica running them failed. Workflows are only resumed by
+// the leader, so Resume first claims the scheduler lease, and does nothing if another replica holds
+// it. Each workflow is leased to a single replica, so workflows leased by another replica, or
+// already running on this replica, are not resumed. Jobs that were running are reconciled with the
+// agents they were placed on, and each workflow is then driven to completion in the background.
+func (s *Scheduler) Resume(ctx context.Context) error {
+	leader, _, err := s.claimLeadership(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to claim scheduler lease: %w", err)
+	}
+	if !leader {
+		return nil
+	}
+
+	ws, err := s.getWorkflowsByStatus(ctx, core.WorkflowScheduled, core.WorkflowRunning)
+	if err != nil {
+		return fmt.Errorf("failed to get workflows: %w", err)
+	}
+
+	for _, w := range ws {
+		if s.isRunning(w.ID) {
+			continue
+		}
+
+		expires, ok, err := s.claimWorkflow(ctx, w.ID)
+		if err != nil {
+			return fmt.Errorf("failed to claim workflow: %w", err)
+		}
+		if !ok {
+			continue
+		}
+
+		if err := s.resumeWorkflow(ctx, w, expires); err != nil {
+			s.releaseWorkflow(ctx, w.ID)
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeWorkflow resumes workflow w, on which this replica holds a lease that expires at
+// leaseExpires.
+func (s *Scheduler) resumeWorkflow(ctx context.Context, w core.Workflow, leaseExpires time.Time) error {
+	jobs, err := s.getJobs(ctx, w.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get jobs: %w", err)
+	}
+
+	volumes, err := s.getVolumes(ctx, w.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get volumes: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"workflowID":   w.ID,
+		"workflowName": w.Name,
+		"status":       w.Status,
+	}).Print("resuming workflow")
+
+	s.startWorkflow(w, jobs, volumes, leaseExpires)
+	return nil
+}
@@ -0,0 +1,352 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// nopMessager is a Messager that discards published messages.
+type nopMessager struct {
+	Messager
+}
+
+func (nopMessager) Publish(subject string, v interface{}) error { return nil }
+
+// leasePersister is a Persister that holds workflows and their jobs, without volumes, and records
+// the leases held on them, along with the scheduler lease.
+type leasePersister struct {
+	Persister
+
+	mu          sync.Mutex
+	ws          map[string]core.Workflow
+	jobs        map[string][]core.Job // Jobs of each workflow.
+	owners      map[string]string
+	expires     map[string]time.Time
+	statuses    map[string][]core.WorkflowStatus // Statuses set on each workflow, in order.
+	jobStatuses map[string][]core.JobStatus      // Statuses set on each job, in order.
+	attempts    map[string]int                   // Number of attempts recorded for each job.
+	finished    chan string                      // Receives the ID of each workflow that finishes.
+}
+
+func newLeasePersister(ws ...core.Workflow) *leasePersister {
+	p := &leasePersister{
+		ws:          make(map[string]core.Workflow),
+		jobs:        make(map[string][]core.Job),
+		owners:      make(map[string]string),
+		expires:     make(map[string]time.Time),
+		statuses:    make(map[string][]core.WorkflowStatus),
+		jobStatuses: make(map[string][]core.JobStatus),
+		attempts:    make(map[string]int),
+		finished:    make(chan string, len(ws)),
+	}
+	for _, w := range ws {
+		p.ws[w.ID] = w
+	}
+	return p
+}
+
+func (p *leasePersister) GetWorkflowsByStatus(ctx context.Context, pa core.PageArgs, statuses []core.WorkflowStatus) (core.WorkflowsPage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var page core.WorkflowsPage
+	for _, w := range p.ws {
+		for _, s := range statuses {
+			if w.Status == s {
+				page.Workflows = append(page.Workflows, w)
+			}
+		}
+	}
+	return page, nil
+}
+
+func (p *leasePersister) GetJobsByWorkflowID(ctx context.Context, pa core.PageArgs, wid string) (core.JobsPage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return core.JobsPage{Jobs: p.jobs[wid]}, nil
+}
+
+func (p *leasePersister) GetVolumesByWorkflowID(context.Context, core.PageArgs, string) (core.VolumesPage, error) {
+	return core.VolumesPage{}, nil
+}
+
+func (p *leasePersister) SetWorkflowStatus(ctx context.Context, id string, status core.WorkflowStatus) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w := p.ws[id]
+	w.Status = status
+	p.ws[id] = w
+	p.statuses[id] = append(p.statuses[id], status)
+	if status.IsTerminal() {
+		p.finished <- id
+	}
+	return nil
+}
+
+func (p *leasePersister) SetJobStatus(ctx context.Context, id string, status core.JobStatus) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.jobStatuses[id] = append(p.jobStatuses[id], status)
+	return nil
+}
+
+func (p *leasePersister) AddJobAttempt(ctx context.Context, id string, a core.JobAttempt) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.attempts[id]++
+	return nil
+}
+
+func (p *leasePersister) SetJobFinishedAt(context.Context, string, time.Time) error { return nil }
+
+func (p *leasePersister) SetWorkflowStartedAt(context.Context, string, time.Time) error  { return nil }
+func (p *leasePersister) SetWorkflowFinishedAt(context.Context, string, time.Time) error { return nil }
+
+func (p *leasePersister) ClaimWorkflow(ctx context.Context, id, owner string, now, expires time.Time) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cur, ok := p.owners[id]; ok && cur != owner && !p.expires[id].Before(now) {
+		return false, nil
+	}
+	p.owners[id] = owner
+	p.expires[id] = expires
+	return true, nil
+}
+
+func (p *leasePersister) ClaimLease(ctx context.Context, name, owner string, now, expires time.Time) (bool, error) {
+	return p.ClaimWorkflow(ctx, name, owner, now, expires)
+}
+
+func (p *leasePersister) ReleaseWorkflow(ctx context.Context, id, owner string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.owners[id] == owner {
+		delete(p.owners, id)
+		delete(p.expires, id)
+	}
+	return nil
+}
+
+// wait waits for the workflow with the supplied ID to finish, and returns the statuses set on it.
+func (p *leasePersister) wait(t *testing.T, id string) []core.WorkflowStatus {
+	t.Helper()
+
+	select {
+	case got := <-p.finished:
+		if got != id {
+			t.Fatalf("got workflow %v finished, want %v", got, id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("workflow %v did not finish", id)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.statuses[id]
+}
+
+func TestResumeLeased(t *testing.T) {
+	p := newLeasePersister(core.Workflow{ID: "w", Status: core.WorkflowRunning})
+
+	a, err := New(nopMessager{}, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	b, err := New(nopMessager{}, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// The workflow is leased by replica A, so should not be resumed by replica B.
+	if _, ok, err := a.claimWorkflow(context.Background(), "w"); err != nil || !ok {
+		t.Fatalf("failed to claim workflow: %v", err)
+	}
+	if err := b.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	p.mu.Lock()
+	n := len(p.statuses["w"])
+	p.mu.Unlock()
+	if n != 0 {
+		t.Fatal("workflow leased by another replica resumed")
+	}
+
+	// Once the lease of replica A expires, replica B should resume the workflow, and release the
+	// lease once it completes.
+	p.mu.Lock()
+	p.expires["w"] = time.Now().Add(-time.Second)
+	p.mu.Unlock()
+
+	if err := b.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got := p.wait(t, "w"); got[len(got)-1] != core.WorkflowSucceeded {
+		t.Errorf("got statuses %v, want final status %v", got, core.WorkflowSucceeded)
+	}
+
+	// The lease is released once the run is removed, so wait for it.
+	deadline := time.Now().Add(5 * time.Second)
+	for b.isRunning("w") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	p.mu.Lock()
+	_, leased := p.owners["w"]
+	p.mu.Unlock()
+	if leased {
+		t.Error("lease not released")
+	}
+}
+
+func TestLeaseLost(t *testing.T) {
+	p := newLeasePersister(core.Workflow{ID: "w", Status: core.WorkflowRunning})
+
+	// With no nodes registered, the job waits to be placed until the run is cancelled.
+	p.jobs["w"] = []core.Job{{ID: "j", WorkflowID: "w"}}
+
+	a, err := New(nopMessager{}, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	b, err := New(nopMessager{}, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	if err := a.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	r, err := a.getRun("w")
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// Replica A stalls past the expiry of its lease, and replica B takes the lease.
+	p.mu.Lock()
+	p.expires["w"] = time.Now().Add(-time.Second)
+	p.mu.Unlock()
+
+	if _, ok, err := b.claimWorkflow(context.Background(), "w"); err != nil || !ok {
+		t.Fatalf("failed to claim workflow: %v", err)
+	}
+
+	// Once replica A attempts to renew its lease, it should stop running the workflow.
+	a.renewLeases(context.Background())
+
+	if a.isRunning("w") {
+		t.Error("workflow still running after lease lost")
+	}
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workflow run did not stop")
+	}
+
+	// Replica A should neither record an outcome, nor release the lease held by replica B.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.statuses["w"] {
+		if s.IsTerminal() {
+			t.Errorf("got status %v recorded after lease lost", s)
+		}
+	}
+	if got := p.jobStatuses["j"]; len(got) > 0 {
+		t.Errorf("got job statuses %v recorded after lease lost", got)
+	}
+	if got := p.attempts["j"]; got > 0 {
+		t.Errorf("got %v job attempts recorded after lease lost", got)
+	}
+	if got, want := p.owners["w"], b.id; got != want {
+		t.Errorf("got lease owner %v, want %v", got, want)
+	}
+}
+
+func TestLeadership(t *testing.T) {
+	p := newLeasePersister(core.Workflow{ID: "w", Status: core.WorkflowRunning})
+
+	// With no nodes registered, the job waits to be placed until the run is cancelled.
+	p.jobs["w"] = []core.Job{{ID: "j", WorkflowID: "w"}}
+
+	a, err := New(nopMessager{}, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	b, err := New(nopMessager{}, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// Replica A is elected, so only it should run the workflow.
+	if err := a.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if err := b.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if !a.isRunning("w") {
+		t.Error("workflow not run by leader")
+	}
+	if b.isRunning("w") {
+		t.Error("workflow run by replica that is not the leader")
+	}
+
+	// Replica A stalls past the expiry of its leases, and replica B is elected in its place.
+	p.mu.Lock()
+	p.expires[schedulerLease] = time.Now().Add(-time.Second)
+	p.expires["w"] = time.Now().Add(-time.Second)
+	p.mu.Unlock()
+
+	if err := b.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	defer b.abandonWorkflows()
+
+	if !b.isRunning("w") {
+		t.Error("workflow not run by new leader")
+	}
+
+	// Once replica A attempts to renew its lease, it should stop running workflows.
+	if leader, _, err := a.claimLeadership(context.Background()); err != nil || leader {
+		t.Fatalf("got leader %v, err %v", leader, err)
+	}
+	if a.isRunning("w") {
+		t.Error("workflow still run by previous leader")
+	}
+}
+
+func TestResumeTimeout(t *testing.T) {
+	// The workflow started longer ago than its timeout.
+	started := time.Now().Add(-2 * time.Hour)
+	p := newLeasePersister(core.Workflow{
+		ID:        "w",
+		Status:    core.WorkflowRunning,
+		StartedAt: &started,
+		Timeout:   time.Hour,
+	})
+
+	s, err := New(nopMessager{}, p, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if err := s.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// The timeout should run from when the workflow started, rather than from when it resumed.
+	if got := p.wait(t, "w"); got[len(got)-1] != core.WorkflowTimedOut {
+		t.Errorf("got statuses %v, want final status %v", got, core.WorkflowTimedOut)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// maxRetryBackoff is the maximum delay between attempts to run a job.
+const maxRetryBackoff = 10 * time.Minute
+
+// exitError is returned when a job exits with a non-zero exit code.
+type exitError struct {
+	rc int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("job exited with code %v", e.rc)
+}
+
+// shouldRetry returns true if a job with retry policy p that failed with err on the supplied
+// attempt should be retried. Cancelled jobs, and jobs that no node has the resources to run, are
+// not retried. If the job exited with a non-zero exit code and p lists exit codes, the job is only
+// retried if the exit code is listed. Other failures, such as a lost node or failure to pull an
+// image, are retried regardless.
+func shouldRetry(p core.RetryPolicy, attempt int, err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, errNoNodeFits) || attempt > p.Retries {
+		return false
+	}
+
+	var ee *exitError
+	if !errors.As(err, &ee) || len(p.ExitCodes) == 0 {
+		return true
+	}
+	for _, rc := range p.ExitCodes {
+		if rc == ee.rc {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay returns the delay before retrying a job with retry policy p that failed on the
+// supplied attempt. The delay doubles with each attempt, up to maxRetryBackoff.
+func retryDelay(p core.RetryPolicy, attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d
+}
+
+// attemptOutputKey returns the key under which the output of the supplied attempt of the job with
+// the supplied ID is archived.
+func attemptOutputKey(id string, attempt int) string {
+	return fmt.Sprintf("%v.attempt.%v", id, attempt)
+}
+
+// archiveOutput moves the output captured so far for the job with the supplied ID, along with its
+// log, to the archive key for the supplied attempt, so that the next attempt starts with empty
+// output. The key the output can be retrieved from is returned. Failure to archive the output is
+// logged, in which case the output remains under the job ID.
+func (s *Scheduler) archiveOutput(id string, attempt int) string {
+	key := attemptOutputKey(id, attempt)
+	if err := s.iop.MoveJobOutput(id, key); err != nil {
+		logrus.WithError(err).WithField("jobID", id).Warn("failed to archive job output")
+		return id
+	}
+	return key
+}
+
+// runJobAttempts runs job j to completion, retrying it on failure according to its retry policy.
+// Each attempt is recorded. If nodeID is not empty, the job is placed on the node with that ID.
+func (s *Scheduler) runJobAttempts(ctx context.Context, nodeID string, j core.Job) error {
+	log := logrus.WithFields(logrus.Fields{
+		"jobID":   j.ID,
+		"jobName": j.Name,
+	})
+
+	// Attempts are recorded using a context that is not cancelled with the job.
+	pctx := context.Background()
+
+	for attempt := len(j.Attempts) + 1; ; attempt++ {
+		a := core.JobAttempt{
+			Number:    attempt,
+			StartedAt: now(),
+			OutputKey: j.ID,
+		}
+
+		var err error
+		a.NodeID, err = s.placeAndRunJob(ctx, nodeID, j)
+
+		a.FinishedAt = now()
+		var ee *exitError
+		if errors.As(err, &ee) {
+			a.ExitCode = &ee.rc
+		} else if err != nil {
+			a.Error = err.Error()
+		} else {
+			a.ExitCode = new(int)
+		}
+
+		// Attempts of jobs of an abandoned workflow are recorded by the replica that now holds the
+		// lease.
+		if jobAbandoned(ctx) {
+			return err
+		}
+
+		retry := ctx.Err() == nil && shouldRetry(j.Retry, attempt, err)
+		if retry {
+			a.OutputKey = s.archiveOutput(j.ID, attempt)
+		}
+		s.addJobAttempt(pctx, j.ID, a)
+		j.Attempts = append(j.Attempts, a)
+
+		if !retry {
+			return err
+		}
+
+		d := retryDelay(j.Retry, attempt)
+		log.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"delay":   d,
+		}).Print("retrying job")
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// Subsequent attempts are run afresh, rather than resumed.
+		j.Status = core.JobPending
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+func TestShouldRetry(t *testing.T) {
+	all := core.RetryPolicy{Retries: 2}
+	listed := core.RetryPolicy{Retries: 2, ExitCodes: []int{3}}
+
+	tests := []struct {
+		name    string
+		p       core.RetryPolicy
+		attempt int
+		err     error
+		want    bool
+	}{
+		{"Succeeded", all, 1, nil, false},
+		{"NoRetries", core.RetryPolicy{}, 1, errNodeLost, false},
+		{"Cancelled", all, 1, fmt.Errorf("wrapped: %w", context.Canceled), false},
+		{"NoNodeFits", all, 1, errNoNodeFits, false},
+		{"Exhausted", all, 3, errNodeLost, false},
+		{"LastRetry", all, 2, errNodeLost, true},
+		{"AnyExitCode", all, 1, &exitError{1}, true},
+		{"ListedExitCode", listed, 1, &exitError{3}, true},
+		{"UnlistedExitCode", listed, 1, &exitError{1}, false},
+		{"ListedOtherFailure", listed, 1, errNodeLost, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := shouldRetry(tt.p, tt.attempt, tt.err), tt.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := core.RetryPolicy{Backoff: time.Minute}
+
+	tests := []struct {
+		name    string
+		p       core.RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"NoBackoff", core.RetryPolicy{}, 3, 0},
+		{"First", p, 1, time.Minute},
+		{"Second", p, 2, 2 * time.Minute},
+		{"Third", p, 3, 4 * time.Minute},
+		{"Capped", p, 10, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := retryDelay(tt.p, tt.attempt), tt.want; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
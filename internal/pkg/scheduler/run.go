@@ -0,0 +1,284 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+var (
+	// errJobCancelled is returned when a job is cancelled before it is dispatched.
+	errJobCancelled = errors.New("job cancelled")
+
+	// errRunAbandoned is returned when a job is dispatched after the lease on its workflow was lost.
+	errRunAbandoned = errors.New("workflow lease lost")
+)
+
+// runContextKey is the key under which the run of a workflow is stored in the contexts of its jobs.
+type runContextKey struct{}
+
+// jobAbandoned returns true if ctx belongs to a job of a workflow run that has been abandoned.
+func jobAbandoned(ctx context.Context) bool {
+	r, ok := ctx.Value(runContextKey{}).(*workflowRun)
+	return ok && r.isAbandoned()
+}
+
+// jobRun tracks the state of a running job.
+type jobRun struct {
+	cancel context.CancelFunc // Cancels the job.
+	done   chan struct{}      // Closed when the job completes.
+}
+
+// workflowRun tracks the state of a running workflow.
+type workflowRun struct {
+	cancel context.CancelFunc // Cancels the workflow.
+	done   chan struct{}      // Closed when the workflow completes.
+	nodeID string             // If not empty, the node that all jobs must be placed on.
+
+	mu           sync.Mutex
+	jobs         map[string]*jobRun // Running jobs, by job ID.
+	cancelled    map[string]bool    // IDs of jobs cancelled before being dispatched.
+	leaseExpires time.Time          // When the lease on the workflow expires, unless renewed.
+	abandoned    bool               // Set once the lease on the workflow is lost.
+}
+
+// newWorkflowRun returns a new workflowRun for a workflow whose lease expires at leaseExpires,
+// along with a context that is cancelled when the workflow is cancelled.
+func newWorkflowRun(leaseExpires time.Time) (*workflowRun, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workflowRun{
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		jobs:         make(map[string]*jobRun),
+		cancelled:    make(map[string]bool),
+		leaseExpires: leaseExpires,
+	}, ctx
+}
+
+// leaseRenewed records that the lease on the workflow was renewed until expires.
+func (r *workflowRun) leaseRenewed(expires time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.leaseExpires = expires
+}
+
+// leaseExpired returns true if the lease on the workflow expired before t.
+func (r *workflowRun) leaseExpired(t time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.leaseExpires.Before(t)
+}
+
+// abandon cancels the run without recording the outcome of the workflow or its jobs, which are
+// left for the replica that now holds the lease to resume. Jobs that are running are not stopped.
+func (r *workflowRun) abandon() {
+	r.mu.Lock()
+	r.abandoned = true
+	r.mu.Unlock()
+
+	r.cancel()
+}
+
+// isAbandoned returns true if the run was abandoned because the lease on the workflow was lost.
+func (r *workflowRun) isAbandoned() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.abandoned
+}
+
+// startJob registers the job with the supplied ID as running, and returns a context that is
+// cancelled when the job is cancelled, along with a function to call when the job completes. If
+// the job has already been cancelled, errJobCancelled is returned. If the run has been abandoned,
+// errRunAbandoned is returned.
+func (r *workflowRun) startJob(ctx context.Context, id string) (context.Context, func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.abandoned {
+		return nil, nil, errRunAbandoned
+	}
+	if r.cancelled[id] {
+		return nil, nil, errJobCancelled
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, runContextKey{}, r))
+	jr := &jobRun{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	r.jobs[id] = jr
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.jobs, id)
+		r.mu.Unlock()
+
+		cancel()
+		close(jr.done)
+	}, nil
+}
+
+// cancelJob cancels the job with the supplied ID. If the job is running, cancelJob waits for it
+// to complete, or for ctx to be done. Otherwise, the job is prevented from being dispatched, and
+// running is false.
+func (r *workflowRun) cancelJob(ctx context.Context, id string) (running bool, err error) {
+	r.mu.Lock()
+	jr, running := r.jobs[id]
+	if !running {
+		r.cancelled[id] = true
+	}
+	r.mu.Unlock()
+
+	if !running {
+		return false, nil
+	}
+
+	jr.cancel()
+
+	select {
+	case <-jr.done:
+		return true, nil
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// isCancelled returns true if the job with the supplied ID was cancelled before being dispatched.
+func (r *workflowRun) isCancelled(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cancelled[id]
+}
+
+// cancelWorkflow cancels the workflow, and waits for it to complete, or for ctx to be done.
+func (r *workflowRun) cancelWorkflow(ctx context.Context) error {
+	r.cancel()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// getRun returns the run associated with the workflow with the supplied ID. If the workflow is
+// not running, core.ErrWorkflowNotRunning is returned.
+func (s *Scheduler) getRun(id string) (*workflowRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[id]
+	if !ok {
+		return nil, core.ErrWorkflowNotRunning
+	}
+	return r, nil
+}
+
+// cancelRequest is a request to cancel a workflow, or a job within it.
+type cancelRequest struct {
+	WorkflowID string
+	JobID      string // If not empty, the job to cancel.
+}
+
+// cancelReply is the reply of the leader to a cancelRequest.
+type cancelReply struct {
+	NotRunning bool   // Set if the workflow is not running.
+	Err        string // Reason the cancellation failed, if it failed for another reason.
+}
+
+// cancel carries out cancellation request req, on a workflow run by this replica. If the workflow
+// is not running, core.ErrWorkflowNotRunning is returned.
+func (s *Scheduler) cancel(ctx context.Context, req cancelRequest) error {
+	r, err := s.getRun(req.WorkflowID)
+	if err != nil {
+		return err
+	}
+
+	if req.JobID == "" {
+		return r.cancelWorkflow(ctx)
+	}
+
+	running, err := r.cancelJob(ctx, req.JobID)
+	if err != nil {
+		return err
+	}
+
+	// If the job was not running, it will not be dispatched, so record the status now.
+	if !running {
+		s.setJobStatus(ctx, req.JobID, core.JobCancelled)
+	}
+	return nil
+}
+
+// requestCancel carries out cancellation request req, on the leader if this replica is not the
+// leader.
+func (s *Scheduler) requestCancel(ctx context.Context, req cancelRequest) error {
+	if s.isLeader() {
+		return s.cancel(ctx, req)
+	}
+
+	var resp cancelReply
+	if err := s.m.Request(cancelSubject, req, &resp, cancelTimeout+leaderRequestTimeout); err != nil {
+		return fmt.Errorf("failed to request cancellation: %w", err)
+	}
+	if resp.NotRunning {
+		return core.ErrWorkflowNotRunning
+	}
+	if resp.Err != "" {
+		return errors.New(resp.Err)
+	}
+	return nil
+}
+
+// cancelHandler handles cancellation requests sent by other replicas. If this replica is the
+// leader, the request is carried out in the background, and the outcome is sent in reply. Failure
+// to reply is logged.
+func (s *Scheduler) cancelHandler(subject, reply string, req *cancelRequest) {
+	if !s.isLeader() {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+		defer cancel()
+
+		var resp cancelReply
+		if err := s.cancel(ctx, *req); errors.Is(err, core.ErrWorkflowNotRunning) {
+			resp.NotRunning = true
+		} else if err != nil {
+			resp.Err = err.Error()
+		}
+
+		if err := s.m.Publish(reply, resp); err != nil {
+			logrus.WithError(err).WithField("subject", subject).Warn("failed to reply to request")
+		}
+	}()
+}
+
+// CancelWorkflow cancels workflow w, and waits for it to complete. Running jobs are stopped, and
+// jobs that have not yet been dispatched are not run. Workflows are only run by the leader, so if
+// this replica is not the leader, the leader is asked to cancel the workflow. If the workflow is
+// not running, core.ErrWorkflowNotRunning is returned.
+func (s *Scheduler) CancelWorkflow(ctx context.Context, w core.Workflow) error {
+	return s.requestCancel(ctx, cancelRequest{WorkflowID: w.ID})
+}
+
+// CancelJob cancels job j. If the job is running, it is stopped, and CancelJob waits for it to
+// complete. If the job has not yet been dispatched, it will not be run. Workflows are only run by
+// the leader, so if this replica is not the leader, the leader is asked to cancel the job. If the
+// workflow the job belongs to is not running, core.ErrWorkflowNotRunning is returned.
+func (s *Scheduler) CancelJob(ctx context.Context, j core.Job) error {
+	return s.requestCancel(ctx, cancelRequest{WorkflowID: j.WorkflowID, JobID: j.ID})
+}
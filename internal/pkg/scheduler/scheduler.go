@@ -4,38 +4,304 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
 )
 
 // Messager is the interface that is needed to send and receive messages.
 type Messager interface {
 	Request(subject string, v interface{}, vPtr interface{}, timeout time.Duration) error
+	Publish(subject string, v interface{}) error
 	Subscribe(subject string, cb nats.Handler) (*nats.Subscription, error)
 }
 
 // Persister is the interface that describes what is needed to persist scheduler data.
 type Persister interface {
-	SetWorkflowStatus(context.Context, string, string) error
-	SetJobStatus(context.Context, string, string) error
+	GetWorkflowsByStatus(context.Context, core.PageArgs, []core.WorkflowStatus) (core.WorkflowsPage, error)
+	GetJobsByWorkflowID(context.Context, core.PageArgs, string) (core.JobsPage, error)
+	GetVolumesByWorkflowID(context.Context, core.PageArgs, string) (core.VolumesPage, error)
+	SetWorkflowStatus(context.Context, string, core.WorkflowStatus) error
+	SetWorkflowNodeID(context.Context, string, string) error
+	SetWorkflowStartedAt(context.Context, string, time.Time) error
+	SetWorkflowFinishedAt(context.Context, string, time.Time) error
+	ClaimWorkflow(ctx context.Context, id, owner string, now, expires time.Time) (bool, error)
+	ReleaseWorkflow(ctx context.Context, id, owner string) error
+	ClaimLease(ctx context.Context, name, owner string, now, expires time.Time) (bool, error)
+	SetJobStatus(context.Context, string, core.JobStatus) error
 	SetJobExitCode(context.Context, string, int) error
+	SetJobNodeID(context.Context, string, string) error
+	SetJobStartedAt(context.Context, string, time.Time) error
+	SetJobFinishedAt(context.Context, string, time.Time) error
+	AddJobAttempt(context.Context, string, core.JobAttempt) error
+	GetSecretByName(context.Context, string, string) (core.Secret, error)
 }
 
 // IOPersister is the interface that describes what is needed to persist Job IO data.
 type IOPersister interface {
 	Set(string, string) error
 	Get(string) (string, error)
+	MoveJobOutput(string, string) error
 }
 
-// Scheduler represents an instance of the scheduler.
+// Scheduler represents a replica of the scheduler. Only the replica that holds the scheduler lease
+// runs workflows.
 type Scheduler struct {
 	m   Messager
 	p   Persister
 	iop IOPersister
+	id  string // Identifies this replica of the scheduler, as the holder of workflow leases.
+
+	reg  *registry
+	d    *dispatcher
+	subs []*nats.Subscription
+	stop chan struct{}
+
+	stopOnce sync.Once
+
+	cleanupGracePeriod time.Duration // How long jobs run always are given to clean up.
+	volumeTimeout      time.Duration // How long an agent is given to create or delete a volume.
+
+	mu            sync.Mutex
+	leaderExpires time.Time               // When the scheduler lease held by this replica expires.
+	runs          map[string]*workflowRun // Running workflows, by workflow ID.
+}
+
+// OptPolicy sets the policy that decides the order in which queued jobs are placed on nodes, and
+// the nodes they are placed on. If not set, PriorityPolicy is used.
+func OptPolicy(p Policy) func(*Scheduler) error {
+	return func(s *Scheduler) error {
+		s.reg.policy = p
+		return nil
+	}
+}
+
+// OptDefaultNodeCPUs sets the number of CPUs assumed for a node that does not report its number of
+// CPUs to n. If not set, DefaultNodeCPUs is used.
+func OptDefaultNodeCPUs(n int) func(*Scheduler) error {
+	return func(s *Scheduler) error {
+		if n < 1 {
+			return fmt.Errorf("invalid default node CPUs %v", n)
+		}
+		s.reg.defaultCPUs = n
+		return nil
+	}
+}
+
+// OptUserLimits sets the limits on the jobs of each user placed on nodes at once to l. Jobs that
+// would exceed the limits wait until other jobs of the user complete.
+func OptUserLimits(l core.Limits) func(*Scheduler) error {
+	return func(s *Scheduler) error {
+		s.reg.userLimits = l
+		return nil
+	}
+}
+
+// OptProjectLimits sets the limits on the jobs of each project placed on nodes at once to l. Jobs
+// that would exceed the limits wait until other jobs of the project complete.
+func OptProjectLimits(l core.Limits) func(*Scheduler) error {
+	return func(s *Scheduler) error {
+		s.reg.projectLimits = l
+		return nil
+	}
+}
+
+// OptCleanupGracePeriod sets how long jobs that are to be run always are given to clean up once
+// their workflow is cancelled or times out to d. If not set, DefaultCleanupGracePeriod is used.
+func OptCleanupGracePeriod(d time.Duration) func(*Scheduler) error {
+	return func(s *Scheduler) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid cleanup grace period %v", d)
+		}
+		s.cleanupGracePeriod = d
+		return nil
+	}
+}
+
+// OptVolumeTimeout sets how long an agent is given to create or delete each volume to d. If not
+// set, DefaultVolumeTimeout is used.
+func OptVolumeTimeout(d time.Duration) func(*Scheduler) error {
+	return func(s *Scheduler) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid volume timeout %v", d)
+		}
+		s.volumeTimeout = d
+		return nil
+	}
 }
 
 // New creates a new scheduler.
-func New(m Messager, p Persister, iop IOPersister) (*Scheduler, error) {
-	return &Scheduler{m, p, iop}, nil
+func New(m Messager, p Persister, iop IOPersister, options ...func(*Scheduler) error) (*Scheduler, error) {
+	s := &Scheduler{
+		m:    m,
+		p:    p,
+		iop:  iop,
+		reg:  newRegistry(nodeHeartbeatTimeout),
+		d:    newDispatcher(),
+		stop: make(chan struct{}),
+		runs: make(map[string]*workflowRun),
+
+		cleanupGracePeriod: DefaultCleanupGracePeriod,
+		volumeTimeout:      DefaultVolumeTimeout,
+	}
+	id, err := newReplicaID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate replica ID: %w", err)
+	}
+	s.id = id
+	for _, opt := range options {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Start starts the scheduler by subscribing to registration, heartbeat and event messages from
+// agents, and to workflows scheduled and requests sent by other replicas, monitoring registered nodes for liveness,
+// and maintaining the scheduler lease and leases on workflows.
+func (s *Scheduler) Start() error {
+	type subscription struct {
+		subject string
+		handler nats.Handler
+	}
+	subs := []subscription{
+		{"node.register", s.nodeHeartbeatHandler},
+		{"node.heartbeat", s.nodeHeartbeatHandler},
+		{workflowScheduledSubject, s.workflowScheduledHandler},
+		{userUsageSubject, s.leaderHandler(func(q leaderQuery) interface{} {
+			return s.reg.userUsage(q.ID)
+		})},
+		{projectUsageSubject, s.leaderHandler(func(q leaderQuery) interface{} {
+			return s.reg.projectUsage(q.ID)
+		})},
+		{cancelSubject, s.cancelHandler},
+		{queuePositionSubject, s.leaderHandler(func(q leaderQuery) interface{} {
+			pos, ok := s.reg.position(q.ID)
+			return queuePosition{pos, ok}
+		})},
+	}
+	for _, subject := range eventSubjects {
+		subs = append(subs, subscription{subject, s.d.eventHandler})
+	}
+	for _, sub := range subs {
+		ns, err := s.m.Subscribe(sub.subject, sub.handler)
+		if err != nil {
+			logrus.WithField("subject", sub.subject).WithError(err).Warn("failed to subscribe")
+			return err
+		}
+		logrus.WithField("subject", sub.subject).Info("subscribed")
+
+		s.subs = append(s.subs, ns)
+	}
+
+	go s.reapNodes(s.stop)
+	go s.maintainLeases(s.stop)
+
+	return nil
+}
+
+// Stop stops the scheduler by removing interest in messages from agents. Calls after the first
+// have no effect.
+func (s *Scheduler) Stop() (err error) {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+
+		for _, sub := range s.subs {
+			if err = sub.Unsubscribe(); err != nil {
+				logrus.WithField("subject", sub.Subject).WithError(err).Warn("failed to unsubscribe")
+				return
+			}
+		}
+	})
+	return err
+}
+
+// now returns the current time, with the precision that is persisted.
+func now() time.Time {
+	return time.Now().UTC().Round(time.Millisecond)
+}
+
+// setWorkflowStatus records the status of the workflow with the supplied ID. When the workflow
+// starts running or reaches a terminal state, the time is also recorded. Once recorded, the status
+// change is published. Failure to record the status is logged.
+func (s *Scheduler) setWorkflowStatus(ctx context.Context, id string, status core.WorkflowStatus) {
+	log := logrus.WithField("workflowID", id)
+
+	switch {
+	case status == core.WorkflowRunning:
+		if err := s.p.SetWorkflowStartedAt(ctx, id, now()); err != nil {
+			log.WithError(err).Warn("failed to set workflow start time")
+		}
+	case status.IsTerminal():
+		if err := s.p.SetWorkflowFinishedAt(ctx, id, now()); err != nil {
+			log.WithError(err).Warn("failed to set workflow finish time")
+		}
+	}
+
+	if err := s.p.SetWorkflowStatus(ctx, id, status); err != nil {
+		logrus.WithError(err).WithField("workflowID", id).Warn("failed to set workflow status")
+	}
+
+	s.publishStatus(workflowUpdatedSubject(id), id, status.String())
+}
+
+// setWorkflowNodeID records the ID of the node the volumes of the workflow with the supplied ID
+// were created on. Failure to record the node ID is logged.
+func (s *Scheduler) setWorkflowNodeID(ctx context.Context, id, nodeID string) {
+	if err := s.p.SetWorkflowNodeID(ctx, id, nodeID); err != nil {
+		logrus.WithError(err).WithField("workflowID", id).Warn("failed to set workflow node ID")
+	}
+}
+
+// setJobStatus records the status of the job with the supplied ID. When the job starts running or
+// reaches a terminal state, the time is also recorded. Once recorded, the status change is
+// published. Failure to record the status is logged.
+func (s *Scheduler) setJobStatus(ctx context.Context, id string, status core.JobStatus) {
+	log := logrus.WithField("jobID", id)
+
+	switch {
+	case status == core.JobRunning:
+		if err := s.p.SetJobStartedAt(ctx, id, now()); err != nil {
+			log.WithError(err).Warn("failed to set job start time")
+		}
+	case status.IsTerminal():
+		if err := s.p.SetJobFinishedAt(ctx, id, now()); err != nil {
+			log.WithError(err).Warn("failed to set job finish time")
+		}
+	}
+
+	if err := s.p.SetJobStatus(ctx, id, status); err != nil {
+		logrus.WithError(err).WithField("jobID", id).Warn("failed to set job status")
+	}
+
+	s.publishStatus(jobUpdatedSubject(id), id, status.String())
+}
+
+// setJobExitCode records the exit code of the job with the supplied ID. Failure to record the exit
+// code is logged.
+func (s *Scheduler) setJobExitCode(ctx context.Context, id string, rc int) {
+	if err := s.p.SetJobExitCode(ctx, id, rc); err != nil {
+		logrus.WithError(err).WithField("jobID", id).Warn("failed to set job exit code")
+	}
+}
+
+// setJobNodeID records the ID of the node the job with the supplied ID was placed on. Failure to
+// record the node ID is logged.
+func (s *Scheduler) setJobNodeID(ctx context.Context, id, nodeID string) {
+	if err := s.p.SetJobNodeID(ctx, id, nodeID); err != nil {
+		logrus.WithError(err).WithField("jobID", id).Warn("failed to set job node ID")
+	}
+}
+
+// addJobAttempt records an attempt to run the job with the supplied ID. Failure to record the
+// attempt is logged.
+func (s *Scheduler) addJobAttempt(ctx context.Context, id string, a core.JobAttempt) {
+	if err := s.p.AddJobAttempt(ctx, id, a); err != nil {
+		logrus.WithError(err).WithField("jobID", id).Warn("failed to add job attempt")
+	}
 }
@@ -0,0 +1,46 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStop(t *testing.T) {
+	s, err := New(nopMessager{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	// Stopping more than once should have no further effect.
+	for i := 0; i < 2; i++ {
+		if err := s.Stop(); err != nil {
+			t.Fatalf("unexpected failure: %v", err)
+		}
+	}
+
+	select {
+	case <-s.stop:
+	default:
+		t.Error("stop not closed")
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  func(*Scheduler) error
+	}{
+		{"DefaultNodeCPUs", OptDefaultNodeCPUs(0)},
+		{"CleanupGracePeriod", OptCleanupGracePeriod(0)},
+		{"VolumeTimeout", OptVolumeTimeout(-time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(nopMessager{}, nil, nil, tt.opt); err == nil {
+				t.Error("unexpected success")
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// Workload is a recorded workload, which can be replayed against a policy using Simulate.
+type Workload struct {
+	Nodes []Node        // Nodes available throughout the workload.
+	Jobs  []WorkloadJob // Jobs submitted during the workload.
+}
+
+// WorkloadJob describes a job in a recorded workload.
+type WorkloadJob struct {
+	ID         string            // Unique job ID.
+	UserID     string            // ID of the user the job belongs to.
+	ProjectID  string            // ID of the project the job belongs to, if any.
+	Priority   int               // Priority of the job, relative to other queued jobs.
+	NodeID     string            // If not empty, the job must be placed on the node with this ID.
+	Resources  core.Resources    // Resources required by the job.
+	NodeLabels map[string]string // Labels the node the job is placed on must have.
+	Submit     time.Duration     // Time the job became ready to run, relative to the start of the workload.
+	Runtime    time.Duration     // Time the job ran for, once placed.
+}
+
+// RecordWorkload returns the workload described by persisted jobs, so that it can be replayed
+// using Simulate. Only jobs that ran to completion are included. Each job is submitted once it was
+// created and the jobs it requires had finished, and runs for as long as it ran. The capacity of
+// nodes is not persisted, so the workload includes each node a job ran on, without its capacity or
+// labels.
+func RecordWorkload(jobs []core.Job) Workload {
+	finished := make(map[string]time.Time)
+	for _, j := range jobs {
+		if j.FinishedAt != nil {
+			finished[j.ID] = *j.FinishedAt
+		}
+	}
+
+	// ready returns the time job j became ready to run.
+	ready := func(j core.Job) time.Time {
+		t := j.CreatedAt
+		for _, id := range j.Requires {
+			if f, ok := finished[id]; ok && f.After(t) {
+				t = f
+			}
+		}
+		return t
+	}
+
+	var start time.Time
+	var ran []core.Job
+	for _, j := range jobs {
+		if j.StartedAt == nil || j.FinishedAt == nil {
+			continue
+		}
+		if t := ready(j); start.IsZero() || t.Before(start) {
+			start = t
+		}
+		ran = append(ran, j)
+	}
+
+	var w Workload
+	nodes := make(map[string]bool)
+	for _, j := range ran {
+		w.Jobs = append(w.Jobs, WorkloadJob{
+			ID:         j.ID,
+			UserID:     j.CreatedByID,
+			ProjectID:  j.ProjectID,
+			Priority:   j.Priority,
+			Resources:  j.Resources,
+			NodeLabels: j.NodeLabels,
+			Submit:     ready(j).Sub(start),
+			Runtime:    j.FinishedAt.Sub(*j.StartedAt),
+		})
+		if j.NodeID != "" && !nodes[j.NodeID] {
+			nodes[j.NodeID] = true
+			w.Nodes = append(w.Nodes, Node{ID: j.NodeID})
+		}
+	}
+	sort.SliceStable(w.Jobs, func(i, j int) bool { return w.Jobs[i].Submit < w.Jobs[j].Submit })
+	sort.Slice(w.Nodes, func(i, j int) bool { return w.Nodes[i].ID < w.Nodes[j].ID })
+	return w
+}
+
+// SimulatedJob describes the outcome of a job in a simulation.
+type SimulatedJob struct {
+	ID     string        // Unique job ID.
+	NodeID string        // ID of the node the job was placed on.
+	Wait   time.Duration // Time the job waited to be placed.
+	Start  time.Duration // Time the job was placed, relative to the start of the workload.
+	Finish time.Duration // Time the job completed, relative to the start of the workload.
+}
+
+// SimulationResult describes the outcome of replaying a workload against a policy.
+type SimulationResult struct {
+	Jobs     []SimulatedJob // Jobs that were placed, in the order they were placed.
+	Unplaced []string       // IDs of jobs that could not be placed, such as those too large for any node.
+
+	Makespan    time.Duration // Time until the last job completed.
+	MeanWait    time.Duration // Mean time jobs waited to be placed.
+	MaxWait     time.Duration // Maximum time a job waited to be placed.
+	Utilization float64       // Fraction of CPU capacity allocated to jobs over the makespan.
+
+	MeanWaitByUser map[string]time.Duration // Mean time jobs waited to be placed, by user ID.
+}
+
+// simEpoch is the time at which simulations start. A fixed time is used so that simulations are
+// deterministic.
+var simEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// simJob tracks a job submitted in a simulation.
+type simJob struct {
+	j      WorkloadJob
+	w      *waiter
+	finish time.Duration // Time the job completes, once placed.
+}
+
+// Simulate replays workload w against the registry the scheduler uses to place jobs, configured
+// by options such as OptPolicy, OptDefaultNodeCPUs, OptUserLimits and OptProjectLimits, returning
+// the outcome. The registry runs on a simulated clock. Each job is queued at its submission time
+// and, once placed, runs for its runtime, or until its walltime if that is shorter. Jobs placed at
+// once are listed in the order they were submitted. The simulation is deterministic, so policies
+// can be compared offline.
+func Simulate(w Workload, options ...func(*Scheduler) error) (SimulationResult, error) {
+	t := simEpoch
+	r := newRegistry(0)
+	r.now = func() time.Time { return t }
+	r.graceEnd = t
+
+	s := &Scheduler{reg: r}
+	for _, opt := range options {
+		if err := opt(s); err != nil {
+			return SimulationResult{}, err
+		}
+	}
+
+	for _, n := range w.Nodes {
+		r.heartbeat(n, t)
+	}
+	var cpus int
+	for _, ns := range r.nodes {
+		cpus += ns.CPUs
+	}
+
+	// Jobs are submitted in order of submission time, with ties broken by workload order.
+	jobs := make([]WorkloadJob, len(w.Jobs))
+	copy(jobs, w.Jobs)
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Submit < jobs[j].Submit })
+
+	var waiting, running []*simJob
+	var res SimulationResult
+	var cpuTime float64
+
+	// collect records the outcome of waiting jobs placed or failed as of time now.
+	collect := func(now time.Duration) {
+		still := waiting[:0]
+		for _, sj := range waiting {
+			switch {
+			case !sj.w.finished:
+				still = append(still, sj)
+				continue
+			case sj.w.a == nil:
+				res.Unplaced = append(res.Unplaced, sj.j.ID)
+				continue
+			}
+
+			d := sj.j.Runtime
+			if wt := sj.j.Resources.Walltime; wt > 0 && wt < d {
+				d = wt
+			}
+			sj.finish = now + d
+			running = append(running, sj)
+
+			res.Jobs = append(res.Jobs, SimulatedJob{
+				ID:     sj.j.ID,
+				NodeID: sj.w.a.ID,
+				Wait:   now - sj.j.Submit,
+				Start:  now,
+				Finish: sj.finish,
+			})
+			cpuTime += float64(sj.w.a.q.weight()) * d.Seconds()
+		}
+		for i := len(still); i < len(waiting); i++ {
+			waiting[i] = nil
+		}
+		waiting = still
+	}
+
+	next := 0
+	for next < len(jobs) || len(running) > 0 {
+		// Advance to the next submission or completion.
+		now := time.Duration(math.MaxInt64)
+		if next < len(jobs) {
+			now = jobs[next].Submit
+		}
+		for _, sj := range running {
+			if sj.finish < now {
+				now = sj.finish
+			}
+		}
+		t = simEpoch.Add(now)
+
+		// Complete jobs, in the order they were placed.
+		done := running
+		running = nil
+		for _, sj := range done {
+			if sj.finish > now {
+				running = append(running, sj)
+				continue
+			}
+			r.release(sj.w.a)
+			collect(now)
+		}
+
+		// Submit jobs.
+		for ; next < len(jobs) && jobs[next].Submit <= now; next++ {
+			j := jobs[next]
+			sj := &simJob{j: j}
+			sj.w = r.enqueue(QueuedJob{
+				JobID:      j.ID,
+				UserID:     j.UserID,
+				ProjectID:  j.ProjectID,
+				Priority:   j.Priority,
+				NodeID:     j.NodeID,
+				Resources:  j.Resources,
+				NodeLabels: j.NodeLabels,
+			})
+			waiting = append(waiting, sj)
+			collect(now)
+		}
+
+		// If nothing is running and nothing further will be submitted, queued jobs can never be
+		// placed.
+		if len(running) == 0 && next >= len(jobs) {
+			break
+		}
+	}
+
+	for _, sj := range waiting {
+		res.Unplaced = append(res.Unplaced, sj.j.ID)
+	}
+
+	summarize(&res, w, cpuTime, cpus)
+	return res, nil
+}
+
+// summarize computes the summary statistics of simulation result res, of workload w, in which
+// cpuTime CPU seconds of the supplied number of CPUs were allocated to jobs.
+func summarize(res *SimulationResult, w Workload, cpuTime float64, cpus int) {
+	if len(res.Jobs) == 0 {
+		return
+	}
+
+	users := make(map[string]string)
+	for _, j := range w.Jobs {
+		users[j.ID] = j.UserID
+	}
+
+	var total time.Duration
+	totalByUser := make(map[string]time.Duration)
+	countByUser := make(map[string]int)
+	for _, j := range res.Jobs {
+		total += j.Wait
+		if j.Wait > res.MaxWait {
+			res.MaxWait = j.Wait
+		}
+		if j.Finish > res.Makespan {
+			res.Makespan = j.Finish
+		}
+		totalByUser[users[j.ID]] += j.Wait
+		countByUser[users[j.ID]]++
+	}
+	res.MeanWait = total / time.Duration(len(res.Jobs))
+
+	res.MeanWaitByUser = make(map[string]time.Duration)
+	for u, d := range totalByUser {
+		res.MeanWaitByUser[u] = d / time.Duration(countByUser[u])
+	}
+
+	if cpus > 0 && res.Makespan > 0 {
+		res.Utilization = cpuTime / (float64(cpus) * res.Makespan.Seconds())
+	}
+}
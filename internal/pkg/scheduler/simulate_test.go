@@ -0,0 +1,228 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+// testWorkload is a workload in which a large job is queued behind a small job, followed by further
+// small jobs.
+var testWorkload = Workload{
+	Nodes: []Node{
+		{ID: "one", CPUs: 2},
+		{ID: "two", CPUs: 2},
+	},
+	Jobs: []WorkloadJob{
+		{ID: "small1", UserID: "a", Resources: core.Resources{CPUs: 1}, Runtime: time.Minute},
+		{ID: "small2", UserID: "a", Resources: core.Resources{CPUs: 1}, Runtime: time.Minute},
+		{ID: "large", UserID: "b", Resources: core.Resources{CPUs: 2}, Runtime: time.Minute},
+		{ID: "small3", UserID: "a", Resources: core.Resources{CPUs: 1}, Runtime: time.Minute},
+		{ID: "walltime", UserID: "c", Resources: core.Resources{CPUs: 1, Walltime: time.Second}, Submit: time.Minute, Runtime: time.Hour},
+		{ID: "huge", UserID: "c", Resources: core.Resources{CPUs: 4}, Submit: time.Minute, Runtime: time.Minute},
+	},
+}
+
+func TestSimulate(t *testing.T) {
+	tests := []struct {
+		name         string
+		p            Policy
+		wantMakespan time.Duration
+		wantWait     map[string]time.Duration
+	}{
+		// Small jobs are spread across both nodes, so the large job must wait, and the job queued
+		// behind it must wait too.
+		{"FIFO", FIFOPolicy(), 2 * time.Minute, map[string]time.Duration{
+			"small1":   0,
+			"small2":   0,
+			"large":    time.Minute,
+			"small3":   time.Minute,
+			"walltime": 0,
+		}},
+		// Small jobs are packed onto one node, leaving room for the large job.
+		{"BinPack", BinPackPolicy(), 2 * time.Minute, map[string]time.Duration{
+			"small1":   0,
+			"small2":   0,
+			"large":    0,
+			"small3":   time.Minute,
+			"walltime": 0,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Simulate(testWorkload, OptPolicy(tt.p))
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+
+			if got, want := res.Makespan, tt.wantMakespan; got != want {
+				t.Errorf("got makespan %v, want %v", got, want)
+			}
+
+			wait := make(map[string]time.Duration)
+			for _, j := range res.Jobs {
+				wait[j.ID] = j.Wait
+				if j.ID == "walltime" {
+					if got, want := j.Finish-j.Start, time.Second; got != want {
+						t.Errorf("got runtime %v, want %v", got, want)
+					}
+				}
+			}
+			if got, want := wait, tt.wantWait; !reflect.DeepEqual(got, want) {
+				t.Errorf("got wait %v, want %v", got, want)
+			}
+
+			if got, want := res.Unplaced, []string{"huge"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got unplaced %v, want %v", got, want)
+			}
+
+			// The simulation should be deterministic.
+			again, err := Simulate(testWorkload, OptPolicy(tt.p))
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+			if got, want := again, res; !reflect.DeepEqual(got, want) {
+				t.Errorf("got result %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSimulatePriority(t *testing.T) {
+	// User A runs a job, and then queues a high priority job alongside a job of user B.
+	w := Workload{
+		Nodes: []Node{{ID: "one", CPUs: 1}},
+		Jobs: []WorkloadJob{
+			{ID: "a1", UserID: "a", Resources: core.Resources{CPUs: 1}, Runtime: time.Minute},
+			{ID: "b1", UserID: "b", Resources: core.Resources{CPUs: 1}, Submit: time.Second, Runtime: time.Minute},
+			{ID: "a2", UserID: "a", Priority: 1, Resources: core.Resources{CPUs: 1}, Submit: time.Second, Runtime: time.Minute},
+		},
+	}
+
+	// fairSharePolicy is a policy that places jobs in fair-share order alone.
+	fairSharePolicy := orderPolicy{
+		order: func(s State) []QueuedJob {
+			return fairShare(s.Queue, s.Users, allocatedCapacity(s.Users))
+		},
+		choose: leastLoaded,
+	}
+
+	tests := []struct {
+		name      string
+		p         Policy
+		wantOrder []string
+	}{
+		// User B has no recent usage, so its job is placed ahead of the high priority job of user A.
+		{"FairShare", fairSharePolicy, []string{"a1", "b1", "a2"}},
+		// The high priority job is placed first, regardless of the recent usage of user A.
+		{"Priority", PriorityPolicy(), []string{"a1", "a2", "b1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Simulate(w, OptPolicy(tt.p))
+			if err != nil {
+				t.Fatalf("unexpected failure: %v", err)
+			}
+
+			var order []string
+			for _, j := range res.Jobs {
+				order = append(order, j.ID)
+			}
+			if got, want := order, tt.wantOrder; !reflect.DeepEqual(got, want) {
+				t.Errorf("got order %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSimulateLimits(t *testing.T) {
+	w := Workload{
+		Nodes: []Node{{ID: "one", CPUs: 4}},
+		Jobs: []WorkloadJob{
+			{ID: "a1", UserID: "a", Resources: core.Resources{CPUs: 1}, Runtime: time.Minute},
+			{ID: "a2", UserID: "a", Resources: core.Resources{CPUs: 1}, Runtime: time.Minute},
+			{ID: "b1", UserID: "b", Resources: core.Resources{CPUs: 1}, Runtime: time.Minute},
+			{ID: "a3", UserID: "a", Resources: core.Resources{CPUs: 2}, Runtime: time.Minute},
+		},
+	}
+
+	// User A may only run one job at once, so its jobs should run in turn, while the job of user B
+	// runs alongside them. A job that exceeds the limits by itself should not be placed.
+	res, err := Simulate(w, OptUserLimits(core.Limits{RunningJobs: 1, CPUs: 1}))
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	wait := make(map[string]time.Duration)
+	for _, j := range res.Jobs {
+		wait[j.ID] = j.Wait
+	}
+	want := map[string]time.Duration{
+		"a1": 0,
+		"a2": time.Minute,
+		"b1": 0,
+	}
+	if got := wait; !reflect.DeepEqual(got, want) {
+		t.Errorf("got wait %v, want %v", got, want)
+	}
+	if got, want := res.Unplaced, []string{"a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got unplaced %v, want %v", got, want)
+	}
+
+	// Invalid options should be rejected.
+	if _, err := Simulate(w, OptDefaultNodeCPUs(0)); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestRecordWorkload(t *testing.T) {
+	at := func(d time.Duration) *time.Time {
+		t := simEpoch.Add(d)
+		return &t
+	}
+
+	jobs := []core.Job{
+		{
+			ID:          "b",
+			CreatedAt:   simEpoch,
+			StartedAt:   at(3 * time.Minute),
+			FinishedAt:  at(5 * time.Minute),
+			NodeID:      "two",
+			Requires:    []string{"a"},
+			Resources:   core.Resources{CPUs: 2},
+			CreatedByID: "u",
+			ProjectID:   "p",
+		},
+		{
+			ID:          "a",
+			CreatedAt:   simEpoch.Add(time.Minute),
+			StartedAt:   at(time.Minute),
+			FinishedAt:  at(2 * time.Minute),
+			NodeID:      "one",
+			CreatedByID: "u",
+			Priority:    1,
+		},
+		{
+			ID:          "cancelled",
+			CreatedAt:   simEpoch,
+			FinishedAt:  at(time.Minute),
+			CreatedByID: "u",
+		},
+	}
+
+	// Job B was ready once job A finished, and the workload starts once job A was created.
+	want := Workload{
+		Nodes: []Node{{ID: "one"}, {ID: "two"}},
+		Jobs: []WorkloadJob{
+			{ID: "a", UserID: "u", Priority: 1, Submit: 0, Runtime: time.Minute},
+			{ID: "b", UserID: "u", ProjectID: "p", Resources: core.Resources{CPUs: 2}, Submit: time.Minute, Runtime: 2 * time.Minute},
+		},
+	}
+	if got := RecordWorkload(jobs); !reflect.DeepEqual(got, want) {
+		t.Errorf("got workload %+v, want %+v", got, want)
+	}
+}
@@ -4,8 +4,9 @@ package scheduler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"runtime"
 	"strings"
 	"time"
 
@@ -15,8 +16,23 @@ import (
 	scs "github.com/sylabs/scs-library-client/client"
 )
 
+// errWalltimeExceeded is returned when a job does not complete within its walltime.
+var errWalltimeExceeded = errors.New("walltime exceeded")
+
+const (
+	// DefaultCleanupGracePeriod is how long jobs that are to be run always are given to clean up
+	// once their workflow is cancelled or times out, unless otherwise configured.
+	DefaultCleanupGracePeriod = time.Minute
+
+	// DefaultVolumeTimeout is how long an agent is given to create or delete each volume, unless
+	// otherwise configured.
+	DefaultVolumeTimeout = time.Minute
+)
+
 const (
 	jobStartAckTimeout        = time.Minute
+	jobStopAckTimeout         = time.Minute
+	jobStatusAckTimeout       = time.Minute
 	volumeOpAckTimeout        = time.Minute
 	cacheOpAckTimeout         = time.Minute
 	imageDownloadOpAckTimeout = 10 * time.Minute
@@ -28,218 +44,267 @@ type agentCacheInfo struct {
 	Hash   string
 }
 
-// runJob runs a job to completion.
-func (s *Scheduler) runJob(ctx context.Context, j core.Job, ac agentCacheInfo) error {
+// jobEnv returns the environment variables of job j, resolving the values of secrets registered by
+// the user that created the job.
+func (s *Scheduler) jobEnv(ctx context.Context, j core.Job) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, e := range j.Env {
+		if e.Secret == "" {
+			env[e.Name] = e.Value
+			continue
+		}
+
+		sec, err := s.p.GetSecretByName(ctx, j.CreatedByID, e.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve secret %q: %w", e.Secret, err)
+		}
+		env[e.Name] = sec.Value
+	}
+	return env, nil
+}
+
+// runJob runs a job to completion. If the job exits with a non-zero exit code, an error is
+// returned.
+func (s *Scheduler) runJob(ctx context.Context, n *nodeState, j core.Job, ac agentCacheInfo) error {
 	log := logrus.WithFields(logrus.Fields{
 		"jobID":   j.ID,
 		"jobName": j.Name,
+		"nodeID":  n.ID,
 	})
 	log.Print("job starting")
 	defer func(t time.Time) {
 		log.WithField("took", time.Since(t)).Print("job completed")
 	}(time.Now())
 
-	jobFinished := make(chan struct{})
+	jobFinished, done := s.d.expect(fmt.Sprintf("job.%v.finished", j.ID))
+	defer done()
 
-	// TODO: this should be a persistent subscription elsewhere.
-	_, err := s.m.Subscribe(fmt.Sprintf("job.%v.finished", j.ID), func(msg struct {
-		Status string
-		RC     int
-	}) {
-		s.p.SetJobStatus(ctx, j.ID, msg.Status)
-		s.p.SetJobExitCode(ctx, j.ID, msg.RC)
-		close(jobFinished)
-	})
+	env, err := s.jobEnv(ctx, j)
 	if err != nil {
+		log.WithError(err).Print("failed to start job")
 		return err
 	}
 
+	// The resolved environment shadows the environment declared by the job, which references
+	// secrets by name only.
 	jobInfo := struct {
 		core.Job
 		agentCacheInfo
+		Env map[string]string
 	}{
 		j,
 		ac,
+		env,
 	}
 
 	var resp nats.Msg
-	if err := s.m.Request("node.1.job.start", jobInfo, &resp, jobStartAckTimeout); err != nil {
+	if err := s.m.Request(n.subject("job.start"), jobInfo, &resp, jobStartAckTimeout); err != nil {
 		log.WithError(err).Print("failed to start job")
 		return err
 	}
 
-	// Wait for response or timeout.
+	return s.waitJob(ctx, n, j, jobFinished)
+}
+
+// waitJob waits for job j, running on node n, to exit. If the job exits with a non-zero exit
+// code, an error is returned. If ctx is done before the job exits, the job is stopped.
+func (s *Scheduler) waitJob(ctx context.Context, n *nodeState, j core.Job, jobFinished <-chan []byte) error {
 	select {
-	case <-jobFinished:
-		return nil
+	case data := <-jobFinished:
+		var msg struct {
+			RC int
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		return s.jobExited(ctx, j, msg.RC)
+	case <-n.gone:
+		return errNodeLost
 	case <-ctx.Done():
+		// Jobs of an abandoned workflow are left running, for the replica that now holds the lease
+		// to resume.
+		if !jobAbandoned(ctx) {
+			s.stopJob(n, j)
+		}
 		return ctx.Err()
 	}
 }
 
+// jobExited records the exit code of job j. If the exit code is non-zero, an *exitError is
+// returned.
+func (s *Scheduler) jobExited(ctx context.Context, j core.Job, rc int) error {
+	s.setJobExitCode(ctx, j.ID, rc)
+	if rc != 0 {
+		return &exitError{rc}
+	}
+	return nil
+}
+
+// stopJob requests that the agent stop a running job. Failure to stop the job is logged.
+func (s *Scheduler) stopJob(n *nodeState, j core.Job) {
+	log := logrus.WithFields(logrus.Fields{
+		"jobID":   j.ID,
+		"jobName": j.Name,
+		"nodeID":  n.ID,
+	})
+	log.Print("stopping job")
+
+	var resp nats.Msg
+	if err := s.m.Request(n.subject("job.stop"), j, &resp, jobStopAckTimeout); err != nil {
+		log.WithError(err).Print("failed to stop job")
+	}
+}
+
 // createVolume sets up a volume on an agent.
-func (s *Scheduler) createVolume(ctx context.Context, v core.Volume) error {
+func (s *Scheduler) createVolume(ctx context.Context, n *nodeState, v core.Volume) error {
 	log := logrus.WithFields(logrus.Fields{
 		"volumeID":   v.ID,
 		"volumeName": v.Name,
 		"volumeType": v.Type,
+		"nodeID":     n.ID,
 	})
 	log.Print("creating volume")
 	defer func(t time.Time) {
 		log.WithField("took", time.Since(t)).Print("creation completed")
 	}(time.Now())
 
-	createFinished := make(chan error)
-
-	// TODO: this should be a persistent subscription elsewhere.
-	_, err := s.m.Subscribe(fmt.Sprintf("volume.%v.create", v.ID), func(msg struct {
-		Err error
-	}) {
-		createFinished <- msg.Err
-		close(createFinished)
-	})
-	if err != nil {
-		return err
-	}
+	createFinished, done := s.d.expect(fmt.Sprintf("volume.%v.create", v.ID))
+	defer done()
 
 	var resp nats.Msg
-	if err := s.m.Request("node.1.volume.create", v, &resp, volumeOpAckTimeout); err != nil {
+	if err := s.m.Request(n.subject("volume.create"), v, &resp, volumeOpAckTimeout); err != nil {
 		log.WithError(err).Print("failed to create volume")
 		return err
 	}
 
 	// Wait for response or timeout.
-	select {
-	case err := <-createFinished:
+	var msg struct {
+		Err json.RawMessage
+	}
+	if err := awaitEvent(ctx, createFinished, &msg); err != nil {
 		return err
-	case <-ctx.Done():
-		return ctx.Err()
 	}
+	return agentError(msg.Err)
 }
 
 // deleteVolume tears down a volume on an agent.
-func (s *Scheduler) deleteVolume(ctx context.Context, v core.Volume) error {
+func (s *Scheduler) deleteVolume(ctx context.Context, n *nodeState, v core.Volume) error {
 	log := logrus.WithFields(logrus.Fields{
 		"volumeID":   v.ID,
 		"volumeName": v.Name,
 		"volumeType": v.Type,
+		"nodeID":     n.ID,
 	})
 	log.Print("deleting volume")
 	defer func(t time.Time) {
 		log.WithField("took", time.Since(t)).Print("deletion completed")
 	}(time.Now())
 
-	deleteFinished := make(chan error)
-
-	// TODO: this should be a persistent subscription elsewhere.
-	_, err := s.m.Subscribe(fmt.Sprintf("volume.%v.delete", v.ID), func(msg struct {
-		Err error
-	}) {
-		deleteFinished <- msg.Err
-		close(deleteFinished)
-	})
-	if err != nil {
-		return err
-	}
+	deleteFinished, done := s.d.expect(fmt.Sprintf("volume.%v.delete", v.ID))
+	defer done()
 
 	var resp nats.Msg
-	if err := s.m.Request("node.1.volume.delete", v, &resp, volumeOpAckTimeout); err != nil {
+	if err := s.m.Request(n.subject("volume.delete"), v, &resp, volumeOpAckTimeout); err != nil {
 		log.WithError(err).Print("failed to delete volume")
 		return err
 	}
 
 	// Wait for response or timeout.
-	select {
-	case err := <-deleteFinished:
+	var msg struct {
+		Err json.RawMessage
+	}
+	if err := awaitEvent(ctx, deleteFinished, &msg); err != nil {
 		return err
-	case <-ctx.Done():
-		return ctx.Err()
 	}
+	return agentError(msg.Err)
 }
 
+// image describes an image to be downloaded by an agent.
 type image struct {
-	URI string
+	CorrelationID string // Correlates the download with its completion event.
+	URI           string
 }
 
 // imageDownload pull an image to the cache on the agent.
-func (s *Scheduler) imageDownload(ctx context.Context, i image) error {
+func (s *Scheduler) imageDownload(ctx context.Context, n *nodeState, i image) error {
 	log := logrus.WithFields(logrus.Fields{
 		"imageURI": i.URI,
+		"nodeID":   n.ID,
 	})
 	log.Print("downloading image")
 	defer func(t time.Time) {
 		log.WithField("took", time.Since(t)).Print("download completed")
 	}(time.Now())
 
-	downloadFinished := make(chan error)
-
-	// TODO: this should be a persistent subscription elsewhere.
-	sub, err := s.m.Subscribe("image.download", func(msg struct {
-		Err error
-	}) {
-		downloadFinished <- msg.Err
-		close(downloadFinished)
-	})
+	id, err := newCorrelationID()
 	if err != nil {
 		return err
 	}
-	sub.AutoUnsubscribe(1)
+	i.CorrelationID = id
+
+	downloadFinished, done := s.d.expect(fmt.Sprintf("image.%v.download", id))
+	defer done()
 
 	var resp nats.Msg
-	if err := s.m.Request("node.1.image.download", i, &resp, imageDownloadOpAckTimeout); err != nil {
+	if err := s.m.Request(n.subject("image.download"), i, &resp, imageDownloadOpAckTimeout); err != nil {
 		log.WithError(err).Print("failed to download image")
 		return err
 	}
 
 	// Wait for response or timeout.
-	select {
-	case err := <-downloadFinished:
+	var msg struct {
+		Err json.RawMessage
+	}
+	if err := awaitEvent(ctx, downloadFinished, &msg); err != nil {
 		return err
-	case <-ctx.Done():
-		return ctx.Err()
 	}
+	return agentError(msg.Err)
 }
 
 // imageCached checks the agent image cache for existance of an image based on its hash.
-func (s *Scheduler) imageCached(ctx context.Context, hash string) (bool, error) {
+func (s *Scheduler) imageCached(ctx context.Context, n *nodeState, hash string) (bool, error) {
 	log := logrus.WithFields(logrus.Fields{
-		"hash": hash,
+		"hash":   hash,
+		"nodeID": n.ID,
 	})
 	log.Print("checking agent image cache")
 	defer func(t time.Time) {
 		log.WithField("took", time.Since(t)).Print("agent image cache check completed")
 	}(time.Now())
 
-	checkFinished := make(chan bool)
-
-	// TODO: this should be a persistent subscription elsewhere.
-	sub, err := s.m.Subscribe("image.cached", func(msg struct {
-		Exists bool
-	}) {
-		checkFinished <- msg.Exists
-		close(checkFinished)
-	})
+	id, err := newCorrelationID()
 	if err != nil {
 		return false, err
 	}
-	sub.AutoUnsubscribe(1)
+
+	checkFinished, done := s.d.expect(fmt.Sprintf("image.%v.cached", id))
+	defer done()
+
+	check := struct {
+		CorrelationID string // Correlates the check with its completion event.
+		Hash          string
+	}{
+		id,
+		hash,
+	}
 
 	var resp nats.Msg
-	if err := s.m.Request("node.1.image.cached", hash, &resp, cacheOpAckTimeout); err != nil {
+	if err := s.m.Request(n.subject("image.cached"), check, &resp, cacheOpAckTimeout); err != nil {
 		log.WithError(err).Print("failed to get cache data")
 		return false, err
 	}
 
 	// Wait for response or timeout.
-	select {
-	case exists := <-checkFinished:
-		return exists, nil
-	case <-ctx.Done():
-		return false, ctx.Err()
+	var msg struct {
+		Exists bool
 	}
+	if err := awaitEvent(ctx, checkFinished, &msg); err != nil {
+		return false, err
+	}
+	return msg.Exists, nil
 }
 
-func (s *Scheduler) prepAgent(ctx context.Context, j core.Job) (ac agentCacheInfo, err error) {
+func (s *Scheduler) prepAgent(ctx context.Context, n *nodeState, j core.Job) (ac agentCacheInfo, err error) {
 	logrus.Print("preparing agent")
 	defer func(t time.Time) {
 		logrus.WithField("took", time.Since(t)).Print("agent prepared")
@@ -274,15 +339,14 @@ func (s *Scheduler) prepAgent(ctx context.Context, j core.Job) (ac agentCacheInf
 
 	// Get library image metadata using the path and tag of the uri
 	imageRef := r.Path + ":" + r.Tags[0]
-	// TODO: this should use agent GOARCH, should be part of agent check-in
-	meta, err := client.GetImage(ctx, runtime.GOARCH, imageRef)
+	meta, err := client.GetImage(ctx, n.Arch, imageRef)
 	if err != nil {
 		logrus.WithError(err).Warnf("could not fetch image metadata")
 		return ac, err
 	}
 
 	// Check image in cache by hash
-	cached, err := s.imageCached(ctx, meta.Hash)
+	cached, err := s.imageCached(ctx, n, meta.Hash)
 	if err != nil {
 		logrus.WithError(err).Warnf("while checking agent cache")
 		return ac, err
@@ -292,7 +356,7 @@ func (s *Scheduler) prepAgent(ctx context.Context, j core.Job) (ac agentCacheInf
 	r.Tags = []string{meta.Hash}
 	if !cached {
 		// Have agent download image by hash
-		err := s.imageDownload(ctx, image{r.String()})
+		err := s.imageDownload(ctx, n, image{URI: r.String()})
 		if err != nil {
 			logrus.WithError(err).Warnf("while downloading image to agent cache")
 			return ac, err
@@ -305,9 +369,439 @@ func (s *Scheduler) prepAgent(ctx context.Context, j core.Job) (ac agentCacheInf
 	return ac, nil
 }
 
-// runWorkflow runs a workflow to completion.
-func (s *Scheduler) runWorkflow(w core.Workflow, jobs []core.Job, volumes map[string]core.Volume) {
-	ctx := context.Background()
+// prepAndRunJob prepares the agent on node n for job j, and then runs it to completion.
+func (s *Scheduler) prepAndRunJob(ctx context.Context, n *nodeState, j core.Job) error {
+	ci, err := s.prepAgent(ctx, n, j)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: default to singularity image pulling for non-library images for now
+	return s.runJob(ctx, n, j, ci)
+}
+
+// placeAndRunJob queues job j until it can be placed on a node with enough free capacity for the
+// resources it requires, and then runs it to completion. If nodeID is not empty, the job is placed
+// on the node with that ID. If the job was running when the scheduler last stopped, it is resumed
+// on the node it was placed on. If the job does not complete within its walltime, it is stopped,
+// and errWalltimeExceeded is returned. The ID of the node the job was placed on is returned.
+func (s *Scheduler) placeAndRunJob(ctx context.Context, nodeID string, j core.Job) (string, error) {
+	resume := j.Status == core.JobRunning && j.NodeID != ""
+	if resume {
+		nodeID = j.NodeID
+	}
+
+	a, err := s.reg.acquire(ctx, QueuedJob{
+		JobID:      j.ID,
+		UserID:     j.CreatedByID,
+		ProjectID:  j.ProjectID,
+		Priority:   j.Priority,
+		NodeID:     nodeID,
+		Resources:  j.Resources,
+		NodeLabels: j.NodeLabels,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer s.reg.release(a)
+	n := a.nodeState
+
+	// The walltime applies from the point the job is placed, rather than while it is queued.
+	wctx := ctx
+	if j.Resources.Walltime > 0 {
+		var cancel context.CancelFunc
+		wctx, cancel = context.WithTimeout(ctx, j.Resources.Walltime)
+		defer cancel()
+	}
+
+	if resume {
+		err = s.resumeJob(wctx, n, j)
+	} else {
+		// The placement and status of the job are recorded using a context that is not cancelled
+		// with the job.
+		pctx := context.Background()
+
+		s.setJobNodeID(pctx, j.ID, n.ID)
+		s.setJobStatus(pctx, j.ID, core.JobRunning)
+
+		err = s.prepAndRunJob(wctx, n, j)
+	}
+
+	if err != nil && ctx.Err() == nil && errors.Is(wctx.Err(), context.DeadlineExceeded) {
+		return n.ID, errWalltimeExceeded
+	}
+	return n.ID, err
+}
+
+// dispatchJob runs job j to completion as part of workflow run r, retrying it according to its
+// retry policy, and recording its status as it progresses. If the job does not complete within
+// its timeout, it is stopped.
+func (s *Scheduler) dispatchJob(ctx context.Context, r *workflowRun, j core.Job) error {
+	ctx, done, err := r.startJob(ctx, j.ID)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	// If the job was running when the scheduler last stopped, its timeout runs from when it
+	// started.
+	if j.Timeout > 0 {
+		start := time.Now()
+		if j.StartedAt != nil {
+			start = *j.StartedAt
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(j.Timeout))
+		defer cancel()
+	}
+
+	// The outcome of the job is recorded using a context that is not cancelled with the job.
+	pctx := context.Background()
+
+	if err := s.runJobAttempts(ctx, r.nodeID, j); err != nil {
+		// The outcome of jobs of an abandoned workflow is recorded by the replica that now holds
+		// the lease.
+		if r.isAbandoned() {
+			return err
+		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			logrus.WithField("jobID", j.ID).Print("job timed out")
+			s.setJobStatus(pctx, j.ID, core.JobTimedOut)
+			return err
+		}
+
+		if errors.Is(err, context.Canceled) {
+			logrus.WithField("jobID", j.ID).Print("job cancelled")
+			s.setJobStatus(pctx, j.ID, core.JobCancelled)
+			return err
+		}
+
+		logrus.WithError(err).WithField("jobID", j.ID).Print("job failed")
+		s.setJobStatus(pctx, j.ID, core.JobFailed)
+		return err
+	}
+
+	s.setJobStatus(pctx, j.ID, core.JobSucceeded)
+	return nil
+}
+
+// jobResult describes the outcome of running a job.
+type jobResult struct {
+	id  string
+	err error
+}
+
+// conditionMet returns true if condition c is met by the outcomes of the jobs required by a job,
+// of which unsucceeded did not succeed (including any that were skipped), and failed failed
+// (including any that were skipped because a job they depend on failed).
+func conditionMet(c core.JobCondition, unsucceeded, failed int) bool {
+	switch c {
+	case core.JobOnFailure:
+		return failed > 0
+	case core.JobAlways:
+		return true
+	default:
+		return unsucceeded == 0
+	}
+}
+
+// runJobs runs jobs to completion using run, with at most maxConcurrency jobs running at once. If
+// maxConcurrency is not positive, the number of concurrently running jobs is not limited.
+//
+// Each job is dispatched once all of the jobs it requires have completed, if its condition is met
+// by their outcomes. By default, a job is only run if all of the jobs it requires succeed, so if a
+// job fails, the jobs that depend on it (directly or indirectly) are not run, unless they are to
+// be run on failure or always. Jobs whose condition is not met are not run, and are treated as
+// failing if a job they depend on (directly or indirectly) failed, and as neither succeeding nor
+// failing otherwise. Jobs that do not depend on a failed job continue to be dispatched. If ctx is
+// done, no further jobs are dispatched, except for jobs that are to be run always, so that they
+// can clean up. These share a context that is not cancelled with ctx, but expires once grace has
+// elapsed, or at the deadline of ctx if it has not yet passed. Jobs that were not run are returned
+// in notRun, along with the first error encountered.
+//
+// Jobs that are already in a terminal state (for example, because they completed before the
+// scheduler last stopped) are not run again, but are treated as having completed with that state.
+func runJobs(ctx context.Context, jobs []core.Job, maxConcurrency int, grace time.Duration, run func(context.Context, core.Job) error) (notRun []core.Job, err error) {
+	// Index jobs by ID, and build up a mapping of parents to children.
+	byID := make(map[string]core.Job)
+	children := make(map[string][]string)
+	unmet := make(map[string]int)
+	ready := []string{}
+	for _, j := range jobs {
+		byID[j.ID] = j
+		unmet[j.ID] = len(j.Requires)
+		if len(j.Requires) == 0 {
+			ready = append(ready, j.ID)
+		}
+		for _, p := range j.Requires {
+			children[p] = append(children[p], j.ID)
+		}
+	}
+
+	// Count the required jobs of each job that did not succeed, and that failed.
+	unsucceeded := make(map[string]int)
+	failed := make(map[string]int)
+
+	// finish records that the job with the supplied ID has completed, and whether it succeeded or
+	// failed. A job that was skipped did not succeed, and failed if a job it depends on failed.
+	// Children with all requirements completed are made ready if their condition is met, and are
+	// skipped otherwise.
+	var finish func(id string, succeeded, failedJob bool)
+	finish = func(id string, succeeded, failedJob bool) {
+		for _, c := range children[id] {
+			if !succeeded {
+				unsucceeded[c]++
+			}
+			if failedJob {
+				failed[c]++
+			}
+			if unmet[c]--; unmet[c] > 0 {
+				continue
+			}
+
+			if conditionMet(byID[c].When, unsucceeded[c], failed[c]) {
+				ready = append(ready, c)
+			} else {
+				finish(c, false, failed[c] > 0)
+			}
+		}
+	}
+
+	// complete records the outcome of a job.
+	complete := func(r jobResult) {
+		if r.err != nil {
+			if err == nil {
+				err = r.err
+			}
+			finish(r.id, false, true)
+			return
+		}
+		finish(r.id, true, false)
+	}
+
+	results := make(chan jobResult)
+	running := 0
+	dispatched := make(map[string]bool)
+
+	// The context of jobs run once ctx is done, created when the first such job is dispatched.
+	var cleanupCtx context.Context
+
+	for {
+		// Dispatch as many ready jobs as the concurrency cap allows.
+		for len(ready) > 0 && (maxConcurrency <= 0 || running < maxConcurrency) {
+			j := byID[ready[0]]
+			ready = ready[1:]
+
+			jctx := ctx
+			if ctx.Err() != nil && !j.Status.IsTerminal() {
+				if j.When != core.JobAlways {
+					finish(j.ID, false, false)
+					continue
+				}
+				if cleanupCtx == nil {
+					var cancel context.CancelFunc
+					cleanupCtx, cancel = cleanupContext(ctx, grace)
+					defer cancel()
+				}
+				jctx = cleanupCtx
+			}
+
+			dispatched[j.ID] = true
+
+			if j.Status.IsTerminal() {
+				switch j.Status {
+				case core.JobSucceeded:
+					complete(jobResult{id: j.ID})
+				case core.JobSkipped:
+					finish(j.ID, false, false)
+				default:
+					complete(jobResult{j.ID, fmt.Errorf("job completed with status %v", j.Status)})
+				}
+				continue
+			}
+
+			running++
+			go func(ctx context.Context, j core.Job) {
+				results <- jobResult{j.ID, run(ctx, j)}
+			}(jctx, j)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		// Wait for a job to complete.
+		r := <-results
+		running--
+
+		complete(r)
+	}
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	for _, j := range jobs {
+		if !dispatched[j.ID] && !j.Status.IsTerminal() {
+			notRun = append(notRun, j)
+		}
+	}
+	return notRun, err
+}
+
+// cleanupContext returns a context for jobs that clean up once ctx is done. The context is not
+// cancelled with ctx, but expires once grace has elapsed, or at the deadline of ctx if it has not
+// yet passed.
+func cleanupContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	now := time.Now()
+	deadline := now.Add(grace)
+	if d, ok := ctx.Deadline(); ok && d.After(now) && d.Before(deadline) {
+		deadline = d
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
+
+// createVolumes brings up volumes on node n. Each volume must be created within the volume timeout.
+func (s *Scheduler) createVolumes(ctx context.Context, n *nodeState, volumes map[string]core.Volume) error {
+	for _, v := range volumes {
+		if err := func() error {
+			ctx, cancel := context.WithTimeout(ctx, s.volumeTimeout)
+			defer cancel()
+
+			return s.createVolume(ctx, n, v)
+		}(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteVolumes tears down volumes on node n. Each volume must be deleted within the volume
+// timeout. Failure to delete a volume is logged, but does not prevent deletion of the remaining
+// volumes.
+func (s *Scheduler) deleteVolumes(ctx context.Context, n *nodeState, volumes map[string]core.Volume) {
+	for _, v := range volumes {
+		func() {
+			ctx, cancel := context.WithTimeout(ctx, s.volumeTimeout)
+			defer cancel()
+
+			if err := s.deleteVolume(ctx, n, v); err != nil {
+				logrus.WithError(err).WithField("volumeID", v.ID).Warn("failed to delete volume")
+			}
+		}()
+	}
+}
+
+// maxResources returns the largest amount of each resource required by any of jobs.
+func maxResources(jobs []core.Job) (res core.Resources) {
+	for _, j := range jobs {
+		if j.Resources.CPUs > res.CPUs {
+			res.CPUs = j.Resources.CPUs
+		}
+		if j.Resources.Memory > res.Memory {
+			res.Memory = j.Resources.Memory
+		}
+	}
+	return res
+}
+
+// nodeLabels returns the labels required by any of jobs. If jobs require different values for the
+// same label, ok is false.
+func nodeLabels(jobs []core.Job) (labels map[string]string, ok bool) {
+	labels = make(map[string]string)
+	for _, j := range jobs {
+		for k, v := range j.NodeLabels {
+			if lv, found := labels[k]; found && lv != v {
+				return nil, false
+			}
+			labels[k] = v
+		}
+	}
+	return labels, true
+}
+
+// setUpVolumes selects a node for workflow run r, and brings up the volumes of workflow w on it.
+// All jobs in the workflow are placed on the selected node, so that they have access to the
+// volumes. The node is selected by queueing a request for the largest resources and the labels
+// required by any of jobs, with the highest priority of any of jobs. If the volumes were brought
+// up before the scheduler last stopped, the node they were created on is selected, and they are not
+// created again.
+func (s *Scheduler) setUpVolumes(ctx context.Context, r *workflowRun, w core.Workflow, jobs []core.Job, volumes map[string]core.Volume) (*nodeState, error) {
+	q := QueuedJob{
+		UserID:    w.CreatedByID,
+		ProjectID: w.ProjectID,
+		NodeID:    w.NodeID,
+	}
+	if w.NodeID == "" {
+		labels, ok := nodeLabels(jobs)
+		if !ok {
+			return nil, errNoNodeFits
+		}
+		q.Resources = maxResources(jobs)
+		q.NodeLabels = labels
+	}
+	for _, j := range jobs {
+		if j.Priority > q.Priority {
+			q.Priority = j.Priority
+		}
+	}
+
+	a, err := s.reg.acquire(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	s.reg.release(a)
+	n := a.nodeState
+
+	r.nodeID = n.ID
+
+	if w.NodeID != "" {
+		return n, nil
+	}
+
+	if err := s.createVolumes(ctx, n, volumes); err != nil {
+		return n, err
+	}
+
+	s.setWorkflowNodeID(context.Background(), w.ID, n.ID)
+
+	return n, nil
+}
+
+// runWorkflow runs a workflow to completion, on which this replica holds a lease. The supplied
+// context is cancelled when the workflow is cancelled. If the workflow does not complete within
+// its timeout, running jobs are stopped. If the workflow was running when the scheduler last
+// stopped, its timeout runs from when it started. Once the workflow completes, the lease is
+// released. If the lease is lost, the run is abandoned without recording the outcome.
+func (s *Scheduler) runWorkflow(ctx context.Context, r *workflowRun, w core.Workflow, jobs []core.Job, volumes map[string]core.Volume) {
+	defer func() {
+		s.mu.Lock()
+		if s.runs[w.ID] == r {
+			delete(s.runs, w.ID)
+		}
+		s.mu.Unlock()
+
+		if !r.isAbandoned() {
+			s.releaseWorkflow(context.Background(), w.ID)
+		}
+
+		r.cancel()
+		close(r.done)
+	}()
+
+	if w.Timeout > 0 {
+		start := time.Now()
+		if w.StartedAt != nil {
+			start = *w.StartedAt
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(w.Timeout))
+		defer cancel()
+	}
+
+	// Status updates and volume teardown use a context that is not cancelled with the workflow.
+	pctx := context.Background()
 
 	log := logrus.WithFields(logrus.Fields{
 		"workflowID":   w.ID,
@@ -318,52 +812,113 @@ func (s *Scheduler) runWorkflow(w core.Workflow, jobs []core.Job, volumes map[st
 		log.WithField("took", time.Since(t)).Print("workflow completed")
 	}(time.Now())
 
-	s.p.SetWorkflowStatus(ctx, w.ID, "RUNNING")
+	s.setWorkflowStatus(pctx, w.ID, core.WorkflowRunning)
 
-	// Bring up volumes on agent.
-	for _, v := range volumes {
-		ctx, cancel := context.WithTimeout(ctx, time.Minute) // TODO
-		defer cancel()
+	status := core.WorkflowSucceeded
 
-		if err := s.createVolume(ctx, v); err != nil {
-			break
-		}
+	// Bring up volumes, if required.
+	var n *nodeState
+	var err error
+	if len(volumes) > 0 {
+		n, err = s.setUpVolumes(ctx, r, w, jobs, volumes)
 	}
 
 	// Run jobs.
-	for _, j := range jobs {
-		ctx, cancel := context.WithTimeout(ctx, time.Minute) // TODO
-		defer cancel()
+	notRun := jobs
+	if err != nil {
+		log.WithError(err).Print("failed to create volumes")
+		status = core.WorkflowFailed
+	} else {
+		dispatch := func(ctx context.Context, j core.Job) error {
+			return s.dispatchJob(ctx, r, j)
+		}
+		if notRun, err = runJobs(ctx, jobs, w.MaxConcurrency, s.cleanupGracePeriod, dispatch); err != nil {
+			status = core.WorkflowFailed
+		}
+	}
 
-		ci, err := s.prepAgent(ctx, j)
-		if err != nil {
-			break
+	// The replica that now holds the lease resumes the workflow, using the volumes as they are.
+	if r.isAbandoned() {
+		log.Warn("workflow abandoned")
+		return
+	}
+
+	// If the workflow was cancelled or timed out, jobs that were not run are cancelled. Otherwise,
+	// they were skipped because their condition was not met.
+	switch ctx.Err() {
+	case context.Canceled:
+		log.Print("workflow cancelled")
+		status = core.WorkflowCancelled
+	case context.DeadlineExceeded:
+		log.Print("workflow timed out")
+		status = core.WorkflowTimedOut
+	}
+	for _, j := range notRun {
+		if status == core.WorkflowCancelled || status == core.WorkflowTimedOut {
+			s.setJobStatus(pctx, j.ID, core.JobCancelled)
+		} else if !r.isCancelled(j.ID) {
+			s.setJobStatus(pctx, j.ID, core.JobSkipped)
 		}
+	}
 
-		// NOTE: default to singularity image pulling for non-library images for now
-		if err := s.runJob(ctx, j, ci); err != nil {
-			break
+	// Tear down volumes, unless the node they were created on has been lost.
+	if n != nil {
+		select {
+		case <-n.gone:
+			log.WithField("nodeID", n.ID).Warn("unable to delete volumes on lost node")
+		default:
+			s.deleteVolumes(pctx, n, volumes)
 		}
 	}
 
-	// Tear down volumes on agent.
-	for _, v := range volumes {
-		ctx, cancel := context.WithTimeout(ctx, time.Minute) // TODO
-		defer cancel()
+	s.setWorkflowStatus(pctx, w.ID, status)
+}
 
-		if err := s.deleteVolume(ctx, v); err != nil {
-			break
-		}
+// isRunning returns true if the workflow with the supplied ID is running on this replica.
+func (s *Scheduler) isRunning(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.runs[id]
+	return ok
+}
+
+// startWorkflow starts running a workflow in the background, unless it is already running. This
+// replica must hold a lease on the workflow, which expires at leaseExpires.
+func (s *Scheduler) startWorkflow(w core.Workflow, jobs []core.Job, volumes map[string]core.Volume, leaseExpires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.runs[w.ID]; ok {
+		return
 	}
 
-	s.p.SetWorkflowStatus(ctx, w.ID, "COMPLETED")
+	r, rctx := newWorkflowRun(leaseExpires)
+	s.runs[w.ID] = r
+
+	go s.runWorkflow(rctx, r, w, jobs, volumes)
 }
 
-// AddWorkflow schedules a workflow for execution.
+// AddWorkflow schedules a workflow for execution. If this replica is not the leader, the leader is
+// notified, so that it runs the workflow. If a lease on the workflow cannot be claimed, the
+// workflow is left to be resumed once one can.
 func (s *Scheduler) AddWorkflow(ctx context.Context, w core.Workflow, jobs []core.Job, volumes map[string]core.Volume) error {
-	s.p.SetWorkflowStatus(ctx, w.ID, "SCHEDULED")
+	s.setWorkflowStatus(ctx, w.ID, core.WorkflowScheduled)
+
+	if !s.isLeader() {
+		if err := s.m.Publish(workflowScheduledSubject, w.ID); err != nil {
+			logrus.WithError(err).WithField("workflowID", w.ID).Warn("failed to notify leader, deferring to resumption")
+		}
+		return nil
+	}
+
+	expires, ok, err := s.claimWorkflow(ctx, w.ID)
+	if err != nil || !ok {
+		logrus.WithError(err).WithField("workflowID", w.ID).Warn("failed to claim workflow, deferring to resumption")
+		return nil
+	}
 
-	go s.runWorkflow(w, jobs, volumes)
+	s.startWorkflow(w, jobs, volumes, expires)
 
 	return nil
 }
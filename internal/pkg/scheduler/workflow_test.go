@@ -0,0 +1,415 @@
+// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sylabs/fuzzball-service/internal/pkg/core"
+)
+
+const (
+	// holdTimeout is how long a held job waits for the expected number of jobs to run at once, as
+	// jobs such as those run alone cannot reach it.
+	holdTimeout = 100 * time.Millisecond
+
+	// holdSettle is how long a held job continues to run once the expected number of jobs have run
+	// at once, so that any jobs run in excess of it overlap.
+	holdSettle = 10 * time.Millisecond
+)
+
+// jobRecorder records the order in which jobs are run, and the peak number of concurrent jobs.
+type jobRecorder struct {
+	mu      sync.Mutex
+	order   []string
+	running int
+	peak    int
+	failIDs map[string]bool
+
+	hold    int           // If set, each job blocks until this many jobs have run at once.
+	reached chan struct{} // Closed once hold jobs have run at once.
+}
+
+func (r *jobRecorder) run(ctx context.Context, j core.Job) error {
+	r.mu.Lock()
+	r.order = append(r.order, j.ID)
+	if r.reached == nil {
+		r.reached = make(chan struct{})
+	}
+	r.running++
+	if r.running > r.peak {
+		r.peak = r.running
+		if r.peak == r.hold {
+			close(r.reached)
+		}
+	}
+	reached := r.reached
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.running--
+		r.mu.Unlock()
+	}()
+
+	if r.hold > 0 {
+		select {
+		case <-reached:
+		case <-time.After(holdTimeout):
+		}
+		time.Sleep(holdSettle)
+	}
+
+	if r.failIDs[j.ID] {
+		return errors.New("job failed")
+	}
+	return nil
+}
+
+func TestRunJobs(t *testing.T) {
+	// A fans out to B, C and D, which fan in to E.
+	jobs := []core.Job{
+		{ID: "a"},
+		{ID: "b", Requires: []string{"a"}},
+		{ID: "c", Requires: []string{"a"}},
+		{ID: "d", Requires: []string{"a"}},
+		{ID: "e", Requires: []string{"b", "c", "d"}},
+	}
+
+	tests := []struct {
+		name           string
+		maxConcurrency int
+		failIDs        map[string]bool
+		wantRun        []string
+		wantNotRun     []string
+		wantPeak       int
+		wantErr        bool
+	}{
+		{"Unbounded", 0, nil, []string{"a", "b", "c", "d", "e"}, nil, 3, false},
+		{"Capped", 2, nil, []string{"a", "b", "c", "d", "e"}, nil, 2, false},
+		{"Serial", 1, nil, []string{"a", "b", "c", "d", "e"}, nil, 1, false},
+		{"RootFails", 0, map[string]bool{"a": true}, []string{"a"}, []string{"b", "c", "d", "e"}, 1, true},
+		{"ChildFails", 1, map[string]bool{"b": true}, []string{"a", "b", "c", "d"}, []string{"e"}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each job blocks until the expected number of jobs run at once, so that the peak is
+			// reached if permitted, and exceeded if the limit is not respected.
+			r := jobRecorder{failIDs: tt.failIDs, hold: tt.wantPeak}
+
+			notRun, err := runJobs(context.Background(), jobs, tt.maxConcurrency, DefaultCleanupGracePeriod, r.run)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// Verify the expected jobs were not run.
+			gotNotRun := make(map[string]bool)
+			for _, j := range notRun {
+				gotNotRun[j.ID] = true
+			}
+			if got, want := len(notRun), len(tt.wantNotRun); got != want {
+				t.Fatalf("got %v jobs not run, want %v", got, want)
+			}
+			for _, id := range tt.wantNotRun {
+				if !gotNotRun[id] {
+					t.Errorf("job %v was run", id)
+				}
+			}
+
+			// Verify the expected jobs were run.
+			ran := make(map[string]int)
+			for i, id := range r.order {
+				ran[id] = i
+			}
+			if got, want := len(r.order), len(tt.wantRun); got != want {
+				t.Fatalf("got %v jobs run, want %v", got, want)
+			}
+			for _, id := range tt.wantRun {
+				if _, ok := ran[id]; !ok {
+					t.Errorf("job %v was not run", id)
+				}
+			}
+
+			// Verify no job ran before the jobs it requires.
+			for _, j := range jobs {
+				for _, p := range j.Requires {
+					if i, ok := ran[j.ID]; ok && ran[p] > i {
+						t.Errorf("job %v ran before required job %v", j.ID, p)
+					}
+				}
+			}
+
+			if got, want := r.peak, tt.wantPeak; got != want {
+				t.Errorf("got peak concurrency %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRunJobsConditions(t *testing.T) {
+	// A is required by B, which is required by cleanup, which always runs, and notify, which runs on
+	// failure.
+	jobs := []core.Job{
+		{ID: "a"},
+		{ID: "b", Requires: []string{"a"}, When: core.JobOnSuccess},
+		{ID: "cleanup", Requires: []string{"b"}, When: core.JobAlways},
+		{ID: "notify", Requires: []string{"b"}, When: core.JobOnFailure},
+	}
+
+	tests := []struct {
+		name       string
+		failIDs    map[string]bool
+		wantRun    []string
+		wantNotRun []string
+		wantErr    bool
+	}{
+		{"Succeeded", nil, []string{"a", "b", "cleanup"}, []string{"notify"}, false},
+		{"ParentFailed", map[string]bool{"b": true}, []string{"a", "b", "cleanup", "notify"}, nil, true},
+		{"AncestorFailed", map[string]bool{"a": true}, []string{"a", "cleanup", "notify"}, []string{"b"}, true},
+		{"CleanupFailed", map[string]bool{"cleanup": true}, []string{"a", "b", "cleanup"}, []string{"notify"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := jobRecorder{failIDs: tt.failIDs}
+
+			notRun, err := runJobs(context.Background(), jobs, 1, DefaultCleanupGracePeriod, r.run)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got, want := r.order, tt.wantRun; !reflect.DeepEqual(got, want) {
+				t.Errorf("got jobs run %v, want %v", got, want)
+			}
+
+			var gotNotRun []string
+			for _, j := range notRun {
+				gotNotRun = append(gotNotRun, j.ID)
+			}
+			if got, want := gotNotRun, tt.wantNotRun; !reflect.DeepEqual(got, want) {
+				t.Errorf("got jobs not run %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRunJobsCancelled(t *testing.T) {
+	jobs := []core.Job{
+		{ID: "a"},
+		{ID: "b", Requires: []string{"a"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := jobRecorder{}
+
+	notRun, err := runJobs(ctx, jobs, 0, DefaultCleanupGracePeriod, r.run)
+	if got, want := err, context.Canceled; !errors.Is(got, want) {
+		t.Errorf("got err %v, want %v", got, want)
+	}
+	if got, want := len(r.order), 0; got != want {
+		t.Errorf("got %v jobs run, want %v", got, want)
+	}
+	if got, want := len(notRun), len(jobs); got != want {
+		t.Errorf("got %v jobs not run, want %v", got, want)
+	}
+}
+
+func TestRunJobsCancelledAlways(t *testing.T) {
+	// A is required by B, which is required by cleanup, which always runs.
+	jobs := []core.Job{
+		{ID: "a"},
+		{ID: "b", Requires: []string{"a"}},
+		{ID: "cleanup", Requires: []string{"b"}, When: core.JobAlways},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Cancel while A is running. Cleanup should still run, with a context that is not cancelled.
+	var cleanupErr error
+	run := func(jctx context.Context, j core.Job) error {
+		switch j.ID {
+		case "a":
+			cancel()
+			<-jctx.Done()
+			return jctx.Err()
+		case "cleanup":
+			cleanupErr = jctx.Err()
+		default:
+			t.Errorf("job %v run after cancellation", j.ID)
+		}
+		return nil
+	}
+
+	notRun, err := runJobs(ctx, jobs, 0, DefaultCleanupGracePeriod, run)
+	if got, want := err, context.Canceled; !errors.Is(got, want) {
+		t.Errorf("got err %v, want %v", got, want)
+	}
+	if cleanupErr != nil {
+		t.Errorf("cleanup run with context error %v", cleanupErr)
+	}
+
+	var gotNotRun []string
+	for _, j := range notRun {
+		gotNotRun = append(gotNotRun, j.ID)
+	}
+	if got, want := gotNotRun, []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs not run %v, want %v", got, want)
+	}
+}
+
+func TestRunJobsCleanupBounded(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration // If set, deadline of the workflow, from when it starts.
+		grace    time.Duration
+		wantMax  time.Duration // Maximum time cleanup should be given.
+	}{
+		{"GracePeriod", 0, 50 * time.Millisecond, 50 * time.Millisecond},
+		{"WorkflowDeadline", 50 * time.Millisecond, time.Hour, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := []core.Job{
+				{ID: "a"},
+				{ID: "cleanup", Requires: []string{"a"}, When: core.JobAlways},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.deadline > 0 {
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+
+			// Cancel while A is running. Cleanup blocks until its context expires.
+			var cleanupErr error
+			var cleanupTook time.Duration
+			run := func(jctx context.Context, j core.Job) error {
+				if j.ID == "a" {
+					cancel()
+					<-jctx.Done()
+					return jctx.Err()
+				}
+				start := time.Now()
+				<-jctx.Done()
+				cleanupTook = time.Since(start)
+				cleanupErr = jctx.Err()
+				return cleanupErr
+			}
+
+			if _, err := runJobs(ctx, jobs, 0, tt.grace, run); err == nil {
+				t.Error("unexpected success")
+			}
+			if got, want := cleanupErr, context.DeadlineExceeded; !errors.Is(got, want) {
+				t.Errorf("got cleanup context error %v, want %v", got, want)
+			}
+			if cleanupTook > tt.wantMax+time.Second {
+				t.Errorf("cleanup ran for %v, want at most %v", cleanupTook, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestCancelWorkflowCleanupBlocks(t *testing.T) {
+	p := newLeasePersister(core.Workflow{ID: "w", Status: core.WorkflowRunning})
+
+	// With no nodes registered, each job waits to be placed until its context is done, so
+	// cleanup blocks until its grace period elapses.
+	p.jobs["w"] = []core.Job{
+		{ID: "j", WorkflowID: "w"},
+		{ID: "cleanup", WorkflowID: "w", Requires: []string{"j"}, When: core.JobAlways},
+	}
+
+	s, err := New(nopMessager{}, p, nil, OptCleanupGracePeriod(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if err := s.Resume(context.Background()); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.CancelWorkflow(ctx, core.Workflow{ID: "w"}); err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+	if got := p.wait(t, "w"); got[len(got)-1] != core.WorkflowCancelled {
+		t.Errorf("got statuses %v, want final status %v", got, core.WorkflowCancelled)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if got, want := p.jobStatuses["cleanup"], []core.JobStatus{core.JobTimedOut}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got cleanup statuses %v, want %v", got, want)
+	}
+}
+
+func TestRunJobsResumed(t *testing.T) {
+	// A completed and B was running when the scheduler stopped, and C requires B. D failed, and E
+	// requires D.
+	jobs := []core.Job{
+		{ID: "a", Status: core.JobSucceeded},
+		{ID: "b", Status: core.JobRunning, Requires: []string{"a"}},
+		{ID: "c", Status: core.JobPending, Requires: []string{"b"}},
+		{ID: "d", Status: core.JobFailed},
+		{ID: "e", Status: core.JobPending, Requires: []string{"d"}},
+	}
+
+	r := jobRecorder{}
+
+	notRun, err := runJobs(context.Background(), jobs, 0, DefaultCleanupGracePeriod, r.run)
+	if err == nil {
+		t.Error("unexpected success")
+	}
+
+	// Only jobs that had not completed should be run.
+	if got, want := r.order, []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs run %v, want %v", got, want)
+	}
+
+	// Jobs that depend on a failed job should not be run.
+	if got, want := len(notRun), 1; got != want {
+		t.Fatalf("got %v jobs not run, want %v", got, want)
+	}
+	if got, want := notRun[0].ID, "e"; got != want {
+		t.Errorf("got job %v not run, want %v", got, want)
+	}
+}
+
+func TestNodeLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		jobs   []core.Job
+		want   map[string]string
+		wantOK bool
+	}{
+		{"None", []core.Job{{}, {}}, map[string]string{}, true},
+		{"Union", []core.Job{
+			{NodeLabels: map[string]string{"zone": "a"}},
+			{NodeLabels: map[string]string{"zone": "a", "gpu": "v100"}},
+		}, map[string]string{"zone": "a", "gpu": "v100"}, true},
+		{"Conflict", []core.Job{
+			{NodeLabels: map[string]string{"gpu": "v100"}},
+			{NodeLabels: map[string]string{"gpu": "a100"}},
+		}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := nodeLabels(tt.jobs)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got labels %v, want %v", got, tt.want)
+			}
+		})
+	}
+}